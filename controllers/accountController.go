@@ -0,0 +1,445 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var accountValidate = validator.New()
+
+// accountListSpec is what GET /staff/accounts can be filtered and sorted by.
+var accountListSpec = helpers.ListSpec{
+	Fields: map[string]helpers.ListField{
+		"name":       {Field: "name", Kind: helpers.LIST_FIELD_STRING, Sort: true},
+		"domain":     {Field: "domain", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"industry":   {Field: "industry", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"size":       {Field: "size", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"owner_id":   {Field: "owner_id", Kind: helpers.LIST_FIELD_ID, Filter: true},
+		"created_at": {Field: "created_at", Kind: helpers.LIST_FIELD_TIME, Filter: true, Sort: true},
+	},
+	DefaultSort: "name",
+}
+
+// findAccessibleAccount loads the account named in the path, callers with an
+// "own" scope only reach the accounts they own. It writes the error response itself.
+func findAccessibleAccount(ctx context.Context, c *gin.Context, accounts database.AccountStore) (models.Account, bool) {
+	accountId, err := primitive.ObjectIDFromHex(c.Param("account_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+		return models.Account{}, false
+	}
+
+	account, err := accounts.FindByID(ctx, accountId)
+	if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return account, false
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching account"})
+		return account, false
+	}
+
+	owner := ""
+	if account.OwnerID != nil {
+		owner = account.OwnerID.Hex()
+	}
+
+	if err := helpers.MatchUserTypeToUid(c, owner); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return account, false
+	}
+	return account, true
+}
+
+// prepareAccountDomain normalizes the domain and makes sure no other account
+// has it, it writes the error response itself.
+func prepareAccountDomain(ctx context.Context, c *gin.Context, accounts database.AccountStore, account *models.Account) bool {
+	if account.Domain == nil {
+		return true
+	}
+
+	domain, err := helpers.NormalizeDomain(*account.Domain)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	account.Domain = &domain
+
+	if domain == "" {
+		return true
+	}
+
+	existing, err := accounts.FindByDomain(ctx, domain)
+	if err == nil && existing.ID != account.ID {
+		c.JSON(http.StatusConflict, gin.H{"error": helpers.ErrAccountDomainTaken.Error()})
+		return false
+	}
+
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for domain"})
+		return false
+	}
+	return true
+}
+
+// validateOwner checks the owner of an account or deal is a staff member the
+// caller may hand it to, "own" callers only themselves. It writes the error response itself.
+func validateOwner(ctx context.Context, c *gin.Context, users database.UserStore, ownerId primitive.ObjectID) bool {
+	if err := helpers.MatchUserTypeToUid(c, ownerId.Hex()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	_, err := users.FindByID(ctx, ownerId.Hex())
+	if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "owner does not exist"})
+		return false
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching user"})
+		return false
+	}
+	return true
+}
+
+// requires accounts:read:any
+func GetAccounts(accounts database.AccountStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		query, err := helpers.ParseListQuery(c.Request.URL.Query(), accountListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		page, err := accounts.List(ctx, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing accounts"})
+			return
+		}
+
+		c.JSON(http.StatusOK, helpers.NewPageBody(query, page))
+	}
+}
+
+// CreateAccount adds a company account, owned by the caller unless owner_id
+// names someone else. Customers already signed up are not linked to it by
+// their domain, link them through PUT /staff/customers/:customer_id/account.
+// requires accounts:write
+func CreateAccount(accounts database.AccountStore, users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var account models.Account
+		if err := c.BindJSON(&account); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		account.ID = primitive.NewObjectID()
+		if !prepareAccountDomain(ctx, c, accounts, &account) {
+			return
+		}
+
+		if account.Domain != nil && *account.Domain == "" {
+			account.Domain = nil
+		}
+
+		if err := accountValidate.Struct(account); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if account.OwnerID == nil {
+			ownerId, err := primitive.ObjectIDFromHex(helpers.GetPrincipal(c).Id)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+				return
+			}
+			account.OwnerID = &ownerId
+		}
+
+		if !validateOwner(ctx, c, users, *account.OwnerID) {
+			return
+		}
+
+		account.AccountId = account.ID.Hex()
+		account.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		account.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		if err := accounts.Create(ctx, &account); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "account was not created"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, account)
+	}
+}
+
+// GetAccount returns the account with its contacts, their open tickets and
+// the interactions with them that changed last, in the viewer's time zone.
+// Callers with an "own" interaction scope only see their interactions, those
+// without one get null.
+// requires accounts:read
+func GetAccount(accounts database.AccountStore, users database.UserStore, roles database.RoleStore, customers database.CustomerStore, tickets database.TicketStore, interactions database.InteractionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		account, ok := findAccessibleAccount(ctx, c, accounts)
+		if !ok {
+			return
+		}
+
+		loc, ok := viewerLocation(ctx, c, users)
+		if !ok {
+			return
+		}
+
+		principal := helpers.GetPrincipal(c)
+		role, err := roles.FindByName(ctx, principal.Role)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "UnAuthenticated to access this resource"})
+			return
+		}
+
+		summary, err := helpers.SummarizeAccount(ctx, customers, tickets, account)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching account"})
+			return
+		}
+
+		switch helpers.GrantedScope(role, "interactions:read") {
+		case models.SCOPE_ANY:
+			summary.RecentInteractions, err = helpers.RecentAccountInteractions(ctx, interactions, account.ID)
+		case models.SCOPE_OWN:
+			userId, _ := primitive.ObjectIDFromHex(principal.Id)
+			summary.RecentInteractions, err = helpers.RecentAccountInteractions(ctx, interactions, account.ID, database.Eq("user_id", userId))
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching account"})
+			return
+		}
+		if summary.RecentInteractions != nil {
+			summary.RecentInteractions = helpers.InteractionsIn(summary.RecentInteractions, loc)
+		}
+
+		c.JSON(http.StatusOK, summary)
+	}
+}
+
+// UpdateAccount edits an account, an empty domain stops new customers joining it.
+// requires accounts:write
+func UpdateAccount(accounts database.AccountStore, users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		found, ok := findAccessibleAccount(ctx, c, accounts)
+		if !ok {
+			return
+		}
+
+		var account models.Account
+		if err := c.BindJSON(&account); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		account.ID = found.ID
+		if !prepareAccountDomain(ctx, c, accounts, &account) {
+			return
+		}
+
+		clearDomain := account.Domain != nil && *account.Domain == ""
+		if clearDomain {
+			account.Domain = nil
+		}
+
+		if err := accountValidate.StructExcept(account, "Name"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updateObj := bson.M{}
+
+		if account.Name != nil {
+			updateObj["name"] = account.Name
+		}
+
+		if clearDomain {
+			updateObj["domain"] = nil
+		}
+
+		if account.Domain != nil {
+			updateObj["domain"] = account.Domain
+		}
+
+		if account.Industry != nil {
+			updateObj["industry"] = account.Industry
+		}
+
+		if account.Size != "" {
+			updateObj["size"] = account.Size
+		}
+
+		if account.OwnerID != nil {
+			if !validateOwner(ctx, c, users, *account.OwnerID) {
+				return
+			}
+			updateObj["owner_id"] = account.OwnerID
+		}
+
+		updateObj["updated_at"] = time.Now()
+
+		err := accounts.Update(ctx, found.ID, updateObj)
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating account"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "account updated successfully"})
+	}
+}
+
+// DeleteAccount removes an account, its contacts stay as customers without one
+// and their tickets and interactions are unlinked with them. An account that
+// still has deals is kept, see helpers.ErrAccountHasDeals.
+// requires accounts:delete
+func DeleteAccount(accounts database.AccountStore, customers database.CustomerStore, tickets database.TicketStore, interactions database.InteractionStore, deals database.DealStore, transactions database.Transactor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		account, ok := findAccessibleAccount(ctx, c, accounts)
+		if !ok {
+			return
+		}
+
+		err := transactions.RunInTransaction(ctx, func(ctx context.Context) error {
+			linked, err := deals.List(ctx, database.ListQuery{Filters: []database.Filter{database.Eq("account_id", account.ID)}, Limit: 1})
+			if err != nil {
+				return err
+			}
+			if linked.Total > 0 {
+				return helpers.ErrAccountHasDeals
+			}
+
+			if err := accounts.Delete(ctx, account.ID); err != nil {
+				return err
+			}
+			if err := customers.ClearAccount(ctx, account.ID); err != nil {
+				return err
+			}
+			if err := tickets.ClearAccount(ctx, account.ID); err != nil {
+				return err
+			}
+			return interactions.ClearAccount(ctx, account.ID)
+		})
+		if errors.Is(err, helpers.ErrAccountHasDeals) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting account"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "account deleted successfully"})
+	}
+}
+
+type accountLink struct {
+	// AccountId is the account to link the customer to, null unlinks it
+	AccountId *string `json:"account_id"`
+}
+
+// LinkCustomerAccount makes a customer a contact of an account, or of none,
+// together with their tickets, interactions and deals. It settles the account their
+// signup matched, linking them to it confirms the match.
+// requires customers:write:any
+func LinkCustomerAccount(customers database.CustomerStore, accounts database.AccountStore, tickets database.TicketStore, interactions database.InteractionStore, deals database.DealStore, transactions database.Transactor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var body accountLink
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		customerId, err := primitive.ObjectIDFromHex(c.Param("customer_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+			return
+		}
+
+		var accountId *primitive.ObjectID
+		if body.AccountId != nil {
+			id, err := primitive.ObjectIDFromHex(*body.AccountId)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+				return
+			}
+
+			_, err = accounts.FindByID(ctx, id)
+			if errors.Is(err, database.ErrNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "account does not exist"})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching account"})
+				return
+			}
+			accountId = &id
+		}
+
+		err = transactions.RunInTransaction(ctx, func(ctx context.Context) error {
+			err := customers.Update(ctx, customerId.Hex(), bson.M{"account_id": accountId, "pending_account_id": nil, "updated_at": time.Now()})
+			if err != nil {
+				return err
+			}
+			if err := tickets.SetCustomerAccount(ctx, customerId, accountId); err != nil {
+				return err
+			}
+			if err := interactions.SetCustomerAccount(ctx, customerId, accountId); err != nil {
+				return err
+			}
+			return deals.SetCustomerAccount(ctx, customerId, accountId)
+		})
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating customer"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "customer account updated successfully"})
+	}
+}
@@ -0,0 +1,120 @@
+package controllers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+)
+
+func TestAccountDomainMatching(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	manager, managerToken := app.staff("manager", models.ROLE_MANAGER)
+	agent, agentToken := app.staff("agent", models.ROLE_AGENT)
+
+	response := app.do(http.MethodPost, "/staff/accounts", managerToken, map[string]any{"name": "Acme", "domain": "acme.com"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("create account got status %d: %s", response.Code, response.Body.String())
+	}
+	var account models.Account
+	decode(t, response, &account)
+
+	// signing up never makes anyone a contact, a matching domain waits for staff
+	signups := []struct {
+		email   string
+		pending bool
+	}{
+		{"jo@eu.acme.com", true},
+		{"sam@acme.com", true},
+		{"pat@gmail.com", false},
+		{"lee@acme.com.example.org", false},
+	}
+
+	for _, tt := range signups {
+		t.Run(tt.email, func(t *testing.T) {
+			body := map[string]any{"name": tt.email, "email": tt.email, "password": "secret"}
+			response := app.do(http.MethodPost, "/portal/signup", "", body)
+			if response.Code != http.StatusOK && response.Code != http.StatusCreated {
+				t.Fatalf("signup got status %d: %s", response.Code, response.Body.String())
+			}
+
+			customer, err := app.stores.Customers.FindByEmail(ctx, tt.email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if customer.AccountID != nil {
+				t.Fatalf("signup joined account %s", customer.AccountID.Hex())
+			}
+			if pending := customer.PendingAccountID != nil && *customer.PendingAccountID == account.ID; pending != tt.pending {
+				t.Fatalf("got pending match %v, want %v", pending, tt.pending)
+			}
+		})
+	}
+
+	alice, _ := app.customer("Alice", "alice@acme.com")
+	start := time.Now().Add(24 * time.Hour)
+	app.ticket(alice, app.interaction(agent, alice, "agent call", start), "alice is stuck")
+	app.interaction(manager, alice, "manager call", start.Add(2*time.Hour))
+
+	sam, err := app.stores.Customers.FindByEmail(ctx, "sam@acme.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// linking brings the customer's tickets and interactions along and confirms a pending match
+	for _, customer := range []models.Customer{alice, sam} {
+		response := app.do(http.MethodPut, "/staff/customers/"+customer.CustomerId+"/account", managerToken, map[string]any{"account_id": account.AccountId})
+		if response.Code != http.StatusOK {
+			t.Fatalf("link got status %d: %s", response.Code, response.Body.String())
+		}
+	}
+
+	tests := []struct {
+		name         string
+		token        string
+		interactions int
+	}{
+		{"manager sees every interaction", managerToken, 2},
+		{"agent sees their own", agentToken, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := app.do(http.MethodGet, "/staff/accounts/"+account.AccountId, tt.token, nil)
+			if response.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", response.Code, response.Body.String())
+			}
+
+			var summary helpers.AccountSummary
+			decode(t, response, &summary)
+			if summary.ContactCount != 2 || summary.PendingContactCount != 1 || summary.OpenTicketCount != 1 {
+				t.Fatalf("got %d contacts, %d pending and %d open tickets, want 2, 1 and 1", summary.ContactCount, summary.PendingContactCount, summary.OpenTicketCount)
+			}
+			if len(summary.RecentInteractions) != tt.interactions {
+				t.Fatalf("got %d interactions, want %d", len(summary.RecentInteractions), tt.interactions)
+			}
+		})
+	}
+
+	// deleting the account unlinks everything that pointed at it
+	response = app.do(http.MethodDelete, "/staff/accounts/"+account.AccountId, managerToken, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("delete got status %d: %s", response.Code, response.Body.String())
+	}
+
+	jo, err := app.stores.Customers.FindByEmail(ctx, "jo@eu.acme.com")
+	if err != nil || jo.PendingAccountID != nil {
+		t.Fatalf("signup still waits for the deleted account (%v)", err)
+	}
+
+	tickets, err := app.stores.Tickets.List(ctx, database.ListQuery{Filters: []database.Filter{database.Eq("account_id", account.ID)}, Limit: 10})
+	if err != nil || tickets.Total != 0 {
+		t.Fatalf("got %d tickets still on the deleted account (%v)", tickets.Total, err)
+	}
+}
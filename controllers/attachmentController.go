@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// findAccessibleInteraction loads the interaction named in the path, staff on
+// an "own" scope must have logged it and customers must be the one it was with.
+func findAccessibleInteraction(ctx context.Context, c *gin.Context, interactions database.InteractionStore) (models.Interaction, bool) {
+	return findAccessibleInteractionByID(ctx, c, interactions, c.Param("interaction_id"))
+}
+
+func findAccessibleInteractionByID(ctx context.Context, c *gin.Context, interactions database.InteractionStore, id string) (models.Interaction, bool) {
+	interactionId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interaction ID"})
+		return models.Interaction{}, false
+	}
+
+	interaction, err := interactions.FindByID(ctx, interactionId)
+	if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Interaction not found"})
+		return interaction, false
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching interaction"})
+		return interaction, false
+	}
+
+	if helpers.GetPrincipal(c).IsCustomer() {
+		err = helpers.MatchCustomerTypeToCid(c, interaction.CustomerID.Hex())
+	} else {
+		err = helpers.MatchUserTypeToUid(c, interaction.UserID.Hex())
+	}
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return interaction, false
+	}
+	return interaction, true
+}
+
+// saveUploadedAttachment reads the "file" form field and stores it against the
+// owner, it writes the response itself.
+func saveUploadedAttachment(ctx context.Context, c *gin.Context, attachments database.AttachmentStore, blobs database.BlobStore, ownerType string, ownerID primitive.ObjectID) {
+	// leave some room above the file limit for the multipart framing
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, helpers.ATTACHMENT_MAX_SIZE+1<<20)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": helpers.ErrAttachmentTooLarge.Error()})
+			return
+		}
+
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a file must be uploaded in the \"file\" form field"})
+		return
+	}
+
+	attachment, err := helpers.SaveAttachment(ctx, attachments, blobs, file, ownerType, ownerID, helpers.GetPrincipal(c))
+	switch {
+	case errors.Is(err, helpers.ErrAttachmentTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, helpers.ErrAttachmentTypeNotAllowed):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+	case errors.Is(err, helpers.ErrAttachmentRejected):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "attachment was not saved"})
+	default:
+		c.JSON(http.StatusCreated, attachment)
+	}
+}
+
+// signAttachment answers with a short lived download link for an attachment of the given owner.
+func signAttachment(ctx context.Context, c *gin.Context, attachments database.AttachmentStore, ownerType string, ownerID primitive.ObjectID) {
+	attachmentId, err := primitive.ObjectIDFromHex(c.Param("attachment_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment ID"})
+		return
+	}
+
+	attachment, err := attachments.FindByID(ctx, attachmentId)
+	if err != nil || attachment.OwnerType != ownerType || attachment.OwnerID != ownerID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+
+	url, expiresAt := helpers.SignAttachmentURL(attachment.AttachmentId, time.Now())
+	c.JSON(http.StatusOK, gin.H{"url": url, "expires_at": expiresAt})
+}
+
+// requires tickets:write
+func UploadTicketAttachment(tickets database.TicketStore, attachments database.AttachmentStore, blobs database.BlobStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+
+		ticket, ok := findAccessibleTicket(ctx, c, tickets)
+		if !ok {
+			return
+		}
+
+		saveUploadedAttachment(ctx, c, attachments, blobs, models.ATTACHMENT_TICKET, ticket.ID)
+	}
+}
+
+// requires tickets:read
+func GetTicketAttachments(tickets database.TicketStore, attachments database.AttachmentStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		ticket, ok := findAccessibleTicket(ctx, c, tickets)
+		if !ok {
+			return
+		}
+
+		ticketAttachments, err := attachments.ListByOwner(ctx, models.ATTACHMENT_TICKET, ticket.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing attachments"})
+			return
+		}
+
+		c.JSON(http.StatusOK, ticketAttachments)
+	}
+}
+
+// requires tickets:read
+func GetTicketAttachmentURL(tickets database.TicketStore, attachments database.AttachmentStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		ticket, ok := findAccessibleTicket(ctx, c, tickets)
+		if !ok {
+			return
+		}
+
+		signAttachment(ctx, c, attachments, models.ATTACHMENT_TICKET, ticket.ID)
+	}
+}
+
+// requires interactions:write
+func UploadInteractionAttachment(interactions database.InteractionStore, attachments database.AttachmentStore, blobs database.BlobStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+
+		interaction, ok := findAccessibleInteraction(ctx, c, interactions)
+		if !ok {
+			return
+		}
+
+		saveUploadedAttachment(ctx, c, attachments, blobs, models.ATTACHMENT_INTERACTION, interaction.ID)
+	}
+}
+
+// requires interactions:read
+func GetInteractionAttachments(interactions database.InteractionStore, attachments database.AttachmentStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		interaction, ok := findAccessibleInteraction(ctx, c, interactions)
+		if !ok {
+			return
+		}
+
+		interactionAttachments, err := attachments.ListByOwner(ctx, models.ATTACHMENT_INTERACTION, interaction.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing attachments"})
+			return
+		}
+
+		c.JSON(http.StatusOK, interactionAttachments)
+	}
+}
+
+// requires interactions:read
+func GetInteractionAttachmentURL(interactions database.InteractionStore, attachments database.AttachmentStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		interaction, ok := findAccessibleInteraction(ctx, c, interactions)
+		if !ok {
+			return
+		}
+
+		signAttachment(ctx, c, attachments, models.ATTACHMENT_INTERACTION, interaction.ID)
+	}
+}
+
+// DownloadAttachment serves a file to anyone holding a valid signed link, the
+// ownership checks happened when the link was issued.
+func DownloadAttachment(attachments database.AttachmentStore, blobs database.BlobStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		attachmentIdStr := c.Param("attachment_id")
+		if err := helpers.VerifyAttachmentSignature(attachmentIdStr, c.Query("expires"), c.Query("signature"), time.Now()); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		attachmentId, err := primitive.ObjectIDFromHex(attachmentIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment ID"})
+			return
+		}
+
+		attachment, err := attachments.FindByID(ctx, attachmentId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+			return
+		}
+
+		content, err := blobs.Get(ctx, attachment.StorageKey)
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while reading attachment"})
+			return
+		}
+		defer content.Close()
+
+		// always download, never render uploaded content inline, and never hand
+		// the browser a type it would run script in, whatever older uploads stored
+		contentType := attachment.ContentType
+		if helpers.IsActiveContentType(contentType) {
+			contentType = "application/octet-stream"
+		}
+
+		c.DataFromReader(http.StatusOK, attachment.Size, contentType, content, map[string]string{
+			"Content-Security-Policy": "sandbox",
+			"Content-Disposition":     mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}),
+			"X-Content-Type-Options":  "nosniff",
+			"ETag":                    strconv.Quote(attachment.Checksum),
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package controllers_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+)
+
+// upload posts content as the file field of a form, declared as contentType.
+func (a *testApp) upload(path, token, fileName, contentType string, content []byte) *httptest.ResponseRecorder {
+	a.t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="`+fileName+`"`)
+	header.Set("Content-Type", contentType)
+
+	part, err := form.CreatePart(header)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	part.Write(content)
+	form.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/api/v1"+path, &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	request.Header.Set("token", token)
+
+	response := httptest.NewRecorder()
+	a.handler.ServeHTTP(response, request)
+	return response
+}
+
+func TestAttachmentContentType(t *testing.T) {
+	app := newTestApp(t)
+
+	agent, _ := app.staff("agent", models.ROLE_AGENT)
+	alice, aliceToken := app.customer("Alice", "alice@example.com")
+	ticket := app.ticket(alice, app.interaction(agent, alice, "alice meeting", time.Now().Add(24*time.Hour)), "see attached")
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name     string
+		fileName string
+		declared string
+		content  []byte
+		status   int
+		want     string
+	}{
+		{"html declared as an image", "cat.png", "image/png", []byte("<!DOCTYPE html><script>alert(1)</script>"), http.StatusUnsupportedMediaType, ""},
+		{"svg declared as svg", "cat.svg", "image/svg+xml", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), http.StatusCreated, "text/plain"},
+		{"image declared as html", "cat.html", "text/html", png, http.StatusCreated, "image/png"},
+		{"csv narrows plain text", "rows.csv", "text/csv", []byte("a,b\n1,2\n"), http.StatusCreated, "text/csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := app.upload("/tickets/"+ticket.TicketId+"/attachments", aliceToken, tt.fileName, tt.declared, tt.content)
+			if response.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", response.Code, tt.status, response.Body.String())
+			}
+
+			if tt.want == "" {
+				return
+			}
+
+			var attachment models.Attachment
+			decode(t, response, &attachment)
+			if attachment.ContentType != tt.want {
+				t.Fatalf("got content type %s, want %s", attachment.ContentType, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CreateCalendarToken issues the current user a new calendar feed URL, any
+// earlier URL stops working.
+func CreateCalendarToken(users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		token, hash, err := helpers.NewCalendarToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = users.Update(ctx, helpers.GetPrincipal(c).Id, bson.M{"calendar_token_hash": hash, "updated_at": time.Now()})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while saving calendar token"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"url": "/api/v1/calendar/" + token + ".ics"})
+	}
+}
+
+// GetCalendarFeed serves a user's interactions from CALENDAR_FEED_PAST ago to
+// CALENDAR_FEED_AHEAD to calendar apps, the token in the URL is the only
+// credential they can send, so the user's role is checked on every fetch.
+func GetCalendarFeed(users database.UserStore, roles database.RoleStore, interactions database.InteractionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		token, ok := strings.CutSuffix(c.Param("token"), ".ics")
+		if !ok || token == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "calendar not found"})
+			return
+		}
+
+		user, err := users.FindByCalendarToken(ctx, helpers.HashCalendarToken(token))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "calendar not found"})
+			return
+		}
+
+		roleName := ""
+		if user.Role != nil {
+			roleName = *user.Role
+		}
+
+		role, err := roles.FindByName(ctx, roleName)
+		if err != nil || helpers.GrantedScope(role, "interactions:read") == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "calendar feed is not available to this user"})
+			return
+		}
+
+		now := time.Now()
+		query := database.ListQuery{
+			Filters: append(database.InteractionWindowFilters(now.Add(-helpers.CALENDAR_FEED_PAST), now.Add(helpers.CALENDAR_FEED_AHEAD)), database.Eq("user_id", user.ID)),
+			Sort:    []database.SortField{{Field: "start_time"}},
+			Limit:   helpers.CALENDAR_FEED_MAX,
+		}
+
+		page, err := interactions.List(ctx, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing interactions"})
+			return
+		}
+
+		organizer := ""
+		if user.Email != nil {
+			organizer = *user.Email
+		}
+
+		c.Header("Cache-Control", "private, max-age=300")
+		c.Data(http.StatusOK, "text/calendar; charset=utf-8", helpers.CalendarFeed(page.Items, organizer, now))
+	}
+}
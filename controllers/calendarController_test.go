@@ -0,0 +1,55 @@
+package controllers_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCalendarFeed(t *testing.T) {
+	app := newTestApp(t)
+
+	agent, _ := app.staff("agent", models.ROLE_AGENT)
+	alice, _ := app.customer("Alice", "alice@example.com")
+
+	now := time.Now()
+	app.interaction(agent, alice, "next week", now.Add(7*24*time.Hour))
+	app.interaction(agent, alice, "last month", now.Add(-30*24*time.Hour))
+	app.interaction(agent, alice, "in two years", now.Add(2*365*24*time.Hour))
+
+	token, hash, err := helpers.NewCalendarToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.stores.Users.Update(context.Background(), agent.UserId, bson.M{"calendar_token_hash": hash}); err != nil {
+		t.Fatal(err)
+	}
+
+	response := app.do(http.MethodGet, "/calendar/"+token+".ics", "", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", response.Code, response.Body.String())
+	}
+
+	feed := response.Body.String()
+	for title, want := range map[string]bool{"next week": true, "last month": false, "in two years": false} {
+		if got := strings.Contains(feed, "SUMMARY:"+title); got != want {
+			t.Errorf("feed lists %q: %v, want %v", title, got, want)
+		}
+	}
+
+	// a role that no longer reads interactions loses the feed with the token still valid
+	if err := app.stores.Users.Update(context.Background(), agent.UserId, bson.M{"role": "RETIRED"}); err != nil {
+		t.Fatal(err)
+	}
+
+	response = app.do(http.MethodGet, "/calendar/"+token+".ics", "", nil)
+	if response.Code != http.StatusForbidden {
+		t.Fatalf("got status %d, want %d", response.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var commentValidate = validator.New()
+
+// commentListSpec is what a ticket's conversation can be filtered and sorted by.
+var commentListSpec = helpers.ListSpec{
+	Fields: map[string]helpers.ListField{
+		"author_type": {Field: "author_type", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"created_at":  {Field: "created_at", Kind: helpers.LIST_FIELD_TIME, Filter: true, Sort: true},
+	},
+	DefaultSort: "created_at",
+}
+
+// GetTicketComments lists the ticket's conversation oldest first, internal
+// notes are left out for customers.
+func GetTicketComments(tickets database.TicketStore, comments database.CommentStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		query, err := helpers.ParseListQuery(c.Request.URL.Query(), commentListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ticket, ok := findAccessibleTicket(ctx, c, tickets)
+		if !ok {
+			return
+		}
+
+		query.Filters = append(query.Filters, database.Eq("ticket_id", ticket.ID))
+		if !helpers.GetPrincipal(c).IsUser() {
+			query.Filters = append(query.Filters, database.Eq("internal", false))
+		}
+
+		page, err := comments.List(ctx, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing comments"})
+			return
+		}
+
+		c.JSON(http.StatusOK, helpers.NewPageBody(query, page))
+	}
+}
+
+// CreateTicketComment posts a reply as the calling customer or staff user, the
+// first public staff reply counts as the ticket's first response. The customer
+// is emailed about public staff replies, an answer to that email is filed on the ticket.
+func CreateTicketComment(tickets database.TicketStore, comments database.CommentStore, customers database.CustomerStore, templates database.EmailTemplateStore, notifications database.NotificationStore, transactions database.Transactor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var comment models.TicketComment
+		if err := c.BindJSON(&comment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := commentValidate.Struct(comment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ticket, ok := findAccessibleTicket(ctx, c, tickets)
+		if !ok {
+			return
+		}
+
+		principal := helpers.GetPrincipal(c)
+
+		if comment.Internal && !principal.IsUser() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only staff can add internal notes"})
+			return
+		}
+
+		if ticket.Status != nil && *ticket.Status == models.TICKET_CLOSED {
+			c.JSON(http.StatusConflict, gin.H{"error": "ticket is closed, reopen it to continue the conversation"})
+			return
+		}
+
+		if comment.ParentID != nil {
+			parent, err := comments.FindByID(ctx, *comment.ParentID)
+			if err != nil || parent.TicketID != ticket.ID || (parent.Internal && !principal.IsUser()) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "parent comment does not exist on this ticket"})
+				return
+			}
+		}
+
+		comment.TicketID = ticket.ID
+		comment.CustomerID = ticket.CustomerID
+		comment.AssigneeID = ticket.AssigneeID
+		comment.AuthorType = principal.Type
+		comment.AuthorId = principal.Id
+		comment.AuthorName = principal.Name
+		comment.EditedAt = nil
+		comment.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		comment.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		comment.ID = primitive.NewObjectID()
+		comment.CommentId = comment.ID.Hex()
+
+		outbox := []models.Notification{}
+		if principal.IsUser() && !comment.Internal {
+			customer, err := customers.FindByID(ctx, ticket.CustomerID.Hex())
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching customer"})
+				return
+			}
+
+			if customer.Email != nil {
+				notification, err := helpers.TicketReplyNotification(ctx, templates, ticket, comment, helpers.CustomerContact(customer), time.Now())
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while writing notification emails"})
+					return
+				}
+				outbox = append(outbox, notification)
+			}
+		}
+
+		// the email is queued with the comment, a crash cannot lose it
+		insertErr := transactions.RunInTransaction(ctx, func(ctx context.Context) error {
+			if err := comments.Create(ctx, &comment); err != nil {
+				return err
+			}
+			return notifications.Enqueue(ctx, outbox)
+		})
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "comment was not created"})
+			return
+		}
+
+		if principal.IsUser() && !comment.Internal {
+			if err := helpers.RecordFirstResponse(ctx, tickets, ticket, time.Now()); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while recording first response"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusCreated, comment)
+	}
+}
+
+// UpdateTicketComment lets the author correct their comment within COMMENT_EDIT_WINDOW.
+func UpdateTicketComment(tickets database.TicketStore, comments database.CommentStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var body struct {
+			Body *string `json:"body" validate:"required,min=1,max=10000"`
+		}
+
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := commentValidate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ticket, ok := findAccessibleTicket(ctx, c, tickets)
+		if !ok {
+			return
+		}
+
+		commentId, err := primitive.ObjectIDFromHex(c.Param("comment_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+			return
+		}
+
+		comment, err := comments.FindByID(ctx, commentId)
+		if err != nil || comment.TicketID != ticket.ID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+			return
+		}
+
+		now := time.Now()
+		if err := helpers.CanEditComment(helpers.GetPrincipal(c), comment, now); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = comments.Update(ctx, commentId, bson.M{"body": body.Body, "edited_at": now, "updated_at": now})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating comment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "comment updated successfully"})
+	}
+}
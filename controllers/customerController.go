@@ -1,259 +1,352 @@
-package controllers
-
-import (
-	"context"
-	"fmt"
-	"log"
-	"net/http"
-	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
-	"github.com/roh4nyh/matrice_ai/database"
-	helper "github.com/roh4nyh/matrice_ai/helpers"
-	"github.com/roh4nyh/matrice_ai/models"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-)
-
-const (
-	customerdatabaseName   = "Cluster0"
-	customerCollectionName = "customers"
-)
-
-var customerValidate = validator.New()
-var CustomerCollection *mongo.Collection = database.OpenCollection(customerdatabaseName, customerCollectionName)
-
-func CustomerSignUp() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		var customer models.Customer
-		if err := c.BindJSON(&customer); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		validationErr := customerValidate.Struct(customer)
-		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
-			return
-		}
-
-		count, err := CustomerCollection.CountDocuments(ctx, bson.M{"email": customer.Email})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for email"})
-			log.Panic(err)
-		}
-
-		if count > 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "this email already exists"})
-			return
-		}
-
-		password := HashPassword(*customer.Password)
-		customer.Password = &password
-
-		customer.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		customer.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		customer.ID = primitive.NewObjectID()
-		customer.CustomerId = customer.ID.Hex()
-
-		token, _ := helper.GenerateCustomerToken(*customer.Email, *customer.Name, customer.CustomerId)
-		customer.Token = &token
-
-		resultInsertionNumber, insertErr := CustomerCollection.InsertOne(ctx, customer)
-		if insertErr != nil {
-			msg := fmt.Sprintln("Customer item was not created")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
-		}
-
-		c.JSON(http.StatusCreated, resultInsertionNumber)
-	}
-}
-
-func CustomerLogIn() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		var customer models.Customer
-		var foundCustomer models.Customer
-
-		if err := c.BindJSON(&customer); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		err := CustomerCollection.FindOne(ctx, bson.M{"email": customer.Email}).Decode(&foundCustomer)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "email or password is incorrect"})
-			return
-		}
-
-		passwordIsValid, msg := VerifyPassword(*customer.Password, *foundCustomer.Password)
-		if !passwordIsValid {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
-		}
-
-		if foundCustomer.Email == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "customer not found"})
-			return
-		}
-
-		token, err := helper.GenerateCustomerToken(*foundCustomer.Email, *foundCustomer.Name, foundCustomer.CustomerId)
-		if err != nil || token == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		helper.UpdateCustomerToken(token, foundCustomer.CustomerId)
-
-		err = CustomerCollection.FindOne(ctx, bson.M{"customer_id": foundCustomer.CustomerId}).Decode(&foundCustomer)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		c.JSON(http.StatusOK, foundCustomer)
-	}
-}
-
-func GetCustomers() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		var customers []models.Customer
-
-		cursor, err := CustomerCollection.Find(ctx, bson.M{})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing customers"})
-			return
-		}
-
-		if err = cursor.All(ctx, &customers); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding customer data"})
-			return
-		}
-
-		if len(customers) == 0 {
-			c.JSON(http.StatusOK, gin.H{"error": "no customers available"})
-			return
-		}
-
-		c.JSON(http.StatusOK, customers)
-	}
-}
-
-func GetCustomer() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		customerId := c.Param("customer_id")
-
-		if err := helper.MatchCustomerTypeToCid(c, customerId); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		var customer models.Customer
-		err := CustomerCollection.FindOne(ctx, bson.M{"customer_id": customerId}).Decode(&customer)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
-
-		if customer.Email == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "customer not found"})
-			return
-		}
-
-		c.JSON(http.StatusOK, customer)
-	}
-}
-
-func UpdateCustomer() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		customerId := c.Param("customer_id")
-
-		if err := helper.MatchCustomerTypeToCid(c, customerId); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		var customer models.Customer
-
-		if err := c.BindJSON(&customer); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		updateObj := bson.M{}
-
-		if customer.Name != nil {
-			updateObj["name"] = customer.Name
-		}
-
-		if customer.Email != nil {
-			updateObj["email"] = customer.Email
-		}
-
-		if customer.Company != nil {
-			updateObj["company"] = customer.Company
-		}
-
-		if customer.Phone != nil {
-			updateObj["phone"] = customer.Phone
-		}
-
-		if customer.Password != nil {
-			password := HashPassword(*customer.Password)
-			updateObj["password"] = password
-		}
-
-		updateObj["updated_at"] = time.Now()
-
-		filter := bson.M{"customer_id": bson.M{"$eq": customerId}}
-		update := bson.M{"$set": updateObj}
-
-		_, err := CustomerCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating customer"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "customer updated successfully"})
-	}
-}
-
-func DeleteCustomer() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		customerId := c.Param("customer_id")
-
-		if err := helper.MatchCustomerTypeToCid(c, customerId); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		filter := bson.M{"customer_id": bson.M{"$eq": customerId}}
-
-		_, err := CustomerCollection.DeleteOne(ctx, filter)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting customer"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "customer deleted successfully"})
-	}
-}
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/roh4nyh/matrice_ai/database"
+	helper "github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var customerValidate = validator.New()
+
+// CustomerSignUp registers a customer. Nothing proves they own their mailbox,
+// so one whose email is at an account's domain only waits for staff to link
+// them to it, see LinkCustomerAccount.
+func CustomerSignUp(customers database.CustomerStore, accounts database.AccountStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var customer models.Customer
+		if err := c.BindJSON(&customer); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := customerValidate.Struct(customer)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if err := helper.PreparePreferences(customer.TimeZone, customer.Locale); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		count, err := customers.CountByEmail(ctx, *customer.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for email"})
+			log.Panic(err)
+		}
+
+		if count > 0 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "this email already exists"})
+			return
+		}
+
+		customer.AccountID = nil
+		customer.PendingAccountID, err = helper.MatchAccount(ctx, accounts, *customer.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while matching account"})
+			return
+		}
+
+		password := HashPassword(*customer.Password)
+		customer.Password = &password
+
+		customer.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		customer.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		customer.ID = primitive.NewObjectID()
+		customer.CustomerId = customer.ID.Hex()
+
+		insertErr := customers.Create(ctx, &customer)
+		if insertErr != nil {
+			msg := fmt.Sprintln("Customer item was not created")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"InsertedID": customer.ID})
+	}
+}
+
+func CustomerLogIn(customers database.CustomerStore, sessions database.SessionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var customer models.Customer
+
+		if err := c.BindJSON(&customer); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if customer.Email == nil || customer.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
+
+		foundCustomer, err := customers.FindByEmail(ctx, *customer.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "email or password is incorrect"})
+			return
+		}
+
+		passwordIsValid, msg := VerifyPassword(*customer.Password, *foundCustomer.Password)
+		if !passwordIsValid {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return
+		}
+
+		if foundCustomer.Email == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "customer not found"})
+			return
+		}
+
+		session, refreshToken, err := helper.StartSession(ctx, sessions, models.PRINCIPAL_CUSTOMER, foundCustomer.CustomerId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		token, err := helper.GenerateCustomerToken(*foundCustomer.Email, *foundCustomer.Name, foundCustomer.CustomerId, session.SessionId)
+		if err != nil || token == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		foundCustomer.Token = &token
+		foundCustomer.RefreshToken = &refreshToken
+
+		c.JSON(http.StatusOK, foundCustomer)
+	}
+}
+
+// RefreshCustomerToken rotates the refresh token and issues a fresh access token
+func RefreshCustomerToken(customers database.CustomerStore, sessions database.SessionStore, revocations database.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if body.RefreshToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+			return
+		}
+
+		session, refreshToken, err := helper.RotateSession(ctx, sessions, revocations, models.PRINCIPAL_CUSTOMER, body.RefreshToken)
+		if errors.Is(err, helper.ErrInvalidRefreshToken) || errors.Is(err, helper.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		foundCustomer, err := customers.FindByID(ctx, session.PrincipalId)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "customer not found"})
+			return
+		}
+
+		token, err := helper.GenerateCustomerToken(*foundCustomer.Email, *foundCustomer.Name, foundCustomer.CustomerId, session.SessionId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+	}
+}
+
+// CustomerLogOut revokes the current session, the access token in hand stops working immediately
+func CustomerLogOut(sessions database.SessionStore, revocations database.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := helper.RevokeSession(ctx, sessions, revocations, c.GetString("sid")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while logging out"})
+			return
+		}
+
+		if err := revocations.Revoke(ctx, c.GetString("jti"), time.Unix(c.GetInt64("exp"), 0)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while logging out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
+	}
+}
+
+// customerListSpec is what GET /customers can be filtered and sorted by.
+var customerListSpec = helper.ListSpec{
+	Fields: map[string]helper.ListField{
+		"company":            {Field: "company", Kind: helper.LIST_FIELD_STRING, Filter: true, Sort: true},
+		"account_id":         {Field: "account_id", Kind: helper.LIST_FIELD_ID, Filter: true},
+		"pending_account_id": {Field: "pending_account_id", Kind: helper.LIST_FIELD_ID, Filter: true},
+		"email":              {Field: "email", Kind: helper.LIST_FIELD_STRING, Filter: true, Sort: true},
+		"name":               {Field: "name", Kind: helper.LIST_FIELD_STRING, Sort: true},
+		"created_at":         {Field: "created_at", Kind: helper.LIST_FIELD_TIME, Filter: true, Sort: true},
+	},
+	DefaultSort: "-created_at",
+}
+
+func GetCustomers(customers database.CustomerStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		query, err := helper.ParseListQuery(c.Request.URL.Query(), customerListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		page, err := customers.List(ctx, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing customers"})
+			return
+		}
+
+		c.JSON(http.StatusOK, helper.NewPageBody(query, page))
+	}
+}
+
+func GetCustomer(customers database.CustomerStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		customerId := c.Param("customer_id")
+
+		if err := helper.MatchCustomerTypeToCid(c, customerId); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		customer, err := customers.FindByID(ctx, customerId)
+		if errors.Is(err, database.ErrNotFound) || customer.Email == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "customer not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, customer)
+	}
+}
+
+func UpdateCustomer(customers database.CustomerStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		customerId := c.Param("customer_id")
+
+		if err := helper.MatchCustomerTypeToCid(c, customerId); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var customer models.Customer
+
+		if err := c.BindJSON(&customer); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updateObj := bson.M{}
+
+		if customer.Name != nil {
+			updateObj["name"] = customer.Name
+		}
+
+		if customer.Email != nil {
+			updateObj["email"] = customer.Email
+		}
+
+		if customer.Company != nil {
+			updateObj["company"] = customer.Company
+		}
+
+		if customer.Phone != nil {
+			updateObj["phone"] = customer.Phone
+		}
+
+		if customer.Password != nil {
+			password := HashPassword(*customer.Password)
+			updateObj["password"] = password
+		}
+
+		if err := helper.PreparePreferences(customer.TimeZone, customer.Locale); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if customer.TimeZone != nil {
+			updateObj["time_zone"] = customer.TimeZone
+		}
+
+		if customer.Locale != nil {
+			updateObj["locale"] = customer.Locale
+		}
+
+		updateObj["updated_at"] = time.Now()
+
+		err := customers.Update(ctx, customerId, updateObj)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating customer"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "customer updated successfully"})
+	}
+}
+
+func DeleteCustomer(customers database.CustomerStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		customerId := c.Param("customer_id")
+
+		if err := helper.MatchCustomerTypeToCid(c, customerId); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err := customers.Delete(ctx, customerId)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting customer"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "customer deleted successfully"})
+	}
+}
@@ -0,0 +1,613 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var dealValidate = validator.New()
+
+// dealListSpec is what the deal lists can be filtered and sorted by.
+var dealListSpec = helpers.ListSpec{
+	Fields: map[string]helpers.ListField{
+		"pipeline":            {Field: "pipeline", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"stage":               {Field: "stage", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"status":              {Field: "status", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"currency":            {Field: "currency", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"owner_id":            {Field: "owner_id", Kind: helpers.LIST_FIELD_ID, Filter: true},
+		"customer_id":         {Field: "customer_id", Kind: helpers.LIST_FIELD_ID, Filter: true},
+		"account_id":          {Field: "account_id", Kind: helpers.LIST_FIELD_ID, Filter: true},
+		"amount":              {Field: "amount", Sort: true},
+		"expected_close_date": {Field: "expected_close_date", Kind: helpers.LIST_FIELD_STRING, Sort: true},
+		"created_at":          {Field: "created_at", Kind: helpers.LIST_FIELD_TIME, Filter: true, Sort: true},
+		"updated_at":          {Field: "updated_at", Kind: helpers.LIST_FIELD_TIME, Sort: true},
+	},
+	DefaultSort: "-updated_at",
+}
+
+// findAccessibleDeal loads the deal named in the path, callers with an "own"
+// scope only reach the deals they own. It writes the error response itself.
+func findAccessibleDeal(ctx context.Context, c *gin.Context, deals database.DealStore) (models.Deal, bool) {
+	dealId, err := primitive.ObjectIDFromHex(c.Param("deal_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deal ID"})
+		return models.Deal{}, false
+	}
+
+	deal, err := deals.FindByID(ctx, dealId)
+	if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "deal not found"})
+		return deal, false
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching deal"})
+		return deal, false
+	}
+
+	owner := ""
+	if deal.OwnerID != nil {
+		owner = deal.OwnerID.Hex()
+	}
+
+	if err := helpers.MatchUserTypeToUid(c, owner); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return deal, false
+	}
+	return deal, true
+}
+
+// pickDealStage finds the named stage of a pipeline, or the stage new deals
+// start in when stageName is empty. It writes the error response itself.
+func pickDealStage(ctx context.Context, c *gin.Context, pipelines database.PipelineStore, pipelineName, stageName string) (models.PipelineStage, bool) {
+	pipeline, err := pipelines.FindByName(ctx, pipelineName)
+	if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("pipeline %s does not exist", pipelineName)})
+		return models.PipelineStage{}, false
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching pipeline"})
+		return models.PipelineStage{}, false
+	}
+
+	var stage models.PipelineStage
+	var ok bool
+	if stageName == "" {
+		stage, ok = helpers.FirstOpenStage(pipeline)
+	} else {
+		stage, ok = helpers.FindStage(pipeline, stageName)
+	}
+
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("pipeline %s has no stage %s", pipelineName, stageName)})
+		return stage, false
+	}
+	return stage, true
+}
+
+// resolveDealParties checks the deal's customer and account exist, a deal with
+// only a customer goes to the customer's account. It writes the error response itself.
+func resolveDealParties(ctx context.Context, c *gin.Context, customers database.CustomerStore, accounts database.AccountStore, deal *models.Deal) bool {
+	if deal.CustomerID == nil && deal.AccountID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a deal needs a customer_id or an account_id"})
+		return false
+	}
+
+	if deal.CustomerID != nil {
+		customer, err := customers.FindByID(ctx, deal.CustomerID.Hex())
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "customer does not exist"})
+			return false
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching customer"})
+			return false
+		}
+
+		if deal.AccountID == nil {
+			deal.AccountID = customer.AccountID
+		} else if customer.AccountID == nil || *customer.AccountID != *deal.AccountID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "customer is not a contact of this account"})
+			return false
+		}
+	}
+
+	if deal.AccountID != nil {
+		_, err := accounts.FindByID(ctx, *deal.AccountID)
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account does not exist"})
+			return false
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching account"})
+			return false
+		}
+	}
+	return true
+}
+
+// checkDealLink makes sure an interaction with customer may be linked to the
+// deal and the caller may read the deal, callers with deals:read:own only
+// their own. It writes the error response itself.
+func checkDealLink(ctx context.Context, c *gin.Context, deals database.DealStore, roles database.RoleStore, customer models.Customer, dealId primitive.ObjectID) bool {
+	deal, err := deals.FindByID(ctx, dealId)
+	if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deal does not exist"})
+		return false
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching deal"})
+		return false
+	}
+
+	principal := helpers.GetPrincipal(c)
+	role, err := roles.FindByName(ctx, principal.Role)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "UnAuthenticated to access this resource"})
+		return false
+	}
+
+	scope := helpers.GrantedScope(role, "deals:read")
+	if scope == "" || (scope == models.SCOPE_OWN && (deal.OwnerID == nil || deal.OwnerID.Hex() != principal.Id)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "UnAuthenticated to access this resource"})
+		return false
+	}
+
+	if !helpers.DealCoversCustomer(deal, customer) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deal is not with this customer or their account"})
+		return false
+	}
+	return true
+}
+
+// listDeals writes one page of the deals matching the query and scope, it
+// writes the error response itself.
+func listDeals(ctx context.Context, c *gin.Context, deals database.DealStore, scope ...database.Filter) {
+	query, err := helpers.ParseListQuery(c.Request.URL.Query(), dealListSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query.Filters = append(query.Filters, scope...)
+
+	page, err := deals.List(ctx, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing deals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, helpers.NewPageBody(query, page))
+}
+
+// requires deals:read:any
+func GetDeals(deals database.DealStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		listDeals(ctx, c, deals)
+	}
+}
+
+// GetMyDeals lists the deals the caller owns.
+// requires deals:read
+func GetMyDeals(deals database.DealStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		ownerId, err := primitive.ObjectIDFromHex(helpers.GetPrincipal(c).Id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		listDeals(ctx, c, deals, database.Eq("owner_id", ownerId))
+	}
+}
+
+// CreateDeal opens a deal with a customer or account, owned by the caller
+// unless owner_id names someone else. It starts in the first open stage of
+// its pipeline, or DEAL_DEFAULT_PIPELINE, unless stage says otherwise.
+// requires deals:write
+func CreateDeal(deals database.DealStore, pipelines database.PipelineStore, users database.UserStore, customers database.CustomerStore, accounts database.AccountStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var deal models.Deal
+		if err := c.BindJSON(&deal); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := dealValidate.Struct(deal); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !resolveDealParties(ctx, c, customers, accounts, &deal) {
+			return
+		}
+
+		if deal.Pipeline == "" {
+			deal.Pipeline = helpers.DEAL_DEFAULT_PIPELINE
+		}
+
+		stage, ok := pickDealStage(ctx, c, pipelines, deal.Pipeline, deal.Stage)
+		if !ok {
+			return
+		}
+
+		principal := helpers.GetPrincipal(c)
+		if deal.OwnerID == nil {
+			ownerId, err := primitive.ObjectIDFromHex(principal.Id)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+				return
+			}
+			deal.OwnerID = &ownerId
+		}
+
+		if !validateOwner(ctx, c, users, *deal.OwnerID) {
+			return
+		}
+
+		if deal.Currency == "" {
+			deal.Currency = helpers.DEAL_DEFAULT_CURRENCY
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		deal.ID = primitive.NewObjectID()
+		deal.DealId = deal.ID.Hex()
+		deal.Stage = stage.Name
+		deal.Status = helpers.DealStatus(stage)
+		deal.ClosedAt = nil
+		deal.StageHistory = []models.DealStageChange{{Pipeline: deal.Pipeline, To: stage.Name, ActorId: principal.Id, ChangedAt: now}}
+		deal.CreatedAt = now
+		deal.UpdatedAt = now
+
+		// a deal created in a won or lost stage closed today
+		if deal.Status != models.DEAL_OPEN {
+			deal.ClosedAt = &now
+			deal.ExpectedCloseDate = now.UTC().Format(helpers.DEAL_DATE_LAYOUT)
+		}
+
+		if err := deals.Create(ctx, &deal); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "deal was not created"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, deal)
+	}
+}
+
+// requires deals:read
+func GetDeal(deals database.DealStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		deal, ok := findAccessibleDeal(ctx, c, deals)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, deal)
+	}
+}
+
+type dealUpdate struct {
+	Title    *string             `json:"title" validate:"omitempty,min=1"`
+	OwnerID  *primitive.ObjectID `json:"owner_id"`
+	Amount   *int64              `json:"amount" validate:"omitempty,gte=0"`
+	Currency *string             `json:"currency" validate:"omitempty,iso4217"`
+	// ExpectedCloseDate "" clears the date
+	ExpectedCloseDate *string `json:"expected_close_date" validate:"omitempty,datetime=2006-01-02"`
+	Pipeline          *string `json:"pipeline"`
+	Stage             *string `json:"stage"`
+	// Note is kept with the stage change
+	Note string `json:"note" validate:"max=1000"`
+}
+
+// UpdateDeal edits a deal and moves it between stages, a move to another
+// pipeline without a stage goes to its first open stage. Every move is kept
+// in the stage history, moving to a won or lost stage closes the deal today.
+// requires deals:write
+func UpdateDeal(deals database.DealStore, pipelines database.PipelineStore, users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		deal, ok := findAccessibleDeal(ctx, c, deals)
+		if !ok {
+			return
+		}
+
+		var body dealUpdate
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		clearCloseDate := body.ExpectedCloseDate != nil && *body.ExpectedCloseDate == ""
+		if clearCloseDate {
+			body.ExpectedCloseDate = nil
+		}
+
+		if err := dealValidate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now := time.Now()
+		updateObj := bson.M{}
+
+		if body.Title != nil {
+			updateObj["title"] = body.Title
+		}
+
+		if body.Amount != nil {
+			updateObj["amount"] = *body.Amount
+		}
+
+		if body.Currency != nil {
+			updateObj["currency"] = *body.Currency
+		}
+
+		if clearCloseDate {
+			updateObj["expected_close_date"] = nil
+		}
+
+		if body.ExpectedCloseDate != nil {
+			updateObj["expected_close_date"] = *body.ExpectedCloseDate
+		}
+
+		if body.OwnerID != nil {
+			if !validateOwner(ctx, c, users, *body.OwnerID) {
+				return
+			}
+			updateObj["owner_id"] = body.OwnerID
+		}
+
+		pipelineName := deal.Pipeline
+		if body.Pipeline != nil {
+			pipelineName = *body.Pipeline
+		}
+
+		var move *models.DealStageChange
+		var status string
+		var closedAt *time.Time
+		if pipelineName != deal.Pipeline || (body.Stage != nil && *body.Stage != deal.Stage) {
+			stageName := ""
+			if body.Stage != nil {
+				stageName = *body.Stage
+			}
+
+			stage, ok := pickDealStage(ctx, c, pipelines, pipelineName, stageName)
+			if !ok {
+				return
+			}
+
+			move = &models.DealStageChange{FromPipeline: deal.Pipeline, Pipeline: pipelineName, From: deal.Stage, To: stage.Name, ActorId: helpers.GetPrincipal(c).Id, Note: body.Note, ChangedAt: now}
+			status = helpers.DealStatus(stage)
+
+			if status != models.DEAL_OPEN {
+				closedAt = &now
+				updateObj["expected_close_date"] = now.UTC().Format(helpers.DEAL_DATE_LAYOUT)
+			}
+		}
+
+		updateObj["updated_at"] = now
+
+		// a move saves the edits with it, so a lost race saves neither
+		var err error
+		if move == nil {
+			err = deals.Update(ctx, deal.ID, updateObj)
+		} else {
+			err = deals.MoveStage(ctx, deal.ID, *move, status, closedAt, updateObj)
+			if errors.Is(err, database.ErrNotFound) {
+				err = helpers.ErrDealMovedMeanwhile
+			}
+		}
+		if errors.Is(err, helpers.ErrDealMovedMeanwhile) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "deal not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating deal"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "deal updated successfully"})
+	}
+}
+
+// DeleteDeal removes a deal, the interactions that were part of it stay.
+// requires deals:delete
+func DeleteDeal(deals database.DealStore, interactions database.InteractionStore, transactions database.Transactor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		deal, ok := findAccessibleDeal(ctx, c, deals)
+		if !ok {
+			return
+		}
+
+		err := transactions.RunInTransaction(ctx, func(ctx context.Context) error {
+			if err := deals.Delete(ctx, deal.ID); err != nil {
+				return err
+			}
+			return interactions.ClearDeal(ctx, deal.ID)
+		})
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting deal"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "deal deleted successfully"})
+	}
+}
+
+// forecastMonths reads ?from= and ?to=, "2006-01" months that default to the
+// current month and the five after it. It writes the error response itself.
+func forecastMonths(c *gin.Context) (from, to time.Time, ok bool) {
+	now := time.Now().UTC()
+	from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	if value := c.Query("from"); value != "" {
+		month, err := time.Parse(helpers.DEAL_MONTH_LAYOUT, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a month like 2006-01"})
+			return from, to, false
+		}
+		from = month
+	}
+
+	to = from.AddDate(0, 5, 0)
+	if value := c.Query("to"); value != "" {
+		month, err := time.Parse(helpers.DEAL_MONTH_LAYOUT, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a month like 2006-01"})
+			return from, to, false
+		}
+		to = month
+	}
+
+	if to.Before(from) || !to.Before(from.AddDate(0, helpers.DEAL_FORECAST_MAX_MONTHS, 0)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("to must be from or one of the %d months after it", helpers.DEAL_FORECAST_MAX_MONTHS-1)})
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// GetDealForecast sums the open and won deals expected to close per owner,
+// month and currency, see helpers.ForecastDeals. ?owner_id= and ?pipeline=
+// narrow it, callers who may only read their own deals get theirs.
+// requires deals:read
+func GetDealForecast(deals database.DealStore, pipelines database.PipelineStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		from, to, ok := forecastMonths(c)
+		if !ok {
+			return
+		}
+
+		filters := []database.Filter{}
+
+		owner := c.Query("owner_id")
+		if owner == "" && c.GetString("scope") != models.SCOPE_ANY {
+			owner = helpers.GetPrincipal(c).Id
+		}
+
+		if owner != "" {
+			if err := helpers.MatchUserTypeToUid(c, owner); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			ownerId, err := primitive.ObjectIDFromHex(owner)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "owner_id must be an ID"})
+				return
+			}
+			filters = append(filters, database.Eq("owner_id", ownerId))
+		}
+
+		if pipeline := c.Query("pipeline"); pipeline != "" {
+			filters = append(filters, database.Eq("pipeline", pipeline))
+		}
+
+		forecast, err := helpers.ForecastDeals(ctx, deals, pipelines, from, to, filters)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while forecasting deals"})
+			return
+		}
+
+		c.JSON(http.StatusOK, forecast)
+	}
+}
+
+type dealLink struct {
+	// DealId is the deal the interaction was part of, null unlinks it
+	DealId *string `json:"deal_id"`
+}
+
+// LinkInteractionDeal makes an interaction part of a deal with its customer
+// or their account, or of none.
+// requires interactions:write
+func LinkInteractionDeal(interactions database.InteractionStore, customers database.CustomerStore, deals database.DealStore, roles database.RoleStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var body dealLink
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		interaction, ok := findAccessibleInteraction(ctx, c, interactions)
+		if !ok {
+			return
+		}
+
+		var dealId *primitive.ObjectID
+		if body.DealId != nil {
+			id, err := primitive.ObjectIDFromHex(*body.DealId)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deal ID"})
+				return
+			}
+
+			customer, err := customers.FindByID(ctx, interaction.CustomerID.Hex())
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching customer"})
+				return
+			}
+
+			if !checkDealLink(ctx, c, deals, roles, customer, id) {
+				return
+			}
+			dealId = &id
+		}
+
+		err := interactions.Update(ctx, interaction.ID, bson.M{"deal_id": dealId, "updated_at": time.Now()})
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Interaction not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating interaction"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "interaction deal updated successfully"})
+	}
+}
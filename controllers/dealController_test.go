@@ -0,0 +1,84 @@
+package controllers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+)
+
+func TestDealLinks(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	owner, ownerToken := app.staff("owner", models.ROLE_AGENT)
+	other, otherToken := app.staff("other", models.ROLE_AGENT)
+	_, managerToken := app.staff("manager", models.ROLE_MANAGER)
+	alice, _ := app.customer("Alice", "alice@acme.com")
+
+	response := app.do(http.MethodPost, "/staff/accounts", managerToken, map[string]any{"name": "Acme", "domain": "acme.com"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("create account got status %d: %s", response.Code, response.Body.String())
+	}
+	var account models.Account
+	decode(t, response, &account)
+
+	link := func(account any) {
+		t.Helper()
+
+		response := app.do(http.MethodPut, "/staff/customers/"+alice.CustomerId+"/account", managerToken, map[string]any{"account_id": account})
+		if response.Code != http.StatusOK {
+			t.Fatalf("link got status %d: %s", response.Code, response.Body.String())
+		}
+	}
+	link(account.AccountId)
+
+	response = app.do(http.MethodPost, "/staff/deals", ownerToken, map[string]any{"title": "renewal", "customer_id": alice.CustomerId, "amount": 100})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("create deal got status %d: %s", response.Code, response.Body.String())
+	}
+	var deal models.Deal
+	decode(t, response, &deal)
+
+	// only callers who may read the deal link interactions to it
+	start := time.Now().Add(24 * time.Hour)
+	tests := []struct {
+		name        string
+		interaction models.Interaction
+		token       string
+		status      int
+	}{
+		{"another agent's interaction", app.interaction(other, alice, "other call", start), otherToken, http.StatusForbidden},
+		{"the owner's interaction", app.interaction(owner, alice, "owner call", start.Add(2*time.Hour)), ownerToken, http.StatusOK},
+		{"a manager", app.interaction(other, alice, "manager call", start.Add(4*time.Hour)), managerToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/staff/interactions/" + tt.interaction.InteractionId + "/deal"
+			response := app.do(http.MethodPut, path, tt.token, map[string]any{"deal_id": deal.DealId})
+			if response.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", response.Code, tt.status, response.Body.String())
+			}
+		})
+	}
+
+	// the account keeps its deals, unlinking the customer takes the deal off it
+	response = app.do(http.MethodDelete, "/staff/accounts/"+account.AccountId, managerToken, nil)
+	if response.Code != http.StatusConflict {
+		t.Fatalf("delete with a deal got status %d: %s", response.Code, response.Body.String())
+	}
+
+	link(nil)
+	saved, err := app.stores.Deals.FindByID(ctx, deal.ID)
+	if err != nil || saved.AccountID != nil {
+		t.Fatalf("deal still on account %v (%v)", saved.AccountID, err)
+	}
+
+	response = app.do(http.MethodDelete, "/staff/accounts/"+account.AccountId, managerToken, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("delete got status %d: %s", response.Code, response.Body.String())
+	}
+}
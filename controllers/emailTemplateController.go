@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// emailTemplateView is a template as emails are rendered with it, Edited tells
+// an admin's version from the default.
+type emailTemplateView struct {
+	Locale    string     `json:"locale"`
+	Name      string     `json:"name"`
+	Source    string     `json:"source"`
+	Edited    bool       `json:"edited"`
+	UpdatedBy string     `json:"updated_by,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// GetEmailTemplates lists the templates of every locale that has any
+// requires templates:read
+func GetEmailTemplates(templates database.EmailTemplateStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		edited, err := templates.List(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing email templates"})
+			return
+		}
+
+		views := []emailTemplateView{}
+		for _, locale := range helpers.EmailTemplates.Locales() {
+			for _, name := range utils.EmailTemplateNames {
+				if source, ok := helpers.EmailTemplates[locale][name]; ok {
+					views = append(views, emailTemplateView{Locale: locale, Name: name, Source: source})
+				}
+			}
+		}
+
+		for _, template := range edited {
+			view := emailTemplateView{
+				Locale:    template.Locale,
+				Name:      template.Name,
+				Source:    template.Source,
+				Edited:    true,
+				UpdatedBy: template.UpdatedBy,
+				UpdatedAt: &template.UpdatedAt,
+			}
+
+			i := slices.IndexFunc(views, func(v emailTemplateView) bool { return v.Locale == view.Locale && v.Name == view.Name })
+			if i >= 0 {
+				views[i] = view
+			} else {
+				views = append(views, view)
+			}
+		}
+
+		c.JSON(http.StatusOK, views)
+	}
+}
+
+// GetEmailTemplate returns the template emails in the locale are rendered with,
+// which may come from its language or the default locale
+// requires templates:read
+func GetEmailTemplate(templates database.EmailTemplateStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		locale, name, ok := emailTemplateParams(c)
+		if !ok {
+			return
+		}
+
+		current, err := helpers.CurrentEmailTemplates(ctx, templates)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while loading email templates"})
+			return
+		}
+
+		source, foundIn, ok := current.Lookup(locale, name)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "email template not found"})
+			return
+		}
+
+		view := emailTemplateView{Locale: foundIn, Name: name, Source: source}
+		if template, err := templates.Find(ctx, foundIn, name); err == nil {
+			view.Edited = true
+			view.UpdatedBy = template.UpdatedBy
+			view.UpdatedAt = &template.UpdatedAt
+		}
+
+		c.JSON(http.StatusOK, view)
+	}
+}
+
+// PutEmailTemplate replaces a template for one locale, every email is rendered
+// with it first and a template that fails is refused
+// requires templates:write:any
+func PutEmailTemplate(templates database.EmailTemplateStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		locale, name, ok := emailTemplateParams(c)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			Source string `json:"source"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if strings.TrimSpace(body.Source) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "source is required"})
+			return
+		}
+
+		current, err := helpers.CurrentEmailTemplates(ctx, templates)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while loading email templates"})
+			return
+		}
+
+		current.Set(locale, name, body.Source)
+		if err := helpers.CheckEmailTemplates(current); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now := time.Now()
+		template := models.EmailTemplate{
+			ID:        primitive.NewObjectID(),
+			Locale:    locale,
+			Name:      name,
+			Source:    body.Source,
+			UpdatedBy: helpers.GetPrincipal(c).Id,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		template.TemplateId = template.ID.Hex()
+
+		if err := templates.Save(ctx, template); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while saving email template"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "email template saved successfully"})
+	}
+}
+
+// DeleteEmailTemplate drops an admin's version, emails go back to the default
+// requires templates:write:any
+func DeleteEmailTemplate(templates database.EmailTemplateStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		locale, name, ok := emailTemplateParams(c)
+		if !ok {
+			return
+		}
+
+		err := templates.Delete(ctx, locale, name)
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "email template was not edited"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting email template"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "email template reset to the default"})
+	}
+}
+
+// emailTemplateParams reads :locale and :name, it writes the error response itself.
+func emailTemplateParams(c *gin.Context) (locale, name string, ok bool) {
+	locale, name = c.Param("locale"), c.Param("name")
+
+	if !utils.IsTemplateLocale(locale) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "locale must be a language like de or a language and region like de-AT"})
+		return "", "", false
+	}
+
+	if !slices.Contains(utils.EmailTemplateNames, name) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "email template not found"})
+		return "", "", false
+	}
+	return locale, name, true
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetUserFreeBusy lists when a staff member is busy and free between ?from= and
+// ?to=, ?customer_id= adds the customer's interactions so the free slots suit
+// both and ?duration= drops free slots shorter than a meeting would take. Slots
+// are given in the viewer's time zone. Only times are shown, so any staff member
+// may look up any other.
+// requires interactions:read
+func GetUserFreeBusy(users database.UserStore, customers database.CustomerStore, interactions database.InteractionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		from, to, windowed, ok := interactionWindowQuery(c)
+		if !ok {
+			return
+		}
+
+		if !windowed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to are required"})
+			return
+		}
+
+		minLength := time.Duration(0)
+		if value := c.Query("duration"); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "duration must be a positive duration such as 30m"})
+				return
+			}
+			minLength = d
+		}
+
+		loc, ok := viewerLocation(ctx, c, users)
+		if !ok {
+			return
+		}
+
+		user, err := users.FindByID(ctx, c.Param("user_id"))
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching user"})
+			return
+		}
+
+		customerID := primitive.NilObjectID
+		if value := c.Query("customer_id"); value != "" {
+			customer, err := customers.FindByID(ctx, value)
+			if errors.Is(err, database.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching customer"})
+				return
+			}
+			customerID = customer.ID
+		}
+
+		schedule, err := participantSchedule(ctx, interactions, user.ID, customerID, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing interactions"})
+			return
+		}
+
+		busy := helpers.BusySlots(schedule, from, to)
+		free := helpers.FreeSlots(busy, from, to, minLength)
+
+		c.JSON(http.StatusOK, gin.H{
+			"from": from.In(loc),
+			"to":   to.In(loc),
+			"busy": helpers.SlotsIn(busy, loc),
+			"free": helpers.SlotsIn(free, loc),
+		})
+	}
+}
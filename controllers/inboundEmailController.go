@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/matrice_ai/helpers"
+)
+
+// ReceiveInboundEmail files a raw RFC 5322 email a mail provider posts as the
+// request body, the provider authenticates with INBOUND_EMAIL_SECRET as a bearer token.
+func ReceiveInboundEmail(mailbox *helpers.Mailbox) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		if helpers.INBOUND_EMAIL_SECRET == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "inbound email is not enabled"})
+			return
+		}
+
+		token, bearer := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !bearer || subtle.ConstantTimeCompare([]byte(token), []byte(helpers.INBOUND_EMAIL_SECRET)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid inbound email secret"})
+			return
+		}
+
+		body := http.MaxBytesReader(c.Writer, c.Request.Body, helpers.INBOUND_EMAIL_MAX_SIZE)
+		result, err := mailbox.Receive(ctx, body)
+
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "email is too large"})
+		case errors.Is(err, helpers.ErrMalformedEmail):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, helpers.ErrUnknownSender):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		case errors.Is(err, helpers.ErrEmailInFiling):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, helpers.ErrQueueBusy):
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while filing email"})
+		default:
+			c.JSON(http.StatusOK, result)
+		}
+	}
+}
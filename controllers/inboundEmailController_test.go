@@ -0,0 +1,103 @@
+package controllers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/utils"
+)
+
+// inbound posts a raw email to the inbound email webhook.
+func (a *testApp) inbound(from, messageID, inReplyTo, subject, text string) helpers.InboundResult {
+	a.t.Helper()
+
+	raw := fmt.Sprintf("From: %s\r\nTo: support@crm.test\r\nSubject: %s\r\nMessage-ID: <%s>\r\n", from, subject, messageID)
+	if inReplyTo != "" {
+		raw += fmt.Sprintf("In-Reply-To: <%s>\r\n", inReplyTo)
+	}
+	raw += "Date: " + time.Now().Format(time.RFC1123Z) + "\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n" + text + "\r\n"
+
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/inbound/email", strings.NewReader(raw))
+	request.Header.Set("Authorization", "Bearer "+helpers.INBOUND_EMAIL_SECRET)
+
+	response := httptest.NewRecorder()
+	a.handler.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		a.t.Fatalf("got status %d filing the email: %s", response.Code, response.Body.String())
+	}
+
+	var result helpers.InboundResult
+	decode(a.t, response, &result)
+	return result
+}
+
+func TestInboundEmailThreading(t *testing.T) {
+	secret := helpers.INBOUND_EMAIL_SECRET
+	helpers.INBOUND_EMAIL_SECRET = "test-inbound-secret"
+	t.Cleanup(func() { helpers.INBOUND_EMAIL_SECRET = secret })
+
+	app := newTestApp(t)
+
+	agent, _ := app.staff("agent", models.ROLE_AGENT)
+	_, adminToken := app.staff("admin", models.ROLE_ADMIN)
+	alice, _ := app.customer("Alice", "alice@example.com")
+	app.customer("Bob", "bob@example.com")
+	ticket := app.ticket(alice, app.interaction(agent, alice, "setup", time.Now().Add(time.Hour)), "the printer is broken")
+
+	response := app.do(http.MethodPost, "/tickets/"+ticket.TicketId+"/comments", adminToken, map[string]any{"body": "try turning it off and on"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want 201: %s", response.Code, response.Body.String())
+	}
+
+	mailer := utils.NewCaptureMailer("support@crm.test")
+	if err := helpers.DeliverNotifications(app.stores.Notifications, mailer)(context.Background(), models.Job{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reply the customer gets carries the ticket in its subject and Message-ID
+	messages := mailer.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d emails, want the reply", len(messages))
+	}
+	reply := messages[0]
+	if !strings.Contains(reply.Subject, "[#"+ticket.TicketId+"]") {
+		t.Fatalf("subject %q has no ticket token", reply.Subject)
+	}
+	if !strings.HasPrefix(reply.ID, "ticket-"+ticket.TicketId) {
+		t.Fatalf("Message-ID %q does not name the ticket", reply.ID)
+	}
+
+	answer := app.inbound("Alice <alice@example.com>", "answer-1@example.com", reply.ID+"@crm.test", "Re: your ticket", "It works now.")
+	if answer.Action != helpers.INBOUND_COMMENT_ADDED || answer.TicketId != ticket.TicketId {
+		t.Fatalf("got %+v, want a comment on ticket %s", answer, ticket.TicketId)
+	}
+
+	tests := []struct {
+		name   string
+		from   string
+		action string
+	}{
+		{"the same email delivered again", "alice@example.com", helpers.INBOUND_COMMENT_ADDED},
+		{"another sender reusing the Message-ID", "bob@example.com", helpers.INBOUND_TICKET_CREATED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := app.inbound(tt.from, "answer-1@example.com", "", "Paper jam", "The paper is stuck.")
+			if result.Action != tt.action {
+				t.Fatalf("got %+v, want %s", result, tt.action)
+			}
+
+			if tt.action == helpers.INBOUND_COMMENT_ADDED && result.CommentId != answer.CommentId {
+				t.Fatalf("got comment %s, want the first one %s", result.CommentId, answer.CommentId)
+			}
+		})
+	}
+}
@@ -1,241 +1,1057 @@
-package controllers
-
-import (
-	"context"
-	"fmt"
-	"net/http"
-	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
-	"github.com/roh4nyh/matrice_ai/database"
-	"github.com/roh4nyh/matrice_ai/helpers"
-	"github.com/roh4nyh/matrice_ai/models"
-	"github.com/roh4nyh/matrice_ai/utils"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-)
-
-const (
-	InteractionDatabaseName   = "Cluster0"
-	InteractionCollectionName = "interactions"
-)
-
-var InteractionValidate = validator.New()
-var InteractionCollection *mongo.Collection = database.OpenCollection(InteractionDatabaseName, InteractionCollectionName)
-
-func CreateInteractionAndSendEmail() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var interaction models.Interaction
-		if err := c.BindJSON(&interaction); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		validationErr := userValidate.Struct(interaction)
-		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
-			return
-		}
-
-		interaction.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		interaction.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		interaction.ID = primitive.NewObjectID()
-		interaction.InteractionId = interaction.ID.Hex()
-
-		userIDStr := c.GetString("uid")
-		userID, err := primitive.ObjectIDFromHex(userIDStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-			return
-		}
-		interaction.UserID = userID
-
-		customerIDStr := c.Param("customer_id")
-		customerID, err := primitive.ObjectIDFromHex(customerIDStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
-			return
-		}
-		interaction.CustomerID = customerID
-
-		var customer models.Customer
-		err = CustomerCollection.FindOne(ctx, bson.M{"customer_id": customerIDStr}).Decode(&customer)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		if customer.Email == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "customer not found"})
-			return
-		}
-
-		resultInsertionNumber, insertErr := InteractionCollection.InsertOne(ctx, interaction)
-		if insertErr != nil {
-			msg := fmt.Sprintln("fialed to create Interaction")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
-		}
-
-		userEmail := c.GetString("email")
-		customerEmail := customer.Email
-
-		errorChan := make(chan error, 2)
-
-		// Send email to the user
-		go func() {
-			if err := utils.SendInteractionNotificationWithEmail(interaction, userEmail, interaction.StartTime.String()); err != nil {
-				errorChan <- fmt.Errorf("failed to send email to user: %w", err)
-			}
-			close(errorChan) // Close channel when done
-		}()
-
-		// Send email to the customer
-		go func() {
-			if err := utils.SendInteractionNotificationWithEmail(interaction, *customerEmail, interaction.StartTime.String()); err != nil {
-				errorChan <- fmt.Errorf("failed to send email to customer: %w", err)
-			}
-		}()
-
-		go func() {
-			for err := range errorChan {
-				fmt.Println("Error:", err)
-			}
-		}()
-
-		c.JSON(http.StatusCreated, resultInsertionNumber)
-	}
-}
-
-// this is admin feature !!!
-func GetAllInteractions() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		if err := helpers.CheckUserType(c, "ADMIN"); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		var interactions []models.Interaction
-
-		cursor, err := InteractionCollection.Find(ctx, bson.M{})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing users"})
-			return
-		}
-
-		if err = cursor.All(ctx, &interactions); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding user data"})
-			return
-		}
-
-		if len(interactions) == 0 {
-			c.JSON(http.StatusOK, gin.H{"error": "no interactions available"})
-			return
-		}
-
-		c.JSON(http.StatusOK, interactions)
-	}
-}
-
-// get all interactions related to a user
-func GetInteractionsByUserID() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userIdStr := c.GetString("uid")
-
-		if err := helpers.MatchUserTypeToUid(c, userIdStr); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		userId, err := primitive.ObjectIDFromHex(userIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var interactions []models.Interaction
-
-		cursor, err := InteractionCollection.Find(ctx, bson.M{"user_id": userId})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing interactions"})
-			return
-		}
-
-		if err = cursor.All(ctx, &interactions); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding interaction data"})
-			return
-		}
-
-		if len(interactions) == 0 {
-			c.JSON(http.StatusOK, gin.H{"message": "No interactions available related to this user"})
-			return
-		}
-
-		c.JSON(http.StatusOK, interactions)
-	}
-}
-
-func DeleteInteraction() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		userIdStr := c.GetString("uid")
-
-		if err := helpers.MatchUserTypeToUid(c, userIdStr); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		interactionIdStr := c.Param("interaction_id")
-		interactionId, err := primitive.ObjectIDFromHex(interactionIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interaction ID"})
-			return
-		}
-
-		// need to do user himself can only delete his interaction, not others ???
-		// if err := helper.MatchUserTypeToUid(c, interactionId); err != nil {
-		// 	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		// 	return
-		// }
-
-		// check if interaction exists and belongs to the user
-		var interaction models.Interaction
-
-		err = InteractionCollection.FindOne(ctx, bson.M{"_id": interactionId}).Decode(&interaction)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching interaction"})
-			return
-		}
-
-		if interaction.UserID.Hex() != userIdStr {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "UnAuthorized to delete this interaction"})
-			return
-		}
-
-		result, err := InteractionCollection.DeleteOne(ctx, bson.M{"_id": interactionId})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting interaction"})
-			return
-		}
-
-		if result.DeletedCount == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Interaction not found"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "Interaction deleted successfully"})
-	}
-}
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var InteractionValidate = validator.New()
+
+// CreateInteractionAndSendEmail schedules an interaction with a customer, start_local
+// and end_local are local times in time_zone, which defaults to the staff
+// member's own time zone. deal_id makes it part of a deal with the customer.
+func CreateInteractionAndSendEmail(interactions database.InteractionStore, users database.UserStore, customers database.CustomerStore, deals database.DealStore, roles database.RoleStore, jobs database.JobStore, notifications database.NotificationStore, locks database.ScheduleLockStore, transactions database.Transactor, templates database.EmailTemplateStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var interaction models.Interaction
+		if err := c.BindJSON(&interaction); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := userValidate.Struct(interaction)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		user, err := users.FindByID(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching user"})
+			return
+		}
+
+		defaultZone := ""
+		if user.TimeZone != nil {
+			defaultZone = *user.TimeZone
+		}
+
+		if err := helpers.ResolveInteractionTimes(&interaction, defaultZone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := helpers.PrepareInteraction(&interaction, time.Now()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		interaction.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		interaction.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		interaction.ID = primitive.NewObjectID()
+		interaction.InteractionId = interaction.ID.Hex()
+
+		userIDStr := c.GetString("uid")
+		userID, err := primitive.ObjectIDFromHex(userIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		interaction.UserID = userID
+
+		customerIDStr := c.Param("customer_id")
+		customerID, err := primitive.ObjectIDFromHex(customerIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+			return
+		}
+		interaction.CustomerID = customerID
+
+		customer, err := customers.FindByID(ctx, customerIDStr)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if customer.Email == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "customer not found"})
+			return
+		}
+		interaction.AccountID = customer.AccountID
+
+		if interaction.DealID != nil && !checkDealLink(ctx, c, deals, roles, customer, *interaction.DealID) {
+			return
+		}
+
+		// the emails are queued with the interaction, a crash cannot lose them
+		outbox, err := helpers.InteractionNotifications(ctx, templates, interaction, utils.NOTICE_CREATED, helpers.UserContact(user), helpers.CustomerContact(customer), time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while writing notification emails"})
+			return
+		}
+
+		insertErr := transactions.RunInTransaction(ctx, func(ctx context.Context) error {
+			if err := checkConflicts(ctx, interactions, locks, interaction); err != nil {
+				return err
+			}
+
+			if err := interactions.Create(ctx, &interaction); err != nil {
+				return err
+			}
+			return notifications.Enqueue(ctx, outbox)
+		})
+
+		var conflict *conflictError
+		if errors.As(insertErr, &conflict) {
+			respondConflicts(c, conflict.clashes)
+			return
+		}
+
+		if insertErr != nil {
+			msg := fmt.Sprintln("fialed to create Interaction")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return
+		}
+
+		rescheduleReminders(ctx, jobs, interaction, time.Now())
+
+		c.JSON(http.StatusCreated, gin.H{"InsertedID": interaction.ID})
+	}
+}
+
+// interactionTypeQuery reads the optional ?type= filter, it writes the error response itself.
+func interactionTypeQuery(c *gin.Context) (string, bool) {
+	interactionType := c.Query("type")
+	if interactionType != "" && !helpers.IsInteractionType(interactionType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helpers.ErrInvalidInteractionType.Error()})
+		return "", false
+	}
+	return interactionType, true
+}
+
+// interactionWindowQuery reads the optional ?from=&to= window, it writes the error response itself.
+func interactionWindowQuery(c *gin.Context) (from, to time.Time, windowed, ok bool) {
+	fromStr, toStr := c.Query("from"), c.Query("to")
+	if fromStr == "" && toStr == "" {
+		return from, to, false, true
+	}
+
+	from, fromErr := time.Parse(time.RFC3339, fromStr)
+	to, toErr := time.Parse(time.RFC3339, toStr)
+	if fromErr != nil || toErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must both be RFC 3339 times"})
+		return from, to, false, false
+	}
+
+	if !to.After(from) || to.Sub(from) > helpers.INTERACTION_WINDOW_MAX {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("to must be after from and at most %s later", helpers.INTERACTION_WINDOW_MAX)})
+		return from, to, false, false
+	}
+	return from, to, true, true
+}
+
+// viewerLocation is the time zone interaction times are given in: ?tz= when
+// set, else the staff member's own, else UTC. It writes the error response itself.
+func viewerLocation(ctx context.Context, c *gin.Context, users database.UserStore) (*time.Location, bool) {
+	if tz := c.Query("tz"); tz != "" {
+		loc, err := helpers.LoadTimeZone(tz)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		return loc, true
+	}
+
+	user, err := users.FindByID(ctx, helpers.GetPrincipal(c).Id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching user"})
+		return nil, false
+	}
+	return helpers.UserLocation(user), true
+}
+
+// interactionListSpec is what the interaction lists can be filtered and
+// sorted by, next to ?type= and the ?from=&to= window.
+var interactionListSpec = helpers.ListSpec{
+	Fields: map[string]helpers.ListField{
+		"customer_id": {Field: "customer_id", Kind: helpers.LIST_FIELD_ID, Filter: true},
+		"user_id":     {Field: "user_id", Kind: helpers.LIST_FIELD_ID, Filter: true},
+		"deal_id":     {Field: "deal_id", Kind: helpers.LIST_FIELD_ID, Filter: true},
+		"start_time":  {Field: "start_time", Kind: helpers.LIST_FIELD_TIME, Filter: true, Sort: true},
+		"created_at":  {Field: "created_at", Kind: helpers.LIST_FIELD_TIME, Filter: true, Sort: true},
+		"updated_at":  {Field: "updated_at", Kind: helpers.LIST_FIELD_TIME, Sort: true},
+	},
+	DefaultSort: "-start_time",
+}
+
+// listInteractions writes one page of the interactions matching the query and
+// scope. With ?from=&to= the page is one of the interactions in the window,
+// each expanded into its occurrences there, so a repeating one can fill several
+// entries. It writes the error response itself.
+func listInteractions(ctx context.Context, c *gin.Context, interactions database.InteractionStore, users database.UserStore, scope ...database.Filter) {
+	query, err := helpers.ParseListQuery(c.Request.URL.Query(), interactionListSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query.Filters = append(query.Filters, scope...)
+
+	interactionType, ok := interactionTypeQuery(c)
+	if !ok {
+		return
+	}
+	if interactionType != "" {
+		query.Filters = append(query.Filters, database.InteractionTypeFilter(interactionType))
+	}
+
+	from, to, windowed, ok := interactionWindowQuery(c)
+	if !ok {
+		return
+	}
+
+	// the page and its total only count interactions that show in the window
+	if windowed {
+		query.Filters = append(query.Filters, database.InteractionTimeFilter(from, to))
+	}
+
+	loc, ok := viewerLocation(ctx, c, users)
+	if !ok {
+		return
+	}
+
+	page, err := interactions.List(ctx, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing interactions"})
+		return
+	}
+
+	if windowed {
+		page.Items = helpers.ExpandInteractions(page.Items, from, to)
+	}
+	page.Items = helpers.InteractionsIn(page.Items, loc)
+
+	c.JSON(http.StatusOK, helpers.NewPageBody(query, page))
+}
+
+// GetAllInteractions lists every interaction a page at a time, see listInteractions.
+// Times are given in the viewer's time zone, see viewerLocation.
+// requires interactions:read:any
+func GetAllInteractions(interactions database.InteractionStore, users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		listInteractions(ctx, c, interactions, users)
+	}
+}
+
+// get all interactions related to a user, in the viewer's time zone
+func GetInteractionsByUserID(interactions database.InteractionStore, users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIdStr := c.GetString("uid")
+
+		if err := helpers.MatchUserTypeToUid(c, userIdStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userId, err := primitive.ObjectIDFromHex(userIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		listInteractions(ctx, c, interactions, users, database.Eq("user_id", userId))
+	}
+}
+
+type interactionUpdate struct {
+	Title       *string    `json:"title"`
+	Description *string    `json:"description"`
+	StartTime   *time.Time `json:"start_time"`
+	EndTime     *time.Time `json:"end_time"`
+	StartLocal  *string    `json:"start_local"`
+	EndLocal    *string    `json:"end_local"`
+	TimeZone    *string    `json:"time_zone"`
+	RRule       *string    `json:"rrule"`
+	// Revision is the revision the edit is based on, a stale one gets 409
+	Revision *int `json:"revision"`
+}
+
+// UpdateInteraction edits or reschedules an interaction in place, the old
+// values are kept in its revisions and both sides are told about the change.
+// For a repeating interaction ?occurrence= (the occurrence's original start)
+// with ?scope=this edits only that occurrence and ?scope=future splits the
+// series there, without it the whole series changes. start_local and end_local
+// are local times in the interaction's time zone, or in time_zone when it changes.
+// requires interactions:write
+func UpdateInteraction(interactions database.InteractionStore, tickets database.TicketStore, users database.UserStore, customers database.CustomerStore, attachments database.AttachmentStore, blobs database.BlobStore, jobs database.JobStore, notifications database.NotificationStore, locks database.ScheduleLockStore, transactions database.Transactor, templates database.EmailTemplateStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var body interactionUpdate
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		interaction, ok := findAccessibleInteraction(ctx, c, interactions)
+		if !ok {
+			return
+		}
+
+		occurrence, ok := occurrenceQuery(c, interaction)
+		if !ok {
+			return
+		}
+
+		scope := c.DefaultQuery("scope", "this")
+		if scope != "this" && scope != "future" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be this or future"})
+			return
+		}
+
+		// all future occurrences from the first one is the whole series
+		if occurrence != nil && scope == "future" && occurrence.Equal(interaction.StartTime) {
+			occurrence = nil
+		}
+
+		now := time.Now()
+		previous := interactionRevision(interaction, helpers.GetPrincipal(c).Id, now)
+
+		// the edit only lands on the revision the client saw, not just the one read here
+		if body.Revision != nil {
+			previous.Revision = *body.Revision
+		}
+
+		switch {
+		case occurrence == nil:
+			revised, change, err := reviseInteraction(interaction, body)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			revised.Revision = previous.Revision + 1
+			revised.UpdatedAt = now
+
+			user, customer := helpers.InteractionContacts(ctx, users, customers, revised)
+			outbox, err := helpers.InteractionNotifications(ctx, templates, revised, change, user, customer, now)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while writing notification emails"})
+				return
+			}
+
+			// occurrences edited on their own keep matching the series' moved occurrences
+			shift := revised.StartTime.Sub(interaction.StartTime)
+
+			if !saveRevision(ctx, c, transactions, interactions, revised, previous, func(ctx context.Context) error {
+				if helpers.IsRecurring(interaction) && shift != 0 {
+					if err := interactions.ShiftSeries(ctx, interaction.InteractionId, shift); err != nil {
+						return err
+					}
+				}
+
+				if change == utils.NOTICE_RESCHEDULED {
+					if err := checkConflicts(ctx, interactions, locks, revised); err != nil {
+						return err
+					}
+				}
+				return notifications.Enqueue(ctx, outbox)
+			}) {
+				return
+			}
+
+			rescheduleReminders(ctx, jobs, revised, now)
+
+			c.JSON(http.StatusOK, gin.H{"message": "interaction updated successfully", "revision": revised.Revision})
+		case scope == "this":
+			if body.RRule != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "rrule can only change for the whole series or with scope=future"})
+				return
+			}
+
+			// the occurrence becomes an interaction of its own and the series skips it
+			single := helpers.Occurrence(interaction, *occurrence)
+			single.RRule = ""
+			single.ExDates = nil
+
+			single, change, err := reviseInteraction(single, body)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			series := interaction
+			series.ExDates = append(slices.Clone(interaction.ExDates), *occurrence)
+			series.Revision = previous.Revision + 1
+			series.UpdatedAt = now
+
+			single.ID = primitive.NewObjectID()
+			single.InteractionId = single.ID.Hex()
+			single.SeriesId = interaction.InteractionId
+			single.Revision = series.Revision
+			single.CreatedAt = now
+			single.UpdatedAt = now
+
+			user, customer := helpers.InteractionContacts(ctx, users, customers, single)
+			outbox, err := helpers.InteractionNotifications(ctx, templates, single, change, user, customer, now)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while writing notification emails"})
+				return
+			}
+
+			if !saveRevision(ctx, c, transactions, interactions, series, previous, func(ctx context.Context) error {
+				if err := interactions.Create(ctx, &single); err != nil {
+					return err
+				}
+
+				if change == utils.NOTICE_RESCHEDULED {
+					if err := checkConflicts(ctx, interactions, locks, single, series); err != nil {
+						return err
+					}
+				}
+				return notifications.Enqueue(ctx, outbox)
+			}) {
+				return
+			}
+
+			rescheduleReminders(ctx, jobs, series, now)
+			rescheduleReminders(ctx, jobs, single, now)
+
+			c.JSON(http.StatusOK, gin.H{"message": "occurrence updated successfully", "interaction_id": single.InteractionId, "revision": single.Revision})
+		default:
+			ended, rest, err := helpers.SplitSeries(interaction, *occurrence)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			rest, change, err := reviseInteraction(rest, body)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			ended.Revision = previous.Revision + 1
+			ended.UpdatedAt = now
+
+			// the rest of the series starts over as a new interaction
+			rest.ID = primitive.NewObjectID()
+			rest.InteractionId = rest.ID.Hex()
+			rest.Revision = 0
+			rest.Revisions = nil
+			rest.CreatedAt = now
+			rest.UpdatedAt = now
+
+			user, customer := helpers.InteractionContacts(ctx, users, customers, interaction)
+			outbox, err := helpers.InteractionNotifications(ctx, templates, ended, utils.NOTICE_UPDATED, user, customer, now)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while writing notification emails"})
+				return
+			}
+
+			restOutbox, err := helpers.InteractionNotifications(ctx, templates, rest, change, user, customer, now)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while writing notification emails"})
+				return
+			}
+			outbox = append(outbox, restOutbox...)
+
+			// the rest of the series keeps the files, the open tickets and the
+			// occurrences edited on their own from the split on
+			copies, err := helpers.CopyAttachments(ctx, attachments, blobs, models.ATTACHMENT_INTERACTION, interaction.ID, rest.ID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while copying interaction attachments"})
+				return
+			}
+			shift := rest.StartTime.Sub(*occurrence)
+
+			if !saveRevision(ctx, c, transactions, interactions, ended, previous, func(ctx context.Context) error {
+				if err := interactions.Create(ctx, &rest); err != nil {
+					return err
+				}
+
+				for i := range copies {
+					if err := attachments.Create(ctx, &copies[i]); err != nil {
+						return err
+					}
+				}
+
+				if err := tickets.MoveOpenTickets(ctx, interaction.ID, rest.ID); err != nil {
+					return err
+				}
+
+				if err := interactions.MoveSeries(ctx, interaction.InteractionId, rest.InteractionId, *occurrence); err != nil {
+					return err
+				}
+
+				if shift != 0 {
+					if err := interactions.ShiftSeries(ctx, rest.InteractionId, shift); err != nil {
+						return err
+					}
+				}
+
+				if change == utils.NOTICE_RESCHEDULED {
+					if err := checkConflicts(ctx, interactions, locks, rest, ended); err != nil {
+						return err
+					}
+				}
+				return notifications.Enqueue(ctx, outbox)
+			}) {
+				if err := helpers.DeleteAttachmentBlobs(ctx, blobs, copies); err != nil {
+					log.Printf("error removing copied attachments of interaction %s: %v", rest.InteractionId, err)
+				}
+				return
+			}
+
+			rescheduleReminders(ctx, jobs, ended, now)
+			rescheduleReminders(ctx, jobs, rest, now)
+
+			c.JSON(http.StatusOK, gin.H{"message": "future occurrences updated successfully", "interaction_id": rest.InteractionId, "revision": rest.Revision})
+		}
+	}
+}
+
+// reviseInteraction applies an update to a copy of interaction and names the
+// change for the notification email.
+func reviseInteraction(interaction models.Interaction, body interactionUpdate) (models.Interaction, string, error) {
+	change := ""
+	revised := interaction
+
+	if body.TimeZone != nil {
+		loc, err := helpers.LoadTimeZone(*body.TimeZone)
+		if err != nil {
+			return revised, "", err
+		}
+
+		// moving a repeating interaction to another zone moves its occurrences after DST changes
+		if revised.TimeZone = loc.String(); helpers.InteractionLocation(interaction).String() != revised.TimeZone {
+			change = utils.NOTICE_UPDATED
+			if helpers.IsRecurring(interaction) {
+				change = utils.NOTICE_RESCHEDULED
+			}
+		}
+	}
+
+	if err := resolveLocalTimes(&body, helpers.InteractionLocation(revised)); err != nil {
+		return revised, "", err
+	}
+
+	if body.Title != nil && (interaction.Title == nil || *body.Title != *interaction.Title) {
+		revised.Title = body.Title
+		change = utils.NOTICE_UPDATED
+	}
+
+	if body.Description != nil && (interaction.Description == nil || *body.Description != *interaction.Description) {
+		revised.Description = body.Description
+		change = utils.NOTICE_UPDATED
+	}
+
+	if body.StartTime != nil && !body.StartTime.Equal(interaction.StartTime) {
+		if body.StartTime.IsZero() && helpers.InteractionType(interaction) == models.INTERACTION_MEETING {
+			return revised, "", errors.New("a meeting needs a start_time")
+		}
+
+		if body.StartTime.IsZero() && helpers.IsRecurring(interaction) {
+			return revised, "", errors.New("a repeating interaction needs a start_time")
+		}
+		revised.StartTime = *body.StartTime
+		change = utils.NOTICE_RESCHEDULED
+
+		// the interaction keeps its length unless a new end_time comes with it
+		if interaction.EndTime != nil {
+			end := revised.StartTime.Add(helpers.InteractionDuration(interaction))
+			revised.EndTime = &end
+		}
+
+		// cancelled occurrences move along with the series
+		shift := revised.StartTime.Sub(interaction.StartTime)
+		revised.ExDates = nil
+		for _, exdate := range interaction.ExDates {
+			revised.ExDates = append(revised.ExDates, exdate.Add(shift))
+		}
+	}
+
+	if body.EndTime != nil && (revised.EndTime == nil || !body.EndTime.Equal(*revised.EndTime)) {
+		if !helpers.BlocksTime(revised) {
+			return revised, "", errors.New("end_time only applies to meetings and calls with a start_time")
+		}
+
+		if !body.EndTime.After(revised.StartTime) {
+			return revised, "", errors.New("end_time must be after start_time")
+		}
+		revised.EndTime = body.EndTime
+		change = utils.NOTICE_RESCHEDULED
+	}
+
+	if body.RRule != nil && *body.RRule != interaction.RRule {
+		revised.RRule = *body.RRule
+		if err := helpers.PrepareRecurrence(&revised); err != nil {
+			return revised, "", err
+		}
+
+		if revised.RRule == "" {
+			revised.ExDates = nil
+		}
+
+		if revised.RRule != interaction.RRule {
+			change = utils.NOTICE_RESCHEDULED
+		}
+	}
+
+	if change == "" {
+		return revised, "", errors.New("nothing to update")
+	}
+	return revised, change, nil
+}
+
+// resolveLocalTimes turns the start_local and end_local of an update into
+// start_time and end_time in loc.
+func resolveLocalTimes(body *interactionUpdate, loc *time.Location) error {
+	locals := []struct {
+		local  *string
+		target **time.Time
+		name   string
+	}{
+		{body.StartLocal, &body.StartTime, "start"},
+		{body.EndLocal, &body.EndTime, "end"},
+	}
+
+	for _, l := range locals {
+		if l.local == nil {
+			continue
+		}
+
+		if *l.target != nil {
+			return fmt.Errorf("send either %[1]s_time or %[1]s_local", l.name)
+		}
+
+		t, err := helpers.ParseLocalTime(*l.local, loc)
+		if err != nil {
+			return err
+		}
+		*l.target = &t
+	}
+	return nil
+}
+
+// interactionRevision snapshots interaction before an edit.
+func interactionRevision(interaction models.Interaction, changedById string, now time.Time) models.InteractionRevision {
+	return models.InteractionRevision{
+		Revision:    interaction.Revision,
+		Title:       interaction.Title,
+		Description: interaction.Description,
+		StartTime:   interaction.StartTime,
+		EndTime:     interaction.EndTime,
+		TimeZone:    interaction.TimeZone,
+		RRule:       interaction.RRule,
+		ExDates:     interaction.ExDates,
+		ChangedById: changedById,
+		ChangedAt:   now,
+	}
+}
+
+// saveRevision stores revised in one transaction with the writes of also,
+// it writes the error response itself.
+func saveRevision(ctx context.Context, c *gin.Context, transactions database.Transactor, interactions database.InteractionStore, revised models.Interaction, previous models.InteractionRevision, also func(ctx context.Context) error) bool {
+	err := transactions.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := interactions.Revise(ctx, revised, previous); err != nil {
+			return err
+		}
+		return also(ctx)
+	})
+
+	var conflict *conflictError
+	if errors.As(err, &conflict) {
+		respondConflicts(c, conflict.clashes)
+		return false
+	}
+
+	if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": "interaction was changed meanwhile, reload it and try again"})
+		return false
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating interaction"})
+		return false
+	}
+	return true
+}
+
+// interactionConflict is what a clash reveals about the other interaction, its
+// IDs and type only when the caller may read it.
+type interactionConflict struct {
+	InteractionId string    `json:"interaction_id,omitempty"`
+	UserID        string    `json:"user_id,omitempty"`
+	CustomerID    string    `json:"customer_id,omitempty"`
+	Type          string    `json:"type,omitempty"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+}
+
+// conflictError aborts the transaction of an interaction that clashes with others.
+type conflictError struct {
+	clashes []models.Interaction
+}
+
+func (e *conflictError) Error() string {
+	return "interaction clashes with other interactions"
+}
+
+// checkConflicts fails with a *conflictError when candidate overlaps another
+// interaction of its staff member or its customer, replaced are interactions
+// the same request changes as they will be saved. It runs in the transaction
+// that saves candidate and locks both schedules first, so two bookings of the
+// same people cannot both pass it.
+func checkConflicts(ctx context.Context, interactions database.InteractionStore, locks database.ScheduleLockStore, candidate models.Interaction, replaced ...models.Interaction) error {
+	if !helpers.BlocksTime(candidate) {
+		return nil
+	}
+
+	keys := []string{"user:" + candidate.UserID.Hex()}
+	if !candidate.CustomerID.IsZero() {
+		keys = append(keys, "customer:"+candidate.CustomerID.Hex())
+	}
+	if err := locks.Lock(ctx, keys...); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	from, to := helpers.ConflictWindow(candidate, now)
+
+	schedule, err := participantSchedule(ctx, interactions, candidate.UserID, candidate.CustomerID, from, to)
+	if err != nil {
+		return err
+	}
+
+	for i := range schedule {
+		for _, r := range replaced {
+			if schedule[i].ID == r.ID {
+				schedule[i] = r
+			}
+		}
+	}
+
+	if clashes := helpers.FindConflicts(candidate, schedule, now); len(clashes) > 0 {
+		return &conflictError{clashes: clashes}
+	}
+	return nil
+}
+
+// respondConflicts answers 409 with the occurrences in the way, what they are
+// is only told for interactions the caller may read.
+func respondConflicts(c *gin.Context, clashes []models.Interaction) {
+	conflicts := []interactionConflict{}
+	for _, clash := range clashes {
+		conflict := interactionConflict{
+			StartTime: clash.StartTime,
+			EndTime:   clash.StartTime.Add(helpers.InteractionDuration(clash)),
+		}
+
+		if helpers.MatchUserTypeToUid(c, clash.UserID.Hex()) == nil {
+			conflict.InteractionId = clash.InteractionId
+			conflict.UserID = clash.UserID.Hex()
+			conflict.CustomerID = clash.CustomerID.Hex()
+			conflict.Type = helpers.InteractionType(clash)
+		}
+		conflicts = append(conflicts, conflict)
+	}
+
+	c.JSON(http.StatusConflict, gin.H{"error": "interaction clashes with other interactions", "conflicts": conflicts})
+}
+
+// participantSchedule lists the interactions of a staff member and of a
+// customer that can take up time in [from, to), a nil customer ID leaves the
+// customer out.
+func participantSchedule(ctx context.Context, interactions database.InteractionStore, userID, customerID primitive.ObjectID, from, to time.Time) ([]models.Interaction, error) {
+	participant := database.Eq("user_id", userID)
+	if !customerID.IsZero() {
+		participant = database.Or(participant, database.Eq("customer_id", customerID))
+	}
+
+	// an interaction without an end_time lasts at most INTERACTION_DURATION_MAX
+	query := database.ListQuery{
+		Filters: append(database.InteractionWindowFilters(from.Add(-helpers.INTERACTION_DURATION_MAX), to), participant),
+		Sort:    []database.SortField{{Field: "start_time"}},
+		Limit:   helpers.LIST_MAX_LIMIT,
+	}
+
+	schedule := []models.Interaction{}
+	for {
+		page, err := interactions.List(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, page.Items...)
+
+		if page.Next == nil {
+			return schedule, nil
+		}
+		query.After = page.Next
+	}
+}
+
+// occurrenceQuery reads ?occurrence=, the original start of one occurrence of
+// a repeating interaction, it writes the error response itself.
+func occurrenceQuery(c *gin.Context, interaction models.Interaction) (*time.Time, bool) {
+	value := c.Query("occurrence")
+	if value == "" {
+		return nil, true
+	}
+
+	if !helpers.IsRecurring(interaction) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": helpers.ErrNotRecurring.Error()})
+		return nil, false
+	}
+
+	occurrence, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "occurrence must be an RFC 3339 time"})
+		return nil, false
+	}
+
+	if !helpers.OccursAt(interaction, occurrence) {
+		c.JSON(http.StatusNotFound, gin.H{"error": helpers.ErrOccurrenceNotFound.Error()})
+		return nil, false
+	}
+	return &occurrence, true
+}
+
+func rescheduleReminders(ctx context.Context, jobs database.JobStore, interaction models.Interaction, now time.Time) {
+	if err := helpers.ScheduleInteractionReminders(ctx, jobs, interaction, now); err != nil {
+		log.Printf("error scheduling reminders for interaction %s: %v", interaction.InteractionId, err)
+	}
+}
+
+// GetInteractionHistory lists the earlier versions of an interaction, oldest
+// first, with times in the viewer's time zone
+// requires interactions:read
+func GetInteractionHistory(interactions database.InteractionStore, users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		interaction, ok := findAccessibleInteraction(ctx, c, interactions)
+		if !ok {
+			return
+		}
+
+		loc, ok := viewerLocation(ctx, c, users)
+		if !ok {
+			return
+		}
+
+		revisions := []models.InteractionRevision{}
+		for _, revision := range interaction.Revisions {
+			revision.StartTime = revision.StartTime.In(loc)
+			if revision.EndTime != nil {
+				end := revision.EndTime.In(loc)
+				revision.EndTime = &end
+			}
+			revisions = append(revisions, revision)
+		}
+
+		c.JSON(http.StatusOK, revisions)
+	}
+}
+
+// CompleteInteractionTask marks a task interaction as done
+// requires interactions:write
+func CompleteInteractionTask(interactions database.InteractionStore, jobs database.JobStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		interaction, ok := findAccessibleInteraction(ctx, c, interactions)
+		if !ok {
+			return
+		}
+
+		if helpers.InteractionType(interaction) != models.INTERACTION_TASK {
+			c.JSON(http.StatusBadRequest, gin.H{"error": helpers.ErrInteractionNotTask.Error()})
+			return
+		}
+
+		task := models.TaskDetails{}
+		if interaction.Task != nil {
+			task = *interaction.Task
+		}
+
+		if task.Completed {
+			c.JSON(http.StatusConflict, gin.H{"error": "task is already completed"})
+			return
+		}
+
+		now := time.Now()
+		task.Completed = true
+		task.CompletedAt = &now
+
+		err := interactions.Update(ctx, interaction.ID, bson.M{"task": task, "updated_at": now})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while completing task"})
+			return
+		}
+
+		if err := helpers.CancelInteractionReminders(ctx, jobs, interaction.InteractionId); err != nil {
+			log.Printf("error cancelling reminders for interaction %s: %v", interaction.InteractionId, err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "task completed successfully"})
+	}
+}
+
+// DeleteInteraction cancels an interaction, both sides get a cancellation email.
+// With ?occurrence= only that occurrence of a repeating interaction is cancelled,
+// without it a series goes together with the occurrences edited out of it and
+// the reminders, queued emails and files of all of them.
+func DeleteInteraction(interactions database.InteractionStore, users database.UserStore, customers database.CustomerStore, attachments database.AttachmentStore, blobs database.BlobStore, jobs database.JobStore, notifications database.NotificationStore, transactions database.Transactor, templates database.EmailTemplateStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		interactionIdStr := c.Param("interaction_id")
+		interactionId, err := primitive.ObjectIDFromHex(interactionIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interaction ID"})
+			return
+		}
+
+		// check if interaction exists and belongs to the user, unless the role may delete any
+		interaction, err := interactions.FindByID(ctx, interactionId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching interaction"})
+			return
+		}
+
+		if err := helpers.MatchUserTypeToUid(c, interaction.UserID.Hex()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "UnAuthorized to delete this interaction"})
+			return
+		}
+
+		occurrence, ok := occurrenceQuery(c, interaction)
+		if !ok {
+			return
+		}
+
+		if occurrence != nil {
+			now := time.Now()
+			previous := interactionRevision(interaction, helpers.GetPrincipal(c).Id, now)
+
+			revised := interaction
+			revised.ExDates = append(slices.Clone(interaction.ExDates), *occurrence)
+			revised.Revision = previous.Revision + 1
+			revised.UpdatedAt = now
+
+			user, customer := helpers.InteractionContacts(ctx, users, customers, revised)
+			outbox, err := helpers.InteractionNotifications(ctx, templates, helpers.Occurrence(revised, *occurrence), utils.NOTICE_CANCELLED, user, customer, now)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while writing notification emails"})
+				return
+			}
+
+			if !saveRevision(ctx, c, transactions, interactions, revised, previous, func(ctx context.Context) error {
+				return notifications.Enqueue(ctx, outbox)
+			}) {
+				return
+			}
+
+			rescheduleReminders(ctx, jobs, revised, now)
+
+			c.JSON(http.StatusOK, gin.H{"message": "occurrence cancelled successfully", "revision": revised.Revision})
+			return
+		}
+
+		// the series' cancellation covers its edited occurrences, they share its calendar UID
+		now := time.Now()
+		user, customer := helpers.InteractionContacts(ctx, users, customers, interaction)
+		outbox, err := helpers.InteractionNotifications(ctx, templates, interaction, utils.NOTICE_CANCELLED, user, customer, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while writing notification emails"})
+			return
+		}
+
+		occurrences, err := interactions.ListBySeries(ctx, interaction.InteractionId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching interaction occurrences"})
+			return
+		}
+
+		ids := []string{}
+		owned := []models.Attachment{}
+		for _, doomed := range append([]models.Interaction{interaction}, occurrences...) {
+			ids = append(ids, doomed.InteractionId)
+
+			files, err := attachments.ListByOwner(ctx, models.ATTACHMENT_INTERACTION, doomed.ID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching interaction attachments"})
+				return
+			}
+			owned = append(owned, files...)
+		}
+
+		err = transactions.RunInTransaction(ctx, func(ctx context.Context) error {
+			if err := interactions.Delete(ctx, interactionId); err != nil {
+				return err
+			}
+
+			if err := interactions.DeleteSeries(ctx, interaction.InteractionId); err != nil {
+				return err
+			}
+
+			for _, id := range ids {
+				if err := helpers.CancelInteractionReminders(ctx, jobs, id); err != nil {
+					return err
+				}
+			}
+
+			for _, attachment := range owned {
+				if err := attachments.Delete(ctx, attachment.ID); err != nil && !errors.Is(err, database.ErrNotFound) {
+					return err
+				}
+			}
+
+			if err := notifications.CancelQueued(ctx, ids, now); err != nil {
+				return err
+			}
+			return notifications.Enqueue(ctx, outbox)
+		})
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Interaction not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting interaction"})
+			return
+		}
+
+		// the blob store is outside the transaction, a leftover file is only unreachable
+		if err := helpers.DeleteAttachmentBlobs(ctx, blobs, owned); err != nil {
+			log.Printf("error removing files of interaction %s: %v", interaction.InteractionId, err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Interaction deleted successfully"})
+	}
+}
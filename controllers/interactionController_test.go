@@ -0,0 +1,321 @@
+package controllers_test
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUpdateInteractionRevision(t *testing.T) {
+	app := newTestApp(t)
+
+	agent, agentToken := app.staff("agent", models.ROLE_AGENT)
+	alice, _ := app.customer("Alice", "alice@example.com")
+	interaction := app.interaction(agent, alice, "kickoff", time.Now().Add(24*time.Hour))
+
+	// each step edits the title on top of the revision a client last saw
+	tests := []struct {
+		name     string
+		revision int
+		status   int
+	}{
+		{"edit the first revision", 0, http.StatusOK},
+		{"edit a stale copy", 0, http.StatusConflict},
+		{"edit the current revision", 1, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]any{"title": tt.name, "revision": tt.revision}
+			response := app.do(http.MethodPut, "/staff/interactions/"+interaction.InteractionId, agentToken, body)
+			if response.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", response.Code, tt.status, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestInteractionSeries(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	agent, agentToken := app.staff("agent", models.ROLE_AGENT)
+	alice, _ := app.customer("Alice", "alice@example.com")
+
+	day := 24 * time.Hour
+	start := time.Now().Add(2 * day).Truncate(time.Hour).UTC()
+	series := app.interaction(agent, alice, "standup", start)
+	if err := app.stores.Interactions.Update(ctx, series.ID, bson.M{"rrule": "FREQ=DAILY;COUNT=10"}); err != nil {
+		t.Fatal(err)
+	}
+	ticket := app.ticket(alice, series, "standup runs late")
+
+	path := "/staff/interactions/" + series.InteractionId
+	put := func(query string, body map[string]any) map[string]any {
+		t.Helper()
+
+		response := app.do(http.MethodPut, path+query, agentToken, body)
+		if response.Code != http.StatusOK {
+			t.Fatalf("PUT %s got status %d: %s", query, response.Code, response.Body.String())
+		}
+
+		var result map[string]any
+		decode(t, response, &result)
+		return result
+	}
+
+	// day 6 is edited on its own, then the whole series moves an hour later
+	detached := put("?scope=this&occurrence="+start.Add(6*day).Format(time.RFC3339), map[string]any{"title": "long standup"})
+	detachedId, _ := primitive.ObjectIDFromHex(detached["interaction_id"].(string))
+	put("", map[string]any{"start_time": start.Add(time.Hour), "revision": 1})
+
+	occurrence, err := app.stores.Interactions.FindByID(ctx, detachedId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.Add(6*day + time.Hour); occurrence.RecurrenceId == nil || !occurrence.RecurrenceId.Equal(want) {
+		t.Fatalf("got recurrence_id %v, want %v", occurrence.RecurrenceId, want)
+	}
+
+	upload := app.upload(path+"/attachments", agentToken, "agenda.txt", "text/plain", []byte("agenda"))
+	if upload.Code != http.StatusCreated {
+		t.Fatalf("upload got status %d: %s", upload.Code, upload.Body.String())
+	}
+
+	// from day 4 on the series continues as a new interaction
+	split := put("?scope=future&occurrence="+start.Add(4*day+time.Hour).Format(time.RFC3339), map[string]any{"title": "new standup"})
+	restId, _ := primitive.ObjectIDFromHex(split["interaction_id"].(string))
+
+	files, err := app.stores.Attachments.ListByOwner(ctx, models.ATTACHMENT_INTERACTION, restId)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("got %d files on the rest of the series, want 1 (%v)", len(files), err)
+	}
+
+	moved, err := app.stores.Tickets.FindByID(ctx, ticket.ID)
+	if err != nil || moved.InteractionID != restId {
+		t.Fatalf("open ticket is about %s, want %s (%v)", moved.InteractionID.Hex(), restId.Hex(), err)
+	}
+
+	occurrence, err = app.stores.Interactions.FindByID(ctx, detachedId)
+	if err != nil || occurrence.SeriesId != restId.Hex() {
+		t.Fatalf("edited occurrence belongs to %s, want %s (%v)", occurrence.SeriesId, restId.Hex(), err)
+	}
+
+	// deleting the rest takes its edited occurrence and files along
+	response := app.do(http.MethodDelete, "/staff/interactions/"+restId.Hex(), agentToken, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("DELETE got status %d: %s", response.Code, response.Body.String())
+	}
+
+	if _, err := app.stores.Interactions.FindByID(ctx, detachedId); err == nil {
+		t.Fatal("edited occurrence outlived its series")
+	}
+
+	files, err = app.stores.Attachments.ListByOwner(ctx, models.ATTACHMENT_INTERACTION, restId)
+	if err != nil || len(files) != 0 {
+		t.Fatalf("got %d files after the delete, want 0 (%v)", len(files), err)
+	}
+
+	files, err = app.stores.Attachments.ListByOwner(ctx, models.ATTACHMENT_INTERACTION, series.ID)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("got %d files on the ended series, want 1 (%v)", len(files), err)
+	}
+}
+
+func TestInteractionConflicts(t *testing.T) {
+	app := newTestApp(t)
+
+	agent, agentToken := app.staff("agent", models.ROLE_AGENT)
+	other, _ := app.staff("other", models.ROLE_AGENT)
+	alice, _ := app.customer("Alice", "alice@example.com")
+	bob, _ := app.customer("Bob", "bob@example.com")
+
+	start := time.Now().Add(48 * time.Hour).Truncate(time.Hour).UTC()
+	theirs := app.interaction(other, alice, "their call", start)
+	mine := app.interaction(agent, bob, "my call", start.Add(4*time.Hour))
+	moved := app.interaction(agent, alice, "to move", start.Add(8*time.Hour))
+
+	// each step moves one of the agent's interactions onto a busy hour
+	tests := []struct {
+		name   string
+		start  time.Time
+		status int
+		reveal bool
+	}{
+		{"clash with another agent's interaction", start.Add(30 * time.Minute), http.StatusConflict, false},
+		{"clash with an own interaction", start.Add(4*time.Hour + 30*time.Minute), http.StatusConflict, true},
+		{"free hour", start.Add(2 * time.Hour), http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			end := tt.start.Add(time.Hour)
+			body := map[string]any{"start_time": tt.start, "end_time": end}
+			response := app.do(http.MethodPut, "/staff/interactions/"+moved.InteractionId, agentToken, body)
+			if response.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", response.Code, tt.status, response.Body.String())
+			}
+
+			if tt.status != http.StatusConflict {
+				return
+			}
+
+			var conflict struct {
+				Conflicts []map[string]any `json:"conflicts"`
+			}
+			decode(t, response, &conflict)
+			if len(conflict.Conflicts) != 1 {
+				t.Fatalf("got %d conflicts, want 1", len(conflict.Conflicts))
+			}
+
+			id, revealed := conflict.Conflicts[0]["interaction_id"]
+			if revealed != tt.reveal {
+				t.Fatalf("got interaction_id %v, want it shown: %v", id, tt.reveal)
+			}
+			if tt.reveal && id != mine.InteractionId {
+				t.Fatalf("got interaction_id %v, want %s", id, mine.InteractionId)
+			}
+			if !tt.reveal && id == theirs.InteractionId {
+				t.Fatal("conflict shows another agent's interaction")
+			}
+		})
+	}
+
+	t.Run("concurrent bookings of one customer", func(t *testing.T) {
+		slot := start.Add(24 * time.Hour)
+		body := map[string]any{"title": "booking", "description": "booking", "start_time": slot, "end_time": slot.Add(time.Hour)}
+
+		codes := make(chan int, 5)
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				codes <- app.do(http.MethodPost, "/staff/customers/"+alice.CustomerId+"/interactions", agentToken, body).Code
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		created := 0
+		for code := range codes {
+			if code == http.StatusCreated {
+				created++
+			} else if code != http.StatusConflict {
+				t.Fatalf("got status %d, want 201 or 409", code)
+			}
+		}
+		if created != 1 {
+			t.Fatalf("got %d bookings of the same hour, want 1", created)
+		}
+	})
+}
+
+func TestInteractionEmails(t *testing.T) {
+	app := newTestApp(t)
+
+	_, agentToken := app.staff("agent", models.ROLE_AGENT)
+	alice, _ := app.customer("Alice", "alice@example.com")
+
+	start := time.Now().Add(48 * time.Hour).Truncate(time.Hour).UTC()
+	body := map[string]any{"title": "kickoff", "description": "kickoff", "start_time": start, "end_time": start.Add(time.Hour)}
+	response := app.do(http.MethodPost, "/staff/customers/"+alice.CustomerId+"/interactions", agentToken, body)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want 201: %s", response.Code, response.Body.String())
+	}
+
+	mailer := utils.NewCaptureMailer("crm@example.com")
+	deliver := helpers.DeliverNotifications(app.stores.Notifications, mailer)
+	if err := deliver(context.Background(), models.Job{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the staff member and the customer each get their copy, once
+	sent := map[string]int{}
+	for _, message := range mailer.Messages() {
+		sent[strings.Join(message.To, ",")]++
+	}
+	want := map[string]int{"agent@staff.test": 1, "alice@example.com": 1}
+	if !maps.Equal(sent, want) {
+		t.Fatalf("got emails to %v, want %v", sent, want)
+	}
+
+	mailer.Reset()
+	if err := deliver(context.Background(), models.Job{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.Messages()) != 0 {
+		t.Fatalf("sent %d emails again", len(mailer.Messages()))
+	}
+}
+
+func TestListInteractionsWindow(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	agent, agentToken := app.staff("agent", models.ROLE_AGENT)
+	alice, _ := app.customer("Alice", "alice@example.com")
+
+	day := 24 * time.Hour
+	from := time.Now().Add(30 * day).Truncate(time.Hour).UTC()
+	to := from.Add(7 * day)
+
+	app.interaction(agent, alice, "before", from.Add(-3*day))
+	app.interaction(agent, alice, "first", from.Add(day))
+	app.interaction(agent, alice, "second", from.Add(2*day))
+	app.interaction(agent, alice, "after", to.Add(3*day))
+
+	// two of the three standups fall in the window
+	series := app.interaction(agent, alice, "standup", from.Add(-day))
+	if err := app.stores.Interactions.Update(ctx, series.ID, bson.M{"rrule": "FREQ=DAILY;COUNT=3"}); err != nil {
+		t.Fatal(err)
+	}
+	// a task shows on its due date, not its start
+	task := app.interaction(agent, alice, "follow up", from.Add(-5*day))
+	due := from.Add(3 * day)
+	if err := app.stores.Interactions.Update(ctx, task.ID, bson.M{"task": models.TaskDetails{DueAt: &due}}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/staff/interactions/mine?limit=1&sort=start_time&from=" + from.Format(time.RFC3339) + "&to=" + to.Format(time.RFC3339)
+	var titles []string
+	for cursor := ""; ; {
+		response := app.do(http.MethodGet, path+cursor, agentToken, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", response.Code, response.Body.String())
+		}
+
+		var page helpers.PageBody[models.Interaction]
+		decode(t, response, &page)
+		if page.Total != 4 {
+			t.Fatalf("got total %d, want 4", page.Total)
+		}
+		if len(page.Data) == 0 {
+			t.Fatalf("got an empty page after %v", titles)
+		}
+		for _, interaction := range page.Data {
+			titles = append(titles, *interaction.Title)
+		}
+
+		if page.NextCursor == nil {
+			break
+		}
+		cursor = "&cursor=" + url.QueryEscape(*page.NextCursor)
+	}
+
+	want := []string{"follow up", "standup", "standup", "first", "second"}
+	if strings.Join(titles, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %v, want %v", titles, want)
+	}
+}
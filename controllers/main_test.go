@@ -0,0 +1,181 @@
+package controllers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/routes"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	helpers.USER_SECRET_KEY = "test-user-secret"
+	helpers.CUSTOMER_SECRET_KEY = "test-customer-secret"
+	helpers.ATTACHMENT_SIGNING_KEY = "test-attachment-key"
+
+	if err := helpers.LoadEmailTemplates(""); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
+// testApp is the whole API on the memory stores, the way main wires it.
+type testApp struct {
+	t       *testing.T
+	stores  *database.Stores
+	handler http.Handler
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+
+	stores := database.NewMemoryStores()
+
+	blobs, err := database.NewBlobStore(database.BlobConfig{Driver: "local", LocalDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores.Blobs = blobs
+
+	ctx := context.Background()
+	if err := helpers.SeedRoles(ctx, stores.Roles); err != nil {
+		t.Fatal(err)
+	}
+	if err := helpers.SeedPipeline(ctx, stores.Pipelines); err != nil {
+		t.Fatal(err)
+	}
+
+	app := gin.New()
+	api := app.Group("/api/v1")
+	routes.StaffRoutes(api, stores)
+	routes.PortalRoutes(api, stores)
+	routes.SharedRoutes(api, stores)
+
+	return &testApp{t: t, stores: stores, handler: app}
+}
+
+// staff stores a staff user with role and returns them with an access token.
+func (a *testApp) staff(name, role string) (models.User, string) {
+	a.t.Helper()
+
+	email := name + "@staff.test"
+	user := models.User{ID: primitive.NewObjectID(), Name: &name, Email: &email, Role: &role, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	user.UserId = user.ID.Hex()
+	if err := a.stores.Users.Create(context.Background(), &user); err != nil {
+		a.t.Fatal(err)
+	}
+
+	token, err := helpers.GenerateUserToken(email, name, user.UserId, role, primitive.NewObjectID().Hex())
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return user, token
+}
+
+// customer stores a customer with email and returns them with an access token.
+func (a *testApp) customer(name, email string) (models.Customer, string) {
+	a.t.Helper()
+
+	customer := models.Customer{ID: primitive.NewObjectID(), Name: &name, Email: &email, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	customer.CustomerId = customer.ID.Hex()
+	if err := a.stores.Customers.Create(context.Background(), &customer); err != nil {
+		a.t.Fatal(err)
+	}
+
+	token, err := helpers.GenerateCustomerToken(email, name, customer.CustomerId, primitive.NewObjectID().Hex())
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return customer, token
+}
+
+// interaction stores a meeting between user and customer at start.
+func (a *testApp) interaction(user models.User, customer models.Customer, title string, start time.Time) models.Interaction {
+	a.t.Helper()
+
+	end := start.Add(time.Hour)
+	interaction := models.Interaction{
+		ID:          primitive.NewObjectID(),
+		UserID:      user.ID,
+		CustomerID:  customer.ID,
+		AccountID:   customer.AccountID,
+		Type:        models.INTERACTION_MEETING,
+		Title:       &title,
+		Description: &title,
+		StartTime:   start,
+		EndTime:     &end,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	interaction.InteractionId = interaction.ID.Hex()
+	if err := a.stores.Interactions.Create(context.Background(), &interaction); err != nil {
+		a.t.Fatal(err)
+	}
+	return interaction
+}
+
+// ticket stores an open ticket the customer raised about the interaction.
+func (a *testApp) ticket(customer models.Customer, interaction models.Interaction, description string) models.Ticket {
+	a.t.Helper()
+
+	status := models.TICKET_OPEN
+	ticket := models.Ticket{
+		ID:            primitive.NewObjectID(),
+		InteractionID: interaction.ID,
+		CustomerID:    customer.ID,
+		AccountID:     customer.AccountID,
+		Status:        &status,
+		Description:   &description,
+		Priority:      models.PRIORITY_NORMAL,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	ticket.TicketId = ticket.ID.Hex()
+	if err := a.stores.Tickets.Create(context.Background(), &ticket); err != nil {
+		a.t.Fatal(err)
+	}
+	return ticket
+}
+
+// do sends the request with body as JSON and returns the response.
+func (a *testApp) do(method, path, token string, body any) *httptest.ResponseRecorder {
+	a.t.Helper()
+
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			a.t.Fatal(err)
+		}
+	}
+
+	request := httptest.NewRequest(method, "/api/v1"+path, &reader)
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("token", token)
+	}
+
+	response := httptest.NewRecorder()
+	a.handler.ServeHTTP(response, request)
+	return response
+}
+
+// decode reads the JSON response body into v.
+func decode(t *testing.T, response *httptest.ResponseRecorder, v any) {
+	t.Helper()
+
+	if err := json.Unmarshal(response.Body.Bytes(), v); err != nil {
+		t.Fatalf("error decoding %q: %v", response.Body.String(), err)
+	}
+}
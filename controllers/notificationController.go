@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// notificationListSpec is what an interaction's notifications can be filtered and sorted by.
+var notificationListSpec = helpers.ListSpec{
+	Fields: map[string]helpers.ListField{
+		"status":         {Field: "status", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"recipient_type": {Field: "recipient_type", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"created_at":     {Field: "created_at", Kind: helpers.LIST_FIELD_TIME, Filter: true, Sort: true},
+	},
+	DefaultSort: "created_at",
+}
+
+// GetNotifications lists the emails sent about an interaction with their
+// delivery status, so staff can see whether the customer was actually told.
+// They stay listed after the interaction is deleted, a caller who may only
+// read their own interactions sees the emails about those.
+// requires interactions:read
+func GetNotifications(notifications database.NotificationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		interactionId := c.Query("interaction_id")
+		if interactionId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "interaction_id is required"})
+			return
+		}
+
+		query, err := helpers.ParseListQuery(c.Request.URL.Query(), notificationListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := primitive.ObjectIDFromHex(interactionId); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interaction ID"})
+			return
+		}
+		query.Filters = append(query.Filters, database.Eq("interaction_id", interactionId))
+
+		if c.GetString("scope") != models.SCOPE_ANY {
+			userID, err := primitive.ObjectIDFromHex(helpers.GetPrincipal(c).Id)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+				return
+			}
+			query.Filters = append(query.Filters, database.Eq("user_id", userID))
+		}
+
+		page, err := notifications.List(ctx, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing notifications"})
+			return
+		}
+
+		c.JSON(http.StatusOK, helpers.NewPageBody(query, page))
+	}
+}
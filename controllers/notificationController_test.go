@@ -0,0 +1,79 @@
+package controllers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/utils"
+)
+
+func TestGetNotifications(t *testing.T) {
+	app := newTestApp(t)
+
+	agent, agentToken := app.staff("agent", models.ROLE_AGENT)
+	_, otherToken := app.staff("other", models.ROLE_AGENT)
+	_, adminToken := app.staff("admin", models.ROLE_ADMIN)
+	alice, _ := app.customer("Alice", "alice@example.com")
+
+	start := time.Now().Add(48 * time.Hour).Truncate(time.Hour).UTC()
+	body := map[string]any{"title": "kickoff", "description": "kickoff", "start_time": start, "end_time": start.Add(time.Hour)}
+	response := app.do(http.MethodPost, "/staff/customers/"+alice.CustomerId+"/interactions", agentToken, body)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want 201: %s", response.Code, response.Body.String())
+	}
+
+	var created struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	decode(t, response, &created)
+
+	// an email queued before emails carried their participants
+	legacy := app.interaction(agent, alice, "legacy", start.Add(24*time.Hour))
+	message := utils.Message{To: []string{"alice@example.com"}, Subject: "legacy", HTML: "<p>legacy</p>"}
+	notification := helpers.NewNotification(message, models.PRINCIPAL_CUSTOMER, time.Now())
+	notification.InteractionId = legacy.InteractionId
+	if err := app.stores.Notifications.Enqueue(context.Background(), []models.Notification{notification}); err != nil {
+		t.Fatal(err)
+	}
+	if err := helpers.BackfillNotificationParticipants(context.Background(), app.stores.Notifications, app.stores.Interactions); err != nil {
+		t.Fatal(err)
+	}
+
+	// the emails outlive the interaction they were about
+	if response := app.do(http.MethodDelete, "/staff/interactions/"+created.InsertedID, agentToken, nil); response.Code != http.StatusOK {
+		t.Fatalf("got status %d deleting the interaction: %s", response.Code, response.Body.String())
+	}
+
+	tests := []struct {
+		name          string
+		token         string
+		interactionId string
+		want          int
+	}{
+		{"owner of a deleted interaction", agentToken, created.InsertedID, 4},
+		{"another agent", otherToken, created.InsertedID, 0},
+		{"admin", adminToken, created.InsertedID, 4},
+		{"owner of a backfilled email", agentToken, legacy.InteractionId, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := app.do(http.MethodGet, "/staff/notifications?interaction_id="+tt.interactionId, tt.token, nil)
+			if response.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200: %s", response.Code, response.Body.String())
+			}
+
+			var page struct {
+				Data []models.Notification `json:"data"`
+			}
+			decode(t, response, &page)
+			if len(page.Data) != tt.want {
+				t.Fatalf("got %d emails, want %d", len(page.Data), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var pipelineValidate = validator.New()
+
+// requires pipelines:read
+func GetPipelines(pipelines database.PipelineStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		allPipelines, err := pipelines.List(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing pipelines"})
+			return
+		}
+
+		c.JSON(http.StatusOK, allPipelines)
+	}
+}
+
+// requires pipelines:write:any
+func CreatePipeline(pipelines database.PipelineStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var pipeline models.Pipeline
+		if err := c.BindJSON(&pipeline); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := pipelineValidate.Struct(pipeline); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := helpers.ValidatePipelineStages(pipeline.Stages); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := pipelines.FindByName(ctx, *pipeline.Name); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "this pipeline already exists"})
+			return
+		}
+
+		pipeline.ID = primitive.NewObjectID()
+		pipeline.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		pipeline.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		if err := pipelines.Create(ctx, &pipeline); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "pipeline was not created"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, pipeline)
+	}
+}
+
+// UpdatePipeline edits a pipeline, stages replaces the whole list so it also
+// reorders them. A stage that still has deals cannot be left out, nor can its
+// outcome change, the status of those deals follows from it.
+// requires pipelines:write:any
+func UpdatePipeline(pipelines database.PipelineStore, deals database.DealStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		name := c.Param("pipeline_name")
+
+		var pipeline models.Pipeline
+		if err := c.BindJSON(&pipeline); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := pipelineValidate.StructExcept(pipeline, "Name", "Stages"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		found, err := pipelines.FindByName(ctx, name)
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "pipeline not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching pipeline"})
+			return
+		}
+
+		updateObj := bson.M{}
+
+		if pipeline.Description != nil {
+			updateObj["description"] = pipeline.Description
+		}
+
+		if pipeline.Stages != nil {
+			if err := pipelineValidate.Var(pipeline.Stages, "required,min=1,dive"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := helpers.ValidatePipelineStages(pipeline.Stages); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			for _, stage := range found.Stages {
+				next, kept := helpers.FindStage(pipeline, stage.Name)
+				if kept && next.Outcome == stage.Outcome {
+					continue
+				}
+
+				inStage, err := deals.List(ctx, database.ListQuery{
+					Filters: []database.Filter{database.Eq("pipeline", name), database.Eq("stage", stage.Name)},
+					Limit:   1,
+				})
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while counting deals"})
+					return
+				}
+
+				if inStage.Total > 0 && kept {
+					c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("stage %s still has %d deals, move them before changing its outcome", stage.Name, inStage.Total)})
+					return
+				}
+
+				if inStage.Total > 0 {
+					c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("stage %s still has %d deals, move them first", stage.Name, inStage.Total)})
+					return
+				}
+			}
+			updateObj["stages"] = pipeline.Stages
+		}
+
+		updateObj["updated_at"] = time.Now()
+
+		err = pipelines.Update(ctx, name, updateObj)
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "pipeline not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating pipeline"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "pipeline updated successfully"})
+	}
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var queueValidate = validator.New()
+
+func validateQueueMembers(ctx context.Context, users database.UserStore, members []string) error {
+	for _, memberId := range members {
+		if _, err := users.FindByID(ctx, memberId); err != nil {
+			return fmt.Errorf("queue member %s does not exist", memberId)
+		}
+	}
+	return nil
+}
+
+// requires queues:read
+func GetQueues(queues database.QueueStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		allQueues, err := queues.List(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing queues"})
+			return
+		}
+
+		c.JSON(http.StatusOK, allQueues)
+	}
+}
+
+// requires queues:write:any
+func CreateQueue(queues database.QueueStore, users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var queue models.Queue
+		if err := c.BindJSON(&queue); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := queueValidate.Struct(queue); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validateQueueMembers(ctx, users, queue.Members); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := queues.FindByName(ctx, *queue.Name); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "this queue already exists"})
+			return
+		}
+
+		if queue.Strategy == "" {
+			queue.Strategy = models.QUEUE_ROUND_ROBIN
+		}
+
+		if queue.Members == nil {
+			queue.Members = []string{}
+		}
+
+		queue.ID = primitive.NewObjectID()
+		queue.LastAssignedId = ""
+		queue.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		queue.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		if err := queues.Create(ctx, &queue); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "queue was not created"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, queue)
+	}
+}
+
+// requires queues:write:any
+func UpdateQueue(queues database.QueueStore, users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		name := c.Param("queue_name")
+
+		var queue models.Queue
+		if err := c.BindJSON(&queue); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := queueValidate.StructExcept(queue, "Name"); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updateObj := bson.M{}
+
+		if queue.Description != nil {
+			updateObj["description"] = queue.Description
+		}
+
+		if queue.Strategy != "" {
+			updateObj["strategy"] = queue.Strategy
+		}
+
+		if queue.Members != nil {
+			if err := validateQueueMembers(ctx, users, queue.Members); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj["members"] = queue.Members
+		}
+
+		updateObj["updated_at"] = time.Now()
+
+		err := queues.Update(ctx, name, updateObj)
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "queue not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating queue"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "queue updated successfully"})
+	}
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/roh4nyh/matrice_ai/database"
+	helper "github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var roleValidate = validator.New()
+
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if err := helper.ValidatePermission(permission); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requires roles:read:any
+func GetRoles(roles database.RoleStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		allRoles, err := roles.List(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing roles"})
+			return
+		}
+
+		c.JSON(http.StatusOK, allRoles)
+	}
+}
+
+// requires roles:write:any
+func CreateRole(roles database.RoleStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var role models.Role
+		if err := c.BindJSON(&role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := roleValidate.Struct(role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validatePermissions(role.Permissions); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := roles.FindByName(ctx, *role.Name); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "this role already exists"})
+			return
+		}
+
+		role.ID = primitive.NewObjectID()
+		role.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		role.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		if err := roles.Create(ctx, &role); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "role was not created"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, role)
+	}
+}
+
+// requires roles:write:any
+func UpdateRole(roles database.RoleStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		name := c.Param("role_name")
+
+		var role models.Role
+		if err := c.BindJSON(&role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updateObj := bson.M{}
+
+		if role.Description != nil {
+			updateObj["description"] = role.Description
+		}
+
+		if role.Permissions != nil {
+			if err := validatePermissions(role.Permissions); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj["permissions"] = role.Permissions
+		}
+
+		updateObj["updated_at"] = time.Now()
+
+		err := roles.Update(ctx, name, updateObj)
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "role not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating role"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "role updated successfully"})
+	}
+}
+
+// AssignUserRole changes a user's role, it applies from the user's next login or token refresh
+// requires roles:assign:any
+func AssignUserRole(users database.UserStore, roles database.RoleStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		userId := c.Param("user_id")
+
+		var body struct {
+			Role string `json:"role" validate:"required"`
+		}
+
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := roleValidate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if body.Role == models.ROLE_CUSTOMER {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the CUSTOMER role is reserved for customer accounts"})
+			return
+		}
+
+		if _, err := roles.FindByName(ctx, body.Role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role does not exist"})
+			return
+		}
+
+		err := users.Update(ctx, userId, bson.M{"role": body.Role, "updated_at": time.Now()})
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while assigning role"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "role assigned successfully"})
+	}
+}
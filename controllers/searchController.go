@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+)
+
+// Search finds customers, tickets (by description or comment) and interactions
+// matching ?q=, ranked and grouped by kind. ?types= narrows the kinds searched
+// and ?limit= caps each group. Callers only find what their role lets them
+// read, customers their own records and never internal notes.
+// requires customers:read, tickets:read or interactions:read
+func Search(roles database.RoleStore, users database.UserStore, customers database.CustomerStore, tickets database.TicketStore, comments database.CommentStore, interactions database.InteractionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		text := strings.TrimSpace(c.Query("q"))
+		if text == "" || utf8.RuneCountInString(text) > helpers.SEARCH_MAX_LENGTH {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("q must be 1 to %d characters", helpers.SEARCH_MAX_LENGTH)})
+			return
+		}
+
+		limit := helpers.SEARCH_DEFAULT_LIMIT
+		if value := c.Query("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > helpers.SEARCH_MAX_LIMIT {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a number from 1 to %d", helpers.SEARCH_MAX_LIMIT)})
+				return
+			}
+			limit = n
+		}
+
+		kinds := []string{helpers.SEARCH_CUSTOMERS, helpers.SEARCH_TICKETS, helpers.SEARCH_INTERACTIONS}
+		if value := c.Query("types"); value != "" {
+			asked := strings.Split(value, ",")
+			for _, kind := range asked {
+				if !slices.Contains(kinds, kind) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "types must be customers, tickets or interactions"})
+					return
+				}
+			}
+			kinds = asked
+		}
+
+		principal := helpers.GetPrincipal(c)
+		role, err := roles.FindByName(ctx, principal.Role)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "UnAuthenticated to access this resource"})
+			return
+		}
+
+		scopes := helpers.GetSearchScopes(role, principal)
+		if len(scopes.Kinds) == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "UnAuthenticated to access this resource"})
+			return
+		}
+
+		loc, ok := viewerLocation(ctx, c, users)
+		if !ok {
+			return
+		}
+
+		results, err := helpers.SearchRecords(ctx, customers, tickets, comments, interactions, text, kinds, limit, scopes)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while searching"})
+			return
+		}
+
+		for i := range results.Interactions {
+			results.Interactions[i].Record = helpers.InteractionIn(results.Interactions[i].Record, loc)
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
@@ -0,0 +1,109 @@
+package controllers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+)
+
+func TestSearchScoping(t *testing.T) {
+	app := newTestApp(t)
+
+	agent, agentToken := app.staff("agent", models.ROLE_AGENT)
+	alice, aliceToken := app.customer("Alice", "alice@example.com")
+	bob, bobToken := app.customer("Bob", "bob@example.com")
+
+	start := time.Now().Add(24 * time.Hour)
+	aliceTicket := app.ticket(alice, app.interaction(agent, alice, "alice meeting", start), "alice is stuck")
+	bobTicket := app.ticket(bob, app.interaction(agent, bob, "bob meeting", start), "bob is stuck")
+
+	// the tickets are only found through their comments
+	comments := []struct {
+		ticket   models.Ticket
+		token    string
+		body     string
+		internal bool
+	}{
+		{aliceTicket, aliceToken, "the invoice is wrong", false},
+		{aliceTicket, agentToken, "invoice disputed before", true},
+		{bobTicket, agentToken, "invoice sent again", false},
+	}
+	for _, comment := range comments {
+		body := map[string]any{"body": comment.body, "internal": comment.internal}
+		response := app.do(http.MethodPost, "/tickets/"+comment.ticket.TicketId+"/comments", comment.token, body)
+		if response.Code != http.StatusCreated {
+			t.Fatalf("comment got status %d: %s", response.Code, response.Body.String())
+		}
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		tickets  []string
+		comments int
+	}{
+		{"customer finds own ticket", aliceToken, []string{aliceTicket.TicketId}, 1},
+		{"other customer finds theirs", bobToken, []string{bobTicket.TicketId}, 1},
+		{"staff finds every ticket and note", agentToken, []string{aliceTicket.TicketId, bobTicket.TicketId}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := app.do(http.MethodGet, "/search?q=invoice&types=tickets", tt.token, nil)
+			if response.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", response.Code, response.Body.String())
+			}
+
+			var results helpers.SearchResults
+			decode(t, response, &results)
+
+			found := map[string]bool{}
+			comments := 0
+			for _, result := range results.Tickets {
+				found[result.Record.TicketId] = true
+				comments += len(result.Comments)
+			}
+
+			if len(found) != len(tt.tickets) {
+				t.Fatalf("got %d tickets, want %v", len(found), tt.tickets)
+			}
+			for _, id := range tt.tickets {
+				if !found[id] {
+					t.Fatalf("ticket %s not found", id)
+				}
+			}
+			if comments != tt.comments {
+				t.Fatalf("got %d comments, want %d", comments, tt.comments)
+			}
+		})
+	}
+
+	// comments carry their ticket's customer, and its assignee once claimed
+	count := func(filter database.Filter) int {
+		t.Helper()
+
+		page, err := app.stores.Comments.List(context.Background(), database.ListQuery{Filters: []database.Filter{filter}, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(page.Items)
+	}
+
+	if n := count(database.Eq("customer_id", alice.ID)); n != 2 {
+		t.Fatalf("got %d comments of alice's, want 2", n)
+	}
+
+	response := app.do(http.MethodPost, "/staff/tickets/"+aliceTicket.TicketId+"/claim", agentToken, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("claim got status %d: %s", response.Code, response.Body.String())
+	}
+
+	if n := count(database.Eq("assignee_id", agent.ID)); n != 2 {
+		t.Fatalf("got %d comments on the claimed ticket, want 2", n)
+	}
+}
@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var slaValidate = validator.New()
+
+// requires sla:read
+func GetSlaPolicies(slas database.SlaStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		policies, err := slas.ListPolicies(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing SLA policies"})
+			return
+		}
+
+		c.JSON(http.StatusOK, policies)
+	}
+}
+
+// requires sla:write:any
+func CreateSlaPolicy(slas database.SlaStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var policy models.SlaPolicy
+		if err := c.BindJSON(&policy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := slaValidate.Struct(policy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if policy.Calendar != "" {
+			if _, err := slas.FindCalendarByName(ctx, policy.Calendar); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "business calendar does not exist"})
+				return
+			}
+		}
+
+		if _, err := slas.FindPolicyByName(ctx, *policy.Name); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "this SLA policy already exists"})
+			return
+		}
+
+		policy.ID = primitive.NewObjectID()
+		policy.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		policy.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		if err := slas.CreatePolicy(ctx, &policy); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "SLA policy was not created"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, policy)
+	}
+}
+
+// UpdateSlaPolicy changes a policy's targets, tickets raised earlier keep the due dates they were given
+// requires sla:write:any
+func UpdateSlaPolicy(slas database.SlaStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		name := c.Param("policy_name")
+
+		var policy models.SlaPolicy
+		if err := c.BindJSON(&policy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updateObj := bson.M{}
+
+		if policy.Priority != nil {
+			if err := slaValidate.Var(*policy.Priority, "eq=low|eq=normal|eq=high|eq=urgent"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "priority must be one of low, normal, high or urgent"})
+				return
+			}
+			updateObj["priority"] = policy.Priority
+		}
+
+		if policy.Company != nil {
+			updateObj["company"] = policy.Company
+		}
+
+		if policy.FirstResponseMinutes > 0 {
+			updateObj["first_response_minutes"] = policy.FirstResponseMinutes
+		}
+
+		if policy.ResolutionMinutes > 0 {
+			updateObj["resolution_minutes"] = policy.ResolutionMinutes
+		}
+
+		if policy.Calendar != "" {
+			if _, err := slas.FindCalendarByName(ctx, policy.Calendar); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "business calendar does not exist"})
+				return
+			}
+			updateObj["calendar"] = policy.Calendar
+		}
+
+		updateObj["updated_at"] = time.Now()
+
+		err := slas.UpdatePolicy(ctx, name, updateObj)
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "SLA policy not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating SLA policy"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "SLA policy updated successfully"})
+	}
+}
+
+// requires sla:read
+func GetBusinessCalendars(slas database.SlaStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		calendars, err := slas.ListCalendars(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing business calendars"})
+			return
+		}
+
+		c.JSON(http.StatusOK, calendars)
+	}
+}
+
+// requires sla:write:any
+func CreateBusinessCalendar(slas database.SlaStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var calendar models.BusinessCalendar
+		if err := c.BindJSON(&calendar); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := slaValidate.Struct(calendar); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := checkBusinessHours(calendar.Hours); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := slas.FindCalendarByName(ctx, *calendar.Name); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "this business calendar already exists"})
+			return
+		}
+
+		if calendar.TimeZone == "" {
+			calendar.TimeZone = "UTC"
+		}
+
+		if calendar.Holidays == nil {
+			calendar.Holidays = []string{}
+		}
+
+		calendar.ID = primitive.NewObjectID()
+		calendar.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		calendar.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		if err := slas.CreateCalendar(ctx, &calendar); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "business calendar was not created"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, calendar)
+	}
+}
+
+// requires sla:write:any
+func UpdateBusinessCalendar(slas database.SlaStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		name := c.Param("calendar_name")
+
+		var calendar models.BusinessCalendar
+		if err := c.BindJSON(&calendar); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updateObj := bson.M{}
+
+		if calendar.TimeZone != "" {
+			if err := slaValidate.Var(calendar.TimeZone, "timezone"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "time_zone must be an IANA time zone"})
+				return
+			}
+			updateObj["time_zone"] = calendar.TimeZone
+		}
+
+		if calendar.Hours != nil {
+			if err := slaValidate.Var(calendar.Hours, "min=1,dive"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := checkBusinessHours(calendar.Hours); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj["hours"] = calendar.Hours
+		}
+
+		if calendar.Holidays != nil {
+			if err := slaValidate.Var(calendar.Holidays, "dive,datetime=2006-01-02"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj["holidays"] = calendar.Holidays
+		}
+
+		updateObj["updated_at"] = time.Now()
+
+		err := slas.UpdateCalendar(ctx, name, updateObj)
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "business calendar not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating business calendar"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "business calendar updated successfully"})
+	}
+}
+
+// checkBusinessHours refuses windows that do not end after they start, they
+// would never count any working time. "15:04" times compare as strings.
+func checkBusinessHours(hours []models.BusinessHours) error {
+	for _, window := range hours {
+		if err := slaValidate.Struct(window); err != nil {
+			return err
+		}
+
+		if window.Start >= window.End {
+			return fmt.Errorf("business hours on weekday %d must end after they start", window.Weekday)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package controllers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/roh4nyh/matrice_ai/models"
+)
+
+func TestBusinessCalendarHours(t *testing.T) {
+	app := newTestApp(t)
+
+	_, adminToken := app.staff("admin", models.ROLE_ADMIN)
+	created := app.do(http.MethodPost, "/staff/sla/calendars", adminToken, map[string]any{
+		"name":  "office",
+		"hours": []map[string]any{{"weekday": 1, "start": "09:00", "end": "17:00"}},
+	})
+	if created.Code != http.StatusCreated {
+		t.Fatalf("got status %d: %s", created.Code, created.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   map[string]any
+		status int
+	}{
+		{"create ending before it starts", http.MethodPost, "/staff/sla/calendars", map[string]any{"name": "night", "hours": []map[string]any{{"weekday": 1, "start": "22:00", "end": "06:00"}}}, http.StatusBadRequest},
+		{"create ending as it starts", http.MethodPost, "/staff/sla/calendars", map[string]any{"name": "empty", "hours": []map[string]any{{"weekday": 1, "start": "09:00", "end": "09:00"}}}, http.StatusBadRequest},
+		{"update ending before it starts", http.MethodPut, "/staff/sla/calendars/office", map[string]any{"hours": []map[string]any{{"weekday": 2, "start": "17:00", "end": "09:00"}}}, http.StatusBadRequest},
+		{"update with a valid window", http.MethodPut, "/staff/sla/calendars/office", map[string]any{"hours": []map[string]any{{"weekday": 2, "start": "08:00", "end": "16:00"}}}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := app.do(tt.method, tt.path, adminToken, tt.body)
+			if response.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", response.Code, tt.status, response.Body.String())
+			}
+		})
+	}
+}
@@ -1,262 +1,552 @@
-package controllers
-
-import (
-	"context"
-	"fmt"
-	"net/http"
-	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
-	"github.com/roh4nyh/matrice_ai/database"
-	"github.com/roh4nyh/matrice_ai/models"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-)
-
-const (
-	TicketDatabaseName   = "Cluster0"
-	TicketCollectionName = "tickets"
-)
-
-var TicketValidate = validator.New()
-var TicketCollection *mongo.Collection = database.OpenCollection(TicketDatabaseName, TicketCollectionName)
-
-func CreateTicket() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var ticket models.Ticket
-		if err := c.BindJSON(&ticket); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		validationErr := userValidate.Struct(ticket)
-		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
-			return
-		}
-
-		interactionIdStr := c.Param("interaction_id")
-		interactionId, err := primitive.ObjectIDFromHex(interactionIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interaction ID"})
-			return
-		}
-
-		customerIdStr := c.GetString("cid")
-		customerId, err := primitive.ObjectIDFromHex(customerIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
-			return
-		}
-
-		filter := bson.M{
-			"_id":         interactionId,
-			"customer_id": customerId,
-		}
-
-		var interaction models.Interaction
-		err = InteractionCollection.FindOne(ctx, filter).Decode(&interaction)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "customer not belongs to this interaction or interaction not exists"})
-			return
-		}
-
-		ticket.CustomerID = customerId
-		ticket.InteractionID = interactionId
-		ticket.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		ticket.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		ticket.ID = primitive.NewObjectID()
-		ticket.TicketId = ticket.ID.Hex()
-
-		resultInsertionNumber, insertErr := TicketCollection.InsertOne(ctx, ticket)
-		if insertErr != nil {
-			msg := fmt.Sprintln("fialed to create Interaction")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
-		}
-
-		c.JSON(http.StatusCreated, resultInsertionNumber)
-	}
-}
-
-func UpdateTicket() gin.HandlerFunc {
-	return func(c *gin.Context) {
-
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var ticket models.Ticket
-		if err := c.BindJSON(&ticket); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		ticketIdStr := c.Param("ticket_id")
-		ticketId, err := primitive.ObjectIDFromHex(ticketIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
-			return
-		}
-
-		customerIdStr := c.GetString("cid")
-		customerId, err := primitive.ObjectIDFromHex(customerIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
-			return
-		}
-
-		filter := bson.M{
-			"_id":         ticketId,
-			"customer_id": customerId,
-		}
-
-		// var ticket models.Ticket
-		err = TicketCollection.FindOne(ctx, filter).Err()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching interaction"})
-			return
-		}
-
-		updateObj := bson.M{}
-
-		if ticket.Status != nil {
-			updateObj["status"] = ticket.Status
-		}
-
-		if ticket.Description != nil {
-			updateObj["description"] = ticket.Description
-		}
-
-		updateObj["updated_at"] = time.Now()
-
-		update := bson.M{"$set": updateObj}
-
-		_, err = TicketCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating ticket"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "ticket updated successfully"})
-
-	}
-}
-
-func GetAllTickets() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var tickets []models.Ticket
-
-		cursor, err := TicketCollection.Find(ctx, bson.M{})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing users"})
-			return
-		}
-
-		if err = cursor.All(ctx, &tickets); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding user data"})
-			return
-		}
-
-		if len(tickets) == 0 {
-			c.JSON(http.StatusOK, gin.H{"error": "no tickets available"})
-			return
-		}
-
-		c.JSON(http.StatusOK, tickets)
-	}
-}
-
-// admin feature !!!
-// func GetTicketByID() gin.HandlerFunc {
-// 	return func(c *gin.Context) {
-// 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-// 		defer cancel()
-
-// 		ticketId := c.Param("ticketId")
-
-// 		var ticket models.Ticket
-// 		err := TicketCollection.FindOne(ctx, bson.M{"ticketid": ticketId}).Decode(&ticket)
-// 		if err != nil {
-// 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching ticket"})
-// 			return
-// 		}
-
-// 		c.JSON(http.StatusOK, ticket)
-// 	}
-// }
-
-func GetTicketsByUserID() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		userIdStr := c.Param("user_id")
-		userId, err := primitive.ObjectIDFromHex(userIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid User ID"})
-			return
-		}
-
-		var tickets []models.Ticket
-		cursor, err := TicketCollection.Find(ctx, bson.M{"user_id": userId})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing users"})
-			return
-		}
-
-		if err = cursor.All(ctx, &tickets); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding user data"})
-			return
-		}
-
-		if len(tickets) == 0 {
-			c.JSON(http.StatusOK, gin.H{"error": "no tickets raised by this user"})
-			return
-		}
-
-		c.JSON(http.StatusOK, tickets)
-
-	}
-}
-
-func DeleteTicket() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		ticketIdStr := c.Param("ticket_id")
-		ticketId, err := primitive.ObjectIDFromHex(ticketIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
-			return
-		}
-
-		customerIdStr := c.GetString("cid")
-		customerId, err := primitive.ObjectIDFromHex(customerIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
-			return
-		}
-
-		filter := bson.M{
-			"_id":         ticketId,
-			"customer_id": customerId,
-		}
-
-		_, err = TicketCollection.DeleteOne(ctx, filter)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "ticket deletion failed or ticket not found"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "ticket deleted successfully"})
-	}
-}
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var TicketValidate = validator.New()
+
+// CreateTicket raises a ticket into the requested queue, or TICKET_DEFAULT_QUEUE,
+// hands it to one of the queue's staff when the queue exists and sets its SLA due dates.
+func CreateTicket(tickets database.TicketStore, interactions database.InteractionStore, customers database.CustomerStore, queues database.QueueStore, users database.UserStore, roles database.RoleStore, slas database.SlaStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var ticket models.Ticket
+		if err := c.BindJSON(&ticket); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := userValidate.Struct(ticket)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		interactionIdStr := c.Param("interaction_id")
+		interactionId, err := primitive.ObjectIDFromHex(interactionIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interaction ID"})
+			return
+		}
+
+		customerIdStr := c.GetString("cid")
+		customerId, err := primitive.ObjectIDFromHex(customerIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+			return
+		}
+
+		interaction, err := interactions.FindByID(ctx, interactionId)
+		if err != nil || interaction.CustomerID != customerId {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "customer not belongs to this interaction or interaction not exists"})
+			return
+		}
+
+		// customers only pick the queue, the priority is staff's to raise and the rest is set for them
+		customer, err := customers.FindByID(ctx, customerIdStr)
+		if err != nil {
+			customer = models.Customer{ID: customerId}
+		}
+
+		ticket.InteractionID = interactionId
+		ticket.Channel = models.TICKET_CHANNEL_PORTAL
+		ticket.Priority = ""
+
+		err = helpers.OpenTicket(ctx, tickets, queues, users, roles, slas, &ticket, customer)
+		if errors.Is(err, helpers.ErrUnknownQueue) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if errors.Is(err, helpers.ErrQueueBusy) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while raising ticket"})
+			return
+		}
+
+		insertErr := tickets.Create(ctx, &ticket)
+		if insertErr != nil {
+			msg := fmt.Sprintln("fialed to create Interaction")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"InsertedID": ticket.ID})
+	}
+}
+
+// ticketUpdate is the body of UpdateTicket, Note is kept in the status history.
+type ticketUpdate struct {
+	Status      *string `json:"status"`
+	Description *string `json:"description"`
+	Priority    *string `json:"priority"`
+	Note        string  `json:"note"`
+}
+
+// UpdateTicket changes the description, moves the status through the ticket
+// workflow and lets staff reprioritise, which recomputes the SLA due dates.
+func UpdateTicket(tickets database.TicketStore, customers database.CustomerStore, slas database.SlaStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var ticket ticketUpdate
+		if err := c.BindJSON(&ticket); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ticketIdStr := c.Param("ticket_id")
+		ticketId, err := primitive.ObjectIDFromHex(ticketIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+			return
+		}
+
+		existing, err := tickets.FindByID(ctx, ticketId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching interaction"})
+			return
+		}
+
+		if err := helpers.MatchCustomerTypeToCid(c, existing.CustomerID.Hex()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updateObj := bson.M{}
+
+		if ticket.Priority != nil && *ticket.Priority != existing.Priority {
+			if !helpers.GetPrincipal(c).IsUser() {
+				c.JSON(http.StatusForbidden, gin.H{"error": "only staff can change the ticket priority"})
+				return
+			}
+
+			if !helpers.IsTicketPriority(*ticket.Priority) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "priority must be one of low, normal, high or urgent"})
+				return
+			}
+
+			company := ""
+			if customer, err := customers.FindByID(ctx, existing.CustomerID.Hex()); err == nil && customer.Company != nil {
+				company = *customer.Company
+			}
+
+			existing.Priority = *ticket.Priority
+			if err := helpers.ApplySlaPolicy(ctx, slas, &existing, company); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while applying SLA policy"})
+				return
+			}
+
+			updateObj["priority"] = existing.Priority
+			updateObj["sla_policy"] = existing.SlaPolicy
+			updateObj["first_response_due"] = existing.FirstResponseDue
+			updateObj["resolution_due"] = existing.ResolutionDue
+		}
+
+		if ticket.Description != nil {
+			updateObj["description"] = ticket.Description
+		}
+
+		// a status move carries the other fields, so nothing is written when it loses a race
+		if ticket.Status != nil && (existing.Status == nil || *ticket.Status != *existing.Status) {
+			err := helpers.TransitionTicket(ctx, tickets, existing, *ticket.Status, helpers.GetPrincipal(c), ticket.Note, updateObj)
+			switch {
+			case errors.Is(err, helpers.ErrInvalidTicketStatus):
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			case errors.Is(err, helpers.ErrTicketTransitionDenied), errors.Is(err, helpers.ErrTicketReopenExpired):
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			case errors.Is(err, helpers.ErrTicketStatusConflict):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			case err != nil:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating ticket status"})
+				return
+			}
+		} else if len(updateObj) > 0 {
+			updateObj["updated_at"] = time.Now()
+
+			err = tickets.Update(ctx, ticketId, updateObj)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating ticket"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "ticket updated successfully"})
+
+	}
+}
+
+// ticketListSpec is what the ticket lists can be filtered and sorted by.
+var ticketListSpec = helpers.ListSpec{
+	Fields: map[string]helpers.ListField{
+		"status":         {Field: "status", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"priority":       {Field: "priority", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"queue":          {Field: "queue", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"channel":        {Field: "channel", Kind: helpers.LIST_FIELD_STRING, Filter: true},
+		"customer_id":    {Field: "customer_id", Kind: helpers.LIST_FIELD_ID, Filter: true},
+		"assignee_id":    {Field: "assignee_id", Kind: helpers.LIST_FIELD_ID, Filter: true},
+		"sla_breached":   {Field: "sla_breached", Kind: helpers.LIST_FIELD_BOOL, Filter: true},
+		"created_at":     {Field: "created_at", Kind: helpers.LIST_FIELD_TIME, Filter: true, Sort: true},
+		"updated_at":     {Field: "updated_at", Kind: helpers.LIST_FIELD_TIME, Filter: true, Sort: true},
+		"resolution_due": {Field: "resolution_due", Kind: helpers.LIST_FIELD_TIME, Sort: true},
+	},
+	DefaultSort: "-created_at",
+}
+
+// listTickets writes one page of the tickets matching the query and scope, it
+// writes the error response itself.
+func listTickets(ctx context.Context, c *gin.Context, tickets database.TicketStore, scope ...database.Filter) {
+	query, err := helpers.ParseListQuery(c.Request.URL.Query(), ticketListSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query.Filters = append(query.Filters, scope...)
+
+	// ?sla=breached is kept from before sla_breached=true
+	switch c.Query("sla") {
+	case "":
+	case "breached":
+		query.Filters = append(query.Filters, database.Eq("sla_breached", true))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sla filter must be breached"})
+		return
+	}
+
+	page, err := tickets.List(ctx, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing tickets"})
+		return
+	}
+
+	c.JSON(http.StatusOK, helpers.NewPageBody(query, page))
+}
+
+// requires tickets:read:any
+func GetAllTickets(tickets database.TicketStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		listTickets(ctx, c, tickets)
+	}
+}
+
+func GetTicket(tickets database.TicketStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		ticketId, err := primitive.ObjectIDFromHex(c.Param("ticket_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+			return
+		}
+
+		ticket, err := tickets.FindByID(ctx, ticketId)
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching ticket"})
+			return
+		}
+
+		if err := helpers.MatchCustomerTypeToCid(c, ticket.CustomerID.Hex()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, ticket)
+	}
+}
+
+func GetTicketHistory(tickets database.TicketStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		ticketId, err := primitive.ObjectIDFromHex(c.Param("ticket_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+			return
+		}
+
+		ticket, err := tickets.FindByID(ctx, ticketId)
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching ticket"})
+			return
+		}
+
+		if err := helpers.MatchCustomerTypeToCid(c, ticket.CustomerID.Hex()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		history := ticket.StatusHistory
+		if history == nil {
+			history = []models.TicketStatusChange{}
+		}
+
+		c.JSON(http.StatusOK, history)
+	}
+}
+
+func GetTicketsByCustomerID(tickets database.TicketStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		customerIdStr := c.Param("customer_id")
+
+		if err := helpers.MatchCustomerTypeToCid(c, customerIdStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		customerId, err := primitive.ObjectIDFromHex(customerIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+			return
+		}
+
+		listTickets(ctx, c, tickets, database.Eq("customer_id", customerId))
+	}
+}
+
+// GetMyTickets lists the tickets assigned to the calling staff user.
+func GetMyTickets(tickets database.TicketStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		userId, err := primitive.ObjectIDFromHex(helpers.GetPrincipal(c).Id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		listTickets(ctx, c, tickets, database.Eq("assignee_id", userId))
+	}
+}
+
+// ClaimTicket assigns an unassigned ticket to the calling staff user.
+func ClaimTicket(tickets database.TicketStore, comments database.CommentStore, transactions database.Transactor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		ticketId, err := primitive.ObjectIDFromHex(c.Param("ticket_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+			return
+		}
+
+		userId, err := primitive.ObjectIDFromHex(helpers.GetPrincipal(c).Id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		ticket, err := tickets.FindByID(ctx, ticketId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+			return
+		}
+
+		ticket.AssigneeID = &userId
+		err = transactions.RunInTransaction(ctx, func(ctx context.Context) error {
+			if err := tickets.Claim(ctx, ticketId, userId, time.Now()); err != nil {
+				return err
+			}
+			return comments.SetTicket(ctx, ticket)
+		})
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusConflict, gin.H{"error": "ticket is already assigned"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while claiming ticket"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "ticket claimed successfully"})
+	}
+}
+
+// AssignTicket hands a ticket to another staff user and optionally moves it to
+// another queue, "own" callers may only pass on tickets assigned to them.
+func AssignTicket(tickets database.TicketStore, comments database.CommentStore, users database.UserStore, roles database.RoleStore, queues database.QueueStore, transactions database.Transactor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var body struct {
+			AssigneeId string `json:"assignee_id" validate:"required"`
+			Queue      string `json:"queue"`
+		}
+
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := TicketValidate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ticketId, err := primitive.ObjectIDFromHex(c.Param("ticket_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+			return
+		}
+
+		ticket, err := tickets.FindByID(ctx, ticketId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+			return
+		}
+
+		currentAssignee := ""
+		if ticket.AssigneeID != nil {
+			currentAssignee = ticket.AssigneeID.Hex()
+		}
+
+		if err := helpers.MatchUserTypeToUid(c, currentAssignee); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		assignee, err := users.FindByID(ctx, body.AssigneeId)
+		if err != nil || !helpers.CanWorkTickets(ctx, roles, assignee) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "assignee does not exist or cannot take tickets"})
+			return
+		}
+
+		now := time.Now()
+		updateObj := bson.M{"assignee_id": assignee.ID, "assigned_at": now, "updated_at": now}
+
+		if body.Queue != "" {
+			if _, err := queues.FindByName(ctx, body.Queue); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "queue does not exist"})
+				return
+			}
+			updateObj["queue"] = body.Queue
+		}
+
+		ticket.AssigneeID = &assignee.ID
+		err = transactions.RunInTransaction(ctx, func(ctx context.Context) error {
+			if err := tickets.Update(ctx, ticketId, updateObj); err != nil {
+				return err
+			}
+			return comments.SetTicket(ctx, ticket)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while assigning ticket"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "ticket assigned successfully"})
+	}
+}
+
+// DeleteTicket removes the ticket together with its comments.
+func DeleteTicket(tickets database.TicketStore, comments database.CommentStore, attachments database.AttachmentStore, blobs database.BlobStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		ticketIdStr := c.Param("ticket_id")
+		ticketId, err := primitive.ObjectIDFromHex(ticketIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+			return
+		}
+
+		ticket, err := tickets.FindByID(ctx, ticketId)
+		if err == nil {
+			if err := helpers.MatchCustomerTypeToCid(c, ticket.CustomerID.Hex()); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			err = tickets.Delete(ctx, ticketId)
+		}
+
+		if err == nil {
+			err = comments.DeleteByTicket(ctx, ticketId)
+		}
+
+		if err == nil {
+			err = helpers.DeleteAttachments(ctx, attachments, blobs, models.ATTACHMENT_TICKET, ticketId)
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "ticket deletion failed or ticket not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "ticket deleted successfully"})
+	}
+}
+
+// findAccessibleTicket loads the ticket named in the path and checks the caller may
+// see it, it writes the error response itself and returns false on failure.
+func findAccessibleTicket(ctx context.Context, c *gin.Context, tickets database.TicketStore) (models.Ticket, bool) {
+	ticketId, err := primitive.ObjectIDFromHex(c.Param("ticket_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+		return models.Ticket{}, false
+	}
+
+	ticket, err := tickets.FindByID(ctx, ticketId)
+	if errors.Is(err, database.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ticket not found"})
+		return ticket, false
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching ticket"})
+		return ticket, false
+	}
+
+	if err := helpers.MatchCustomerTypeToCid(c, ticket.CustomerID.Hex()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return ticket, false
+	}
+	return ticket, true
+}
@@ -0,0 +1,173 @@
+package controllers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTicketScoping(t *testing.T) {
+	app := newTestApp(t)
+
+	agent, agentToken := app.staff("agent", models.ROLE_AGENT)
+	_, readonlyToken := app.staff("readonly", models.ROLE_READONLY)
+	alice, aliceToken := app.customer("Alice", "alice@example.com")
+	bob, bobToken := app.customer("Bob", "bob@example.com")
+
+	start := time.Now().Add(24 * time.Hour)
+	aliceTicket := app.ticket(alice, app.interaction(agent, alice, "alice meeting", start), "alice is stuck")
+	bobTicket := app.ticket(bob, app.interaction(agent, bob, "bob meeting", start), "bob is stuck")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   any
+		status int
+		count  int
+	}{
+		{"customer lists own tickets", http.MethodGet, "/customers/" + alice.CustomerId + "/tickets", aliceToken, nil, http.StatusOK, 1},
+		{"customer lists another's tickets", http.MethodGet, "/customers/" + bob.CustomerId + "/tickets", aliceToken, nil, http.StatusBadRequest, 0},
+		{"customer reads own ticket", http.MethodGet, "/tickets/" + aliceTicket.TicketId, aliceToken, nil, http.StatusOK, 0},
+		{"customer reads another's ticket", http.MethodGet, "/tickets/" + bobTicket.TicketId, aliceToken, nil, http.StatusBadRequest, 0},
+		{"customer comments on another's ticket", http.MethodPost, "/tickets/" + aliceTicket.TicketId + "/comments", bobToken, map[string]any{"body": "mine now"}, http.StatusBadRequest, 0},
+		{"staff lists a customer's tickets", http.MethodGet, "/customers/" + bob.CustomerId + "/tickets", agentToken, nil, http.StatusOK, 1},
+		{"staff lists every ticket", http.MethodGet, "/staff/tickets", agentToken, nil, http.StatusOK, 2},
+		{"read only staff cannot comment", http.MethodPost, "/tickets/" + bobTicket.TicketId + "/comments", readonlyToken, map[string]any{"body": "hello"}, http.StatusForbidden, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := app.do(tt.method, tt.path, tt.token, tt.body)
+			if response.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", response.Code, tt.status, response.Body.String())
+			}
+
+			if tt.count == 0 {
+				return
+			}
+
+			var page struct {
+				Data []models.Ticket `json:"data"`
+			}
+			decode(t, response, &page)
+			if len(page.Data) != tt.count {
+				t.Fatalf("got %d tickets, want %d", len(page.Data), tt.count)
+			}
+		})
+	}
+}
+
+func TestTicketReopenWindow(t *testing.T) {
+	app := newTestApp(t)
+
+	agent, _ := app.staff("agent", models.ROLE_AGENT)
+	alice, aliceToken := app.customer("Alice", "alice@example.com")
+	interaction := app.interaction(agent, alice, "alice meeting", time.Now().Add(24*time.Hour))
+
+	recent := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name       string
+		resolvedAt *time.Time
+		status     int
+		want       string
+	}{
+		{"resolved within the window", &recent, http.StatusOK, models.TICKET_OPEN},
+		{"resolved without resolved_at", nil, http.StatusForbidden, models.TICKET_RESOLVED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ticket := app.ticket(alice, interaction, "still broken")
+			fields := bson.M{"status": models.TICKET_RESOLVED, "resolved_at": tt.resolvedAt}
+			if err := app.stores.Tickets.Update(context.Background(), ticket.ID, fields); err != nil {
+				t.Fatal(err)
+			}
+
+			body := map[string]any{"status": models.TICKET_OPEN, "description": "broken again"}
+			response := app.do(http.MethodPut, "/tickets/"+ticket.TicketId, aliceToken, body)
+			if response.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", response.Code, tt.status, response.Body.String())
+			}
+
+			stored, err := app.stores.Tickets.FindByID(context.Background(), ticket.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *stored.Status != tt.want {
+				t.Fatalf("got status %s, want %s", *stored.Status, tt.want)
+			}
+
+			// the description only lands together with the status move
+			moved := tt.want == models.TICKET_OPEN
+			if (*stored.Description == "broken again") != moved {
+				t.Fatalf("got description %q after the move was %v", *stored.Description, moved)
+			}
+		})
+	}
+}
+
+func TestAssignTicket(t *testing.T) {
+	app := newTestApp(t)
+
+	agent, agentToken := app.staff("agent", models.ROLE_AGENT)
+	other, _ := app.staff("other", models.ROLE_AGENT)
+	_, adminToken := app.staff("admin", models.ROLE_ADMIN)
+	alice, _ := app.customer("Alice", "alice@example.com")
+	interaction := app.interaction(agent, alice, "alice meeting", time.Now().Add(24*time.Hour))
+
+	tests := []struct {
+		name     string
+		assignee models.User
+		token    string
+		status   int
+	}{
+		{"agent moves their own ticket", agent, agentToken, http.StatusOK},
+		{"agent takes another agent's ticket", other, agentToken, http.StatusForbidden},
+		{"admin reassigns another agent's ticket", agent, adminToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ticket := app.ticket(alice, interaction, "needs an owner")
+			fields := bson.M{"assignee_id": tt.assignee.ID}
+			if err := app.stores.Tickets.Update(context.Background(), ticket.ID, fields); err != nil {
+				t.Fatal(err)
+			}
+
+			body := map[string]any{"assignee_id": other.UserId}
+			response := app.do(http.MethodPut, "/staff/tickets/"+ticket.TicketId+"/assignee", tt.token, body)
+			if response.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", response.Code, tt.status, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestCreateTicketPriority(t *testing.T) {
+	app := newTestApp(t)
+
+	agent, _ := app.staff("agent", models.ROLE_AGENT)
+	alice, aliceToken := app.customer("Alice", "alice@example.com")
+	interaction := app.interaction(agent, alice, "alice meeting", time.Now().Add(24*time.Hour))
+
+	body := map[string]any{"description": "everything is on fire", "priority": models.PRIORITY_URGENT}
+	response := app.do(http.MethodPost, "/portal/interactions/"+interaction.InteractionId+"/tickets", aliceToken, body)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("got status %d: %s", response.Code, response.Body.String())
+	}
+
+	page, err := app.stores.Tickets.List(context.Background(), database.ListQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Priority != models.PRIORITY_NORMAL {
+		t.Fatalf("got %+v, want one ticket at normal priority", page.Items)
+	}
+}
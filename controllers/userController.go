@@ -1,307 +1,396 @@
-package controllers
-
-import (
-	"context"
-	"fmt"
-	"log"
-	"net/http"
-	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
-	"github.com/roh4nyh/matrice_ai/database"
-	helper "github.com/roh4nyh/matrice_ai/helpers"
-	"github.com/roh4nyh/matrice_ai/models"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
-)
-
-const (
-	userdatabaseName   = "Cluster0"
-	userCollectionName = "users"
-)
-
-var userValidate = validator.New()
-var UserCollection *mongo.Collection = database.OpenCollection(userdatabaseName, userCollectionName)
-
-func HashPassword(password string) string {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 15)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return string(bytes)
-}
-
-func VerifyPassword(userPassword, foundUserPassword string) (bool, string) {
-	err := bcrypt.CompareHashAndPassword([]byte(foundUserPassword), []byte(userPassword))
-	check := true
-	msg := ""
-
-	if err != nil {
-		msg = fmt.Sprintln("email or password is incorrect")
-		check = false
-	}
-
-	return check, msg
-}
-
-// @Summary Add a new pet to the store
-// @Description get string by ID
-// @ID get-string-by-int
-// @Accept  json
-// @Produce  json
-// @Param   some_id     path    int     true        "Some ID"
-// @Success 200 {string} string  "ok"
-// @Router /string/{some_id} [get]
-func UserSignUp() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		var user models.User
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		validationErr := userValidate.Struct(user)
-		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
-			return
-		}
-
-		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for email"})
-			log.Panic(err)
-		}
-
-		if count > 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "this email already exists"})
-			return
-		}
-
-		password := HashPassword(*user.Password)
-		user.Password = &password
-
-		user.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		user.ID = primitive.NewObjectID()
-		user.UserId = user.ID.Hex()
-
-		token, _ := helper.GenerateUserToken(*user.Email, *user.Name, user.UserId, *user.Role)
-		user.Token = &token
-
-		resultInsertionNumber, insertErr := UserCollection.InsertOne(ctx, user)
-		if insertErr != nil {
-			msg := fmt.Sprintln("User item was not created")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
-		}
-
-		c.JSON(http.StatusCreated, resultInsertionNumber)
-	}
-}
-
-// PingExample godoc
-// @Summary ping example
-// @Schemes
-// @Description do ping
-// @Accept json
-// @Produce json
-// @Success 200 {string} Helloworld
-// @Router /example/helloworld [get]
-func UserLogIn() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		var user models.User
-		var foundUser models.User
-
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "email or password is incorrect"})
-			return
-		}
-
-		passwordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
-		if !passwordIsValid {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
-		}
-
-		if foundUser.Email == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
-			return
-		}
-
-		token, err := helper.GenerateUserToken(*foundUser.Email, *foundUser.Name, foundUser.UserId, *foundUser.Role)
-		if err != nil || token == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		helper.UpdateUserToken(token, foundUser.UserId)
-
-		err = UserCollection.FindOne(ctx, bson.M{"user_id": foundUser.UserId}).Decode(&foundUser)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		c.JSON(http.StatusOK, foundUser)
-	}
-}
-
-func GetUsers() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		if err := helper.CheckUserType(c, models.ROLE_ADMIN); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		var users []models.User
-
-		cursor, err := UserCollection.Find(ctx, bson.M{})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing users"})
-			return
-		}
-
-		if err = cursor.All(ctx, &users); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding user data"})
-			return
-		}
-
-		if len(users) == 0 {
-			c.JSON(http.StatusOK, gin.H{"error": "no users available"})
-			return
-		}
-
-		c.JSON(http.StatusOK, users)
-	}
-}
-
-func GetUser() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userId := c.Param("user_id")
-
-		if err := helper.MatchUserTypeToUid(c, userId); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		var user models.User
-		err := UserCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
-
-		if user.Email == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
-			return
-		}
-
-		c.JSON(http.StatusOK, user)
-	}
-}
-
-func UpdateUser() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userId := c.Param("user_id")
-
-		if err := helper.MatchUserTypeToUid(c, userId); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		var user models.User
-
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		updateObj := bson.M{}
-
-		if user.Name != nil {
-			updateObj["name"] = user.Name
-		}
-
-		if user.Email != nil {
-			updateObj["email"] = user.Email
-		}
-
-		// if user.Password != nil {
-		// 	password := HashPassword(*user.Password)
-		// 	updateObj["password"] = password
-		// }
-
-		if user.Password != nil {
-			password := HashPassword(*user.Password)
-			updateObj["password"] = password
-		}
-
-		// if user.Role != nil && *user.Role == "ADMIN" {
-		// 	updateObj["role"] = user.Role
-		// }
-
-		updateObj["updated_at"] = time.Now()
-
-		filter := bson.M{"user_id": bson.M{"$eq": userId}}
-		update := bson.M{"$set": updateObj}
-
-		_, err := UserCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating user"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "user updated successfully"})
-	}
-}
-
-func DeleteUser() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userId := c.Param("user_id")
-
-		if err := helper.MatchUserTypeToUid(c, userId); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		result, err := UserCollection.DeleteOne(ctx, bson.M{"user_id": userId})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting user"})
-			return
-		}
-
-		if result.DeletedCount == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
-	}
-}
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/roh4nyh/matrice_ai/database"
+	helper "github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var userValidate = validator.New()
+
+func HashPassword(password string) string {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 15)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return string(bytes)
+}
+
+func VerifyPassword(userPassword, foundUserPassword string) (bool, string) {
+	err := bcrypt.CompareHashAndPassword([]byte(foundUserPassword), []byte(userPassword))
+	check := true
+	msg := ""
+
+	if err != nil {
+		msg = fmt.Sprintln("email or password is incorrect")
+		check = false
+	}
+
+	return check, msg
+}
+
+// @Summary Add a new pet to the store
+// @Description get string by ID
+// @ID get-string-by-int
+// @Accept  json
+// @Produce  json
+// @Param   some_id     path    int     true        "Some ID"
+// @Success 200 {string} string  "ok"
+// @Router /string/{some_id} [get]
+func UserSignUp(users database.UserStore, roles database.RoleStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := userValidate.Struct(user)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if err := helper.PreparePreferences(user.TimeZone, user.Locale); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		role := helper.USER_SIGNUP_ROLE
+		if _, err := roles.FindByName(ctx, role); err != nil || role == models.ROLE_CUSTOMER {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "signup role is not configured"})
+			return
+		}
+		user.Role = &role
+
+		count, err := users.CountByEmail(ctx, *user.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for email"})
+			log.Panic(err)
+		}
+
+		if count > 0 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "this email already exists"})
+			return
+		}
+
+		password := HashPassword(*user.Password)
+		user.Password = &password
+
+		user.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		user.ID = primitive.NewObjectID()
+		user.UserId = user.ID.Hex()
+
+		insertErr := users.Create(ctx, &user)
+		if errors.Is(insertErr, database.ErrDuplicate) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "this email already exists"})
+			return
+		}
+		if insertErr != nil {
+			msg := fmt.Sprintln("User item was not created")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"InsertedID": user.ID})
+	}
+}
+
+// PingExample godoc
+// @Summary ping example
+// @Schemes
+// @Description do ping
+// @Accept json
+// @Produce json
+// @Success 200 {string} Helloworld
+// @Router /example/helloworld [get]
+func UserLogIn(users database.UserStore, sessions database.SessionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if user.Email == nil || user.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
+
+		foundUser, err := users.FindByEmail(ctx, *user.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "email or password is incorrect"})
+			return
+		}
+
+		passwordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
+		if !passwordIsValid {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return
+		}
+
+		if foundUser.Email == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
+			return
+		}
+
+		session, refreshToken, err := helper.StartSession(ctx, sessions, models.PRINCIPAL_USER, foundUser.UserId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		token, err := helper.GenerateUserToken(*foundUser.Email, *foundUser.Name, foundUser.UserId, *foundUser.Role, session.SessionId)
+		if err != nil || token == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		foundUser.Token = &token
+		foundUser.RefreshToken = &refreshToken
+
+		c.JSON(http.StatusOK, foundUser)
+	}
+}
+
+// RefreshUserToken rotates the refresh token and issues a fresh access token
+func RefreshUserToken(users database.UserStore, sessions database.SessionStore, revocations database.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if body.RefreshToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+			return
+		}
+
+		session, refreshToken, err := helper.RotateSession(ctx, sessions, revocations, models.PRINCIPAL_USER, body.RefreshToken)
+		if errors.Is(err, helper.ErrInvalidRefreshToken) || errors.Is(err, helper.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		foundUser, err := users.FindByID(ctx, session.PrincipalId)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+
+		token, err := helper.GenerateUserToken(*foundUser.Email, *foundUser.Name, foundUser.UserId, *foundUser.Role, session.SessionId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+	}
+}
+
+// UserLogOut revokes the current session, the access token in hand stops working immediately
+func UserLogOut(sessions database.SessionStore, revocations database.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		if err := helper.RevokeSession(ctx, sessions, revocations, c.GetString("sid")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while logging out"})
+			return
+		}
+
+		if err := revocations.Revoke(ctx, c.GetString("jti"), time.Unix(c.GetInt64("exp"), 0)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while logging out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
+	}
+}
+
+// userListSpec is what GET /users can be filtered and sorted by.
+var userListSpec = helper.ListSpec{
+	Fields: map[string]helper.ListField{
+		"role":       {Field: "role", Kind: helper.LIST_FIELD_STRING, Filter: true},
+		"email":      {Field: "email", Kind: helper.LIST_FIELD_STRING, Filter: true, Sort: true},
+		"name":       {Field: "name", Kind: helper.LIST_FIELD_STRING, Sort: true},
+		"created_at": {Field: "created_at", Kind: helper.LIST_FIELD_TIME, Filter: true, Sort: true},
+	},
+	DefaultSort: "-created_at",
+}
+
+func GetUsers(users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		query, err := helper.ParseListQuery(c.Request.URL.Query(), userListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		page, err := users.List(ctx, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing users"})
+			return
+		}
+
+		c.JSON(http.StatusOK, helper.NewPageBody(query, page))
+	}
+}
+
+func GetUser(users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.Param("user_id")
+
+		if err := helper.MatchUserTypeToUid(c, userId); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		user, err := users.FindByID(ctx, userId)
+		if errors.Is(err, database.ErrNotFound) || user.Email == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+func UpdateUser(users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.Param("user_id")
+
+		if err := helper.MatchUserTypeToUid(c, userId); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var user models.User
+
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updateObj := bson.M{}
+
+		if user.Name != nil {
+			updateObj["name"] = user.Name
+		}
+
+		if user.Email != nil {
+			updateObj["email"] = user.Email
+		}
+
+		// if user.Password != nil {
+		// 	password := HashPassword(*user.Password)
+		// 	updateObj["password"] = password
+		// }
+
+		if user.Password != nil {
+			password := HashPassword(*user.Password)
+			updateObj["password"] = password
+		}
+
+		if err := helper.PreparePreferences(user.TimeZone, user.Locale); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if user.TimeZone != nil {
+			updateObj["time_zone"] = user.TimeZone
+		}
+
+		if user.Locale != nil {
+			updateObj["locale"] = user.Locale
+		}
+
+		// if user.Role != nil && *user.Role == "ADMIN" {
+		// 	updateObj["role"] = user.Role
+		// }
+
+		updateObj["updated_at"] = time.Now()
+
+		err := users.Update(ctx, userId, updateObj)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating user"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "user updated successfully"})
+	}
+}
+
+func DeleteUser(users database.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.Param("user_id")
+
+		if err := helper.MatchUserTypeToUid(c, userId); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		err := users.Delete(ctx, userId)
+		if errors.Is(err, database.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting user"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	}
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type AccountStore interface {
+	Create(ctx context.Context, account *models.Account) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Account, error)
+	FindByDomain(ctx context.Context, domain string) (models.Account, error)
+	List(ctx context.Context, query ListQuery) (Page[models.Account], error)
+	Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type mongoAccountStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoAccountStore) Create(ctx context.Context, account *models.Account) error {
+	_, err := s.collection.InsertOne(ctx, account)
+	return err
+}
+
+func (s *mongoAccountStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Account, error) {
+	var account models.Account
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&account)
+	return account, mongoError(err)
+}
+
+func (s *mongoAccountStore) FindByDomain(ctx context.Context, domain string) (models.Account, error) {
+	var account models.Account
+	err := s.collection.FindOne(ctx, bson.M{"domain": domain}).Decode(&account)
+	return account, mongoError(err)
+}
+
+func (s *mongoAccountStore) List(ctx context.Context, query ListQuery) (Page[models.Account], error) {
+	return findPage[models.Account](ctx, s.collection, query)
+}
+
+func (s *mongoAccountStore) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoAccountStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memoryAccountStore struct {
+	accounts memoryCollection[models.Account]
+}
+
+func (s *memoryAccountStore) Create(ctx context.Context, account *models.Account) error {
+	s.accounts.insert(*account)
+	return nil
+}
+
+func (s *memoryAccountStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Account, error) {
+	return s.accounts.find(func(a models.Account) bool { return a.ID == id })
+}
+
+func (s *memoryAccountStore) FindByDomain(ctx context.Context, domain string) (models.Account, error) {
+	return s.accounts.find(func(a models.Account) bool { return a.Domain != nil && *a.Domain == domain })
+}
+
+func (s *memoryAccountStore) List(ctx context.Context, query ListQuery) (Page[models.Account], error) {
+	return s.accounts.page(query)
+}
+
+func (s *memoryAccountStore) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	return s.accounts.update(func(a models.Account) bool { return a.ID == id }, fields)
+}
+
+func (s *memoryAccountStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if s.accounts.remove(func(a models.Account) bool { return a.ID == id }) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type AttachmentStore interface {
+	Create(ctx context.Context, attachment *models.Attachment) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Attachment, error)
+	ListByOwner(ctx context.Context, ownerType string, ownerID primitive.ObjectID) ([]models.Attachment, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type mongoAttachmentStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoAttachmentStore) Create(ctx context.Context, attachment *models.Attachment) error {
+	_, err := s.collection.InsertOne(ctx, attachment)
+	return err
+}
+
+func (s *mongoAttachmentStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Attachment, error) {
+	var attachment models.Attachment
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&attachment)
+	return attachment, mongoError(err)
+}
+
+func (s *mongoAttachmentStore) ListByOwner(ctx context.Context, ownerType string, ownerID primitive.ObjectID) ([]models.Attachment, error) {
+	attachments := []models.Attachment{}
+
+	cursor, err := s.collection.Find(ctx, bson.M{"owner_type": ownerType, "owner_id": ownerID})
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &attachments)
+	return attachments, err
+}
+
+func (s *mongoAttachmentStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memoryAttachmentStore struct {
+	attachments memoryCollection[models.Attachment]
+}
+
+func (s *memoryAttachmentStore) Create(ctx context.Context, attachment *models.Attachment) error {
+	s.attachments.insert(*attachment)
+	return nil
+}
+
+func (s *memoryAttachmentStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Attachment, error) {
+	return s.attachments.find(func(a models.Attachment) bool { return a.ID == id })
+}
+
+func (s *memoryAttachmentStore) ListByOwner(ctx context.Context, ownerType string, ownerID primitive.ObjectID) ([]models.Attachment, error) {
+	return s.attachments.filter(func(a models.Attachment) bool {
+		return a.OwnerType == ownerType && a.OwnerID == ownerID
+	}), nil
+}
+
+func (s *memoryAttachmentStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if s.attachments.remove(func(a models.Attachment) bool { return a.ID == id }) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore keeps the raw bytes of uploaded files, the metadata lives in AttachmentStore.
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get returns ErrNotFound when no blob is stored under key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// BlobConfig selects the blob store, BLOB_DRIVER is "local" (default) or "s3".
+type BlobConfig struct {
+	Driver      string
+	LocalDir    string
+	S3Endpoint  string
+	S3Bucket    string
+	S3Region    string
+	S3AccessKey string
+	S3SecretKey string
+}
+
+func LoadBlobConfig() BlobConfig {
+	config := BlobConfig{
+		Driver:      os.Getenv("BLOB_DRIVER"),
+		LocalDir:    os.Getenv("BLOB_LOCAL_DIR"),
+		S3Endpoint:  os.Getenv("S3_ENDPOINT"),
+		S3Bucket:    os.Getenv("S3_BUCKET"),
+		S3Region:    os.Getenv("S3_REGION"),
+		S3AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey: os.Getenv("S3_SECRET_KEY"),
+	}
+
+	if config.Driver == "" {
+		config.Driver = "local"
+	}
+
+	if config.LocalDir == "" {
+		config.LocalDir = "uploads"
+	}
+
+	if config.S3Region == "" {
+		config.S3Region = "us-east-1"
+	}
+	return config
+}
+
+func NewBlobStore(config BlobConfig) (BlobStore, error) {
+	switch config.Driver {
+	case "local":
+		if err := os.MkdirAll(config.LocalDir, 0o750); err != nil {
+			return nil, fmt.Errorf("error creating blob directory: %w", err)
+		}
+		return &localBlobStore{root: config.LocalDir}, nil
+	case "s3":
+		if config.S3Endpoint == "" || config.S3Bucket == "" {
+			return nil, fmt.Errorf("S3_ENDPOINT and S3_BUCKET must be set for the s3 blob driver")
+		}
+		return newS3BlobStore(config), nil
+	default:
+		return nil, fmt.Errorf("unknown BLOB_DRIVER %q", config.Driver)
+	}
+}
+
+// localBlobStore keeps every blob as a file below root, keys may contain "/" to
+// shard them into directories.
+type localBlobStore struct {
+	root string
+}
+
+func (s *localBlobStore) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
+
+func (s *localBlobStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	// write to a temporary name first so a failed upload never leaves a partial blob
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *localBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (s *localBlobStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
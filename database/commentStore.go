@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type CommentStore interface {
+	Create(ctx context.Context, comment *models.TicketComment) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.TicketComment, error)
+	// List pages through comments, the caller filters on ticket_id and leaves
+	// out internal notes for customers.
+	List(ctx context.Context, query ListQuery) (Page[models.TicketComment], error)
+	Search(ctx context.Context, search TextSearch) ([]SearchHit[models.TicketComment], error)
+	Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error
+	DeleteByTicket(ctx context.Context, ticketID primitive.ObjectID) error
+	// SetTicket copies the ticket's customer and assignee onto its comments.
+	SetTicket(ctx context.Context, ticket models.Ticket) error
+}
+
+type mongoCommentStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoCommentStore) Create(ctx context.Context, comment *models.TicketComment) error {
+	_, err := s.collection.InsertOne(ctx, comment)
+	return err
+}
+
+func (s *mongoCommentStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.TicketComment, error) {
+	var comment models.TicketComment
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&comment)
+	return comment, mongoError(err)
+}
+
+func (s *mongoCommentStore) List(ctx context.Context, query ListQuery) (Page[models.TicketComment], error) {
+	return findPage[models.TicketComment](ctx, s.collection, query)
+}
+
+func (s *mongoCommentStore) Search(ctx context.Context, search TextSearch) ([]SearchHit[models.TicketComment], error) {
+	return findSearch[models.TicketComment](ctx, s.collection, search)
+}
+
+func (s *mongoCommentStore) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoCommentStore) DeleteByTicket(ctx context.Context, ticketID primitive.ObjectID) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"ticket_id": ticketID})
+	return err
+}
+
+func (s *mongoCommentStore) SetTicket(ctx context.Context, ticket models.Ticket) error {
+	update := bson.M{"$set": bson.M{"customer_id": ticket.CustomerID}}
+	if ticket.AssigneeID != nil {
+		update["$set"].(bson.M)["assignee_id"] = *ticket.AssigneeID
+	} else {
+		update["$unset"] = bson.M{"assignee_id": ""}
+	}
+
+	_, err := s.collection.UpdateMany(ctx, bson.M{"ticket_id": ticket.ID}, update)
+	return err
+}
+
+type memoryCommentStore struct {
+	comments memoryCollection[models.TicketComment]
+}
+
+func (s *memoryCommentStore) Create(ctx context.Context, comment *models.TicketComment) error {
+	s.comments.insert(*comment)
+	return nil
+}
+
+func (s *memoryCommentStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.TicketComment, error) {
+	return s.comments.find(func(c models.TicketComment) bool { return c.ID == id })
+}
+
+func (s *memoryCommentStore) List(ctx context.Context, query ListQuery) (Page[models.TicketComment], error) {
+	return s.comments.page(query)
+}
+
+func (s *memoryCommentStore) Search(ctx context.Context, search TextSearch) ([]SearchHit[models.TicketComment], error) {
+	return s.comments.search(search, CommentSearchFields)
+}
+
+func (s *memoryCommentStore) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	return s.comments.update(func(c models.TicketComment) bool { return c.ID == id }, fields)
+}
+
+func (s *memoryCommentStore) DeleteByTicket(ctx context.Context, ticketID primitive.ObjectID) error {
+	s.comments.remove(func(c models.TicketComment) bool { return c.TicketID == ticketID })
+	return nil
+}
+
+func (s *memoryCommentStore) SetTicket(ctx context.Context, ticket models.Ticket) error {
+	s.comments.modifyAll(func(c models.TicketComment) bool { return c.TicketID == ticket.ID }, func(c *models.TicketComment) {
+		c.CustomerID = ticket.CustomerID
+		c.AssigneeID = ticket.AssigneeID
+	})
+	return nil
+}
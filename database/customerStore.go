@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type CustomerStore interface {
+	Create(ctx context.Context, customer *models.Customer) error
+	CountByEmail(ctx context.Context, email string) (int64, error)
+	FindByEmail(ctx context.Context, email string) (models.Customer, error)
+	FindByID(ctx context.Context, customerId string) (models.Customer, error)
+	List(ctx context.Context, query ListQuery) (Page[models.Customer], error)
+	Search(ctx context.Context, search TextSearch) ([]SearchHit[models.Customer], error)
+	Update(ctx context.Context, customerId string, fields bson.M) error
+	// ClearAccount unlinks every contact of an account and drops the signups
+	// waiting to join it
+	ClearAccount(ctx context.Context, accountID primitive.ObjectID) error
+	Delete(ctx context.Context, customerId string) error
+}
+
+type mongoCustomerStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoCustomerStore) Create(ctx context.Context, customer *models.Customer) error {
+	_, err := s.collection.InsertOne(ctx, customer)
+	return err
+}
+
+func (s *mongoCustomerStore) CountByEmail(ctx context.Context, email string) (int64, error) {
+	return s.collection.CountDocuments(ctx, bson.M{"email": email})
+}
+
+func (s *mongoCustomerStore) FindByEmail(ctx context.Context, email string) (models.Customer, error) {
+	var customer models.Customer
+	err := s.collection.FindOne(ctx, bson.M{"email": email}).Decode(&customer)
+	return customer, mongoError(err)
+}
+
+func (s *mongoCustomerStore) FindByID(ctx context.Context, customerId string) (models.Customer, error) {
+	var customer models.Customer
+	err := s.collection.FindOne(ctx, bson.M{"customer_id": customerId}).Decode(&customer)
+	return customer, mongoError(err)
+}
+
+func (s *mongoCustomerStore) List(ctx context.Context, query ListQuery) (Page[models.Customer], error) {
+	return findPage[models.Customer](ctx, s.collection, query)
+}
+
+func (s *mongoCustomerStore) Search(ctx context.Context, search TextSearch) ([]SearchHit[models.Customer], error) {
+	return findSearch[models.Customer](ctx, s.collection, search)
+}
+
+func (s *mongoCustomerStore) Update(ctx context.Context, customerId string, fields bson.M) error {
+	result, err := s.collection.UpdateOne(ctx, bson.M{"customer_id": customerId}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoCustomerStore) ClearAccount(ctx context.Context, accountID primitive.ObjectID) error {
+	_, err := s.collection.UpdateMany(ctx, bson.M{"account_id": accountID}, bson.M{"$unset": bson.M{"account_id": ""}})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.collection.UpdateMany(ctx, bson.M{"pending_account_id": accountID}, bson.M{"$unset": bson.M{"pending_account_id": ""}})
+	return err
+}
+
+func (s *mongoCustomerStore) Delete(ctx context.Context, customerId string) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"customer_id": customerId})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memoryCustomerStore struct {
+	customers memoryCollection[models.Customer]
+}
+
+func (s *memoryCustomerStore) Create(ctx context.Context, customer *models.Customer) error {
+	s.customers.insert(*customer)
+	return nil
+}
+
+func (s *memoryCustomerStore) CountByEmail(ctx context.Context, email string) (int64, error) {
+	return s.customers.count(func(c models.Customer) bool {
+		return c.Email != nil && *c.Email == email
+	}), nil
+}
+
+func (s *memoryCustomerStore) FindByEmail(ctx context.Context, email string) (models.Customer, error) {
+	return s.customers.find(func(c models.Customer) bool {
+		return c.Email != nil && *c.Email == email
+	})
+}
+
+func (s *memoryCustomerStore) FindByID(ctx context.Context, customerId string) (models.Customer, error) {
+	return s.customers.find(func(c models.Customer) bool { return c.CustomerId == customerId })
+}
+
+func (s *memoryCustomerStore) List(ctx context.Context, query ListQuery) (Page[models.Customer], error) {
+	return s.customers.page(query)
+}
+
+func (s *memoryCustomerStore) Search(ctx context.Context, search TextSearch) ([]SearchHit[models.Customer], error) {
+	return s.customers.search(search, CustomerSearchFields)
+}
+
+func (s *memoryCustomerStore) Update(ctx context.Context, customerId string, fields bson.M) error {
+	return s.customers.update(func(c models.Customer) bool { return c.CustomerId == customerId }, fields)
+}
+
+func (s *memoryCustomerStore) ClearAccount(ctx context.Context, accountID primitive.ObjectID) error {
+	_, err := s.customers.updateAll(func(c models.Customer) bool {
+		return c.AccountID != nil && *c.AccountID == accountID
+	}, bson.M{"account_id": nil})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.customers.updateAll(func(c models.Customer) bool {
+		return c.PendingAccountID != nil && *c.PendingAccountID == accountID
+	}, bson.M{"pending_account_id": nil})
+	return err
+}
+
+func (s *memoryCustomerStore) Delete(ctx context.Context, customerId string) error {
+	if s.customers.remove(func(c models.Customer) bool { return c.CustomerId == customerId }) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -1,52 +1,151 @@
-package database
-
-import (
-	"context"
-	"fmt"
-	"log"
-	"os"
-	"time"
-
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-)
-
-func DBInstance() *mongo.Client {
-	// Load environment variables from .env file
-	// err := godotenv.Load(".env")
-	// if err != nil {
-	//  	log.Printf("error loading .env file: %v", err)
-	//  }
-
-	// Get MongoDB URI from environment variables
-	MONGO_URI := os.Getenv("MONGO_URI")
-	if MONGO_URI == "" {
-		log.Fatal("MONGO_URI environment variable is not set")
-	}
-
-	// Create a new context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Connect to MongoDB
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(MONGO_URI))
-	if err != nil {
-		log.Fatalf("error connecting to MongoDB: %v", err)
-	}
-
-	// Ping MongoDB to verify connection
-	err = client.Ping(ctx, nil)
-	if err != nil {
-		log.Fatalf("error pinging MongoDB: %v", err)
-	}
-
-	fmt.Println("Connected to MongoDB!")
-	return client
-}
-
-func OpenCollection(databaseName, collectionName string) *mongo.Collection {
-	client := DBInstance()
-
-	collection := client.Database(databaseName).Collection(collectionName)
-	return collection
-}
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Config holds everything needed to build the single mongo client shared by all stores.
+type Config struct {
+	URI                    string
+	DatabaseName           string
+	MaxPoolSize            uint64
+	MinPoolSize            uint64
+	MaxConnIdleTime        time.Duration
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	ReadConcern            string
+	WriteConcern           string
+	WriteTimeout           time.Duration
+	// AllowStandalone runs on a server without transactions, see CheckTransactions
+	AllowStandalone bool
+}
+
+// LoadConfig reads the mongo settings from the environment, falling back to
+// the driver defaults for anything that is not set.
+func LoadConfig() (Config, error) {
+	config := Config{
+		URI:                    os.Getenv("MONGO_URI"),
+		DatabaseName:           os.Getenv("MONGO_DATABASE"),
+		MaxPoolSize:            100,
+		ConnectTimeout:         10 * time.Second,
+		ServerSelectionTimeout: 30 * time.Second,
+		ReadConcern:            os.Getenv("MONGO_READ_CONCERN"),
+		WriteConcern:           os.Getenv("MONGO_WRITE_CONCERN"),
+	}
+
+	if config.URI == "" {
+		return config, fmt.Errorf("MONGO_URI environment variable is not set")
+	}
+
+	if config.DatabaseName == "" {
+		config.DatabaseName = "Cluster0"
+	}
+
+	var err error
+	if config.MaxPoolSize, err = envUint("MONGO_MAX_POOL_SIZE", config.MaxPoolSize); err != nil {
+		return config, err
+	}
+	if config.MinPoolSize, err = envUint("MONGO_MIN_POOL_SIZE", config.MinPoolSize); err != nil {
+		return config, err
+	}
+	if config.MaxConnIdleTime, err = envDuration("MONGO_MAX_CONN_IDLE_TIME", config.MaxConnIdleTime); err != nil {
+		return config, err
+	}
+	if config.ConnectTimeout, err = envDuration("MONGO_CONNECT_TIMEOUT", config.ConnectTimeout); err != nil {
+		return config, err
+	}
+	if config.ServerSelectionTimeout, err = envDuration("MONGO_SERVER_SELECTION_TIMEOUT", config.ServerSelectionTimeout); err != nil {
+		return config, err
+	}
+	if config.WriteTimeout, err = envDuration("MONGO_WRITE_TIMEOUT", config.WriteTimeout); err != nil {
+		return config, err
+	}
+	if value := os.Getenv("MONGO_ALLOW_STANDALONE"); value != "" {
+		if config.AllowStandalone, err = strconv.ParseBool(value); err != nil {
+			return config, fmt.Errorf("invalid MONGO_ALLOW_STANDALONE: %w", err)
+		}
+	}
+
+	return config, nil
+}
+
+// Connect creates the mongo client and verifies the connection, the caller
+// owns the client and must Disconnect it on shutdown.
+func Connect(config Config) (*mongo.Client, error) {
+	clientOptions := options.Client().
+		ApplyURI(config.URI).
+		SetMaxPoolSize(config.MaxPoolSize).
+		SetMinPoolSize(config.MinPoolSize).
+		SetConnectTimeout(config.ConnectTimeout).
+		SetServerSelectionTimeout(config.ServerSelectionTimeout)
+
+	if config.MaxConnIdleTime > 0 {
+		clientOptions.SetMaxConnIdleTime(config.MaxConnIdleTime)
+	}
+
+	if config.ReadConcern != "" {
+		clientOptions.SetReadConcern(&readconcern.ReadConcern{Level: config.ReadConcern})
+	}
+
+	if config.WriteConcern != "" || config.WriteTimeout > 0 {
+		writeConcern := &writeconcern.WriteConcern{WTimeout: config.WriteTimeout}
+		if w, err := strconv.Atoi(config.WriteConcern); err == nil {
+			writeConcern.W = w
+		} else if config.WriteConcern != "" {
+			writeConcern.W = config.WriteConcern
+		}
+		clientOptions.SetWriteConcern(writeConcern)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ConnectTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to MongoDB: %w", err)
+	}
+
+	// Ping MongoDB to verify connection
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("error pinging MongoDB: %w", err)
+	}
+
+	fmt.Println("Connected to MongoDB!")
+	return client, nil
+}
+
+func envUint(key string, fallback uint64) (uint64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n, nil
+}
+
+func envDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type DealStore interface {
+	Create(ctx context.Context, deal *models.Deal) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Deal, error)
+	List(ctx context.Context, query ListQuery) (Page[models.Deal], error)
+	Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error
+	// MoveStage moves the deal from stage change.From of change.FromPipeline to
+	// change.To of change.Pipeline, sets fields and appends change to its
+	// history in one update, it returns ErrNotFound when the deal is no longer
+	// where change says it comes from, e.g. because a concurrent update moved
+	// it first, and then leaves fields unsaved too.
+	MoveStage(ctx context.Context, id primitive.ObjectID, change models.DealStageChange, status string, closedAt *time.Time, fields bson.M) error
+	// SetCustomerAccount moves the customer's deals to their new account, nil
+	// takes them off their old one.
+	SetCustomerAccount(ctx context.Context, customerID primitive.ObjectID, accountID *primitive.ObjectID) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type mongoDealStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoDealStore) Create(ctx context.Context, deal *models.Deal) error {
+	_, err := s.collection.InsertOne(ctx, deal)
+	return err
+}
+
+func (s *mongoDealStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Deal, error) {
+	var deal models.Deal
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&deal)
+	return deal, mongoError(err)
+}
+
+func (s *mongoDealStore) List(ctx context.Context, query ListQuery) (Page[models.Deal], error) {
+	return findPage[models.Deal](ctx, s.collection, query)
+}
+
+func (s *mongoDealStore) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoDealStore) MoveStage(ctx context.Context, id primitive.ObjectID, change models.DealStageChange, status string, closedAt *time.Time, fields bson.M) error {
+	set := bson.M{}
+	maps.Copy(set, fields)
+	maps.Copy(set, bson.M{"pipeline": change.Pipeline, "stage": change.To, "status": status, "updated_at": change.ChangedAt})
+	update := bson.M{"$set": set, "$push": bson.M{"stage_history": change}}
+
+	if closedAt != nil {
+		set["closed_at"] = closedAt
+	} else {
+		update["$unset"] = bson.M{"closed_at": ""}
+	}
+
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": id, "pipeline": change.FromPipeline, "stage": change.From}, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoDealStore) SetCustomerAccount(ctx context.Context, customerID primitive.ObjectID, accountID *primitive.ObjectID) error {
+	update := bson.M{"$unset": bson.M{"account_id": ""}}
+	if accountID != nil {
+		update = bson.M{"$set": bson.M{"account_id": *accountID}}
+	}
+
+	_, err := s.collection.UpdateMany(ctx, bson.M{"customer_id": customerID, "account_id": bson.M{"$ne": accountID}}, update)
+	return err
+}
+
+func (s *mongoDealStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memoryDealStore struct {
+	deals memoryCollection[models.Deal]
+}
+
+func (s *memoryDealStore) Create(ctx context.Context, deal *models.Deal) error {
+	s.deals.insert(*deal)
+	return nil
+}
+
+func (s *memoryDealStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Deal, error) {
+	return s.deals.find(func(d models.Deal) bool { return d.ID == id })
+}
+
+func (s *memoryDealStore) List(ctx context.Context, query ListQuery) (Page[models.Deal], error) {
+	return s.deals.page(query)
+}
+
+func (s *memoryDealStore) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	return s.deals.update(func(d models.Deal) bool { return d.ID == id }, fields)
+}
+
+func (s *memoryDealStore) MoveStage(ctx context.Context, id primitive.ObjectID, change models.DealStageChange, status string, closedAt *time.Time, fields bson.M) error {
+	match := func(d models.Deal) bool {
+		return d.ID == id && d.Pipeline == change.FromPipeline && d.Stage == change.From
+	}
+
+	var setErr error
+	err := s.deals.modify(match, func(d *models.Deal) {
+		updated, err := setFields(*d, fields)
+		if err != nil {
+			setErr = err
+			return
+		}
+
+		*d = updated
+		d.Pipeline = change.Pipeline
+		d.Stage = change.To
+		d.Status = status
+		d.ClosedAt = closedAt
+		d.StageHistory = append(slices.Clone(d.StageHistory), change)
+		d.UpdatedAt = change.ChangedAt
+	})
+	if err != nil {
+		return err
+	}
+	return setErr
+}
+
+func (s *memoryDealStore) SetCustomerAccount(ctx context.Context, customerID primitive.ObjectID, accountID *primitive.ObjectID) error {
+	s.deals.modifyAll(func(d models.Deal) bool { return d.CustomerID != nil && *d.CustomerID == customerID }, func(d *models.Deal) {
+		d.AccountID = accountID
+	})
+	return nil
+}
+
+func (s *memoryDealStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if s.deals.remove(func(d models.Deal) bool { return d.ID == id }) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
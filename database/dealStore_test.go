@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMoveStageConflict(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryDealStore{}
+
+	title := "renewal"
+	deal := models.Deal{ID: primitive.NewObjectID(), Title: &title, Pipeline: "sales", Stage: "lead", Status: models.DEAL_OPEN, Amount: 100}
+	if err := store.Create(ctx, &deal); err != nil {
+		t.Fatal(err)
+	}
+
+	// both callers read the deal in lead, the second one's move and edits are lost together
+	tests := []struct {
+		name   string
+		to     string
+		amount int64
+		err    error
+	}{
+		{"first move", "qualified", 200, nil},
+		{"move from a stale stage", "proposal", 300, ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change := models.DealStageChange{FromPipeline: "sales", Pipeline: "sales", From: "lead", To: tt.to, ChangedAt: time.Now()}
+			err := store.MoveStage(ctx, deal.ID, change, models.DEAL_OPEN, nil, bson.M{"amount": tt.amount})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	saved, err := store.FindByID(ctx, deal.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Stage != "qualified" || saved.Amount != 200 || len(saved.StageHistory) != 1 {
+		t.Fatalf("got stage %s, amount %d and %d moves, want qualified, 200 and 1", saved.Stage, saved.Amount, len(saved.StageHistory))
+	}
+}
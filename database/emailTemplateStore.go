@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EmailTemplateStore keeps the email templates admins edited, one per locale and name.
+type EmailTemplateStore interface {
+	List(ctx context.Context) ([]models.EmailTemplate, error)
+	Find(ctx context.Context, locale, name string) (models.EmailTemplate, error)
+	// Save replaces the template of the same locale and name, or adds it.
+	Save(ctx context.Context, template models.EmailTemplate) error
+	Delete(ctx context.Context, locale, name string) error
+}
+
+type mongoEmailTemplateStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoEmailTemplateStore) List(ctx context.Context) ([]models.EmailTemplate, error) {
+	templates := []models.EmailTemplate{}
+
+	cursor, err := s.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &templates)
+	return templates, err
+}
+
+func (s *mongoEmailTemplateStore) Find(ctx context.Context, locale, name string) (models.EmailTemplate, error) {
+	var template models.EmailTemplate
+	err := s.collection.FindOne(ctx, bson.M{"locale": locale, "name": name}).Decode(&template)
+	return template, mongoError(err)
+}
+
+func (s *mongoEmailTemplateStore) Save(ctx context.Context, template models.EmailTemplate) error {
+	filter := bson.M{"locale": template.Locale, "name": template.Name}
+	update := bson.M{
+		"$set": bson.M{"source": template.Source, "updated_by": template.UpdatedBy, "updated_at": template.UpdatedAt},
+		"$setOnInsert": bson.M{
+			"_id":         template.ID,
+			"template_id": template.TemplateId,
+			"created_at":  template.CreatedAt,
+		},
+	}
+
+	_, err := s.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (s *mongoEmailTemplateStore) Delete(ctx context.Context, locale, name string) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"locale": locale, "name": name})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// emailTemplateCacheTTL is how long the edited templates are reused before
+// they are read again, an edit made through another replica shows up after it.
+const emailTemplateCacheTTL = 30 * time.Second
+
+// cachedEmailTemplateStore keeps the list every email is rendered with, so
+// sending one does not read the whole collection. Saving or deleting a
+// template through it drops the list.
+type cachedEmailTemplateStore struct {
+	EmailTemplateStore
+
+	mu       sync.Mutex
+	list     []models.EmailTemplate
+	loadedAt time.Time
+}
+
+func (s *cachedEmailTemplateStore) List(ctx context.Context) ([]models.EmailTemplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.list != nil && time.Since(s.loadedAt) < emailTemplateCacheTTL {
+		return slices.Clone(s.list), nil
+	}
+
+	list, err := s.EmailTemplateStore.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.list, s.loadedAt = list, time.Now()
+	return slices.Clone(list), nil
+}
+
+func (s *cachedEmailTemplateStore) Save(ctx context.Context, template models.EmailTemplate) error {
+	defer s.forget()
+	return s.EmailTemplateStore.Save(ctx, template)
+}
+
+func (s *cachedEmailTemplateStore) Delete(ctx context.Context, locale, name string) error {
+	defer s.forget()
+	return s.EmailTemplateStore.Delete(ctx, locale, name)
+}
+
+func (s *cachedEmailTemplateStore) forget() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.list = nil
+}
+
+type memoryEmailTemplateStore struct {
+	templates memoryCollection[models.EmailTemplate]
+}
+
+func (s *memoryEmailTemplateStore) List(ctx context.Context) ([]models.EmailTemplate, error) {
+	return s.templates.filter(func(models.EmailTemplate) bool { return true }), nil
+}
+
+func (s *memoryEmailTemplateStore) Find(ctx context.Context, locale, name string) (models.EmailTemplate, error) {
+	return s.templates.find(func(t models.EmailTemplate) bool { return t.Locale == locale && t.Name == name })
+}
+
+func (s *memoryEmailTemplateStore) Save(ctx context.Context, template models.EmailTemplate) error {
+	match := func(t models.EmailTemplate) bool { return t.Locale == template.Locale && t.Name == template.Name }
+
+	err := s.templates.modify(match, func(t *models.EmailTemplate) {
+		t.Source = template.Source
+		t.UpdatedBy = template.UpdatedBy
+		t.UpdatedAt = template.UpdatedAt
+	})
+	if err == ErrNotFound {
+		s.templates.insert(template)
+		return nil
+	}
+	return err
+}
+
+func (s *memoryEmailTemplateStore) Delete(ctx context.Context, locale, name string) error {
+	if s.templates.remove(func(t models.EmailTemplate) bool { return t.Locale == locale && t.Name == name }) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
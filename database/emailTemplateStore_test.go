@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+)
+
+func TestCachedEmailTemplateStore(t *testing.T) {
+	ctx := context.Background()
+	backing := &memoryEmailTemplateStore{}
+	store := &cachedEmailTemplateStore{EmailTemplateStore: backing}
+
+	template := func(source string) models.EmailTemplate {
+		return models.EmailTemplate{Locale: "en", Name: "common", Source: source, UpdatedAt: time.Now()}
+	}
+	source := func() string {
+		t.Helper()
+		list, err := store.List(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 {
+			t.Fatalf("got %d templates, want 1", len(list))
+		}
+		return list[0].Source
+	}
+
+	if err := store.Save(ctx, template("first")); err != nil {
+		t.Fatal(err)
+	}
+	if got := source(); got != "first" {
+		t.Fatalf("got %q, want first", got)
+	}
+
+	// an edit behind the cache's back shows up only once the list expires
+	if err := backing.Save(ctx, template("elsewhere")); err != nil {
+		t.Fatal(err)
+	}
+	if got := source(); got != "first" {
+		t.Fatalf("got %q, want the cached first", got)
+	}
+
+	if err := store.Save(ctx, template("second")); err != nil {
+		t.Fatal(err)
+	}
+	if got := source(); got != "second" {
+		t.Fatalf("got %q after saving through the cache, want second", got)
+	}
+}
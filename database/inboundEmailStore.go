@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InboundEmailStore remembers the inbound emails already filed by sender and
+// Message-ID, so one sender cannot shadow the emails of another by reusing
+// their Message-ID.
+type InboundEmailStore interface {
+	// Claim reserves the sender's messageID for the caller to file until
+	// leaseUntil, a claim nobody completed in time is taken over. When the email
+	// was filed or somebody else is filing it, it returns that record and
+	// claimed is false.
+	Claim(ctx context.Context, sender, messageID string, now, leaseUntil, expiresAt time.Time) (email models.InboundEmail, claimed bool, err error)
+	// Complete records what became of the claimed email.
+	Complete(ctx context.Context, email models.InboundEmail) error
+	// Release drops a claim whose email could not be filed, so a retry files it.
+	Release(ctx context.Context, sender, messageID string) error
+}
+
+type mongoInboundEmailStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoInboundEmailStore) Claim(ctx context.Context, sender, messageID string, now, leaseUntil, expiresAt time.Time) (models.InboundEmail, bool, error) {
+	// a filed email or a live claim does not match, the upsert then runs into the unique sender and message_id
+	filter := bson.M{
+		"sender":        sender,
+		"message_id":    messageID,
+		"action":        bson.M{"$exists": false},
+		"claimed_until": bson.M{"$lte": now},
+	}
+	update := bson.M{
+		"$set":         bson.M{"claimed_until": leaseUntil, "expires_at": expiresAt},
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
+	}
+
+	var email models.InboundEmail
+	err := s.collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&email)
+	if err == nil {
+		return email, true, nil
+	}
+
+	if !mongo.IsDuplicateKeyError(err) {
+		return email, false, err
+	}
+
+	err = s.collection.FindOne(ctx, bson.M{"sender": sender, "message_id": messageID}).Decode(&email)
+	return email, false, mongoError(err)
+}
+
+func (s *mongoInboundEmailStore) Complete(ctx context.Context, email models.InboundEmail) error {
+	update := bson.M{"$set": bson.M{
+		"action":      email.Action,
+		"reason":      email.Reason,
+		"ticket_id":   email.TicketId,
+		"comment_id":  email.CommentId,
+		"attachments": email.Attachments,
+		"rejected":    email.Rejected,
+	}}
+
+	result, err := s.collection.UpdateOne(ctx, bson.M{"sender": email.Sender, "message_id": email.MessageID}, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoInboundEmailStore) Release(ctx context.Context, sender, messageID string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"sender": sender, "message_id": messageID, "action": bson.M{"$exists": false}})
+	return err
+}
+
+type memoryInboundEmailStore struct {
+	emails memoryCollection[models.InboundEmail]
+}
+
+func (s *memoryInboundEmailStore) Claim(ctx context.Context, sender, messageID string, now, leaseUntil, expiresAt time.Time) (models.InboundEmail, bool, error) {
+	s.emails.remove(func(e models.InboundEmail) bool { return e.ExpiresAt.Before(now) })
+
+	email, err := s.emails.find(func(e models.InboundEmail) bool { return e.Sender == sender && e.MessageID == messageID })
+	if errors.Is(err, ErrNotFound) {
+		email = models.InboundEmail{ID: primitive.NewObjectID(), Sender: sender, MessageID: messageID, ClaimedUntil: leaseUntil, ExpiresAt: expiresAt}
+		s.emails.insert(email)
+		return email, true, nil
+	}
+
+	err = s.emails.modify(func(e models.InboundEmail) bool {
+		return e.Sender == sender && e.MessageID == messageID && e.Action == "" && !e.ClaimedUntil.After(now)
+	}, func(e *models.InboundEmail) {
+		e.ClaimedUntil = leaseUntil
+		e.ExpiresAt = expiresAt
+		email = *e
+	})
+	if errors.Is(err, ErrNotFound) {
+		return email, false, nil
+	}
+	return email, err == nil, err
+}
+
+func (s *memoryInboundEmailStore) Complete(ctx context.Context, email models.InboundEmail) error {
+	return s.emails.modify(func(e models.InboundEmail) bool { return e.Sender == email.Sender && e.MessageID == email.MessageID }, func(e *models.InboundEmail) {
+		e.Action = email.Action
+		e.Reason = email.Reason
+		e.TicketId = email.TicketId
+		e.CommentId = email.CommentId
+		e.Attachments = email.Attachments
+		e.Rejected = email.Rejected
+	})
+}
+
+func (s *memoryInboundEmailStore) Release(ctx context.Context, sender, messageID string) error {
+	s.emails.remove(func(e models.InboundEmail) bool {
+		return e.Sender == sender && e.MessageID == messageID && e.Action == ""
+	})
+	return nil
+}
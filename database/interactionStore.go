@@ -0,0 +1,353 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// InteractionStore lists take an interaction type to filter on, "" lists every type.
+// List takes InteractionTypeFilter instead.
+type InteractionStore interface {
+	Create(ctx context.Context, interaction *models.Interaction) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Interaction, error)
+	List(ctx context.Context, query ListQuery) (Page[models.Interaction], error)
+	Search(ctx context.Context, search TextSearch) ([]SearchHit[models.Interaction], error)
+	ListByUser(ctx context.Context, userID primitive.ObjectID, interactionType string) ([]models.Interaction, error)
+	ListByCustomer(ctx context.Context, customerID primitive.ObjectID, interactionType string) ([]models.Interaction, error)
+	Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error
+	// Revise saves the edited title, description, times, time zone and recurrence of revised and
+	// appends previous to its revisions, ErrNotFound means someone else edited
+	// it since previous was read.
+	Revise(ctx context.Context, revised models.Interaction, previous models.InteractionRevision) error
+	// ClearDeal unlinks every interaction of a deal
+	ClearDeal(ctx context.Context, dealID primitive.ObjectID) error
+	// SetCustomerAccount copies the customer's account onto their interactions, nil
+	// unlinks them, ClearAccount unlinks every interaction of a deleted account.
+	SetCustomerAccount(ctx context.Context, customerID primitive.ObjectID, accountID *primitive.ObjectID) error
+	ClearAccount(ctx context.Context, accountID primitive.ObjectID) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// ListBySeries returns the occurrences edited out of a repeating interaction.
+	ListBySeries(ctx context.Context, seriesId string) ([]models.Interaction, error)
+	// ShiftSeries moves the recurrence_id of every edited occurrence of the
+	// series by shift, for when the series itself is rescheduled.
+	ShiftSeries(ctx context.Context, seriesId string, shift time.Duration) error
+	// MoveSeries hands the edited occurrences from on to the series toSeriesId,
+	// for when a series is split there.
+	MoveSeries(ctx context.Context, seriesId, toSeriesId string, from time.Time) error
+	// DeleteSeries removes every edited occurrence of the series.
+	DeleteSeries(ctx context.Context, seriesId string) error
+}
+
+type mongoInteractionStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoInteractionStore) Create(ctx context.Context, interaction *models.Interaction) error {
+	_, err := s.collection.InsertOne(ctx, interaction)
+	return err
+}
+
+func (s *mongoInteractionStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Interaction, error) {
+	var interaction models.Interaction
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&interaction)
+	return interaction, mongoError(err)
+}
+
+func (s *mongoInteractionStore) List(ctx context.Context, query ListQuery) (Page[models.Interaction], error) {
+	return findPage[models.Interaction](ctx, s.collection, query)
+}
+
+func (s *mongoInteractionStore) Search(ctx context.Context, search TextSearch) ([]SearchHit[models.Interaction], error) {
+	return findSearch[models.Interaction](ctx, s.collection, search)
+}
+
+func (s *mongoInteractionStore) ListByUser(ctx context.Context, userID primitive.ObjectID, interactionType string) ([]models.Interaction, error) {
+	return s.find(ctx, bson.M{"user_id": userID}, interactionType)
+}
+
+func (s *mongoInteractionStore) ListByCustomer(ctx context.Context, customerID primitive.ObjectID, interactionType string) ([]models.Interaction, error) {
+	return s.find(ctx, bson.M{"customer_id": customerID}, interactionType)
+}
+
+// InteractionTypeFilter matches interactions of one type, untyped
+// interactions predate the type field and were all meetings.
+func InteractionTypeFilter(interactionType string) Filter {
+	if interactionType == models.INTERACTION_MEETING {
+		return Filter{Field: "type", Op: FILTER_IN, Value: bson.A{models.INTERACTION_MEETING, "", nil}}
+	}
+	return Eq("type", interactionType)
+}
+
+// InteractionWindowFilters match the interactions that can have an occurrence
+// in [from, to): they start before to and either repeat or do not end before from.
+func InteractionWindowFilters(from, to time.Time) []Filter {
+	return []Filter{
+		{Field: "start_time", Op: FILTER_LT, Value: to},
+		Or(
+			Filter{Field: "rrule", Op: FILTER_EXISTS, Value: true},
+			Filter{Field: "end_time", Op: FILTER_GTE, Value: from},
+			Filter{Field: "start_time", Op: FILTER_GTE, Value: from},
+		),
+	}
+}
+
+// InteractionTimeFilter matches the interactions listed between from and to:
+// repeating ones that start before to, tasks due in [from, to) and the others
+// starting in it, the ones helpers.ExpandInteractions keeps.
+func InteractionTimeFilter(from, to time.Time) Filter {
+	return Or(
+		And(Filter{Field: "rrule", Op: FILTER_EXISTS, Value: true}, Filter{Field: "start_time", Op: FILTER_LT, Value: to}),
+		And(Filter{Field: "task.due_at", Op: FILTER_GTE, Value: from}, Filter{Field: "task.due_at", Op: FILTER_LT, Value: to}),
+		And(
+			Filter{Field: "task.due_at", Op: FILTER_EXISTS, Value: false},
+			Filter{Field: "start_time", Op: FILTER_GTE, Value: from},
+			Filter{Field: "start_time", Op: FILTER_LT, Value: to},
+		),
+	)
+}
+
+func (s *mongoInteractionStore) find(ctx context.Context, filter bson.M, interactionType string) ([]models.Interaction, error) {
+	interactions := []models.Interaction{}
+
+	switch interactionType {
+	case "":
+	case models.INTERACTION_MEETING:
+		// untyped interactions predate the type field and were all meetings
+		filter["type"] = bson.M{"$in": bson.A{models.INTERACTION_MEETING, "", nil}}
+	default:
+		filter["type"] = interactionType
+	}
+
+	cursor, err := s.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &interactions)
+	return interactions, err
+}
+
+func (s *mongoInteractionStore) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoInteractionStore) Revise(ctx context.Context, revised models.Interaction, previous models.InteractionRevision) error {
+	filter := bson.M{"_id": revised.ID, "revision": previous.Revision}
+	if previous.Revision == 0 {
+		// interactions created before revisions existed have no counter yet
+		filter["revision"] = bson.M{"$in": bson.A{0, nil}}
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"title":       revised.Title,
+			"description": revised.Description,
+			"start_time":  revised.StartTime,
+			"end_time":    revised.EndTime,
+			"time_zone":   revised.TimeZone,
+			"rrule":       revised.RRule,
+			"exdates":     revised.ExDates,
+			"revision":    previous.Revision + 1,
+			"updated_at":  revised.UpdatedAt,
+		},
+		"$push": bson.M{"revisions": previous},
+	}
+
+	result, err := s.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoInteractionStore) ClearDeal(ctx context.Context, dealID primitive.ObjectID) error {
+	_, err := s.collection.UpdateMany(ctx, bson.M{"deal_id": dealID}, bson.M{"$unset": bson.M{"deal_id": ""}})
+	return err
+}
+
+func (s *mongoInteractionStore) SetCustomerAccount(ctx context.Context, customerID primitive.ObjectID, accountID *primitive.ObjectID) error {
+	update := bson.M{"$unset": bson.M{"account_id": ""}}
+	if accountID != nil {
+		update = bson.M{"$set": bson.M{"account_id": *accountID}}
+	}
+
+	_, err := s.collection.UpdateMany(ctx, bson.M{"customer_id": customerID, "account_id": bson.M{"$ne": accountID}}, update)
+	return err
+}
+
+func (s *mongoInteractionStore) ClearAccount(ctx context.Context, accountID primitive.ObjectID) error {
+	_, err := s.collection.UpdateMany(ctx, bson.M{"account_id": accountID}, bson.M{"$unset": bson.M{"account_id": ""}})
+	return err
+}
+
+func (s *mongoInteractionStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoInteractionStore) ListBySeries(ctx context.Context, seriesId string) ([]models.Interaction, error) {
+	return s.find(ctx, bson.M{"series_id": seriesId}, "")
+}
+
+func (s *mongoInteractionStore) ShiftSeries(ctx context.Context, seriesId string, shift time.Duration) error {
+	filter := bson.M{"series_id": seriesId, "recurrence_id": bson.M{"$ne": nil}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"recurrence_id": bson.M{"$add": bson.A{"$recurrence_id", shift.Milliseconds()}},
+	}}}}
+
+	_, err := s.collection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (s *mongoInteractionStore) MoveSeries(ctx context.Context, seriesId, toSeriesId string, from time.Time) error {
+	filter := bson.M{"series_id": seriesId, "recurrence_id": bson.M{"$gte": from}}
+	_, err := s.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"series_id": toSeriesId}})
+	return err
+}
+
+func (s *mongoInteractionStore) DeleteSeries(ctx context.Context, seriesId string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"series_id": seriesId})
+	return err
+}
+
+type memoryInteractionStore struct {
+	interactions memoryCollection[models.Interaction]
+}
+
+func (s *memoryInteractionStore) Create(ctx context.Context, interaction *models.Interaction) error {
+	s.interactions.insert(*interaction)
+	return nil
+}
+
+func (s *memoryInteractionStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Interaction, error) {
+	return s.interactions.find(func(i models.Interaction) bool { return i.ID == id })
+}
+
+func (s *memoryInteractionStore) List(ctx context.Context, query ListQuery) (Page[models.Interaction], error) {
+	return s.interactions.page(query)
+}
+
+func (s *memoryInteractionStore) Search(ctx context.Context, search TextSearch) ([]SearchHit[models.Interaction], error) {
+	return s.interactions.search(search, InteractionSearchFields)
+}
+
+func (s *memoryInteractionStore) ListByUser(ctx context.Context, userID primitive.ObjectID, interactionType string) ([]models.Interaction, error) {
+	return s.interactions.filter(func(i models.Interaction) bool {
+		return i.UserID == userID && isInteractionType(i, interactionType)
+	}), nil
+}
+
+func (s *memoryInteractionStore) ListByCustomer(ctx context.Context, customerID primitive.ObjectID, interactionType string) ([]models.Interaction, error) {
+	return s.interactions.filter(func(i models.Interaction) bool {
+		return i.CustomerID == customerID && isInteractionType(i, interactionType)
+	}), nil
+}
+
+func (s *memoryInteractionStore) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	return s.interactions.update(func(i models.Interaction) bool { return i.ID == id }, fields)
+}
+
+func (s *memoryInteractionStore) Revise(ctx context.Context, revised models.Interaction, previous models.InteractionRevision) error {
+	match := func(i models.Interaction) bool { return i.ID == revised.ID && i.Revision == previous.Revision }
+
+	return s.interactions.modify(match, func(i *models.Interaction) {
+		i.Title = revised.Title
+		i.Description = revised.Description
+		i.StartTime = revised.StartTime
+		i.EndTime = revised.EndTime
+		i.TimeZone = revised.TimeZone
+		i.RRule = revised.RRule
+		i.ExDates = revised.ExDates
+		i.Revision = previous.Revision + 1
+		i.Revisions = append(slices.Clone(i.Revisions), previous)
+		i.UpdatedAt = revised.UpdatedAt
+	})
+}
+
+func isInteractionType(i models.Interaction, interactionType string) bool {
+	if interactionType == "" || i.Type == interactionType {
+		return true
+	}
+	return i.Type == "" && interactionType == models.INTERACTION_MEETING
+}
+
+func (s *memoryInteractionStore) ClearDeal(ctx context.Context, dealID primitive.ObjectID) error {
+	_, err := s.interactions.updateAll(func(i models.Interaction) bool {
+		return i.DealID != nil && *i.DealID == dealID
+	}, bson.M{"deal_id": nil})
+	return err
+}
+
+func (s *memoryInteractionStore) SetCustomerAccount(ctx context.Context, customerID primitive.ObjectID, accountID *primitive.ObjectID) error {
+	s.interactions.modifyAll(func(i models.Interaction) bool { return i.CustomerID == customerID }, func(i *models.Interaction) {
+		i.AccountID = accountID
+	})
+	return nil
+}
+
+func (s *memoryInteractionStore) ClearAccount(ctx context.Context, accountID primitive.ObjectID) error {
+	s.interactions.modifyAll(func(i models.Interaction) bool { return i.AccountID != nil && *i.AccountID == accountID }, func(i *models.Interaction) {
+		i.AccountID = nil
+	})
+	return nil
+}
+
+func (s *memoryInteractionStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if s.interactions.remove(func(i models.Interaction) bool { return i.ID == id }) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *memoryInteractionStore) ListBySeries(ctx context.Context, seriesId string) ([]models.Interaction, error) {
+	return s.interactions.filter(func(i models.Interaction) bool { return i.SeriesId == seriesId }), nil
+}
+
+func (s *memoryInteractionStore) ShiftSeries(ctx context.Context, seriesId string, shift time.Duration) error {
+	s.interactions.modifyAll(func(i models.Interaction) bool {
+		return i.SeriesId == seriesId && i.RecurrenceId != nil
+	}, func(i *models.Interaction) {
+		shifted := i.RecurrenceId.Add(shift)
+		i.RecurrenceId = &shifted
+	})
+	return nil
+}
+
+func (s *memoryInteractionStore) MoveSeries(ctx context.Context, seriesId, toSeriesId string, from time.Time) error {
+	s.interactions.modifyAll(func(i models.Interaction) bool {
+		return i.SeriesId == seriesId && i.RecurrenceId != nil && !i.RecurrenceId.Before(from)
+	}, func(i *models.Interaction) {
+		i.SeriesId = toSeriesId
+	})
+	return nil
+}
+
+func (s *memoryInteractionStore) DeleteSeries(ctx context.Context, seriesId string) error {
+	s.interactions.remove(func(i models.Interaction) bool { return i.SeriesId == seriesId })
+	return nil
+}
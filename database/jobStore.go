@@ -0,0 +1,243 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// JobStore persists the scheduler's jobs. A worker claims a due job by leasing
+// it until a deadline, the calls that finish a job only succeed while the
+// caller still holds the lease and return ErrNotFound otherwise.
+type JobStore interface {
+	// Schedule creates the job or replaces the pending job with the same key.
+	Schedule(ctx context.Context, job *models.Job) error
+	// EnsureRecurring creates the job if its key is new and otherwise only
+	// updates its interval, so restarts keep the next run time.
+	EnsureRecurring(ctx context.Context, job *models.Job) error
+	Claim(ctx context.Context, worker string, now, leaseUntil time.Time) (models.Job, error)
+	// Complete removes a one-off job and moves a recurring one to its next run.
+	Complete(ctx context.Context, job models.Job, worker string, now time.Time) error
+	Retry(ctx context.Context, id primitive.ObjectID, worker string, runAt time.Time, lastError string) error
+	Fail(ctx context.Context, id primitive.ObjectID, worker string, lastError string) error
+	CancelByRef(ctx context.Context, kind, ref string) error
+}
+
+type mongoJobStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoJobStore) Schedule(ctx context.Context, job *models.Job) error {
+	update := bson.M{
+		"$set": bson.M{
+			"kind":       job.Kind,
+			"ref":        job.Ref,
+			"payload":    job.Payload,
+			"run_at":     job.RunAt,
+			"interval":   job.Interval,
+			"status":     models.JOB_PENDING,
+			"attempts":   0,
+			"updated_at": job.UpdatedAt,
+		},
+		"$unset":       bson.M{"last_error": "", "locked_by": "", "locked_until": ""},
+		"$setOnInsert": bson.M{"_id": job.ID, "created_at": job.CreatedAt},
+	}
+
+	_, err := s.collection.UpdateOne(ctx, bson.M{"key": job.Key}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (s *mongoJobStore) EnsureRecurring(ctx context.Context, job *models.Job) error {
+	update := bson.M{
+		"$set": bson.M{"interval": job.Interval},
+		"$setOnInsert": bson.M{
+			"_id":        job.ID,
+			"kind":       job.Kind,
+			"run_at":     job.RunAt,
+			"status":     models.JOB_PENDING,
+			"attempts":   0,
+			"created_at": job.CreatedAt,
+			"updated_at": job.UpdatedAt,
+		},
+	}
+
+	_, err := s.collection.UpdateOne(ctx, bson.M{"key": job.Key}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (s *mongoJobStore) Claim(ctx context.Context, worker string, now, leaseUntil time.Time) (models.Job, error) {
+	filter := bson.M{
+		"status": models.JOB_PENDING,
+		"run_at": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"locked_until": bson.M{"$exists": false}},
+			bson.M{"locked_until": bson.M{"$lt": now}},
+		},
+	}
+
+	update := bson.M{
+		"$set": bson.M{"locked_by": worker, "locked_until": leaseUntil},
+		"$inc": bson.M{"attempts": 1},
+	}
+
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "run_at", Value: 1}}).SetReturnDocument(options.After)
+
+	var job models.Job
+	err := s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	return job, mongoError(err)
+}
+
+func (s *mongoJobStore) Complete(ctx context.Context, job models.Job, worker string, now time.Time) error {
+	filter := bson.M{"_id": job.ID, "locked_by": worker}
+
+	if job.Interval <= 0 {
+		result, err := s.collection.DeleteOne(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		if result.DeletedCount == 0 {
+			return ErrNotFound
+		}
+		return nil
+	}
+
+	return s.release(ctx, filter, bson.M{"run_at": now.Add(job.Interval), "attempts": 0, "updated_at": now}, true)
+}
+
+func (s *mongoJobStore) Retry(ctx context.Context, id primitive.ObjectID, worker string, runAt time.Time, lastError string) error {
+	return s.release(ctx, bson.M{"_id": id, "locked_by": worker}, bson.M{"run_at": runAt, "last_error": lastError, "updated_at": time.Now()}, false)
+}
+
+func (s *mongoJobStore) Fail(ctx context.Context, id primitive.ObjectID, worker string, lastError string) error {
+	return s.release(ctx, bson.M{"_id": id, "locked_by": worker}, bson.M{"status": models.JOB_FAILED, "last_error": lastError, "updated_at": time.Now()}, false)
+}
+
+// release drops the lease of a claimed job while setting fields.
+func (s *mongoJobStore) release(ctx context.Context, filter, fields bson.M, clearError bool) error {
+	unset := bson.M{"locked_by": "", "locked_until": ""}
+	if clearError {
+		unset["last_error"] = ""
+	}
+
+	result, err := s.collection.UpdateOne(ctx, filter, bson.M{"$set": fields, "$unset": unset})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoJobStore) CancelByRef(ctx context.Context, kind, ref string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"kind": kind, "ref": ref})
+	return err
+}
+
+type memoryJobStore struct {
+	jobs memoryCollection[models.Job]
+}
+
+func (s *memoryJobStore) Schedule(ctx context.Context, job *models.Job) error {
+	err := s.jobs.modify(func(j models.Job) bool { return j.Key == job.Key }, func(j *models.Job) {
+		j.Kind = job.Kind
+		j.Ref = job.Ref
+		j.Payload = job.Payload
+		j.RunAt = job.RunAt
+		j.Interval = job.Interval
+		j.Status = models.JOB_PENDING
+		j.Attempts = 0
+		j.LastError = ""
+		j.LockedBy = ""
+		j.LockedUntil = nil
+		j.UpdatedAt = job.UpdatedAt
+	})
+
+	if errors.Is(err, ErrNotFound) {
+		job.Status = models.JOB_PENDING
+		s.jobs.insert(*job)
+		return nil
+	}
+	return err
+}
+
+func (s *memoryJobStore) EnsureRecurring(ctx context.Context, job *models.Job) error {
+	err := s.jobs.modify(func(j models.Job) bool { return j.Key == job.Key }, func(j *models.Job) {
+		j.Interval = job.Interval
+	})
+
+	if errors.Is(err, ErrNotFound) {
+		job.Status = models.JOB_PENDING
+		s.jobs.insert(*job)
+		return nil
+	}
+	return err
+}
+
+func (s *memoryJobStore) Claim(ctx context.Context, worker string, now, leaseUntil time.Time) (models.Job, error) {
+	match := func(j models.Job) bool {
+		return j.Status == models.JOB_PENDING && !j.RunAt.After(now) && (j.LockedUntil == nil || j.LockedUntil.Before(now))
+	}
+
+	var claimed models.Job
+	err := s.jobs.modify(match, func(j *models.Job) {
+		j.LockedBy = worker
+		j.LockedUntil = &leaseUntil
+		j.Attempts++
+		claimed = *j
+	})
+	return claimed, err
+}
+
+func (s *memoryJobStore) Complete(ctx context.Context, job models.Job, worker string, now time.Time) error {
+	match := func(j models.Job) bool { return j.ID == job.ID && j.LockedBy == worker }
+
+	if job.Interval <= 0 {
+		if s.jobs.remove(match) == 0 {
+			return ErrNotFound
+		}
+		return nil
+	}
+
+	return s.jobs.modify(match, func(j *models.Job) {
+		j.RunAt = now.Add(job.Interval)
+		j.Attempts = 0
+		j.LastError = ""
+		j.LockedBy = ""
+		j.LockedUntil = nil
+		j.UpdatedAt = now
+	})
+}
+
+func (s *memoryJobStore) Retry(ctx context.Context, id primitive.ObjectID, worker string, runAt time.Time, lastError string) error {
+	return s.jobs.modify(func(j models.Job) bool { return j.ID == id && j.LockedBy == worker }, func(j *models.Job) {
+		j.RunAt = runAt
+		j.LastError = lastError
+		j.LockedBy = ""
+		j.LockedUntil = nil
+		j.UpdatedAt = time.Now()
+	})
+}
+
+func (s *memoryJobStore) Fail(ctx context.Context, id primitive.ObjectID, worker string, lastError string) error {
+	return s.jobs.modify(func(j models.Job) bool { return j.ID == id && j.LockedBy == worker }, func(j *models.Job) {
+		j.Status = models.JOB_FAILED
+		j.LastError = lastError
+		j.LockedBy = ""
+		j.LockedUntil = nil
+		j.UpdatedAt = time.Now()
+	})
+}
+
+func (s *memoryJobStore) CancelByRef(ctx context.Context, kind, ref string) error {
+	s.jobs.remove(func(j models.Job) bool { return j.Kind == kind && j.Ref == ref })
+	return nil
+}
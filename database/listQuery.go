@@ -0,0 +1,410 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListQuery asks a store for one page of a list. Filters all have to match,
+// Sort orders the list, with _id as the last key so the order is total, and
+// After holds the sort values of the last record of the previous page.
+type ListQuery struct {
+	Filters []Filter
+	Sort    []SortField
+	Limit   int
+	After   []interface{}
+}
+
+// Filter operators
+const (
+	FILTER_EQ  = "eq"
+	FILTER_IN  = "in"
+	FILTER_GTE = "gte"
+	FILTER_LT  = "lt"
+	// FILTER_EXISTS matches when the field is set, or unset for a false Value
+	FILTER_EXISTS = "exists"
+	// FILTER_OR matches when any of the []Filter in Value does, FILTER_AND when
+	// all of them do, Field is unused
+	FILTER_OR  = "or"
+	FILTER_AND = "and"
+)
+
+// Filter compares the field at a bson path with Value, a slice for FILTER_IN.
+type Filter struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+func Eq(field string, value interface{}) Filter {
+	return Filter{Field: field, Op: FILTER_EQ, Value: value}
+}
+
+func Or(filters ...Filter) Filter {
+	return Filter{Op: FILTER_OR, Value: filters}
+}
+
+func And(filters ...Filter) Filter {
+	return Filter{Op: FILTER_AND, Value: filters}
+}
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Page is one page of a list. Total counts every record matching the filters
+// and Next holds the sort values to continue after, it is nil on the last page.
+type Page[T any] struct {
+	Items []T
+	Total int64
+	Next  []interface{}
+}
+
+// SortKeys returns Sort with _id appended unless it is already there.
+func (q ListQuery) SortKeys() []SortField {
+	keys := slices.Clone(q.Sort)
+	if !slices.ContainsFunc(keys, func(key SortField) bool { return key.Field == "_id" }) {
+		keys = append(keys, SortField{Field: "_id"})
+	}
+	return keys
+}
+
+func (q ListQuery) mongoFilter() bson.M {
+	conditions := bson.A{}
+	for _, filter := range q.Filters {
+		conditions = append(conditions, mongoCondition(filter))
+	}
+
+	if len(conditions) == 0 {
+		return bson.M{}
+	}
+	return bson.M{"$and": conditions}
+}
+
+func mongoCondition(filter Filter) bson.M {
+	switch filter.Op {
+	case FILTER_IN:
+		return bson.M{filter.Field: bson.M{"$in": filter.Value}}
+	case FILTER_GTE:
+		return bson.M{filter.Field: bson.M{"$gte": filter.Value}}
+	case FILTER_LT:
+		return bson.M{filter.Field: bson.M{"$lt": filter.Value}}
+	case FILTER_EXISTS:
+		if exists, _ := filter.Value.(bool); !exists {
+			return bson.M{filter.Field: bson.M{"$eq": nil}}
+		}
+		return bson.M{filter.Field: bson.M{"$ne": nil}}
+	case FILTER_OR:
+		branches := bson.A{}
+		for _, branch := range filter.Value.([]Filter) {
+			branches = append(branches, mongoCondition(branch))
+		}
+		return bson.M{"$or": branches}
+	case FILTER_AND:
+		conditions := bson.A{}
+		for _, condition := range filter.Value.([]Filter) {
+			conditions = append(conditions, mongoCondition(condition))
+		}
+		return bson.M{"$and": conditions}
+	default:
+		return bson.M{filter.Field: bson.M{"$eq": filter.Value}}
+	}
+}
+
+// mongoAfter matches the records that sort after the After values: equal on
+// the first keys and past the value on the next one. A missing value sorts
+// before any other, as it does in mongo.
+func (q ListQuery) mongoAfter() bson.M {
+	keys := q.SortKeys()
+
+	branches := bson.A{}
+	for i, key := range keys {
+		conditions := bson.A{}
+		for j := 0; j < i; j++ {
+			conditions = append(conditions, bson.M{keys[j].Field: bson.M{"$eq": q.After[j]}})
+		}
+
+		value := q.After[i]
+		switch {
+		case !key.Desc && value == nil:
+			conditions = append(conditions, bson.M{key.Field: bson.M{"$ne": nil}})
+		case !key.Desc:
+			conditions = append(conditions, bson.M{key.Field: bson.M{"$gt": value}})
+		case value == nil:
+			// nothing sorts before a missing value
+			continue
+		default:
+			conditions = append(conditions, bson.M{"$or": bson.A{
+				bson.M{key.Field: bson.M{"$lt": value}},
+				bson.M{key.Field: bson.M{"$eq": nil}},
+			}})
+		}
+		branches = append(branches, bson.M{"$and": conditions})
+	}
+
+	if len(branches) == 0 {
+		return bson.M{"_id": bson.M{"$exists": false}}
+	}
+	return bson.M{"$or": branches}
+}
+
+// findPage runs query against a mongo collection, every store's List uses it.
+func findPage[T any](ctx context.Context, collection *mongo.Collection, query ListQuery) (Page[T], error) {
+	page := Page[T]{Items: []T{}}
+
+	filter := query.mongoFilter()
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return page, err
+	}
+	page.Total = total
+
+	if len(query.After) == len(query.SortKeys()) {
+		filter = bson.M{"$and": bson.A{filter, query.mongoAfter()}}
+	}
+
+	sort := bson.D{}
+	for _, key := range query.SortKeys() {
+		direction := 1
+		if key.Desc {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: key.Field, Value: direction})
+	}
+
+	// one more than a page tells whether another page follows
+	opts := options.Find().SetSort(sort).SetLimit(int64(query.Limit) + 1)
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return page, err
+	}
+	defer cursor.Close(ctx)
+
+	var last []interface{}
+	for cursor.Next(ctx) {
+		if len(page.Items) == query.Limit {
+			page.Next = last
+			break
+		}
+
+		var item T
+		if err := cursor.Decode(&item); err != nil {
+			return page, err
+		}
+		page.Items = append(page.Items, item)
+		last = sortValues(cursor.Current, query.SortKeys())
+	}
+	return page, cursor.Err()
+}
+
+// page runs query against the in-memory documents the same way findPage does
+// in mongo, documents go through bson so fields are found by their bson path.
+func (m *memoryCollection[T]) page(query ListQuery) (Page[T], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type entry struct {
+		doc T
+		raw bson.Raw
+	}
+
+	keys := query.SortKeys()
+	after := normalizeValues(query.After)
+
+	matched := []entry{}
+	for _, doc := range m.docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return Page[T]{}, err
+		}
+
+		if !matchFilters(raw, query.Filters) {
+			continue
+		}
+		matched = append(matched, entry{doc: doc, raw: raw})
+	}
+
+	slices.SortStableFunc(matched, func(a, b entry) int {
+		return compareSortValues(sortValues(a.raw, keys), sortValues(b.raw, keys), keys)
+	})
+
+	page := Page[T]{Items: []T{}, Total: int64(len(matched))}
+
+	var last []interface{}
+	for _, e := range matched {
+		values := sortValues(e.raw, keys)
+		if len(after) == len(keys) && compareSortValues(values, after, keys) <= 0 {
+			continue
+		}
+
+		if len(page.Items) == query.Limit {
+			page.Next = last
+			break
+		}
+		page.Items = append(page.Items, e.doc)
+		last = values
+	}
+	return page, nil
+}
+
+// sortValues reads the sort keys of a document, nil for a missing field.
+func sortValues(raw bson.Raw, keys []SortField) []interface{} {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i] = fieldValue(raw, key.Field)
+	}
+	return values
+}
+
+func fieldValue(raw bson.Raw, field string) interface{} {
+	value, err := raw.LookupErr(strings.Split(field, ".")...)
+	if err != nil || value.Type == bsontype.Null {
+		return nil
+	}
+
+	var v interface{}
+	if err := value.Unmarshal(&v); err != nil {
+		return nil
+	}
+	return v
+}
+
+// normalizeValues turns Go values into what reading them back from bson gives,
+// time.Time into primitive.DateTime and so on, so they compare with stored ones.
+func normalizeValues(values []interface{}) []interface{} {
+	normalized := make([]interface{}, len(values))
+	for i, value := range values {
+		raw, _ := bson.Marshal(bson.M{"v": value})
+		normalized[i] = fieldValue(raw, "v")
+	}
+	return normalized
+}
+
+func matchFilters(raw bson.Raw, filters []Filter) bool {
+	for _, filter := range filters {
+		if !matchFilter(raw, filter) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchFilter(raw bson.Raw, filter Filter) bool {
+	switch filter.Op {
+	case FILTER_OR:
+		return slices.ContainsFunc(filter.Value.([]Filter), func(branch Filter) bool { return matchFilter(raw, branch) })
+	case FILTER_AND:
+		return matchFilters(raw, filter.Value.([]Filter))
+	}
+
+	value := fieldValue(raw, filter.Field)
+
+	switch filter.Op {
+	case FILTER_IN:
+		candidates, _ := normalizeValues([]interface{}{filter.Value})[0].(bson.A)
+		return slices.ContainsFunc(candidates, func(candidate interface{}) bool { return compareValues(value, candidate) == 0 })
+	case FILTER_GTE, FILTER_LT:
+		bound := normalizeValues([]interface{}{filter.Value})[0]
+		if value == nil || typeRank(value) != typeRank(bound) {
+			return false
+		}
+		cmp := compareValues(value, bound)
+		return (filter.Op == FILTER_GTE && cmp >= 0) || (filter.Op == FILTER_LT && cmp < 0)
+	case FILTER_EXISTS:
+		exists, _ := filter.Value.(bool)
+		return (value != nil) == exists
+	default:
+		return compareValues(value, normalizeValues([]interface{}{filter.Value})[0]) == 0
+	}
+}
+
+func compareSortValues(a, b []interface{}, keys []SortField) int {
+	for i, key := range keys {
+		cmp := compareValues(a[i], b[i])
+		if key.Desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// typeRank orders values of different types the way mongo sorts them.
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int32, int64, float64:
+		return 1
+	case string:
+		return 2
+	case primitive.ObjectID:
+		return 3
+	case bool:
+		return 4
+	case primitive.DateTime:
+		return 5
+	default:
+		return 6
+	}
+}
+
+func compareValues(a, b interface{}) int {
+	if rankA, rankB := typeRank(a), typeRank(b); rankA != rankB {
+		return rankA - rankB
+	}
+
+	switch a := a.(type) {
+	case nil:
+		return 0
+	case int32, int64, float64:
+		x, y := number(a), number(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	case primitive.ObjectID:
+		b := b.(primitive.ObjectID)
+		return bytes.Compare(a[:], b[:])
+	case bool:
+		switch {
+		case a == b.(bool):
+			return 0
+		case a:
+			return 1
+		}
+		return -1
+	case primitive.DateTime:
+		return a.Time().Compare(b.(primitive.DateTime).Time())
+	default:
+		return 0
+	}
+}
+
+func number(v interface{}) float64 {
+	switch v := v.(type) {
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
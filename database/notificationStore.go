@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NotificationStore is the email outbox. A worker claims a queued notification
+// that is due by leasing it, the calls that settle it only succeed while the
+// caller still holds the lease and return ErrNotFound otherwise.
+type NotificationStore interface {
+	Enqueue(ctx context.Context, notifications []models.Notification) error
+	Claim(ctx context.Context, worker string, now, leaseUntil time.Time) (models.Notification, error)
+	MarkSent(ctx context.Context, id primitive.ObjectID, worker string, now time.Time) error
+	Retry(ctx context.Context, id primitive.ObjectID, worker string, nextAttemptAt time.Time, lastError string) error
+	// Fail settles a notification as failed or bounced.
+	Fail(ctx context.Context, id primitive.ObjectID, worker string, status, lastError string) error
+	List(ctx context.Context, query ListQuery) (Page[models.Notification], error)
+	// CancelQueued stops the emails still queued for the interactions, those
+	// already claimed by a worker go out.
+	CancelQueued(ctx context.Context, interactionIds []string, now time.Time) error
+	// SetParticipants stores who the emails about an interaction concern on
+	// those queued before emails carried it.
+	SetParticipants(ctx context.Context, interactionId string, userID, customerID primitive.ObjectID) error
+}
+
+type mongoNotificationStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoNotificationStore) Enqueue(ctx context.Context, notifications []models.Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, 0, len(notifications))
+	for _, notification := range notifications {
+		docs = append(docs, notification)
+	}
+
+	_, err := s.collection.InsertMany(ctx, docs)
+	return err
+}
+
+func (s *mongoNotificationStore) Claim(ctx context.Context, worker string, now, leaseUntil time.Time) (models.Notification, error) {
+	filter := bson.M{
+		"status":          models.NOTIFICATION_QUEUED,
+		"next_attempt_at": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"locked_until": bson.M{"$exists": false}},
+			bson.M{"locked_until": bson.M{"$lt": now}},
+		},
+	}
+
+	update := bson.M{
+		"$set": bson.M{"locked_by": worker, "locked_until": leaseUntil},
+		"$inc": bson.M{"attempts": 1},
+	}
+
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetReturnDocument(options.After)
+
+	var notification models.Notification
+	err := s.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&notification)
+	return notification, mongoError(err)
+}
+
+func (s *mongoNotificationStore) MarkSent(ctx context.Context, id primitive.ObjectID, worker string, now time.Time) error {
+	return s.release(ctx, id, worker, bson.M{"status": models.NOTIFICATION_SENT, "sent_at": now, "updated_at": now})
+}
+
+func (s *mongoNotificationStore) Retry(ctx context.Context, id primitive.ObjectID, worker string, nextAttemptAt time.Time, lastError string) error {
+	return s.release(ctx, id, worker, bson.M{"next_attempt_at": nextAttemptAt, "last_error": lastError, "updated_at": time.Now()})
+}
+
+func (s *mongoNotificationStore) Fail(ctx context.Context, id primitive.ObjectID, worker string, status, lastError string) error {
+	return s.release(ctx, id, worker, bson.M{"status": status, "last_error": lastError, "updated_at": time.Now()})
+}
+
+// release drops the lease of a claimed notification while setting fields.
+func (s *mongoNotificationStore) release(ctx context.Context, id primitive.ObjectID, worker string, fields bson.M) error {
+	filter := bson.M{"_id": id, "locked_by": worker}
+	update := bson.M{"$set": fields, "$unset": bson.M{"locked_by": "", "locked_until": ""}}
+
+	result, err := s.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoNotificationStore) List(ctx context.Context, query ListQuery) (Page[models.Notification], error) {
+	return findPage[models.Notification](ctx, s.collection, query)
+}
+
+func (s *mongoNotificationStore) CancelQueued(ctx context.Context, interactionIds []string, now time.Time) error {
+	filter := bson.M{
+		"interaction_id": bson.M{"$in": interactionIds},
+		"status":         models.NOTIFICATION_QUEUED,
+		"$or": bson.A{
+			bson.M{"locked_until": bson.M{"$exists": false}},
+			bson.M{"locked_until": bson.M{"$lt": now}},
+		},
+	}
+
+	_, err := s.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"status": models.NOTIFICATION_CANCELLED, "updated_at": now}})
+	return err
+}
+
+func (s *mongoNotificationStore) SetParticipants(ctx context.Context, interactionId string, userID, customerID primitive.ObjectID) error {
+	filter := bson.M{"interaction_id": interactionId, "user_id": bson.M{"$exists": false}}
+
+	_, err := s.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"user_id": userID, "customer_id": customerID}})
+	return err
+}
+
+type memoryNotificationStore struct {
+	notifications memoryCollection[models.Notification]
+}
+
+func (s *memoryNotificationStore) Enqueue(ctx context.Context, notifications []models.Notification) error {
+	for _, notification := range notifications {
+		s.notifications.insert(notification)
+	}
+	return nil
+}
+
+func (s *memoryNotificationStore) Claim(ctx context.Context, worker string, now, leaseUntil time.Time) (models.Notification, error) {
+	match := func(n models.Notification) bool {
+		return n.Status == models.NOTIFICATION_QUEUED && !n.NextAttemptAt.After(now) && (n.LockedUntil == nil || n.LockedUntil.Before(now))
+	}
+
+	var claimed models.Notification
+	err := s.notifications.modify(match, func(n *models.Notification) {
+		n.LockedBy = worker
+		n.LockedUntil = &leaseUntil
+		n.Attempts++
+		claimed = *n
+	})
+	return claimed, err
+}
+
+func (s *memoryNotificationStore) MarkSent(ctx context.Context, id primitive.ObjectID, worker string, now time.Time) error {
+	return s.release(id, worker, func(n *models.Notification) {
+		n.Status = models.NOTIFICATION_SENT
+		n.SentAt = &now
+		n.UpdatedAt = now
+	})
+}
+
+func (s *memoryNotificationStore) Retry(ctx context.Context, id primitive.ObjectID, worker string, nextAttemptAt time.Time, lastError string) error {
+	return s.release(id, worker, func(n *models.Notification) {
+		n.NextAttemptAt = nextAttemptAt
+		n.LastError = lastError
+		n.UpdatedAt = time.Now()
+	})
+}
+
+func (s *memoryNotificationStore) Fail(ctx context.Context, id primitive.ObjectID, worker string, status, lastError string) error {
+	return s.release(id, worker, func(n *models.Notification) {
+		n.Status = status
+		n.LastError = lastError
+		n.UpdatedAt = time.Now()
+	})
+}
+
+func (s *memoryNotificationStore) release(id primitive.ObjectID, worker string, fn func(*models.Notification)) error {
+	return s.notifications.modify(func(n models.Notification) bool { return n.ID == id && n.LockedBy == worker }, func(n *models.Notification) {
+		fn(n)
+		n.LockedBy = ""
+		n.LockedUntil = nil
+	})
+}
+
+func (s *memoryNotificationStore) List(ctx context.Context, query ListQuery) (Page[models.Notification], error) {
+	return s.notifications.page(query)
+}
+
+func (s *memoryNotificationStore) CancelQueued(ctx context.Context, interactionIds []string, now time.Time) error {
+	s.notifications.modifyAll(func(n models.Notification) bool {
+		return slices.Contains(interactionIds, n.InteractionId) && n.Status == models.NOTIFICATION_QUEUED &&
+			(n.LockedUntil == nil || n.LockedUntil.Before(now))
+	}, func(n *models.Notification) {
+		n.Status = models.NOTIFICATION_CANCELLED
+		n.UpdatedAt = now
+	})
+	return nil
+}
+
+func (s *memoryNotificationStore) SetParticipants(ctx context.Context, interactionId string, userID, customerID primitive.ObjectID) error {
+	s.notifications.modifyAll(func(n models.Notification) bool {
+		return n.InteractionId == interactionId && n.UserID == nil
+	}, func(n *models.Notification) {
+		n.UserID = &userID
+		n.CustomerID = &customerID
+	})
+	return nil
+}
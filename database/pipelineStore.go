@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type PipelineStore interface {
+	Create(ctx context.Context, pipeline *models.Pipeline) error
+	FindByName(ctx context.Context, name string) (models.Pipeline, error)
+	List(ctx context.Context) ([]models.Pipeline, error)
+	Update(ctx context.Context, name string, fields bson.M) error
+}
+
+type mongoPipelineStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoPipelineStore) Create(ctx context.Context, pipeline *models.Pipeline) error {
+	_, err := s.collection.InsertOne(ctx, pipeline)
+	return err
+}
+
+func (s *mongoPipelineStore) FindByName(ctx context.Context, name string) (models.Pipeline, error) {
+	var pipeline models.Pipeline
+	err := s.collection.FindOne(ctx, bson.M{"name": name}).Decode(&pipeline)
+	return pipeline, mongoError(err)
+}
+
+func (s *mongoPipelineStore) List(ctx context.Context) ([]models.Pipeline, error) {
+	pipelines := []models.Pipeline{}
+
+	cursor, err := s.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &pipelines)
+	return pipelines, err
+}
+
+func (s *mongoPipelineStore) Update(ctx context.Context, name string, fields bson.M) error {
+	result, err := s.collection.UpdateOne(ctx, bson.M{"name": name}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memoryPipelineStore struct {
+	pipelines memoryCollection[models.Pipeline]
+}
+
+func (s *memoryPipelineStore) Create(ctx context.Context, pipeline *models.Pipeline) error {
+	s.pipelines.insert(*pipeline)
+	return nil
+}
+
+func (s *memoryPipelineStore) FindByName(ctx context.Context, name string) (models.Pipeline, error) {
+	return s.pipelines.find(func(p models.Pipeline) bool { return p.Name != nil && *p.Name == name })
+}
+
+func (s *memoryPipelineStore) List(ctx context.Context) ([]models.Pipeline, error) {
+	return s.pipelines.filter(func(models.Pipeline) bool { return true }), nil
+}
+
+func (s *memoryPipelineStore) Update(ctx context.Context, name string, fields bson.M) error {
+	return s.pipelines.update(func(p models.Pipeline) bool { return p.Name != nil && *p.Name == name }, fields)
+}
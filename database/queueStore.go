@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type QueueStore interface {
+	Create(ctx context.Context, queue *models.Queue) error
+	FindByName(ctx context.Context, name string) (models.Queue, error)
+	List(ctx context.Context) ([]models.Queue, error)
+	Update(ctx context.Context, name string, fields bson.M) error
+	// AdvanceCursor moves the round-robin cursor from prev to next, it returns
+	// ErrNotFound when another assignment moved the cursor first.
+	AdvanceCursor(ctx context.Context, name, prev, next string) error
+}
+
+type mongoQueueStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoQueueStore) Create(ctx context.Context, queue *models.Queue) error {
+	_, err := s.collection.InsertOne(ctx, queue)
+	return err
+}
+
+func (s *mongoQueueStore) FindByName(ctx context.Context, name string) (models.Queue, error) {
+	var queue models.Queue
+	err := s.collection.FindOne(ctx, bson.M{"name": name}).Decode(&queue)
+	return queue, mongoError(err)
+}
+
+func (s *mongoQueueStore) List(ctx context.Context) ([]models.Queue, error) {
+	queues := []models.Queue{}
+
+	cursor, err := s.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &queues)
+	return queues, err
+}
+
+func (s *mongoQueueStore) Update(ctx context.Context, name string, fields bson.M) error {
+	result, err := s.collection.UpdateOne(ctx, bson.M{"name": name}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoQueueStore) AdvanceCursor(ctx context.Context, name, prev, next string) error {
+	filter := bson.M{"name": name, "last_assigned_id": prev}
+	if prev == "" {
+		filter["last_assigned_id"] = bson.M{"$in": bson.A{nil, ""}}
+	}
+
+	update := bson.M{"$set": bson.M{"last_assigned_id": next, "updated_at": time.Now()}}
+
+	result, err := s.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memoryQueueStore struct {
+	queues memoryCollection[models.Queue]
+}
+
+func (s *memoryQueueStore) Create(ctx context.Context, queue *models.Queue) error {
+	s.queues.insert(*queue)
+	return nil
+}
+
+func (s *memoryQueueStore) FindByName(ctx context.Context, name string) (models.Queue, error) {
+	return s.queues.find(func(q models.Queue) bool { return q.Name != nil && *q.Name == name })
+}
+
+func (s *memoryQueueStore) List(ctx context.Context) ([]models.Queue, error) {
+	return s.queues.filter(func(models.Queue) bool { return true }), nil
+}
+
+func (s *memoryQueueStore) Update(ctx context.Context, name string, fields bson.M) error {
+	return s.queues.update(func(q models.Queue) bool { return q.Name != nil && *q.Name == name }, fields)
+}
+
+func (s *memoryQueueStore) AdvanceCursor(ctx context.Context, name, prev, next string) error {
+	match := func(q models.Queue) bool {
+		return q.Name != nil && *q.Name == name && q.LastAssignedId == prev
+	}
+
+	return s.queues.modify(match, func(q *models.Queue) {
+		q.LastAssignedId = next
+		q.UpdatedAt = time.Now()
+	})
+}
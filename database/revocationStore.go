@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RevocationStore is the deny list consulted on every authenticated request,
+// entries are keyed by access token id (jti) or session id.
+type RevocationStore interface {
+	Revoke(ctx context.Context, tokenId string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, tokenIds ...string) (bool, error)
+}
+
+type mongoRevocationStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoRevocationStore) Revoke(ctx context.Context, tokenId string, expiresAt time.Time) error {
+	revoked := models.RevokedToken{
+		ID:        primitive.NewObjectID(),
+		TokenId:   tokenId,
+		ExpiresAt: expiresAt,
+	}
+
+	_, err := s.collection.InsertOne(ctx, revoked)
+	return err
+}
+
+func (s *mongoRevocationStore) IsRevoked(ctx context.Context, tokenIds ...string) (bool, error) {
+	filter := bson.M{
+		"token_id":   bson.M{"$in": tokenIds},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+
+	count, err := s.collection.CountDocuments(ctx, filter)
+	return count > 0, err
+}
+
+type memoryRevocationStore struct {
+	revoked memoryCollection[models.RevokedToken]
+}
+
+func (s *memoryRevocationStore) Revoke(ctx context.Context, tokenId string, expiresAt time.Time) error {
+	now := time.Now()
+	s.revoked.remove(func(r models.RevokedToken) bool { return r.ExpiresAt.Before(now) })
+
+	s.revoked.insert(models.RevokedToken{
+		ID:        primitive.NewObjectID(),
+		TokenId:   tokenId,
+		ExpiresAt: expiresAt,
+	})
+	return nil
+}
+
+func (s *memoryRevocationStore) IsRevoked(ctx context.Context, tokenIds ...string) (bool, error) {
+	now := time.Now()
+
+	count := s.revoked.count(func(r models.RevokedToken) bool {
+		return r.ExpiresAt.After(now) && slices.Contains(tokenIds, r.TokenId)
+	})
+	return count > 0, nil
+}
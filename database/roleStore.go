@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type RoleStore interface {
+	Create(ctx context.Context, role *models.Role) error
+	FindByName(ctx context.Context, name string) (models.Role, error)
+	List(ctx context.Context) ([]models.Role, error)
+	Update(ctx context.Context, name string, fields bson.M) error
+}
+
+type mongoRoleStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoRoleStore) Create(ctx context.Context, role *models.Role) error {
+	_, err := s.collection.InsertOne(ctx, role)
+	return err
+}
+
+func (s *mongoRoleStore) FindByName(ctx context.Context, name string) (models.Role, error) {
+	var role models.Role
+	err := s.collection.FindOne(ctx, bson.M{"name": name}).Decode(&role)
+	return role, mongoError(err)
+}
+
+func (s *mongoRoleStore) List(ctx context.Context) ([]models.Role, error) {
+	roles := []models.Role{}
+
+	cursor, err := s.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &roles)
+	return roles, err
+}
+
+func (s *mongoRoleStore) Update(ctx context.Context, name string, fields bson.M) error {
+	result, err := s.collection.UpdateOne(ctx, bson.M{"name": name}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memoryRoleStore struct {
+	roles memoryCollection[models.Role]
+}
+
+func (s *memoryRoleStore) Create(ctx context.Context, role *models.Role) error {
+	s.roles.insert(*role)
+	return nil
+}
+
+func (s *memoryRoleStore) FindByName(ctx context.Context, name string) (models.Role, error) {
+	return s.roles.find(func(r models.Role) bool { return r.Name != nil && *r.Name == name })
+}
+
+func (s *memoryRoleStore) List(ctx context.Context) ([]models.Role, error) {
+	return s.roles.filter(func(models.Role) bool { return true }), nil
+}
+
+func (s *memoryRoleStore) Update(ctx context.Context, name string, fields bson.M) error {
+	return s.roles.update(func(r models.Role) bool { return r.Name != nil && *r.Name == name }, fields)
+}
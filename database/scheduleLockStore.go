@@ -0,0 +1,41 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ScheduleLockStore serializes the bookings of a person: transactions that
+// lock the same key write the same document, so the database lets one of them
+// commit and retries the others, which then see its interaction.
+type ScheduleLockStore interface {
+	Lock(ctx context.Context, keys ...string) error
+}
+
+type mongoScheduleLockStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoScheduleLockStore) Lock(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		update := bson.M{"$inc": bson.M{"version": 1}, "$set": bson.M{"locked_at": time.Now()}}
+
+		_, err := s.collection.UpdateOne(ctx, bson.M{"_id": key}, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memoryScheduleLockStore has nothing to do, memoryTransactor runs one
+// transaction at a time.
+type memoryScheduleLockStore struct{}
+
+func (memoryScheduleLockStore) Lock(ctx context.Context, keys ...string) error {
+	return nil
+}
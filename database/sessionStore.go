@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type SessionStore interface {
+	Create(ctx context.Context, session *models.Session) error
+	FindByID(ctx context.Context, sessionId string) (models.Session, error)
+	// FindByRefreshToken matches the current refresh token hash as well as any
+	// hash already rotated out, so callers can detect reuse.
+	FindByRefreshToken(ctx context.Context, hash string) (models.Session, error)
+	// Rotate swaps oldHash for newHash, it returns ErrNotFound when oldHash is no
+	// longer the current token, e.g. because a concurrent refresh won the race.
+	Rotate(ctx context.Context, sessionId, oldHash, newHash string, expiresAt time.Time) error
+	Revoke(ctx context.Context, sessionId string, revokedAt time.Time) error
+}
+
+type mongoSessionStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoSessionStore) Create(ctx context.Context, session *models.Session) error {
+	_, err := s.collection.InsertOne(ctx, session)
+	return err
+}
+
+func (s *mongoSessionStore) FindByID(ctx context.Context, sessionId string) (models.Session, error) {
+	var session models.Session
+	err := s.collection.FindOne(ctx, bson.M{"session_id": sessionId}).Decode(&session)
+	return session, mongoError(err)
+}
+
+func (s *mongoSessionStore) FindByRefreshToken(ctx context.Context, hash string) (models.Session, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"refresh_token_hash": hash},
+		bson.M{"used_refresh_token_hashes": hash},
+	}}
+
+	var session models.Session
+	err := s.collection.FindOne(ctx, filter).Decode(&session)
+	return session, mongoError(err)
+}
+
+func (s *mongoSessionStore) Rotate(ctx context.Context, sessionId, oldHash, newHash string, expiresAt time.Time) error {
+	filter := bson.M{
+		"session_id":         sessionId,
+		"refresh_token_hash": oldHash,
+		"revoked_at":         bson.M{"$exists": false},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"refresh_token_hash": newHash,
+			"expires_at":         expiresAt,
+			"updated_at":         time.Now(),
+		},
+		"$push": bson.M{"used_refresh_token_hashes": oldHash},
+	}
+
+	result, err := s.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoSessionStore) Revoke(ctx context.Context, sessionId string, revokedAt time.Time) error {
+	update := bson.M{"$set": bson.M{"revoked_at": revokedAt, "updated_at": revokedAt}}
+
+	result, err := s.collection.UpdateOne(ctx, bson.M{"session_id": sessionId}, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memorySessionStore struct {
+	sessions memoryCollection[models.Session]
+}
+
+func (s *memorySessionStore) Create(ctx context.Context, session *models.Session) error {
+	s.sessions.insert(*session)
+	return nil
+}
+
+func (s *memorySessionStore) FindByID(ctx context.Context, sessionId string) (models.Session, error) {
+	return s.sessions.find(func(session models.Session) bool { return session.SessionId == sessionId })
+}
+
+func (s *memorySessionStore) FindByRefreshToken(ctx context.Context, hash string) (models.Session, error) {
+	return s.sessions.find(func(session models.Session) bool {
+		return session.RefreshTokenHash == hash || slices.Contains(session.UsedRefreshTokenHashes, hash)
+	})
+}
+
+func (s *memorySessionStore) Rotate(ctx context.Context, sessionId, oldHash, newHash string, expiresAt time.Time) error {
+	match := func(session models.Session) bool {
+		return session.SessionId == sessionId && session.RefreshTokenHash == oldHash && session.RevokedAt == nil
+	}
+
+	return s.sessions.modify(match, func(session *models.Session) {
+		session.UsedRefreshTokenHashes = append(slices.Clone(session.UsedRefreshTokenHashes), oldHash)
+		session.RefreshTokenHash = newHash
+		session.ExpiresAt = expiresAt
+		session.UpdatedAt = time.Now()
+	})
+}
+
+func (s *memorySessionStore) Revoke(ctx context.Context, sessionId string, revokedAt time.Time) error {
+	match := func(session models.Session) bool { return session.SessionId == sessionId }
+
+	return s.sessions.modify(match, func(session *models.Session) {
+		session.RevokedAt = &revokedAt
+		session.UpdatedAt = revokedAt
+	})
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SlaStore keeps the SLA configuration, policies and the business calendars they count time in.
+type SlaStore interface {
+	CreatePolicy(ctx context.Context, policy *models.SlaPolicy) error
+	FindPolicyByName(ctx context.Context, name string) (models.SlaPolicy, error)
+	ListPolicies(ctx context.Context) ([]models.SlaPolicy, error)
+	UpdatePolicy(ctx context.Context, name string, fields bson.M) error
+	CreateCalendar(ctx context.Context, calendar *models.BusinessCalendar) error
+	FindCalendarByName(ctx context.Context, name string) (models.BusinessCalendar, error)
+	ListCalendars(ctx context.Context) ([]models.BusinessCalendar, error)
+	UpdateCalendar(ctx context.Context, name string, fields bson.M) error
+}
+
+type mongoSlaStore struct {
+	policies  *mongo.Collection
+	calendars *mongo.Collection
+}
+
+func (s *mongoSlaStore) CreatePolicy(ctx context.Context, policy *models.SlaPolicy) error {
+	_, err := s.policies.InsertOne(ctx, policy)
+	return err
+}
+
+func (s *mongoSlaStore) FindPolicyByName(ctx context.Context, name string) (models.SlaPolicy, error) {
+	var policy models.SlaPolicy
+	err := s.policies.FindOne(ctx, bson.M{"name": name}).Decode(&policy)
+	return policy, mongoError(err)
+}
+
+func (s *mongoSlaStore) ListPolicies(ctx context.Context) ([]models.SlaPolicy, error) {
+	policies := []models.SlaPolicy{}
+
+	cursor, err := s.policies.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &policies)
+	return policies, err
+}
+
+func (s *mongoSlaStore) UpdatePolicy(ctx context.Context, name string, fields bson.M) error {
+	return updateByName(ctx, s.policies, name, fields)
+}
+
+func (s *mongoSlaStore) CreateCalendar(ctx context.Context, calendar *models.BusinessCalendar) error {
+	_, err := s.calendars.InsertOne(ctx, calendar)
+	return err
+}
+
+func (s *mongoSlaStore) FindCalendarByName(ctx context.Context, name string) (models.BusinessCalendar, error) {
+	var calendar models.BusinessCalendar
+	err := s.calendars.FindOne(ctx, bson.M{"name": name}).Decode(&calendar)
+	return calendar, mongoError(err)
+}
+
+func (s *mongoSlaStore) ListCalendars(ctx context.Context) ([]models.BusinessCalendar, error) {
+	calendars := []models.BusinessCalendar{}
+
+	cursor, err := s.calendars.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &calendars)
+	return calendars, err
+}
+
+func (s *mongoSlaStore) UpdateCalendar(ctx context.Context, name string, fields bson.M) error {
+	return updateByName(ctx, s.calendars, name, fields)
+}
+
+func updateByName(ctx context.Context, collection *mongo.Collection, name string, fields bson.M) error {
+	result, err := collection.UpdateOne(ctx, bson.M{"name": name}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memorySlaStore struct {
+	policies  memoryCollection[models.SlaPolicy]
+	calendars memoryCollection[models.BusinessCalendar]
+}
+
+func (s *memorySlaStore) CreatePolicy(ctx context.Context, policy *models.SlaPolicy) error {
+	s.policies.insert(*policy)
+	return nil
+}
+
+func (s *memorySlaStore) FindPolicyByName(ctx context.Context, name string) (models.SlaPolicy, error) {
+	return s.policies.find(func(p models.SlaPolicy) bool { return p.Name != nil && *p.Name == name })
+}
+
+func (s *memorySlaStore) ListPolicies(ctx context.Context) ([]models.SlaPolicy, error) {
+	return s.policies.filter(func(models.SlaPolicy) bool { return true }), nil
+}
+
+func (s *memorySlaStore) UpdatePolicy(ctx context.Context, name string, fields bson.M) error {
+	return s.policies.update(func(p models.SlaPolicy) bool { return p.Name != nil && *p.Name == name }, fields)
+}
+
+func (s *memorySlaStore) CreateCalendar(ctx context.Context, calendar *models.BusinessCalendar) error {
+	s.calendars.insert(*calendar)
+	return nil
+}
+
+func (s *memorySlaStore) FindCalendarByName(ctx context.Context, name string) (models.BusinessCalendar, error) {
+	return s.calendars.find(func(b models.BusinessCalendar) bool { return b.Name != nil && *b.Name == name })
+}
+
+func (s *memorySlaStore) ListCalendars(ctx context.Context) ([]models.BusinessCalendar, error) {
+	return s.calendars.filter(func(models.BusinessCalendar) bool { return true }), nil
+}
+
+func (s *memorySlaStore) UpdateCalendar(ctx context.Context, name string, fields bson.M) error {
+	return s.calendars.update(func(b models.BusinessCalendar) bool { return b.Name != nil && *b.Name == name }, fields)
+}
@@ -0,0 +1,425 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	UserCollectionName          = "users"
+	CustomerCollectionName      = "customers"
+	InteractionCollectionName   = "interactions"
+	TicketCollectionName        = "tickets"
+	SessionCollectionName       = "sessions"
+	RevokedTokenCollectionName  = "revoked_tokens"
+	RoleCollectionName          = "roles"
+	QueueCollectionName         = "queues"
+	SlaPolicyCollectionName     = "sla_policies"
+	CalendarCollectionName      = "business_calendars"
+	CommentCollectionName       = "ticket_comments"
+	AttachmentCollectionName    = "attachments"
+	JobCollectionName           = "jobs"
+	NotificationCollectionName  = "notifications"
+	EmailTemplateCollectionName = "email_templates"
+	AccountCollectionName       = "accounts"
+	PipelineCollectionName      = "pipelines"
+	DealCollectionName          = "deals"
+	InboundEmailCollectionName  = "inbound_emails"
+	ScheduleLockCollectionName  = "schedule_locks"
+)
+
+// ErrNotFound is returned by every store when the requested document does not exist.
+var ErrNotFound = errors.New("document not found")
+
+// ErrDuplicate is returned when a write would break a unique index.
+var ErrDuplicate = errors.New("document already exists")
+
+// Stores bundles every repository the handlers depend on, so main can wire
+// either the Mongo backed or the in-memory implementation in one place.
+type Stores struct {
+	Users          UserStore
+	Customers      CustomerStore
+	Interactions   InteractionStore
+	Tickets        TicketStore
+	Sessions       SessionStore
+	Revocations    RevocationStore
+	Roles          RoleStore
+	Queues         QueueStore
+	Slas           SlaStore
+	Comments       CommentStore
+	Attachments    AttachmentStore
+	Jobs           JobStore
+	Notifications  NotificationStore
+	EmailTemplates EmailTemplateStore
+	Accounts       AccountStore
+	Pipelines      PipelineStore
+	Deals          DealStore
+	InboundEmails  InboundEmailStore
+	ScheduleLocks  ScheduleLockStore
+	// Transactions groups writes to several stores
+	Transactions Transactor
+	// Blobs is not tied to the document database, main wires it from LoadBlobConfig
+	Blobs BlobStore
+}
+
+func NewMongoStores(db *mongo.Database) *Stores {
+	return &Stores{
+		Users:        &mongoUserStore{collection: db.Collection(UserCollectionName)},
+		Customers:    &mongoCustomerStore{collection: db.Collection(CustomerCollectionName)},
+		Interactions: &mongoInteractionStore{collection: db.Collection(InteractionCollectionName)},
+		Tickets:      &mongoTicketStore{collection: db.Collection(TicketCollectionName)},
+		Sessions:     &mongoSessionStore{collection: db.Collection(SessionCollectionName)},
+		Revocations:  &mongoRevocationStore{collection: db.Collection(RevokedTokenCollectionName)},
+		Roles:        &mongoRoleStore{collection: db.Collection(RoleCollectionName)},
+		Queues:       &mongoQueueStore{collection: db.Collection(QueueCollectionName)},
+		Slas: &mongoSlaStore{
+			policies:  db.Collection(SlaPolicyCollectionName),
+			calendars: db.Collection(CalendarCollectionName),
+		},
+		Comments:       &mongoCommentStore{collection: db.Collection(CommentCollectionName)},
+		Attachments:    &mongoAttachmentStore{collection: db.Collection(AttachmentCollectionName)},
+		Jobs:           &mongoJobStore{collection: db.Collection(JobCollectionName)},
+		Notifications:  &mongoNotificationStore{collection: db.Collection(NotificationCollectionName)},
+		EmailTemplates: &cachedEmailTemplateStore{EmailTemplateStore: &mongoEmailTemplateStore{collection: db.Collection(EmailTemplateCollectionName)}},
+		Accounts:       &mongoAccountStore{collection: db.Collection(AccountCollectionName)},
+		Pipelines:      &mongoPipelineStore{collection: db.Collection(PipelineCollectionName)},
+		Deals:          &mongoDealStore{collection: db.Collection(DealCollectionName)},
+		InboundEmails:  &mongoInboundEmailStore{collection: db.Collection(InboundEmailCollectionName)},
+		ScheduleLocks:  &mongoScheduleLockStore{collection: db.Collection(ScheduleLockCollectionName)},
+		Transactions:   &mongoTransactor{client: db.Client()},
+	}
+}
+
+func NewMemoryStores() *Stores {
+	return &Stores{
+		Users:          &memoryUserStore{},
+		Customers:      &memoryCustomerStore{},
+		Interactions:   &memoryInteractionStore{},
+		Tickets:        &memoryTicketStore{},
+		Sessions:       &memorySessionStore{},
+		Revocations:    &memoryRevocationStore{},
+		Roles:          &memoryRoleStore{},
+		Queues:         &memoryQueueStore{},
+		Slas:           &memorySlaStore{},
+		Comments:       &memoryCommentStore{},
+		Attachments:    &memoryAttachmentStore{},
+		Jobs:           &memoryJobStore{},
+		Notifications:  &memoryNotificationStore{},
+		EmailTemplates: &memoryEmailTemplateStore{},
+		Accounts:       &memoryAccountStore{},
+		Pipelines:      &memoryPipelineStore{},
+		Deals:          &memoryDealStore{},
+		InboundEmails:  &memoryInboundEmailStore{},
+		ScheduleLocks:  memoryScheduleLockStore{},
+		Transactions:   &memoryTransactor{},
+	}
+}
+
+// EnsureIndexes creates the indexes the mongo stores rely on, it is safe to call on every start.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	indexes := map[string][]mongo.IndexModel{
+		SessionCollectionName: {
+			{Keys: bson.D{{Key: "session_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "refresh_token_hash", Value: 1}}},
+			{Keys: bson.D{{Key: "used_refresh_token_hashes", Value: 1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		RevokedTokenCollectionName: {
+			{Keys: bson.D{{Key: "token_id", Value: 1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		RoleCollectionName: {
+			{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		UserCollectionName: {
+			{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "calendar_token_hash", Value: 1}}, Options: options.Index().SetSparse(true)},
+		},
+		CustomerCollectionName: {
+			textIndex("customer_text", CustomerSearchFields),
+			{Keys: bson.D{{Key: "account_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+			{Keys: bson.D{{Key: "pending_account_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+		},
+		AccountCollectionName: {
+			// accounts without a domain are left out, a cleared domain is stored as null
+			{Keys: bson.D{{Key: "domain", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"domain": bson.M{"$type": "string"}})},
+		},
+		InteractionCollectionName: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "type", Value: 1}}},
+			{Keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "type", Value: 1}}},
+			textIndex("interaction_text", InteractionSearchFields),
+			{Keys: bson.D{{Key: "deal_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+			{Keys: bson.D{{Key: "series_id", Value: 1}, {Key: "recurrence_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+			{Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "updated_at", Value: -1}}, Options: options.Index().SetSparse(true)},
+		},
+		PipelineCollectionName: {
+			{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		DealCollectionName: {
+			{Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "expected_close_date", Value: 1}}},
+			{Keys: bson.D{{Key: "pipeline", Value: 1}, {Key: "stage", Value: 1}}},
+			{Keys: bson.D{{Key: "customer_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+			{Keys: bson.D{{Key: "account_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+		},
+		TicketCollectionName: {
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "resolved_at", Value: 1}}},
+			{Keys: bson.D{{Key: "assignee_id", Value: 1}, {Key: "status", Value: 1}}},
+			{Keys: bson.D{{Key: "sla_breached", Value: 1}}},
+			{Keys: bson.D{{Key: "first_response_due", Value: 1}}},
+			{Keys: bson.D{{Key: "resolution_due", Value: 1}}},
+			{Keys: bson.D{{Key: "interaction_id", Value: 1}}},
+			{Keys: bson.D{{Key: "customer_id", Value: 1}}},
+			{Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "status", Value: 1}}, Options: options.Index().SetSparse(true)},
+			textIndex("ticket_text", TicketSearchFields),
+		},
+		CommentCollectionName: {
+			{Keys: bson.D{{Key: "ticket_id", Value: 1}, {Key: "created_at", Value: 1}}},
+			{Keys: bson.D{{Key: "customer_id", Value: 1}}},
+			textIndex("comment_text", CommentSearchFields),
+		},
+		AttachmentCollectionName: {
+			{Keys: bson.D{{Key: "owner_type", Value: 1}, {Key: "owner_id", Value: 1}}},
+		},
+		SlaPolicyCollectionName: {
+			{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		CalendarCollectionName: {
+			{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		QueueCollectionName: {
+			{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		JobCollectionName: {
+			{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_at", Value: 1}}},
+			{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "ref", Value: 1}}},
+		},
+		NotificationCollectionName: {
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+			{Keys: bson.D{{Key: "interaction_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		},
+		EmailTemplateCollectionName: {
+			{Keys: bson.D{{Key: "locale", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		InboundEmailCollectionName: {
+			{Keys: bson.D{{Key: "sender", Value: 1}, {Key: "message_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+	}
+
+	// inbound emails were unique by Message-ID alone before the sender was part of the key
+	if _, err := db.Collection(InboundEmailCollectionName).Indexes().DropOne(ctx, "message_id_1"); err != nil && !isIndexNotFound(err) {
+		return fmt.Errorf("error dropping the old index on %s: %w", InboundEmailCollectionName, err)
+	}
+
+	for collection, models := range indexes {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("error creating indexes on %s: %w", collection, err)
+		}
+	}
+	return nil
+}
+
+// isIndexNotFound reports whether a dropped index or its collection did not exist.
+func isIndexNotFound(err error) bool {
+	var commandErr mongo.CommandError
+	return errors.As(err, &commandErr) && (commandErr.Code == 27 || commandErr.Code == 26)
+}
+
+func mongoError(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// memoryCollection is the in-memory counterpart of a mongo collection, it keeps
+// documents in insertion order and guards them with a single lock.
+type memoryCollection[T any] struct {
+	mu   sync.RWMutex
+	docs []T
+}
+
+func (m *memoryCollection[T]) insert(doc T) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.docs = append(m.docs, doc)
+}
+
+// insertUnique inserts doc unless a stored document conflicts with it, the way
+// a unique index refuses the write.
+func (m *memoryCollection[T]) insertUnique(doc T, conflicts func(T) bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range m.docs {
+		if conflicts(d) {
+			return false
+		}
+	}
+	m.docs = append(m.docs, doc)
+	return true
+}
+
+func (m *memoryCollection[T]) count(match func(T) bool) int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var n int64
+	for _, doc := range m.docs {
+		if match(doc) {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *memoryCollection[T]) find(match func(T) bool) (T, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, doc := range m.docs {
+		if match(doc) {
+			return doc, nil
+		}
+	}
+
+	var zero T
+	return zero, ErrNotFound
+}
+
+func (m *memoryCollection[T]) filter(match func(T) bool) []T {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	docs := []T{}
+	for _, doc := range m.docs {
+		if match(doc) {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
+
+// update applies fields the same way a mongo "$set" would, keyed by bson field name.
+func (m *memoryCollection[T]) update(match func(T) bool, fields bson.M) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, doc := range m.docs {
+		if !match(doc) {
+			continue
+		}
+
+		updated, err := setFields(doc, fields)
+		if err != nil {
+			return err
+		}
+		m.docs[i] = updated
+		return nil
+	}
+	return ErrNotFound
+}
+
+// updateAll applies fields to every matching document, it returns how many matched.
+func (m *memoryCollection[T]) updateAll(match func(T) bool, fields bson.M) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matched int64
+	for i, doc := range m.docs {
+		if !match(doc) {
+			continue
+		}
+
+		updated, err := setFields(doc, fields)
+		if err != nil {
+			return matched, err
+		}
+		m.docs[i] = updated
+		matched++
+	}
+	return matched, nil
+}
+
+// modify hands the first matching document to fn for an in-place change, for
+// updates that need more than a plain "$set".
+func (m *memoryCollection[T]) modify(match func(T) bool, fn func(*T)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.docs {
+		if match(m.docs[i]) {
+			fn(&m.docs[i])
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// modifyAll is modify for every matching document, it returns how many matched.
+func (m *memoryCollection[T]) modifyAll(match func(T) bool, fn func(*T)) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matched int64
+	for i := range m.docs {
+		if match(m.docs[i]) {
+			fn(&m.docs[i])
+			matched++
+		}
+	}
+	return matched
+}
+
+func (m *memoryCollection[T]) remove(match func(T) bool) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed int64
+	kept := m.docs[:0]
+	for _, doc := range m.docs {
+		if match(doc) {
+			removed++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	m.docs = kept
+	return removed
+}
+
+// setFields round-trips doc through bson so updates built for mongo can be
+// reused verbatim against in-memory documents.
+func setFields[T any](doc T, fields bson.M) (T, error) {
+	var updated T
+
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return updated, err
+	}
+
+	var m bson.M
+	if err := bson.Unmarshal(raw, &m); err != nil {
+		return updated, err
+	}
+
+	for key, value := range fields {
+		m[key] = value
+	}
+
+	raw, err = bson.Marshal(m)
+	if err != nil {
+		return updated, err
+	}
+
+	err = bson.Unmarshal(raw, &updated)
+	return updated, err
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TextSearch asks a store for the records whose text fields match Text, as
+// mongo's $text reads it: words match any of them, "quoted phrases" must all
+// appear and -words must not. Filters narrow the records searched.
+type TextSearch struct {
+	Text    string
+	Filters []Filter
+	Limit   int
+}
+
+// SearchHit is a record matching a text search, a higher Score is a better match.
+type SearchHit[T any] struct {
+	Item  T
+	Score float64
+}
+
+// The text fields each collection is searched on and their weights, the mongo
+// text indexes are built from them and the memory stores score with them.
+var (
+	CustomerSearchFields    = map[string]int32{"name": 10, "company": 5, "email": 5, "phone": 2}
+	TicketSearchFields      = map[string]int32{"description": 1}
+	CommentSearchFields     = map[string]int32{"body": 1}
+	InteractionSearchFields = map[string]int32{"title": 5, "description": 1}
+)
+
+func textIndex(name string, fields map[string]int32) mongo.IndexModel {
+	keys, weights := bson.D{}, bson.D{}
+	for _, field := range sortedKeys(fields) {
+		keys = append(keys, bson.E{Key: field, Value: "text"})
+		weights = append(weights, bson.E{Key: field, Value: fields[field]})
+	}
+	return mongo.IndexModel{Keys: keys, Options: options.Index().SetName(name).SetWeights(weights)}
+}
+
+func sortedKeys(fields map[string]int32) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// the score is read into a field no model has
+const searchScoreField = "search_score"
+
+// findSearch runs a text search against a mongo collection, best match first.
+func findSearch[T any](ctx context.Context, collection *mongo.Collection, search TextSearch) ([]SearchHit[T], error) {
+	hits := []SearchHit[T]{}
+
+	filter := ListQuery{Filters: search.Filters}.mongoFilter()
+	filter["$text"] = bson.M{"$search": search.Text}
+
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{searchScoreField: score}).
+		SetSort(bson.D{{Key: searchScoreField, Value: score}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(search.Limit))
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return hits, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var item T
+		if err := cursor.Decode(&item); err != nil {
+			return hits, err
+		}
+
+		value, _ := fieldValue(cursor.Current, searchScoreField).(float64)
+		hits = append(hits, SearchHit[T]{Item: item, Score: value})
+	}
+	return hits, cursor.Err()
+}
+
+// search scores the in-memory documents much like a mongo text index with the
+// weights given: words are lower cased, stop words dropped and simple English
+// suffixes removed, and each field adds its weight times the share of its
+// words that matched. The scores are not mongo's, the order mostly is.
+func (m *memoryCollection[T]) search(search TextSearch, fields map[string]int32) ([]SearchHit[T], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	text := parseSearchText(search.Text)
+
+	hits := []SearchHit[T]{}
+	for _, doc := range m.docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		if !matchFilters(raw, search.Filters) {
+			continue
+		}
+
+		if score := text.score(raw, fields); score > 0 {
+			hits = append(hits, SearchHit[T]{Item: doc, Score: score})
+		}
+	}
+
+	slices.SortStableFunc(hits, func(a, b SearchHit[T]) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+
+	if len(hits) > search.Limit {
+		hits = hits[:search.Limit]
+	}
+	return hits, nil
+}
+
+type searchText struct {
+	terms    []string
+	phrases  []string
+	excluded []string
+}
+
+// parseSearchText splits a $text search string into its words, "phrases" and -words.
+func parseSearchText(text string) searchText {
+	var parsed searchText
+
+	for i, part := range strings.Split(text, `"`) {
+		// the odd parts are inside quotes
+		if i%2 == 1 {
+			if phrase := strings.ToLower(strings.TrimSpace(part)); phrase != "" {
+				parsed.phrases = append(parsed.phrases, phrase)
+				parsed.terms = append(parsed.terms, searchWords(phrase)...)
+			}
+			continue
+		}
+
+		for _, word := range strings.Fields(part) {
+			if excluded, ok := strings.CutPrefix(word, "-"); ok {
+				parsed.excluded = append(parsed.excluded, searchWords(excluded)...)
+				continue
+			}
+			parsed.terms = append(parsed.terms, searchWords(word)...)
+		}
+	}
+	return parsed
+}
+
+func (t searchText) score(raw bson.Raw, fields map[string]int32) float64 {
+	score := 0.0
+	phrases := map[string]bool{}
+
+	for field, weight := range fields {
+		value, _ := fieldValue(raw, field).(string)
+		if value == "" {
+			continue
+		}
+
+		lower := strings.ToLower(value)
+		for _, phrase := range t.phrases {
+			if strings.Contains(lower, phrase) {
+				phrases[phrase] = true
+			}
+		}
+
+		words := searchWords(value)
+		matched := 0
+		for _, word := range words {
+			if slices.Contains(t.excluded, word) {
+				return 0
+			}
+			if slices.Contains(t.terms, word) {
+				matched++
+			}
+		}
+
+		if matched > 0 {
+			score += float64(weight) * (0.5 + 0.5*float64(matched)/float64(len(words)))
+		}
+	}
+
+	if len(phrases) < len(t.phrases) {
+		return 0
+	}
+	return score
+}
+
+// searchWords splits text into lower cased, stemmed words without stop words.
+func searchWords(text string) []string {
+	words := []string{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if searchStopWords[word] {
+			continue
+		}
+		words = append(words, stemWord(word))
+	}
+	return words
+}
+
+// stemWord strips the common English endings so "invoices" finds "invoice".
+func stemWord(word string) string {
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if stem, ok := strings.CutSuffix(word, suffix); ok && len(stem) >= 3 {
+			return strings.TrimSuffix(stem, "e")
+		}
+	}
+	return strings.TrimSuffix(word, "e")
+}
+
+var searchStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"by": true, "for": true, "from": true, "has": true, "he": true, "in": true, "is": true,
+	"it": true, "its": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"to": true, "was": true, "were": true, "who": true, "will": true, "with": true,
+}
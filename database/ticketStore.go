@@ -0,0 +1,371 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type TicketStore interface {
+	Create(ctx context.Context, ticket *models.Ticket) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (models.Ticket, error)
+	List(ctx context.Context, query ListQuery) (Page[models.Ticket], error)
+	Search(ctx context.Context, search TextSearch) ([]SearchHit[models.Ticket], error)
+	// CountActiveByAssignee counts the assignee's tickets that are still open or in progress.
+	CountActiveByAssignee(ctx context.Context, assigneeID primitive.ObjectID) (int64, error)
+	// ListResolvedBefore returns tickets still resolved whose resolved_at is older than before.
+	ListResolvedBefore(ctx context.Context, before time.Time) ([]models.Ticket, error)
+	Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error
+	// Transition moves the ticket from change.From to change.To, sets fields and
+	// appends change to its history in one update, it returns ErrNotFound when
+	// the ticket is no longer in change.From, e.g. because a concurrent update
+	// moved it first.
+	Transition(ctx context.Context, id primitive.ObjectID, change models.TicketStatusChange, resolvedAt, closedAt *time.Time, fields bson.M) error
+	// ListSlaOverdue returns tickets whose first response or resolution is past
+	// due at now and not yet flagged as breached.
+	ListSlaOverdue(ctx context.Context, now time.Time) ([]models.Ticket, error)
+	// MarkFirstResponse stamps first_responded_at once, it returns ErrNotFound
+	// when the ticket was already responded to.
+	MarkFirstResponse(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	FlagSlaBreach(ctx context.Context, id primitive.ObjectID, breach string) error
+	// Claim assigns an unassigned ticket, it returns ErrNotFound when the ticket
+	// does not exist or somebody already owns it.
+	Claim(ctx context.Context, id, assigneeID primitive.ObjectID, assignedAt time.Time) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// MoveOpenTickets links the tickets about one interaction that are not
+	// closed to another, for when a series continues as a new interaction.
+	MoveOpenTickets(ctx context.Context, from, to primitive.ObjectID) error
+	// SetCustomerAccount copies the customer's account onto their tickets, nil
+	// unlinks them, ClearAccount unlinks every ticket of a deleted account.
+	SetCustomerAccount(ctx context.Context, customerID primitive.ObjectID, accountID *primitive.ObjectID) error
+	ClearAccount(ctx context.Context, accountID primitive.ObjectID) error
+}
+
+type mongoTicketStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoTicketStore) Create(ctx context.Context, ticket *models.Ticket) error {
+	_, err := s.collection.InsertOne(ctx, ticket)
+	return err
+}
+
+func (s *mongoTicketStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Ticket, error) {
+	var ticket models.Ticket
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&ticket)
+	return ticket, mongoError(err)
+}
+
+func (s *mongoTicketStore) List(ctx context.Context, query ListQuery) (Page[models.Ticket], error) {
+	return findPage[models.Ticket](ctx, s.collection, query)
+}
+
+func (s *mongoTicketStore) Search(ctx context.Context, search TextSearch) ([]SearchHit[models.Ticket], error) {
+	return findSearch[models.Ticket](ctx, s.collection, search)
+}
+
+func (s *mongoTicketStore) CountActiveByAssignee(ctx context.Context, assigneeID primitive.ObjectID) (int64, error) {
+	filter := bson.M{
+		"assignee_id": assigneeID,
+		"status":      bson.M{"$in": bson.A{models.TICKET_OPEN, models.TICKETIN_PROGRESS}},
+	}
+	return s.collection.CountDocuments(ctx, filter)
+}
+
+func (s *mongoTicketStore) ListResolvedBefore(ctx context.Context, before time.Time) ([]models.Ticket, error) {
+	return s.find(ctx, bson.M{"status": models.TICKET_RESOLVED, "resolved_at": bson.M{"$lt": before}})
+}
+
+func (s *mongoTicketStore) ListSlaOverdue(ctx context.Context, now time.Time) ([]models.Ticket, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{
+			"first_response_due": bson.M{"$lt": now},
+			"first_responded_at": bson.M{"$exists": false},
+			"sla_breaches":       bson.M{"$ne": models.SLA_FIRST_RESPONSE},
+		},
+		bson.M{
+			"resolution_due": bson.M{"$lt": now},
+			"status":         bson.M{"$in": bson.A{models.TICKET_OPEN, models.TICKETIN_PROGRESS}},
+			"sla_breaches":   bson.M{"$ne": models.SLA_RESOLUTION},
+		},
+	}}
+	return s.find(ctx, filter)
+}
+
+func (s *mongoTicketStore) find(ctx context.Context, filter bson.M) ([]models.Ticket, error) {
+	tickets := []models.Ticket{}
+
+	cursor, err := s.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &tickets)
+	return tickets, err
+}
+
+func (s *mongoTicketStore) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoTicketStore) Transition(ctx context.Context, id primitive.ObjectID, change models.TicketStatusChange, resolvedAt, closedAt *time.Time, fields bson.M) error {
+	set := bson.M{}
+	for field, value := range fields {
+		set[field] = value
+	}
+	set["status"] = change.To
+	set["updated_at"] = change.ChangedAt
+	unset := bson.M{}
+
+	for field, value := range map[string]*time.Time{"resolved_at": resolvedAt, "closed_at": closedAt} {
+		if value != nil {
+			set[field] = value
+		} else {
+			unset[field] = ""
+		}
+	}
+
+	update := bson.M{"$set": set, "$push": bson.M{"status_history": change}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": id, "status": change.From}, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoTicketStore) MarkFirstResponse(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	filter := bson.M{"_id": id, "first_responded_at": bson.M{"$exists": false}}
+
+	result, err := s.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"first_responded_at": at}})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoTicketStore) FlagSlaBreach(ctx context.Context, id primitive.ObjectID, breach string) error {
+	update := bson.M{
+		"$set":      bson.M{"sla_breached": true},
+		"$addToSet": bson.M{"sla_breaches": breach},
+	}
+
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoTicketStore) Claim(ctx context.Context, id, assigneeID primitive.ObjectID, assignedAt time.Time) error {
+	filter := bson.M{"_id": id, "assignee_id": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"assignee_id": assigneeID, "assigned_at": assignedAt, "updated_at": assignedAt}}
+
+	result, err := s.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoTicketStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoTicketStore) MoveOpenTickets(ctx context.Context, from, to primitive.ObjectID) error {
+	filter := bson.M{"interaction_id": from, "status": bson.M{"$ne": models.TICKET_CLOSED}}
+	_, err := s.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"interaction_id": to}})
+	return err
+}
+
+func (s *mongoTicketStore) SetCustomerAccount(ctx context.Context, customerID primitive.ObjectID, accountID *primitive.ObjectID) error {
+	update := bson.M{"$unset": bson.M{"account_id": ""}}
+	if accountID != nil {
+		update = bson.M{"$set": bson.M{"account_id": *accountID}}
+	}
+
+	_, err := s.collection.UpdateMany(ctx, bson.M{"customer_id": customerID, "account_id": bson.M{"$ne": accountID}}, update)
+	return err
+}
+
+func (s *mongoTicketStore) ClearAccount(ctx context.Context, accountID primitive.ObjectID) error {
+	_, err := s.collection.UpdateMany(ctx, bson.M{"account_id": accountID}, bson.M{"$unset": bson.M{"account_id": ""}})
+	return err
+}
+
+type memoryTicketStore struct {
+	tickets memoryCollection[models.Ticket]
+}
+
+func (s *memoryTicketStore) Create(ctx context.Context, ticket *models.Ticket) error {
+	s.tickets.insert(*ticket)
+	return nil
+}
+
+func (s *memoryTicketStore) FindByID(ctx context.Context, id primitive.ObjectID) (models.Ticket, error) {
+	return s.tickets.find(func(t models.Ticket) bool { return t.ID == id })
+}
+
+func (s *memoryTicketStore) List(ctx context.Context, query ListQuery) (Page[models.Ticket], error) {
+	return s.tickets.page(query)
+}
+
+func (s *memoryTicketStore) Search(ctx context.Context, search TextSearch) ([]SearchHit[models.Ticket], error) {
+	return s.tickets.search(search, TicketSearchFields)
+}
+
+func (s *memoryTicketStore) CountActiveByAssignee(ctx context.Context, assigneeID primitive.ObjectID) (int64, error) {
+	return s.tickets.count(func(t models.Ticket) bool {
+		return t.AssigneeID != nil && *t.AssigneeID == assigneeID && t.Status != nil &&
+			(*t.Status == models.TICKET_OPEN || *t.Status == models.TICKETIN_PROGRESS)
+	}), nil
+}
+
+func (s *memoryTicketStore) ListResolvedBefore(ctx context.Context, before time.Time) ([]models.Ticket, error) {
+	return s.tickets.filter(func(t models.Ticket) bool {
+		return t.Status != nil && *t.Status == models.TICKET_RESOLVED && t.ResolvedAt != nil && t.ResolvedAt.Before(before)
+	}), nil
+}
+
+func (s *memoryTicketStore) ListSlaOverdue(ctx context.Context, now time.Time) ([]models.Ticket, error) {
+	return s.tickets.filter(func(t models.Ticket) bool {
+		firstResponse := t.FirstResponseDue != nil && t.FirstResponseDue.Before(now) && t.FirstRespondedAt == nil &&
+			!slices.Contains(t.SlaBreaches, models.SLA_FIRST_RESPONSE)
+		resolution := t.ResolutionDue != nil && t.ResolutionDue.Before(now) && t.Status != nil &&
+			(*t.Status == models.TICKET_OPEN || *t.Status == models.TICKETIN_PROGRESS) &&
+			!slices.Contains(t.SlaBreaches, models.SLA_RESOLUTION)
+		return firstResponse || resolution
+	}), nil
+}
+
+func (s *memoryTicketStore) MarkFirstResponse(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	match := func(t models.Ticket) bool { return t.ID == id && t.FirstRespondedAt == nil }
+
+	return s.tickets.modify(match, func(t *models.Ticket) {
+		t.FirstRespondedAt = &at
+	})
+}
+
+func (s *memoryTicketStore) FlagSlaBreach(ctx context.Context, id primitive.ObjectID, breach string) error {
+	match := func(t models.Ticket) bool { return t.ID == id }
+
+	return s.tickets.modify(match, func(t *models.Ticket) {
+		t.SlaBreached = true
+		if !slices.Contains(t.SlaBreaches, breach) {
+			t.SlaBreaches = append(slices.Clone(t.SlaBreaches), breach)
+		}
+	})
+}
+
+func (s *memoryTicketStore) Update(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	return s.tickets.update(func(t models.Ticket) bool { return t.ID == id }, fields)
+}
+
+func (s *memoryTicketStore) Transition(ctx context.Context, id primitive.ObjectID, change models.TicketStatusChange, resolvedAt, closedAt *time.Time, fields bson.M) error {
+	match := func(t models.Ticket) bool {
+		return t.ID == id && t.Status != nil && *t.Status == change.From
+	}
+
+	var setErr error
+	err := s.tickets.modify(match, func(t *models.Ticket) {
+		if len(fields) > 0 {
+			updated, err := setFields(*t, fields)
+			if err != nil {
+				setErr = err
+				return
+			}
+			*t = updated
+		}
+
+		status := change.To
+		t.Status = &status
+		t.ResolvedAt = resolvedAt
+		t.ClosedAt = closedAt
+		t.StatusHistory = append(slices.Clone(t.StatusHistory), change)
+		t.UpdatedAt = change.ChangedAt
+	})
+	if err != nil {
+		return err
+	}
+	return setErr
+}
+
+func (s *memoryTicketStore) Claim(ctx context.Context, id, assigneeID primitive.ObjectID, assignedAt time.Time) error {
+	match := func(t models.Ticket) bool { return t.ID == id && t.AssigneeID == nil }
+
+	return s.tickets.modify(match, func(t *models.Ticket) {
+		t.AssigneeID = &assigneeID
+		t.AssignedAt = &assignedAt
+		t.UpdatedAt = assignedAt
+	})
+}
+
+func (s *memoryTicketStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if s.tickets.remove(func(t models.Ticket) bool { return t.ID == id }) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *memoryTicketStore) MoveOpenTickets(ctx context.Context, from, to primitive.ObjectID) error {
+	s.tickets.modifyAll(func(t models.Ticket) bool {
+		return t.InteractionID == from && (t.Status == nil || *t.Status != models.TICKET_CLOSED)
+	}, func(t *models.Ticket) {
+		t.InteractionID = to
+	})
+	return nil
+}
+
+func (s *memoryTicketStore) SetCustomerAccount(ctx context.Context, customerID primitive.ObjectID, accountID *primitive.ObjectID) error {
+	s.tickets.modifyAll(func(t models.Ticket) bool { return t.CustomerID == customerID }, func(t *models.Ticket) {
+		t.AccountID = accountID
+	})
+	return nil
+}
+
+func (s *memoryTicketStore) ClearAccount(ctx context.Context, accountID primitive.ObjectID) error {
+	s.tickets.modifyAll(func(t models.Ticket) bool { return t.AccountID != nil && *t.AccountID == accountID }, func(t *models.Ticket) {
+		t.AccountID = nil
+	})
+	return nil
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transactor saves the writes of several stores together or not at all.
+type Transactor interface {
+	// RunInTransaction calls fn with the context the stores must be used with
+	// inside it, fn may run more than once when the database asks for a retry.
+	RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// mongoTransactor uses multi-document transactions where the deployment has
+// them, a standalone server runs the writes one after the other. Main refuses
+// a standalone server unless MONGO_ALLOW_STANDALONE is set, see CheckTransactions.
+type mongoTransactor struct {
+	client *mongo.Client
+
+	mu        sync.Mutex
+	probed    bool
+	supported bool
+}
+
+func (t *mongoTransactor) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	supported, err := t.supportsTransactions(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !supported {
+		return fn(ctx)
+	}
+
+	session, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	return err
+}
+
+// supportsTransactions asks the server once, a failed probe is tried again
+// on the next write rather than taken as an answer.
+func (t *mongoTransactor) supportsTransactions(ctx context.Context) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.probed {
+		supported, err := supportsTransactions(ctx, t.client)
+		if err != nil {
+			return false, fmt.Errorf("error checking MongoDB for transactions: %w", err)
+		}
+		t.probed, t.supported = true, supported
+	}
+	return t.supported, nil
+}
+
+// CheckTransactions fails when the server cannot run transactions, unless
+// allowStandalone accepts that writes meant to be atomic are made one by one.
+func CheckTransactions(ctx context.Context, client *mongo.Client, allowStandalone bool) error {
+	supported, err := supportsTransactions(ctx, client)
+	if err != nil {
+		return fmt.Errorf("error checking MongoDB for transactions: %w", err)
+	}
+
+	if supported {
+		return nil
+	}
+
+	if !allowStandalone {
+		return errors.New("MongoDB is not a replica set, so writes to several collections cannot be atomic; run a replica set or set MONGO_ALLOW_STANDALONE=true")
+	}
+	log.Printf("WARNING: MongoDB is not a replica set, writes meant to be atomic are made one by one and a crash can leave them half done")
+	return nil
+}
+
+// supportsTransactions asks the server whether it is a replica set member or a mongos.
+func supportsTransactions(ctx context.Context, client *mongo.Client) (bool, error) {
+	var hello bson.M
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+
+	_, replicaSet := hello["setName"]
+	return replicaSet || hello["msg"] == "isdbgrid", nil
+}
+
+// memoryTransactor runs one fn at a time, the memory stores cannot roll back.
+type memoryTransactor struct {
+	mu sync.Mutex
+}
+
+func (t *memoryTransactor) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return fn(ctx)
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type UserStore interface {
+	Create(ctx context.Context, user *models.User) error
+	CountByEmail(ctx context.Context, email string) (int64, error)
+	FindByEmail(ctx context.Context, email string) (models.User, error)
+	FindByID(ctx context.Context, userId string) (models.User, error)
+	FindByCalendarToken(ctx context.Context, tokenHash string) (models.User, error)
+	List(ctx context.Context, query ListQuery) (Page[models.User], error)
+	Update(ctx context.Context, userId string, fields bson.M) error
+	Delete(ctx context.Context, userId string) error
+}
+
+type mongoUserStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoUserStore) Create(ctx context.Context, user *models.User) error {
+	_, err := s.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (s *mongoUserStore) CountByEmail(ctx context.Context, email string) (int64, error) {
+	return s.collection.CountDocuments(ctx, bson.M{"email": email})
+}
+
+func (s *mongoUserStore) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	var user models.User
+	err := s.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	return user, mongoError(err)
+}
+
+func (s *mongoUserStore) FindByID(ctx context.Context, userId string) (models.User, error) {
+	var user models.User
+	err := s.collection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user)
+	return user, mongoError(err)
+}
+
+func (s *mongoUserStore) FindByCalendarToken(ctx context.Context, tokenHash string) (models.User, error) {
+	var user models.User
+	err := s.collection.FindOne(ctx, bson.M{"calendar_token_hash": tokenHash}).Decode(&user)
+	return user, mongoError(err)
+}
+
+func (s *mongoUserStore) List(ctx context.Context, query ListQuery) (Page[models.User], error) {
+	return findPage[models.User](ctx, s.collection, query)
+}
+
+func (s *mongoUserStore) Update(ctx context.Context, userId string, fields bson.M) error {
+	result, err := s.collection.UpdateOne(ctx, bson.M{"user_id": userId}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoUserStore) Delete(ctx context.Context, userId string) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memoryUserStore struct {
+	users memoryCollection[models.User]
+}
+
+func (s *memoryUserStore) Create(ctx context.Context, user *models.User) error {
+	inserted := s.users.insertUnique(*user, func(u models.User) bool {
+		return u.Email != nil && user.Email != nil && *u.Email == *user.Email
+	})
+	if !inserted {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+func (s *memoryUserStore) CountByEmail(ctx context.Context, email string) (int64, error) {
+	return s.users.count(func(u models.User) bool {
+		return u.Email != nil && *u.Email == email
+	}), nil
+}
+
+func (s *memoryUserStore) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	return s.users.find(func(u models.User) bool {
+		return u.Email != nil && *u.Email == email
+	})
+}
+
+func (s *memoryUserStore) FindByID(ctx context.Context, userId string) (models.User, error) {
+	return s.users.find(func(u models.User) bool { return u.UserId == userId })
+}
+
+func (s *memoryUserStore) FindByCalendarToken(ctx context.Context, tokenHash string) (models.User, error) {
+	return s.users.find(func(u models.User) bool { return tokenHash != "" && u.CalendarTokenHash == tokenHash })
+}
+
+func (s *memoryUserStore) List(ctx context.Context, query ListQuery) (Page[models.User], error) {
+	return s.users.page(query)
+}
+
+func (s *memoryUserStore) Update(ctx context.Context, userId string, fields bson.M) error {
+	return s.users.update(func(u models.User) bool { return u.UserId == userId }, fields)
+}
+
+func (s *memoryUserStore) Delete(ctx context.Context, userId string) error {
+	if s.users.remove(func(u models.User) bool { return u.UserId == userId }) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
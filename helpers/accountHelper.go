@@ -0,0 +1,166 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// how much of an account GET /staff/accounts/:account_id shows at once, the
+// counts cover everything
+const (
+	ACCOUNT_CONTACTS_LIMIT            = 100
+	ACCOUNT_OPEN_TICKETS_LIMIT        = 50
+	ACCOUNT_RECENT_INTERACTIONS_LIMIT = 20
+)
+
+var (
+	ErrAccountDomainTaken = errors.New("another account already has this domain")
+	ErrAccountHasDeals    = errors.New("account still has deals, delete them or unlink their customers first")
+	ErrPublicEmailDomain  = errors.New("domain is a public email provider, customers there are not one company")
+)
+
+// publicEmailDomains are shared by unrelated people, no account may claim them
+var publicEmailDomains = map[string]bool{
+	"gmail.com": true, "googlemail.com": true, "yahoo.com": true, "outlook.com": true,
+	"hotmail.com": true, "live.com": true, "msn.com": true, "icloud.com": true,
+	"me.com": true, "aol.com": true, "proton.me": true, "protonmail.com": true,
+	"gmx.com": true, "gmx.de": true, "web.de": true, "yandex.com": true,
+	"mail.com": true, "zoho.com": true,
+}
+
+// NormalizeDomain lower cases an account domain and refuses public email providers.
+func NormalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if publicEmailDomains[domain] {
+		return "", ErrPublicEmailDomain
+	}
+	return domain, nil
+}
+
+// MatchAccount finds the account for an email address by its domain, for
+// "jo@eu.acme.com" trying eu.acme.com and then acme.com. It returns nil when
+// no account has the domain.
+func MatchAccount(ctx context.Context, accounts database.AccountStore, email string) (*primitive.ObjectID, error) {
+	_, domain, ok := strings.Cut(strings.ToLower(email), "@")
+	if !ok || publicEmailDomains[domain] {
+		return nil, nil
+	}
+
+	for strings.Contains(domain, ".") {
+		account, err := accounts.FindByDomain(ctx, domain)
+		if err == nil {
+			return &account.ID, nil
+		}
+		if !errors.Is(err, database.ErrNotFound) {
+			return nil, err
+		}
+
+		_, domain, _ = strings.Cut(domain, ".")
+	}
+	return nil, nil
+}
+
+// AccountSummary is an account with its contacts, the signups waiting to join
+// it, the tickets its contacts have open and the interactions with them that
+// changed last. RecentInteractions is nil for callers who may not read them.
+type AccountSummary struct {
+	Account             models.Account       `json:"account"`
+	Contacts            []models.Customer    `json:"contacts"`
+	ContactCount        int64                `json:"contact_count"`
+	PendingContactCount int64                `json:"pending_contact_count"`
+	OpenTickets         []models.Ticket      `json:"open_tickets"`
+	OpenTicketCount     int64                `json:"open_ticket_count"`
+	RecentInteractions  []models.Interaction `json:"recent_interactions"`
+}
+
+// SummarizeAccount reads the account's contacts and open tickets, tickets
+// carry the account of their customer so none of it lists every contact.
+func SummarizeAccount(ctx context.Context, customers database.CustomerStore, tickets database.TicketStore, account models.Account) (AccountSummary, error) {
+	summary := AccountSummary{Account: account, Contacts: []models.Customer{}, OpenTickets: []models.Ticket{}}
+
+	contacts, err := customers.List(ctx, database.ListQuery{
+		Filters: []database.Filter{database.Eq("account_id", account.ID)},
+		Sort:    []database.SortField{{Field: "name"}},
+		Limit:   ACCOUNT_CONTACTS_LIMIT,
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	for _, contact := range contacts.Items {
+		contact.Password = nil
+		summary.Contacts = append(summary.Contacts, contact)
+	}
+	summary.ContactCount = contacts.Total
+
+	pending, err := customers.List(ctx, database.ListQuery{
+		Filters: []database.Filter{database.Eq("pending_account_id", account.ID)},
+		Limit:   1,
+	})
+	if err != nil {
+		return summary, err
+	}
+	summary.PendingContactCount = pending.Total
+
+	openTickets, err := tickets.List(ctx, database.ListQuery{
+		Filters: []database.Filter{
+			database.Eq("account_id", account.ID),
+			{Field: "status", Op: database.FILTER_IN, Value: bson.A{models.TICKET_OPEN, models.TICKETIN_PROGRESS}},
+		},
+		Sort:  []database.SortField{{Field: "created_at", Desc: true}},
+		Limit: ACCOUNT_OPEN_TICKETS_LIMIT,
+	})
+	if err != nil {
+		return summary, err
+	}
+	summary.OpenTickets, summary.OpenTicketCount = openTickets.Items, openTickets.Total
+	return summary, nil
+}
+
+// RecentAccountInteractions lists the interactions with the account's contacts
+// that changed last, scope keeps "own" callers to theirs.
+func RecentAccountInteractions(ctx context.Context, interactions database.InteractionStore, accountID primitive.ObjectID, scope ...database.Filter) ([]models.Interaction, error) {
+	recent, err := interactions.List(ctx, database.ListQuery{
+		Filters: append([]database.Filter{database.Eq("account_id", accountID)}, scope...),
+		Sort:    []database.SortField{{Field: "updated_at", Desc: true}},
+		Limit:   ACCOUNT_RECENT_INTERACTIONS_LIMIT,
+	})
+	return recent.Items, err
+}
+
+// BackfillAccountRecords copies the account of every linked customer onto
+// their tickets and interactions, for those stored before they carried it.
+// Records that already have it are not written again.
+func BackfillAccountRecords(ctx context.Context, customers database.CustomerStore, tickets database.TicketStore, interactions database.InteractionStore) error {
+	query := database.ListQuery{
+		Filters: []database.Filter{{Field: "account_id", Op: database.FILTER_EXISTS, Value: true}},
+		Limit:   LIST_MAX_LIMIT,
+	}
+
+	for {
+		page, err := customers.List(ctx, query)
+		if err != nil {
+			return err
+		}
+
+		for _, customer := range page.Items {
+			if err := tickets.SetCustomerAccount(ctx, customer.ID, customer.AccountID); err != nil {
+				return err
+			}
+			if err := interactions.SetCustomerAccount(ctx, customer.ID, customer.AccountID); err != nil {
+				return err
+			}
+		}
+
+		if page.Next == nil {
+			return nil
+		}
+		query.After = page.Next
+	}
+}
@@ -0,0 +1,336 @@
+package helpers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ATTACHMENT_MAX_SIZE is the largest upload accepted, in bytes.
+var ATTACHMENT_MAX_SIZE = int64FromEnv("ATTACHMENT_MAX_SIZE", 10<<20)
+
+// ATTACHMENT_ALLOWED_TYPES lists the content types that may be uploaded, a
+// "type/*" entry allows every subtype.
+var ATTACHMENT_ALLOWED_TYPES = strings.Split(stringFromEnv("ATTACHMENT_ALLOWED_TYPES",
+	"image/*,application/pdf,text/plain,text/csv,application/zip,"+
+		"application/vnd.ms-powerpoint,application/vnd.openxmlformats-officedocument.presentationml.presentation"), ",")
+
+// ATTACHMENT_URL_TTL is how long a signed download link stays valid.
+var ATTACHMENT_URL_TTL = durationFromEnv("ATTACHMENT_URL_TTL", 5*time.Minute)
+
+// ATTACHMENT_SIGNING_KEY signs download links, it must be set and differ from
+// the token secrets so a leaked link key cannot mint tokens or the other way round.
+var ATTACHMENT_SIGNING_KEY = os.Getenv("ATTACHMENT_SIGNING_KEY")
+
+// activeContentTypes are the types a browser would run script in, they are
+// never trusted from the client and always served as a plain download.
+var activeContentTypes = []string{
+	"text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml",
+	"text/javascript", "application/javascript", "application/x-javascript",
+}
+
+var (
+	ErrAttachmentTooLarge       = fmt.Errorf("file is larger than %d bytes", ATTACHMENT_MAX_SIZE)
+	ErrAttachmentTypeNotAllowed = errors.New("file type is not allowed")
+	ErrAttachmentRejected       = errors.New("file was rejected by the virus scanner")
+	ErrInvalidDownloadLink      = errors.New("download link is invalid or has expired")
+)
+
+// CheckAttachmentSigningKey refuses to run with a missing download link key or
+// one shared with the token secrets.
+func CheckAttachmentSigningKey() error {
+	if ATTACHMENT_SIGNING_KEY == "" {
+		return errors.New("ATTACHMENT_SIGNING_KEY must be set")
+	}
+
+	if ATTACHMENT_SIGNING_KEY == USER_SECRET_KEY || ATTACHMENT_SIGNING_KEY == CUSTOMER_SECRET_KEY {
+		return errors.New("ATTACHMENT_SIGNING_KEY must differ from the token secrets")
+	}
+	return nil
+}
+
+func int64FromEnv(key string, fallback int64) int64 {
+	n, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return n
+}
+
+// AttachmentScanner is the virus scan hook, every upload passes through it
+// before it reaches the blob store and a non-nil error rejects the file.
+type AttachmentScanner interface {
+	Scan(ctx context.Context, fileName string, content io.Reader) error
+}
+
+// Scanner runs ATTACHMENT_SCAN_COMMAND when it is set, replace it to plug in
+// another scanner.
+var Scanner AttachmentScanner = commandScanner{command: os.Getenv("ATTACHMENT_SCAN_COMMAND")}
+
+// commandScanner pipes the file to command on stdin, e.g. "clamdscan --no-summary -",
+// a non-zero exit status means the file is infected.
+type commandScanner struct {
+	command string
+}
+
+func (s commandScanner) Scan(ctx context.Context, fileName string, content io.Reader) error {
+	fields := strings.Fields(s.command)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, fields[0], fields[1:]...)
+	cmd.Stdin = content
+
+	output, err := cmd.CombinedOutput()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("%w: %s", ErrAttachmentRejected, strings.TrimSpace(string(output)))
+	}
+
+	if err != nil {
+		return fmt.Errorf("error running virus scanner: %v", err)
+	}
+	return nil
+}
+
+// attachmentContentType sniffs the type from the first 512 bytes of content.
+// The declared Content-Type, or failing that the file extension, only narrows
+// a generic sniff such as text/plain for a CSV or application/zip for a .pptx,
+// and never to an active type.
+func attachmentContentType(content io.ReadSeeker, declared, fileName string) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	switch sniffed {
+	case "application/octet-stream", "text/plain", "application/zip":
+	default:
+		return sniffed, nil
+	}
+
+	claimed, _, err := mime.ParseMediaType(declared)
+	if err != nil || claimed == "application/octet-stream" {
+		claimed, _, err = mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(fileName)))
+	}
+
+	if err != nil || claimed == "" || IsActiveContentType(claimed) {
+		return sniffed, nil
+	}
+	return claimed, nil
+}
+
+// IsActiveContentType reports whether a browser would run script in contentType.
+func IsActiveContentType(contentType string) bool {
+	return slices.Contains(activeContentTypes, contentType)
+}
+
+func IsAllowedAttachmentType(contentType string) bool {
+	for _, allowed := range ATTACHMENT_ALLOWED_TYPES {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == contentType {
+			return true
+		}
+
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(contentType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveAttachment checks an uploaded file against the size and type limits,
+// scans it, stores its bytes and records it against the owning ticket or interaction.
+func SaveAttachment(ctx context.Context, attachments database.AttachmentStore, blobs database.BlobStore, file *multipart.FileHeader, ownerType string, ownerID primitive.ObjectID, uploader Principal) (models.Attachment, error) {
+	if file.Size > ATTACHMENT_MAX_SIZE {
+		return models.Attachment{}, ErrAttachmentTooLarge
+	}
+
+	content, err := file.Open()
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	defer content.Close()
+
+	return StoreAttachment(ctx, attachments, blobs, file.Filename, file.Header.Get("Content-Type"), content, file.Size, ownerType, ownerID, uploader)
+}
+
+// StoreAttachment is SaveAttachment for a file that did not come from a form
+// upload, such as the attachment of an email.
+func StoreAttachment(ctx context.Context, attachments database.AttachmentStore, blobs database.BlobStore, fileName, declaredType string, content io.ReadSeeker, size int64, ownerType string, ownerID primitive.ObjectID, uploader Principal) (models.Attachment, error) {
+	var attachment models.Attachment
+
+	if size > ATTACHMENT_MAX_SIZE {
+		return attachment, ErrAttachmentTooLarge
+	}
+
+	contentType, err := attachmentContentType(content, declaredType, fileName)
+	if err != nil {
+		return attachment, err
+	}
+
+	if !IsAllowedAttachmentType(contentType) {
+		return attachment, fmt.Errorf("%w: %s", ErrAttachmentTypeNotAllowed, contentType)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return attachment, err
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return attachment, err
+	}
+
+	if err := Scanner.Scan(ctx, fileName, content); err != nil {
+		return attachment, err
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return attachment, err
+	}
+
+	attachment.ID = primitive.NewObjectID()
+	attachment.AttachmentId = attachment.ID.Hex()
+	attachment.OwnerType = ownerType
+	attachment.OwnerID = ownerID
+	attachment.FileName = filepath.Base(fileName)
+	attachment.ContentType = contentType
+	attachment.Size = size
+	attachment.Checksum = hex.EncodeToString(hash.Sum(nil))
+	attachment.StorageKey = fmt.Sprintf("%ss/%s/%s", ownerType, ownerID.Hex(), attachment.AttachmentId)
+	attachment.UploadedByType = uploader.Type
+	attachment.UploadedById = uploader.Id
+	attachment.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	if err := blobs.Put(ctx, attachment.StorageKey, content, size, contentType); err != nil {
+		return attachment, fmt.Errorf("error storing file: %v", err)
+	}
+
+	if err := attachments.Create(ctx, &attachment); err != nil {
+		blobs.Delete(ctx, attachment.StorageKey)
+		return attachment, err
+	}
+	return attachment, nil
+}
+
+// DeleteAttachments removes every file of a ticket or interaction that is being deleted.
+func DeleteAttachments(ctx context.Context, attachments database.AttachmentStore, blobs database.BlobStore, ownerType string, ownerID primitive.ObjectID) error {
+	owned, err := attachments.ListByOwner(ctx, ownerType, ownerID)
+	if err != nil {
+		return err
+	}
+
+	for _, attachment := range owned {
+		if err := blobs.Delete(ctx, attachment.StorageKey); err != nil {
+			return err
+		}
+
+		if err := attachments.Delete(ctx, attachment.ID); err != nil && !errors.Is(err, database.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyAttachments stores a copy of every file of one ticket or interaction for
+// another and returns the new records for the caller to create, in the same
+// transaction as the new owner. DeleteAttachmentBlobs undoes it.
+func CopyAttachments(ctx context.Context, attachments database.AttachmentStore, blobs database.BlobStore, ownerType string, from, to primitive.ObjectID) ([]models.Attachment, error) {
+	owned, err := attachments.ListByOwner(ctx, ownerType, from)
+	if err != nil {
+		return nil, err
+	}
+
+	copies := []models.Attachment{}
+	for _, attachment := range owned {
+		content, err := blobs.Get(ctx, attachment.StorageKey)
+		if err != nil {
+			DeleteAttachmentBlobs(ctx, blobs, copies)
+			return nil, err
+		}
+
+		copied := attachment
+		copied.ID = primitive.NewObjectID()
+		copied.AttachmentId = copied.ID.Hex()
+		copied.OwnerID = to
+		copied.StorageKey = fmt.Sprintf("%ss/%s/%s", ownerType, to.Hex(), copied.AttachmentId)
+
+		err = blobs.Put(ctx, copied.StorageKey, content, copied.Size, copied.ContentType)
+		content.Close()
+		if err != nil {
+			DeleteAttachmentBlobs(ctx, blobs, copies)
+			return nil, fmt.Errorf("error copying file: %v", err)
+		}
+		copies = append(copies, copied)
+	}
+	return copies, nil
+}
+
+// DeleteAttachmentBlobs removes the stored bytes of attachments whose records
+// are gone or were never created, it keeps going past a failed delete.
+func DeleteAttachmentBlobs(ctx context.Context, blobs database.BlobStore, owned []models.Attachment) error {
+	var errs []error
+	for _, attachment := range owned {
+		if err := blobs.Delete(ctx, attachment.StorageKey); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func attachmentSignature(attachmentId string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(ATTACHMENT_SIGNING_KEY))
+	fmt.Fprintf(mac, "%s:%d", attachmentId, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignAttachmentURL returns a download path for the attachment that works
+// without a token until ATTACHMENT_URL_TTL has passed.
+func SignAttachmentURL(attachmentId string, now time.Time) (string, time.Time) {
+	expiresAt := now.Add(ATTACHMENT_URL_TTL).Truncate(time.Second)
+	expires := expiresAt.Unix()
+
+	url := fmt.Sprintf("/api/v1/attachments/%s/download?expires=%d&signature=%s",
+		attachmentId, expires, attachmentSignature(attachmentId, expires))
+	return url, expiresAt
+}
+
+// VerifyAttachmentSignature checks the expires and signature query values of a download link.
+func VerifyAttachmentSignature(attachmentId, expires, signature string, now time.Time) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return ErrInvalidDownloadLink
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(attachmentSignature(attachmentId, expiresAt))) {
+		return ErrInvalidDownloadLink
+	}
+	return nil
+}
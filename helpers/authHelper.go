@@ -1,45 +1,62 @@
-package helpers
-
-import (
-	"fmt"
-
-	"github.com/gin-gonic/gin"
-)
-
-func CheckUserType(c *gin.Context, role string) (err error) {
-	userRole := c.GetString("role")
-
-	if userRole != role {
-		err = fmt.Errorf("UnAuthenticated to access this resource")
-		return err
-	}
-	return nil
-}
-
-// user can access this resource only via his token or he is an ADMIN...
-func MatchUserTypeToUid(c *gin.Context, userId string) (err error) {
-	uid := c.GetString("uid")
-	userType := c.GetString("role")
-
-	if err := CheckUserType(c, "ADMIN"); err == nil {
-		return nil
-	}
-
-	if uid == userId && userType == "USER" {
-		return nil
-	}
-
-	err = fmt.Errorf("UnAuthenticated to access this resource")
-	return err
-}
-
-func MatchCustomerTypeToCid(c *gin.Context, customerId string) (err error) {
-	cid := c.GetString("cid")
-
-	if cid == customerId {
-		return nil
-	}
-
-	err = fmt.Errorf("UnAuthenticated to access this resource")
-	return err
-}
+package helpers
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/matrice_ai/models"
+)
+
+// Principal is the authenticated caller, either a staff user or a customer,
+// set by the authentication middleware under the "principal" key.
+type Principal struct {
+	Type  string `json:"type"`
+	Id    string `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+}
+
+func (p Principal) IsUser() bool {
+	return p.Type == models.PRINCIPAL_USER
+}
+
+func (p Principal) IsCustomer() bool {
+	return p.Type == models.PRINCIPAL_CUSTOMER
+}
+
+func GetPrincipal(c *gin.Context) Principal {
+	principal, _ := c.Get("principal")
+	p, _ := principal.(Principal)
+	return p
+}
+
+// user can access this resource only via his token or his role grants it on any user...
+func MatchUserTypeToUid(c *gin.Context, userId string) (err error) {
+	if c.GetString("scope") == models.SCOPE_ANY {
+		return nil
+	}
+
+	principal := GetPrincipal(c)
+	if principal.IsUser() && principal.Id == userId {
+		return nil
+	}
+
+	err = fmt.Errorf("UnAuthenticated to access this resource")
+	return err
+}
+
+// customer can access this resource only via his token or the caller's role grants it on any customer...
+func MatchCustomerTypeToCid(c *gin.Context, customerId string) (err error) {
+	if c.GetString("scope") == models.SCOPE_ANY {
+		return nil
+	}
+
+	principal := GetPrincipal(c)
+	if principal.IsCustomer() && principal.Id == customerId {
+		return nil
+	}
+
+	err = fmt.Errorf("UnAuthenticated to access this resource")
+	return err
+}
@@ -0,0 +1,112 @@
+package helpers
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/utils"
+)
+
+// CALENDAR_DEFAULT_DURATION is the length of calendar events for interactions
+// that do not say how long they take.
+var CALENDAR_DEFAULT_DURATION = durationFromEnv("CALENDAR_DEFAULT_DURATION", 30*time.Minute)
+
+// CALENDAR_FEED_PAST keeps recently passed interactions in the calendar feed.
+var CALENDAR_FEED_PAST = durationFromEnv("CALENDAR_FEED_PAST", 24*time.Hour)
+
+// CALENDAR_FEED_AHEAD is how far ahead the calendar feed lists interactions.
+var CALENDAR_FEED_AHEAD = durationFromEnv("CALENDAR_FEED_AHEAD", 365*24*time.Hour)
+
+// CALENDAR_FEED_MAX caps the events of one feed, the earliest are kept.
+var CALENDAR_FEED_MAX = int(int64FromEnv("CALENDAR_FEED_MAX", 1000))
+
+// NewCalendarToken returns the secret of a calendar feed URL and the hash stored on the user.
+func NewCalendarToken() (token, hash string, err error) {
+	return newOpaqueToken("calendar token")
+}
+
+func HashCalendarToken(token string) string {
+	return hashOpaqueToken(token)
+}
+
+// InteractionEvent turns an interaction into a calendar event, it reports false
+// for interactions with no time to put in a calendar.
+func InteractionEvent(interaction models.Interaction, organizer string, attendees ...string) (utils.CalendarEvent, bool) {
+	start := InteractionTime(interaction)
+	if start.IsZero() {
+		return utils.CalendarEvent{}, false
+	}
+
+	location := ""
+	if interaction.Meeting != nil {
+		location = interaction.Meeting.Location
+		attendees = append(attendees, interaction.Meeting.Attendees...)
+	}
+
+	summary := ""
+	if interaction.Title != nil {
+		summary = *interaction.Title
+	}
+	if summary == "" {
+		summary = strings.ToUpper(InteractionType(interaction)[:1]) + InteractionType(interaction)[1:]
+	}
+
+	description := ""
+	if interaction.Description != nil {
+		description = *interaction.Description
+	}
+
+	event := utils.CalendarEvent{
+		UID:         interaction.InteractionId,
+		Sequence:    interaction.Revision,
+		Summary:     summary,
+		Description: description,
+		Location:    location,
+		Start:       start,
+		End:         start.Add(InteractionDuration(interaction)),
+		Organizer:   organizer,
+		TimeZone:    InteractionLocation(interaction),
+	}
+
+	// an occurrence edited on its own lives in its own record but belongs to the series' event
+	if interaction.SeriesId != "" {
+		event.UID = interaction.SeriesId
+	}
+
+	if interaction.RecurrenceId != nil {
+		event.RecurrenceID = *interaction.RecurrenceId
+	} else if IsRecurring(interaction) {
+		event.RRule = interaction.RRule
+		event.ExDates = interaction.ExDates
+	}
+
+	for _, attendee := range attendees {
+		if attendee != "" && attendee != organizer && !slices.Contains(event.Attendees, attendee) {
+			event.Attendees = append(event.Attendees, attendee)
+		}
+	}
+	return event, true
+}
+
+// CalendarFeed renders the user's interactions that have not long passed as a subscribable calendar.
+func CalendarFeed(interactions []models.Interaction, organizer string, now time.Time) []byte {
+	events := []utils.CalendarEvent{}
+
+	for _, interaction := range interactions {
+		event, ok := InteractionEvent(interaction, organizer)
+		if !ok {
+			continue
+		}
+
+		// a repeating interaction stays while it has occurrences left
+		if event.End.Before(now.Add(-CALENDAR_FEED_PAST)) && NextOccurrence(interaction, now.Add(-CALENDAR_FEED_PAST)).IsZero() {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	slices.SortFunc(events, func(a, b utils.CalendarEvent) int { return a.Start.Compare(b.Start) })
+	return utils.WriteCalendar(utils.ICS_PUBLISH, events, now)
+}
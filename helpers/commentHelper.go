@@ -0,0 +1,106 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/utils"
+)
+
+// COMMENT_EDIT_WINDOW is how long after posting an author may still edit a comment.
+var COMMENT_EDIT_WINDOW = durationFromEnv("COMMENT_EDIT_WINDOW", 15*time.Minute)
+
+var (
+	ErrCommentNotAuthor   = errors.New("only the author can edit this comment")
+	ErrCommentEditExpired = errors.New("comment can no longer be edited")
+)
+
+// CanEditComment checks that principal wrote the comment and is still inside the edit window.
+func CanEditComment(principal Principal, comment models.TicketComment, now time.Time) error {
+	if comment.AuthorType != principal.Type || comment.AuthorId != principal.Id {
+		return ErrCommentNotAuthor
+	}
+
+	if now.Sub(comment.CreatedAt) > COMMENT_EDIT_WINDOW {
+		return ErrCommentEditExpired
+	}
+	return nil
+}
+
+// BackfillCommentTickets copies the customer and assignee of their ticket
+// onto comments posted before comments carried them. Those of a deleted
+// ticket get a nil customer, no scoped search finds them.
+func BackfillCommentTickets(ctx context.Context, comments database.CommentStore, tickets database.TicketStore) error {
+	query := database.ListQuery{
+		Filters: []database.Filter{{Field: "customer_id", Op: database.FILTER_EXISTS, Value: false}},
+		Limit:   LIST_MAX_LIMIT,
+	}
+
+	for {
+		page, err := comments.List(ctx, query)
+		if err != nil {
+			return err
+		}
+
+		for _, comment := range page.Items {
+			ticket, err := tickets.FindByID(ctx, comment.TicketID)
+			if errors.Is(err, database.ErrNotFound) {
+				ticket = models.Ticket{ID: comment.TicketID}
+			} else if err != nil {
+				return err
+			}
+
+			if err := comments.SetTicket(ctx, ticket); err != nil {
+				return err
+			}
+		}
+
+		if page.Next == nil {
+			return nil
+		}
+		query.After = page.Next
+	}
+}
+
+// TICKET_TITLE_LENGTH is how much of a ticket's description stands for it in emails.
+const TICKET_TITLE_LENGTH = 80
+
+// TicketReplyNotification writes the email telling the customer about a staff
+// reply on their ticket, to be queued in the outbox.
+func TicketReplyNotification(ctx context.Context, templates database.EmailTemplateStore, ticket models.Ticket, comment models.TicketComment, customer Contact, now time.Time) (models.Notification, error) {
+	current, err := CurrentEmailTemplates(ctx, templates)
+	if err != nil {
+		return models.Notification{}, err
+	}
+
+	title, _, _ := strings.Cut(stringValue(ticket.Description), "\n")
+	email := utils.TicketEmail{
+		Recipient:   customer.Name,
+		Author:      comment.AuthorName,
+		TicketId:    ticket.TicketId,
+		Title:       truncateText(title, TICKET_TITLE_LENGTH),
+		Description: stringValue(comment.Body),
+	}
+
+	// an edited template that fails on this reply must not keep the email from going out
+	message, err := utils.TicketReplyMessage(current, customer.Locale, email, customer.Email)
+	if err != nil {
+		log.Printf("error rendering edited email template, using the default: %v", err)
+		message, err = utils.TicketReplyMessage(EmailTemplates, customer.Locale, email, customer.Email)
+	}
+
+	if err != nil {
+		return models.Notification{}, err
+	}
+
+	notification := NewNotification(message, models.PRINCIPAL_CUSTOMER, now)
+	notification.TicketId = ticket.TicketId
+	notification.UserID = ticket.AssigneeID
+	notification.CustomerID = &ticket.CustomerID
+	return notification, nil
+}
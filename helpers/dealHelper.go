@@ -0,0 +1,259 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DEAL_DEFAULT_PIPELINE receives deals created without a pipeline and is seeded
+// on start when missing, DEAL_DEFAULT_CURRENCY is the currency of deals
+// created without one.
+var (
+	DEAL_DEFAULT_PIPELINE = stringFromEnv("DEAL_DEFAULT_PIPELINE", "sales")
+	DEAL_DEFAULT_CURRENCY = stringFromEnv("DEAL_DEFAULT_CURRENCY", "USD")
+)
+
+// DEAL_FORECAST_MAX_MONTHS caps how many months one forecast covers.
+const DEAL_FORECAST_MAX_MONTHS = 24
+
+// the layouts of a deal's expected close date and of a forecast month
+const (
+	DEAL_DATE_LAYOUT  = "2006-01-02"
+	DEAL_MONTH_LAYOUT = "2006-01"
+)
+
+var ErrDealMovedMeanwhile = errors.New("deal was moved to another stage meanwhile, reload it and try again")
+
+// DefaultPipelineStages are the stages DEAL_DEFAULT_PIPELINE is seeded with.
+var DefaultPipelineStages = []models.PipelineStage{
+	{Name: "lead", Probability: 10},
+	{Name: "qualified", Probability: 25},
+	{Name: "proposal", Probability: 50},
+	{Name: "negotiation", Probability: 75},
+	{Name: "won", Probability: 100, Outcome: models.DEAL_WON},
+	{Name: "lost", Probability: 0, Outcome: models.DEAL_LOST},
+}
+
+func SeedPipeline(ctx context.Context, pipelines database.PipelineStore) error {
+	_, err := pipelines.FindByName(ctx, DEAL_DEFAULT_PIPELINE)
+	if err == nil || !errors.Is(err, database.ErrNotFound) {
+		return err
+	}
+
+	name := DEAL_DEFAULT_PIPELINE
+	pipeline := models.Pipeline{
+		ID:        primitive.NewObjectID(),
+		Name:      &name,
+		Stages:    DefaultPipelineStages,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if err := pipelines.Create(ctx, &pipeline); err != nil {
+		return fmt.Errorf("error seeding pipeline %s: %w", name, err)
+	}
+	return nil
+}
+
+// ValidatePipelineStages checks stage names are unique, that a won stage is
+// certain and a lost one hopeless, and that new deals have an open stage to start in.
+func ValidatePipelineStages(stages []models.PipelineStage) error {
+	seen := map[string]bool{}
+	for _, stage := range stages {
+		if seen[stage.Name] {
+			return fmt.Errorf("stage %s is listed twice", stage.Name)
+		}
+		seen[stage.Name] = true
+
+		if stage.Outcome == models.DEAL_WON && stage.Probability != 100 {
+			return fmt.Errorf("won stage %s must have a probability of 100", stage.Name)
+		}
+
+		if stage.Outcome == models.DEAL_LOST && stage.Probability != 0 {
+			return fmt.Errorf("lost stage %s must have a probability of 0", stage.Name)
+		}
+	}
+
+	if _, ok := FirstOpenStage(models.Pipeline{Stages: stages}); !ok {
+		return errors.New("a pipeline needs at least one stage without an outcome")
+	}
+	return nil
+}
+
+// FirstOpenStage is the stage new deals start in.
+func FirstOpenStage(pipeline models.Pipeline) (models.PipelineStage, bool) {
+	for _, stage := range pipeline.Stages {
+		if stage.Outcome == "" {
+			return stage, true
+		}
+	}
+	return models.PipelineStage{}, false
+}
+
+func FindStage(pipeline models.Pipeline, name string) (models.PipelineStage, bool) {
+	for _, stage := range pipeline.Stages {
+		if stage.Name == name {
+			return stage, true
+		}
+	}
+	return models.PipelineStage{}, false
+}
+
+// DealStatus is the status of a deal in stage.
+func DealStatus(stage models.PipelineStage) string {
+	if stage.Outcome != "" {
+		return stage.Outcome
+	}
+	return models.DEAL_OPEN
+}
+
+// DealCoversCustomer tells whether interactions with customer may be linked to
+// the deal, it is the deal's customer or a contact of the deal's account.
+func DealCoversCustomer(deal models.Deal, customer models.Customer) bool {
+	if deal.CustomerID != nil && *deal.CustomerID == customer.ID {
+		return true
+	}
+	return deal.AccountID != nil && customer.AccountID != nil && *deal.AccountID == *customer.AccountID
+}
+
+// ForecastRow sums one owner's deals expected to close in one month in one
+// currency. Pipeline is what the open deals are worth, Weighted the same
+// weighed by the probability of their stage and Won what was already won.
+type ForecastRow struct {
+	OwnerID   primitive.ObjectID `json:"owner_id"`
+	Month     string             `json:"month"`
+	Currency  string             `json:"currency"`
+	OpenDeals int                `json:"open_deals"`
+	WonDeals  int                `json:"won_deals"`
+	Pipeline  int64              `json:"pipeline"`
+	Weighted  int64              `json:"weighted"`
+	Won       int64              `json:"won"`
+}
+
+// ForecastTotal sums the rows of one currency, amounts in different
+// currencies are never added up.
+type ForecastTotal struct {
+	Currency  string `json:"currency"`
+	OpenDeals int    `json:"open_deals"`
+	WonDeals  int    `json:"won_deals"`
+	Pipeline  int64  `json:"pipeline"`
+	Weighted  int64  `json:"weighted"`
+	Won       int64  `json:"won"`
+}
+
+type Forecast struct {
+	From   string          `json:"from"`
+	To     string          `json:"to"`
+	Rows   []ForecastRow   `json:"rows"`
+	Totals []ForecastTotal `json:"totals"`
+}
+
+// ForecastDeals sums the open and won deals expected to close from the month
+// of from through the month of to, per owner, month and currency. Filters
+// narrow the deals counted. Open deals are weighed by the probability their
+// stage has now, deals without an expected close date are left out.
+func ForecastDeals(ctx context.Context, deals database.DealStore, pipelines database.PipelineStore, from, to time.Time, filters []database.Filter) (Forecast, error) {
+	forecast := Forecast{From: from.Format(DEAL_MONTH_LAYOUT), To: to.Format(DEAL_MONTH_LAYOUT), Rows: []ForecastRow{}, Totals: []ForecastTotal{}}
+
+	allPipelines, err := pipelines.List(ctx)
+	if err != nil {
+		return forecast, err
+	}
+
+	probabilities := map[string]int{}
+	for _, pipeline := range allPipelines {
+		for _, stage := range pipeline.Stages {
+			probabilities[*pipeline.Name+"/"+stage.Name] = stage.Probability
+		}
+	}
+
+	end := time.Date(to.Year(), to.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	query := database.ListQuery{
+		Filters: append(slices.Clone(filters),
+			database.Filter{Field: "status", Op: database.FILTER_IN, Value: bson.A{models.DEAL_OPEN, models.DEAL_WON}},
+			database.Filter{Field: "expected_close_date", Op: database.FILTER_GTE, Value: from.Format(DEAL_DATE_LAYOUT)},
+			database.Filter{Field: "expected_close_date", Op: database.FILTER_LT, Value: end.Format(DEAL_DATE_LAYOUT)},
+		),
+		Sort:  []database.SortField{{Field: "expected_close_date"}},
+		Limit: LIST_MAX_LIMIT,
+	}
+
+	rows := map[string]*ForecastRow{}
+	totals := map[string]*ForecastTotal{}
+	for {
+		page, err := deals.List(ctx, query)
+		if err != nil {
+			return forecast, err
+		}
+
+		for _, deal := range page.Items {
+			owner := primitive.NilObjectID
+			if deal.OwnerID != nil {
+				owner = *deal.OwnerID
+			}
+			month := deal.ExpectedCloseDate[:len(DEAL_MONTH_LAYOUT)]
+
+			key := owner.Hex() + "/" + month + "/" + deal.Currency
+			row, ok := rows[key]
+			if !ok {
+				row = &ForecastRow{OwnerID: owner, Month: month, Currency: deal.Currency}
+				rows[key] = row
+			}
+
+			total, ok := totals[deal.Currency]
+			if !ok {
+				total = &ForecastTotal{Currency: deal.Currency}
+				totals[deal.Currency] = total
+			}
+
+			if deal.Status == models.DEAL_WON {
+				row.WonDeals++
+				row.Won += deal.Amount
+				total.WonDeals++
+				total.Won += deal.Amount
+				continue
+			}
+
+			weighted := deal.Amount * int64(probabilities[deal.Pipeline+"/"+deal.Stage]) / 100
+			row.OpenDeals++
+			row.Pipeline += deal.Amount
+			row.Weighted += weighted
+			total.OpenDeals++
+			total.Pipeline += deal.Amount
+			total.Weighted += weighted
+		}
+
+		if page.Next == nil {
+			break
+		}
+		query.After = page.Next
+	}
+
+	for _, row := range rows {
+		forecast.Rows = append(forecast.Rows, *row)
+	}
+	slices.SortFunc(forecast.Rows, func(a, b ForecastRow) int {
+		if n := strings.Compare(a.OwnerID.Hex(), b.OwnerID.Hex()); n != 0 {
+			return n
+		}
+		if n := strings.Compare(a.Month, b.Month); n != 0 {
+			return n
+		}
+		return strings.Compare(a.Currency, b.Currency)
+	})
+
+	for _, total := range totals {
+		forecast.Totals = append(forecast.Totals, *total)
+	}
+	slices.SortFunc(forecast.Totals, func(a, b ForecastTotal) int { return strings.Compare(a.Currency, b.Currency) })
+	return forecast, nil
+}
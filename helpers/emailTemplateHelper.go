@@ -0,0 +1,94 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/utils"
+)
+
+// EmailTemplates are the templates shipped with the application, with those
+// of EMAIL_TEMPLATE_DIR in their place, main loads them with LoadEmailTemplates.
+var EmailTemplates utils.EmailTemplates
+
+var interactionChanges = []string{utils.NOTICE_CREATED, utils.NOTICE_UPDATED, utils.NOTICE_RESCHEDULED, utils.NOTICE_CANCELLED, utils.NOTICE_REMINDER}
+
+// LoadEmailTemplates reads the shipped templates and puts the
+// <locale>/<name>.tmpl files stored under dir in their place, an empty dir
+// keeps the shipped ones.
+func LoadEmailTemplates(dir string) error {
+	builtin, err := utils.BuiltinEmailTemplates()
+	if err != nil {
+		return fmt.Errorf("error loading the shipped email templates: %w", err)
+	}
+
+	merged := builtin
+	if dir != "" {
+		templates, err := utils.LoadEmailTemplates(os.DirFS(dir))
+		if err != nil {
+			return fmt.Errorf("error loading email templates: %w", err)
+		}
+		merged = builtin.Merge(templates)
+	}
+
+	// a broken file stops the start rather than the first email
+	if err := CheckEmailTemplates(merged); err != nil {
+		return err
+	}
+
+	EmailTemplates = merged
+	return nil
+}
+
+// CurrentEmailTemplates returns EmailTemplates with the ones admins edited in their place.
+func CurrentEmailTemplates(ctx context.Context, templates database.EmailTemplateStore) (utils.EmailTemplates, error) {
+	edited, err := templates.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := utils.EmailTemplates{}
+	for _, template := range edited {
+		overrides.Set(template.Locale, template.Name, template.Source)
+	}
+	return EmailTemplates.Merge(overrides), nil
+}
+
+// CheckEmailTemplates renders every email in every locale that has templates
+// of its own, for each interaction type, so mistakes show before anyone is sent one.
+func CheckEmailTemplates(templates utils.EmailTemplates) error {
+	for _, locale := range templates.Locales() {
+		for _, change := range interactionChanges {
+			for _, interactionType := range InteractionTypes {
+				email := utils.InteractionEmail{
+					Recipient:   "Ada Lovelace",
+					Type:        interactionType,
+					Change:      change,
+					Title:       "Quarterly review",
+					Description: "Go through the open tickets.",
+					Location:    "Room 4",
+					Time:        "Jan 2, 2030 3:04 PM UTC",
+				}
+
+				if _, err := templates.Render(locale, utils.InteractionTemplateName(change), email); err != nil {
+					return fmt.Errorf("%s: %w", locale, err)
+				}
+			}
+		}
+
+		email := utils.TicketEmail{
+			Recipient:   "Ada Lovelace",
+			Author:      "Grace Hopper",
+			TicketId:    "0123456789abcdef01234567",
+			Title:       "The invoice is wrong",
+			Description: "We sent a corrected one.",
+		}
+
+		if _, err := templates.Render(locale, utils.TICKET_REPLY_TEMPLATE, email); err != nil {
+			return fmt.Errorf("%s: %w", locale, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package helpers
+
+import (
+	"slices"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+)
+
+// TimeSlot is a stretch of time, busy or free.
+type TimeSlot struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Occupying lists the occurrences of the interactions that block time and
+// overlap [from, to), by start time.
+func Occupying(interactions []models.Interaction, from, to time.Time) []models.Interaction {
+	occupying := []models.Interaction{}
+
+	for _, interaction := range interactions {
+		if !BlocksTime(interaction) {
+			continue
+		}
+
+		// an occurrence that starts before from still overlaps while it lasts
+		earliest := from.Add(-InteractionDuration(interaction)).Add(time.Nanosecond)
+		occupying = append(occupying, ExpandInteractions([]models.Interaction{interaction}, earliest, to)...)
+	}
+
+	slices.SortStableFunc(occupying, func(a, b models.Interaction) int { return a.StartTime.Compare(b.StartTime) })
+	return occupying
+}
+
+// FindConflicts lists the occurrences of others that overlap an occurrence of
+// candidate still to come, a repeating candidate is checked up to
+// INTERACTION_WINDOW_MAX ahead. Others with the candidate's own ID are skipped.
+func FindConflicts(candidate models.Interaction, others []models.Interaction, now time.Time) []models.Interaction {
+	conflicts := []models.Interaction{}
+	if !BlocksTime(candidate) {
+		return conflicts
+	}
+
+	from, _ := ConflictWindow(candidate, now)
+	occurrences := ExpandInteractions([]models.Interaction{candidate}, from.Add(-InteractionDuration(candidate)).Add(time.Nanosecond), from.Add(INTERACTION_WINDOW_MAX))
+	if len(occurrences) == 0 {
+		return conflicts
+	}
+
+	duration := InteractionDuration(candidate)
+	to := occurrences[len(occurrences)-1].StartTime.Add(duration)
+
+	others = slices.DeleteFunc(slices.Clone(others), func(other models.Interaction) bool {
+		return other.InteractionId == candidate.InteractionId
+	})
+
+	for _, busy := range Occupying(others, from, to) {
+		busyEnd := busy.StartTime.Add(InteractionDuration(busy))
+
+		if slices.ContainsFunc(occurrences, func(occurrence models.Interaction) bool {
+			return occurrence.StartTime.Before(busyEnd) && busy.StartTime.Before(occurrence.StartTime.Add(duration))
+		}) {
+			conflicts = append(conflicts, busy)
+		}
+	}
+	return conflicts
+}
+
+// ConflictWindow bounds the time FindConflicts looks for clashes with candidate in.
+func ConflictWindow(candidate models.Interaction, now time.Time) (from, to time.Time) {
+	from = candidate.StartTime
+	if from.Before(now) {
+		from = now
+	}
+	return from, from.Add(INTERACTION_WINDOW_MAX).Add(InteractionDuration(candidate))
+}
+
+// BusySlots merges the time the interactions take up within [from, to).
+func BusySlots(interactions []models.Interaction, from, to time.Time) []TimeSlot {
+	busy := []TimeSlot{}
+
+	for _, interaction := range Occupying(interactions, from, to) {
+		slot := TimeSlot{Start: interaction.StartTime, End: interaction.StartTime.Add(InteractionDuration(interaction))}
+		if slot.Start.Before(from) {
+			slot.Start = from
+		}
+		if slot.End.After(to) {
+			slot.End = to
+		}
+
+		// Occupying is sorted by start, so a slot either extends the last one or follows it
+		if last := len(busy) - 1; last >= 0 && !slot.Start.After(busy[last].End) {
+			if slot.End.After(busy[last].End) {
+				busy[last].End = slot.End
+			}
+			continue
+		}
+		busy = append(busy, slot)
+	}
+	return busy
+}
+
+// FreeSlots lists the gaps between the busy slots in [from, to) that are at least minLength long.
+func FreeSlots(busy []TimeSlot, from, to time.Time, minLength time.Duration) []TimeSlot {
+	free := []TimeSlot{}
+
+	start := from
+	for _, slot := range append(slices.Clone(busy), TimeSlot{Start: to, End: to}) {
+		if slot.Start.Sub(start) >= minLength && slot.Start.After(start) {
+			free = append(free, TimeSlot{Start: start, End: slot.Start})
+		}
+
+		if slot.End.After(start) {
+			start = slot.End
+		}
+	}
+	return free
+}
+
+// SlotsIn gives the slots in loc.
+func SlotsIn(slots []TimeSlot, loc *time.Location) []TimeSlot {
+	localized := make([]TimeSlot, 0, len(slots))
+	for _, slot := range slots {
+		localized = append(localized, TimeSlot{Start: slot.Start.In(loc), End: slot.End.In(loc)})
+	}
+	return localized
+}
@@ -0,0 +1,415 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// INBOUND_EMAIL_SECRET authenticates the mail provider posting to the inbound
+// email webhook, the webhook is off while it is empty.
+var INBOUND_EMAIL_SECRET = stringFromEnv("INBOUND_EMAIL_SECRET", "")
+
+// INBOUND_EMAIL_MAX_SIZE is the largest email accepted, in bytes, attachments included.
+var INBOUND_EMAIL_MAX_SIZE = int64FromEnv("INBOUND_EMAIL_MAX_SIZE", 25<<20)
+
+// INBOUND_EMAIL_DEDUPE_WINDOW is how long the Message-ID of a filed email is
+// remembered, a copy delivered again within it returns the first result.
+var INBOUND_EMAIL_DEDUPE_WINDOW = durationFromEnv("INBOUND_EMAIL_DEDUPE_WINDOW", 7*24*time.Hour)
+
+// INBOUND_EMAIL_CLAIM is how long filing one email may take before another
+// delivery of it may file it instead.
+const INBOUND_EMAIL_CLAIM = 5 * time.Minute
+
+// what became of an inbound email
+const (
+	INBOUND_TICKET_CREATED = "ticket_created"
+	INBOUND_COMMENT_ADDED  = "comment_added"
+	INBOUND_IGNORED        = "ignored"
+)
+
+// COMMENT_MAX_LENGTH is the longest comment body, longer emails are cut.
+const COMMENT_MAX_LENGTH = 10000
+
+var (
+	ErrMalformedEmail = errors.New("email could not be read")
+	ErrUnknownSender  = errors.New("sender is not a known customer")
+	ErrEmailInFiling  = errors.New("email is being filed already, try again later")
+)
+
+// The ticket an email is about is found from a "[#<ticket id>]" token in its
+// subject, or from the Message-ID of an email it answers: "ticket-<id>..." for
+// one about a ticket, "interaction-<id>..." for an interaction notification.
+var (
+	subjectTicketToken = regexp.MustCompile(`\[#([0-9a-f]{24})\]`)
+	messageIDToken     = regexp.MustCompile(`^(ticket|interaction)-([0-9a-f]{24})\b`)
+)
+
+// notificationMessageID is the Message-ID a ticket or interaction notification
+// goes out with, so a reply to it can be traced back to what it was about.
+func notificationMessageID(notification models.Notification) string {
+	switch {
+	case notification.TicketId != "":
+		return fmt.Sprintf("ticket-%s.%s", notification.TicketId, notification.NotificationId)
+	case notification.InteractionId != "":
+		return fmt.Sprintf("interaction-%s.%s", notification.InteractionId, notification.NotificationId)
+	}
+	return ""
+}
+
+// InboundResult tells the sender's side what became of an email.
+type InboundResult struct {
+	Action      string   `json:"action"`
+	Reason      string   `json:"reason,omitempty"`
+	TicketId    string   `json:"ticket_id,omitempty"`
+	CommentId   string   `json:"comment_id,omitempty"`
+	Attachments []string `json:"attachments,omitempty"`
+	// Rejected names the attachments that were not kept and why
+	Rejected []string `json:"rejected,omitempty"`
+}
+
+// Mailbox files the emails customers send to support: a reply about an open
+// ticket becomes a comment on it, any other email a new ticket.
+type Mailbox struct {
+	customers    database.CustomerStore
+	interactions database.InteractionStore
+	tickets      database.TicketStore
+	comments     database.CommentStore
+	attachments  database.AttachmentStore
+	blobs        database.BlobStore
+	queues       database.QueueStore
+	users        database.UserStore
+	roles        database.RoleStore
+	slas         database.SlaStore
+	inbound      database.InboundEmailStore
+}
+
+func NewMailbox(stores *database.Stores) *Mailbox {
+	return &Mailbox{
+		customers:    stores.Customers,
+		interactions: stores.Interactions,
+		tickets:      stores.Tickets,
+		comments:     stores.Comments,
+		attachments:  stores.Attachments,
+		blobs:        stores.Blobs,
+		queues:       stores.Queues,
+		users:        stores.Users,
+		roles:        stores.Roles,
+		slas:         stores.Slas,
+		inbound:      stores.InboundEmails,
+	}
+}
+
+// Receive files one raw RFC 5322 email. Automatic replies are ignored so
+// notifications and out of office notices cannot loop, an email from an
+// address no customer has is ErrUnknownSender. An email whose sender and
+// Message-ID were filed before is not filed again, the first result is returned.
+func (m *Mailbox) Receive(ctx context.Context, raw io.Reader) (InboundResult, error) {
+	email, err := utils.ParseEmail(raw)
+	if err != nil {
+		return InboundResult{}, fmt.Errorf("%w: %w", ErrMalformedEmail, err)
+	}
+
+	if email.AutoReply {
+		return InboundResult{Action: INBOUND_IGNORED, Reason: "automatic reply"}, nil
+	}
+
+	if email.CalendarReply {
+		return InboundResult{Action: INBOUND_IGNORED, Reason: "meeting invite answer"}, nil
+	}
+
+	if email.MessageID == "" {
+		return m.file(ctx, email)
+	}
+
+	now := time.Now()
+	// the Message-ID is the sender's to choose, so it only dedupes their own emails
+	sender := strings.ToLower(email.From)
+	filed, claimed, err := m.inbound.Claim(ctx, sender, email.MessageID, now, now.Add(INBOUND_EMAIL_CLAIM), now.Add(INBOUND_EMAIL_DEDUPE_WINDOW))
+	if err != nil {
+		return InboundResult{}, err
+	}
+
+	if !claimed {
+		if filed.Action == "" {
+			return InboundResult{}, ErrEmailInFiling
+		}
+		return InboundResult{Action: filed.Action, Reason: filed.Reason, TicketId: filed.TicketId, CommentId: filed.CommentId, Attachments: filed.Attachments, Rejected: filed.Rejected}, nil
+	}
+
+	result, err := m.file(ctx, email)
+
+	// the request's context may be gone, the claim must still be settled
+	storeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err != nil {
+		if releaseErr := m.inbound.Release(storeCtx, sender, email.MessageID); releaseErr != nil {
+			log.Printf("error releasing inbound email %s: %v", email.MessageID, releaseErr)
+		}
+		return result, err
+	}
+
+	filed = models.InboundEmail{
+		Sender:      sender,
+		MessageID:   email.MessageID,
+		Action:      result.Action,
+		Reason:      result.Reason,
+		TicketId:    result.TicketId,
+		CommentId:   result.CommentId,
+		Attachments: result.Attachments,
+		Rejected:    result.Rejected,
+	}
+	if err := m.inbound.Complete(storeCtx, filed); err != nil {
+		// the email is filed, failing now would only make the provider deliver it again
+		log.Printf("error recording inbound email %s: %v", email.MessageID, err)
+	}
+	return result, nil
+}
+
+// file makes a ticket or a comment of the email.
+func (m *Mailbox) file(ctx context.Context, email utils.ReceivedEmail) (InboundResult, error) {
+	customer, err := m.sender(ctx, email.From)
+	if err != nil {
+		return InboundResult{}, err
+	}
+
+	principal := Principal{
+		Type:  models.PRINCIPAL_CUSTOMER,
+		Id:    customer.ID.Hex(),
+		Email: stringValue(customer.Email),
+		Name:  stringValue(customer.Name),
+		Role:  models.ROLE_CUSTOMER,
+	}
+
+	ticket, interactionId, err := m.thread(ctx, email, customer)
+	if err != nil {
+		return InboundResult{}, err
+	}
+
+	var result InboundResult
+	if ticket != nil && stringValue(ticket.Status) != models.TICKET_CLOSED {
+		result, err = m.reply(ctx, email, *ticket, principal)
+	} else {
+		result, err = m.raise(ctx, email, ticket, interactionId, customer)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	ticketId, _ := primitive.ObjectIDFromHex(result.TicketId)
+	for _, file := range email.Attachments {
+		attachment, err := StoreAttachment(ctx, m.attachments, m.blobs, file.Filename, file.ContentType, bytes.NewReader(file.Data), int64(len(file.Data)), models.ATTACHMENT_TICKET, ticketId, principal)
+		if err != nil {
+			// the ticket is filed already, a file that cannot be kept must not undo it
+			if !errors.Is(err, ErrAttachmentTooLarge) && !errors.Is(err, ErrAttachmentTypeNotAllowed) && !errors.Is(err, ErrAttachmentRejected) {
+				log.Printf("error storing attachment %q of email %s: %v", file.Filename, email.MessageID, err)
+				err = errors.New("file could not be stored")
+			}
+			result.Rejected = append(result.Rejected, fmt.Sprintf("%s: %v", file.Filename, err))
+			continue
+		}
+		result.Attachments = append(result.Attachments, attachment.AttachmentId)
+	}
+	return result, nil
+}
+
+// ReceiveLMTP is Receive as a utils.LMTPHandler: unknown senders and unreadable
+// emails are refused for good, so the MTA bounces them, anything else that
+// fails is left for the MTA to retry.
+func (m *Mailbox) ReceiveLMTP(ctx context.Context, raw io.Reader) error {
+	result, err := m.Receive(ctx, raw)
+	switch {
+	case errors.Is(err, ErrUnknownSender):
+		return &textproto.Error{Code: 550, Msg: "5.7.1 " + err.Error()}
+	case errors.Is(err, ErrMalformedEmail):
+		return &textproto.Error{Code: 554, Msg: "5.6.0 " + err.Error()}
+	case err != nil:
+		return err
+	}
+
+	detail := result.TicketId
+	if detail == "" {
+		detail = result.Reason
+	}
+	log.Printf("inbound email %s: %s", result.Action, detail)
+	return nil
+}
+
+// sender finds the customer by the From address, which customers may have
+// typed in another case when they signed up.
+func (m *Mailbox) sender(ctx context.Context, address string) (models.Customer, error) {
+	customer, err := m.customers.FindByEmail(ctx, address)
+	if errors.Is(err, database.ErrNotFound) && address != strings.ToLower(address) {
+		customer, err = m.customers.FindByEmail(ctx, strings.ToLower(address))
+	}
+
+	if errors.Is(err, database.ErrNotFound) {
+		return customer, ErrUnknownSender
+	}
+	return customer, err
+}
+
+// thread finds the ticket or the interaction the email is about, a reply to
+// an interaction notification goes on the customer's latest ticket about the
+// interaction that is not closed yet. Tokens that point at another customer's
+// records are ignored, the email then raises a ticket of its own.
+func (m *Mailbox) thread(ctx context.Context, email utils.ReceivedEmail, customer models.Customer) (*models.Ticket, primitive.ObjectID, error) {
+	var ticketIds, interactionIds []string
+
+	if match := subjectTicketToken.FindStringSubmatch(email.Subject); match != nil {
+		ticketIds = append(ticketIds, match[1])
+	}
+
+	for _, reference := range email.References {
+		match := messageIDToken.FindStringSubmatch(reference)
+		switch {
+		case match == nil:
+		case match[1] == "ticket":
+			ticketIds = append(ticketIds, match[2])
+		default:
+			interactionIds = append(interactionIds, match[2])
+		}
+	}
+
+	for _, hex := range ticketIds {
+		id, _ := primitive.ObjectIDFromHex(hex)
+		ticket, err := m.tickets.FindByID(ctx, id)
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, primitive.NilObjectID, err
+		}
+
+		if ticket.CustomerID == customer.ID {
+			return &ticket, ticket.InteractionID, nil
+		}
+	}
+
+	for _, hex := range interactionIds {
+		id, _ := primitive.ObjectIDFromHex(hex)
+		interaction, err := m.interactions.FindByID(ctx, id)
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, primitive.NilObjectID, err
+		}
+
+		if interaction.CustomerID != customer.ID {
+			continue
+		}
+
+		open, err := m.tickets.List(ctx, database.ListQuery{
+			Filters: []database.Filter{
+				database.Eq("interaction_id", interaction.ID),
+				database.Eq("customer_id", customer.ID),
+				{Field: "status", Op: database.FILTER_IN, Value: bson.A{models.TICKET_OPEN, models.TICKETIN_PROGRESS, models.TICKET_RESOLVED}},
+			},
+			Sort:  []database.SortField{{Field: "created_at", Desc: true}},
+			Limit: 1,
+		})
+		if err != nil {
+			return nil, primitive.NilObjectID, err
+		}
+
+		if len(open.Items) > 0 {
+			return &open.Items[0], interaction.ID, nil
+		}
+		return nil, interaction.ID, nil
+	}
+	return nil, primitive.NilObjectID, nil
+}
+
+// reply adds the email to the conversation of an open ticket, a reply to a
+// resolved one reopens it while the customer still may.
+func (m *Mailbox) reply(ctx context.Context, email utils.ReceivedEmail, ticket models.Ticket, principal Principal) (InboundResult, error) {
+	body := utils.ReplyText(email.Text)
+	if body == "" {
+		body = email.Subject
+	}
+	if body == "" {
+		body = "(empty email)"
+	}
+	body = truncateText(body, COMMENT_MAX_LENGTH)
+
+	comment := models.TicketComment{
+		TicketID:   ticket.ID,
+		CustomerID: ticket.CustomerID,
+		AssigneeID: ticket.AssigneeID,
+		AuthorType: principal.Type,
+		AuthorId:   principal.Id,
+		AuthorName: principal.Name,
+		Body:       &body,
+	}
+	comment.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	comment.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	comment.ID = primitive.NewObjectID()
+	comment.CommentId = comment.ID.Hex()
+
+	if err := m.comments.Create(ctx, &comment); err != nil {
+		return InboundResult{}, err
+	}
+
+	if stringValue(ticket.Status) == models.TICKET_RESOLVED {
+		err := TransitionTicket(ctx, m.tickets, ticket, models.TICKET_OPEN, principal, "reopened by email", nil)
+		if err != nil && !errors.Is(err, ErrTicketReopenExpired) && !errors.Is(err, ErrTicketTransitionDenied) && !errors.Is(err, ErrTicketStatusConflict) {
+			log.Printf("error reopening ticket %s: %v", ticket.TicketId, err)
+		}
+	}
+
+	return InboundResult{Action: INBOUND_COMMENT_ADDED, TicketId: ticket.TicketId, CommentId: comment.CommentId}, nil
+}
+
+// raise opens a ticket from the email, one about a closed ticket becomes its follow-up.
+func (m *Mailbox) raise(ctx context.Context, email utils.ReceivedEmail, closed *models.Ticket, interactionId primitive.ObjectID, customer models.Customer) (InboundResult, error) {
+	lines := []string{}
+	if closed != nil {
+		lines = append(lines, fmt.Sprintf("Follow-up to ticket %s", closed.TicketId))
+	}
+	if email.Subject != "" {
+		lines = append(lines, email.Subject)
+	}
+	if text := utils.ReplyText(email.Text); text != "" {
+		lines = append(lines, text)
+	}
+	description := truncateText(strings.Join(lines, "\n\n"), COMMENT_MAX_LENGTH)
+
+	ticket := models.Ticket{
+		InteractionID: interactionId,
+		Description:   &description,
+		Channel:       models.TICKET_CHANNEL_EMAIL,
+	}
+
+	if err := OpenTicket(ctx, m.tickets, m.queues, m.users, m.roles, m.slas, &ticket, customer); err != nil {
+		return InboundResult{}, err
+	}
+
+	if err := m.tickets.Create(ctx, &ticket); err != nil {
+		return InboundResult{}, err
+	}
+	return InboundResult{Action: INBOUND_TICKET_CREATED, TicketId: ticket.TicketId}, nil
+}
+
+// truncateText cuts text to at most max characters without splitting one.
+func truncateText(text string, max int) string {
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	return string([]rune(text)[:max])
+}
@@ -0,0 +1,282 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/utils"
+)
+
+var InteractionTypes = []string{models.INTERACTION_MEETING, models.INTERACTION_TASK, models.INTERACTION_FOLLOWUP, models.INTERACTION_CALL}
+
+var (
+	ErrInvalidInteractionType = errors.New("type must be one of meeting, task, followup or call")
+	ErrInteractionNotTask     = errors.New("only task interactions can be completed")
+)
+
+func IsInteractionType(interactionType string) bool {
+	for _, t := range InteractionTypes {
+		if t == interactionType {
+			return true
+		}
+	}
+	return false
+}
+
+// InteractionType reads the type of a stored interaction, untyped ones predate
+// the field and were all meetings.
+func InteractionType(interaction models.Interaction) string {
+	if interaction.Type == "" {
+		return models.INTERACTION_MEETING
+	}
+	return interaction.Type
+}
+
+// PrepareInteraction defaults the type of a new interaction and checks that it
+// only carries the details block of its own type.
+func PrepareInteraction(interaction *models.Interaction, now time.Time) error {
+	if interaction.Type == "" {
+		interaction.Type = models.INTERACTION_MEETING
+	}
+
+	interaction.Revision = 0
+	interaction.Revisions = nil
+	interaction.ExDates = nil
+	interaction.SeriesId = ""
+	interaction.RecurrenceId = nil
+
+	details := map[string]bool{
+		models.INTERACTION_MEETING: interaction.Meeting != nil,
+		models.INTERACTION_TASK:    interaction.Task != nil,
+		models.INTERACTION_CALL:    interaction.Call != nil,
+	}
+	for detailsType, set := range details {
+		if set && detailsType != interaction.Type {
+			return fmt.Errorf("%s details do not apply to a %s interaction", detailsType, interaction.Type)
+		}
+	}
+
+	switch interaction.Type {
+	case models.INTERACTION_MEETING:
+		if interaction.StartTime.IsZero() {
+			return errors.New("a meeting needs a start_time")
+		}
+	case models.INTERACTION_CALL:
+		if interaction.Call == nil {
+			return errors.New("a call needs call details with a direction")
+		}
+	case models.INTERACTION_TASK:
+		if interaction.Task == nil {
+			interaction.Task = &models.TaskDetails{}
+		}
+
+		interaction.Task.CompletedAt = nil
+		if interaction.Task.Completed {
+			interaction.Task.CompletedAt = &now
+		}
+	}
+	if interaction.EndTime != nil {
+		if !BlocksTime(*interaction) {
+			return errors.New("end_time only applies to meetings and calls with a start_time")
+		}
+
+		if !interaction.EndTime.After(interaction.StartTime) {
+			return errors.New("end_time must be after start_time")
+		}
+	}
+
+	// the end is stored so clients see how long a meeting or call takes
+	if BlocksTime(*interaction) && interaction.EndTime == nil {
+		end := interaction.StartTime.Add(InteractionDuration(*interaction))
+		interaction.EndTime = &end
+	}
+
+	return PrepareRecurrence(interaction)
+}
+
+// InteractionRecipients decides who is emailed about a new interaction: both
+// sides of a meeting, the customer for a follow-up or a call still to happen,
+// and only the staff member for their own task.
+func InteractionRecipients(interaction models.Interaction, now time.Time) (notifyUser, notifyCustomer bool) {
+	switch InteractionType(interaction) {
+	case models.INTERACTION_TASK:
+		return true, false
+	case models.INTERACTION_FOLLOWUP:
+		return false, true
+	case models.INTERACTION_CALL:
+		return false, interaction.StartTime.After(now)
+	default:
+		return true, true
+	}
+}
+
+// InteractionTime is the moment an interaction's notification refers to, the due date for tasks.
+func InteractionTime(interaction models.Interaction) time.Time {
+	if interaction.Task != nil && interaction.Task.DueAt != nil {
+		return *interaction.Task.DueAt
+	}
+	return interaction.StartTime
+}
+
+// INTERACTION_DURATION_MAX caps the duration read from meeting or call details,
+// so interactions without an end_time can be looked up by their start_time.
+const INTERACTION_DURATION_MAX = 24 * time.Hour
+
+// InteractionDuration is how long an interaction takes: up to its end_time, else
+// the duration in its meeting or call details, else CALENDAR_DEFAULT_DURATION.
+func InteractionDuration(interaction models.Interaction) time.Duration {
+	if interaction.EndTime != nil && interaction.EndTime.After(interaction.StartTime) {
+		return interaction.EndTime.Sub(interaction.StartTime)
+	}
+
+	if interaction.Meeting != nil && interaction.Meeting.DurationMinutes > 0 {
+		return min(time.Duration(interaction.Meeting.DurationMinutes)*time.Minute, INTERACTION_DURATION_MAX)
+	}
+
+	if interaction.Call != nil && interaction.Call.DurationMinutes > 0 {
+		return min(time.Duration(interaction.Call.DurationMinutes)*time.Minute, INTERACTION_DURATION_MAX)
+	}
+	return min(CALENDAR_DEFAULT_DURATION, INTERACTION_DURATION_MAX)
+}
+
+// BlocksTime reports whether the interaction keeps its people from being booked
+// elsewhere, which meetings and calls do while tasks and follow-ups do not.
+func BlocksTime(interaction models.Interaction) bool {
+	interactionType := InteractionType(interaction)
+	return !interaction.StartTime.IsZero() && (interactionType == models.INTERACTION_MEETING || interactionType == models.INTERACTION_CALL)
+}
+
+// Contact is someone emailed about an interaction, with the zone and locale
+// their copy is written for.
+type Contact struct {
+	Name     string
+	Email    string
+	Location *time.Location
+	Locale   string
+}
+
+// contact reads the name, email and preferences of a user or customer.
+func contact(name, email, timeZone, locale *string) Contact {
+	c := Contact{Location: preferredLocation(timeZone), Locale: utils.DEFAULT_LOCALE}
+	if name != nil {
+		c.Name = *name
+	}
+	if email != nil {
+		c.Email = *email
+	}
+	if locale != nil {
+		c.Locale = *locale
+	}
+	return c
+}
+
+func UserContact(user models.User) Contact {
+	return contact(user.Name, user.Email, user.TimeZone, user.Locale)
+}
+
+func CustomerContact(customer models.Customer) Contact {
+	return contact(customer.Name, customer.Email, customer.TimeZone, customer.Locale)
+}
+
+// InteractionContacts looks up the staff member who owns the interaction and
+// its customer, a missing record leaves its email empty.
+func InteractionContacts(ctx context.Context, users database.UserStore, customers database.CustomerStore, interaction models.Interaction) (user, customer Contact) {
+	user, customer = contact(nil, nil, nil, nil), contact(nil, nil, nil, nil)
+
+	if found, err := users.FindByID(ctx, interaction.UserID.Hex()); err == nil {
+		user = UserContact(found)
+	}
+
+	if found, err := customers.FindByID(ctx, interaction.CustomerID.Hex()); err == nil {
+		customer = CustomerContact(found)
+	}
+	return user, customer
+}
+
+// InteractionNotifications writes the emails about change (one of the
+// utils.NOTICE_ values) for whoever InteractionRecipients picks, each from the
+// templates of their own locale and with times in their own zone, ready to be
+// queued in the outbox.
+func InteractionNotifications(ctx context.Context, templates database.EmailTemplateStore, interaction models.Interaction, change string, user, customer Contact, now time.Time) ([]models.Notification, error) {
+	notifyUser, notifyCustomer := InteractionRecipients(interaction, now)
+
+	recipients := map[string]Contact{}
+	if notifyUser && user.Email != "" {
+		recipients[models.PRINCIPAL_USER] = user
+	}
+	if notifyCustomer && customer.Email != "" {
+		recipients[models.PRINCIPAL_CUSTOMER] = customer
+	}
+
+	// the same invite goes to everyone, a cancellation must carry a newer SEQUENCE than the last request
+	var invite []byte
+	if event, ok := InteractionEvent(interaction, user.Email, customer.Email); ok && change != utils.NOTICE_REMINDER {
+		method := utils.ICS_REQUEST
+		if change == utils.NOTICE_CANCELLED {
+			method = utils.ICS_CANCEL
+			event.Sequence++
+			event.Cancelled = true
+		}
+		invite = utils.WriteCalendar(method, []utils.CalendarEvent{event}, now)
+	}
+
+	current, err := CurrentEmailTemplates(ctx, templates)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := []models.Notification{}
+	for _, recipientType := range []string{models.PRINCIPAL_USER, models.PRINCIPAL_CUSTOMER} {
+		recipient, ok := recipients[recipientType]
+		if !ok {
+			continue
+		}
+
+		email := utils.InteractionEmail{
+			Recipient:   recipient.Name,
+			Type:        InteractionType(interaction),
+			Change:      change,
+			Title:       stringValue(interaction.Title),
+			Description: stringValue(interaction.Description),
+		}
+
+		if interaction.Meeting != nil {
+			email.Location = interaction.Meeting.Location
+		}
+
+		if start := InteractionTime(interaction); !start.IsZero() {
+			email.Time = utils.FormatTime(start, recipient.Location, recipient.Locale)
+		}
+
+		// an edited template that fails on this interaction must not keep the email from going out
+		message, err := utils.InteractionMessage(current, recipient.Locale, email, recipient.Email, invite)
+		if err != nil {
+			log.Printf("error rendering edited email template, using the default: %v", err)
+			message, err = utils.InteractionMessage(EmailTemplates, recipient.Locale, email, recipient.Email, invite)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		notification := NewNotification(message, recipientType, now)
+		notification.InteractionId = interaction.InteractionId
+		notification.UserID = &interaction.UserID
+		notification.CustomerID = &interaction.CustomerID
+		notification.Change = change
+		notifications = append(notifications, notification)
+	}
+	return notifications, nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
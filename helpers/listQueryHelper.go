@@ -0,0 +1,218 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LIST_DEFAULT_LIMIT is the page size of list routes called without ?limit=,
+// LIST_MAX_LIMIT the largest one a caller may ask for.
+var (
+	LIST_DEFAULT_LIMIT = int(int64FromEnv("LIST_DEFAULT_LIMIT", 50))
+	LIST_MAX_LIMIT     = int(int64FromEnv("LIST_MAX_LIMIT", 200))
+)
+
+// kinds of list fields, they decide how a query value is read
+const (
+	LIST_FIELD_STRING = "string"
+	LIST_FIELD_ID     = "id"
+	LIST_FIELD_BOOL   = "bool"
+	LIST_FIELD_TIME   = "time"
+)
+
+var ErrInvalidCursor = errors.New("cursor is invalid or was made for another sort")
+
+// ListField is a record field a list route can be filtered or sorted by.
+type ListField struct {
+	Field string
+	Kind  string
+	// Filter lets ?<name>=a,b match the values listed, a time is bounded by
+	// ?<name>_after= and ?<name>_before= instead, less an "_at" or "_time" suffix
+	Filter bool
+	Sort   bool
+}
+
+// ListSpec describes the query of one list route, fields are keyed by the
+// name they have in the query string.
+type ListSpec struct {
+	Fields      map[string]ListField
+	DefaultSort string
+}
+
+// ParseListQuery reads ?limit=, ?cursor=, ?sort= and the filters spec allows.
+// sort takes comma separated field names, "-" in front sorts a field descending.
+func ParseListQuery(values url.Values, spec ListSpec) (database.ListQuery, error) {
+	query := database.ListQuery{Limit: LIST_DEFAULT_LIMIT}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > LIST_MAX_LIMIT {
+			return query, fmt.Errorf("limit must be a number from 1 to %d", LIST_MAX_LIMIT)
+		}
+		query.Limit = n
+	}
+
+	sort := values.Get("sort")
+	if sort == "" {
+		sort = spec.DefaultSort
+	}
+
+	for _, name := range strings.Split(sort, ",") {
+		name, desc := strings.CutPrefix(strings.TrimSpace(name), "-")
+
+		field, ok := spec.Fields[name]
+		if !ok || !field.Sort {
+			return query, fmt.Errorf("cannot sort by %q", name)
+		}
+		query.Sort = append(query.Sort, database.SortField{Field: field.Field, Desc: desc})
+	}
+
+	for name, field := range spec.Fields {
+		if !field.Filter {
+			continue
+		}
+
+		if field.Kind == LIST_FIELD_TIME {
+			prefix := strings.TrimSuffix(strings.TrimSuffix(name, "_at"), "_time")
+			for suffix, op := range map[string]string{"_after": database.FILTER_GTE, "_before": database.FILTER_LT} {
+				value := values.Get(prefix + suffix)
+				if value == "" {
+					continue
+				}
+
+				at, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					return query, fmt.Errorf("%s%s must be an RFC 3339 time", prefix, suffix)
+				}
+				query.Filters = append(query.Filters, database.Filter{Field: field.Field, Op: op, Value: at})
+			}
+			continue
+		}
+
+		if !values.Has(name) {
+			continue
+		}
+
+		filter, err := listFilter(name, field, values.Get(name))
+		if err != nil {
+			return query, err
+		}
+		query.Filters = append(query.Filters, filter)
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor, query)
+		if err != nil {
+			return query, err
+		}
+		query.After = after
+	}
+	return query, nil
+}
+
+// listFilter reads the comma separated values of one filter.
+func listFilter(name string, field ListField, raw string) (database.Filter, error) {
+	matches := bson.A{}
+	for _, value := range strings.Split(raw, ",") {
+		switch field.Kind {
+		case LIST_FIELD_ID:
+			id, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				return database.Filter{}, fmt.Errorf("%s must be an ID", name)
+			}
+			matches = append(matches, id)
+		case LIST_FIELD_BOOL:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return database.Filter{}, fmt.Errorf("%s must be true or false", name)
+			}
+			matches = append(matches, b)
+		default:
+			matches = append(matches, value)
+		}
+	}
+
+	if len(matches) == 1 {
+		return database.Eq(field.Field, matches[0]), nil
+	}
+	return database.Filter{Field: field.Field, Op: database.FILTER_IN, Value: matches}, nil
+}
+
+// the cursor is the sort it was made for and the sort values of the last
+// record, as base64 encoded bson so types survive the round trip
+type listCursor struct {
+	Sort   string `bson:"s"`
+	Values bson.A `bson:"v"`
+}
+
+func sortSignature(query database.ListQuery) string {
+	keys := []string{}
+	for _, key := range query.SortKeys() {
+		if key.Desc {
+			keys = append(keys, "-"+key.Field)
+		} else {
+			keys = append(keys, key.Field)
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+func encodeCursor(query database.ListQuery, next []interface{}) string {
+	raw, _ := bson.Marshal(listCursor{Sort: sortSignature(query), Values: next})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(cursor string, query database.ListQuery) ([]interface{}, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var decoded listCursor
+	if err := bson.Unmarshal(raw, &decoded); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if decoded.Sort != sortSignature(query) || len(decoded.Values) != len(query.SortKeys()) {
+		return nil, ErrInvalidCursor
+	}
+
+	// only plain values, a document could smuggle operators into the query
+	for _, value := range decoded.Values {
+		switch value.(type) {
+		case nil, string, bool, int32, int64, float64, primitive.ObjectID, primitive.DateTime:
+		default:
+			return nil, ErrInvalidCursor
+		}
+	}
+	return decoded.Values, nil
+}
+
+// PageBody is the body of every list route, NextCursor is null on the last page.
+type PageBody[T any] struct {
+	Data       []T     `json:"data"`
+	NextCursor *string `json:"next_cursor"`
+	Total      int64   `json:"total"`
+}
+
+func NewPageBody[T any](query database.ListQuery, page database.Page[T]) PageBody[T] {
+	body := PageBody[T]{Data: page.Items, Total: page.Total}
+	if body.Data == nil {
+		body.Data = []T{}
+	}
+
+	if page.Next != nil {
+		cursor := encodeCursor(query, page.Next)
+		body.NextCursor = &cursor
+	}
+	return body
+}
@@ -0,0 +1,181 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/textproto"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const JOB_NOTIFICATION_DELIVERY = "notifications.deliver"
+
+// NOTIFICATION_POLL_INTERVAL is how often the outbox is checked for emails to send.
+var NOTIFICATION_POLL_INTERVAL = durationFromEnv("NOTIFICATION_POLL_INTERVAL", 15*time.Second)
+
+// NOTIFICATION_MAX_ATTEMPTS failed sends mark an email as failed, it is not tried again.
+var NOTIFICATION_MAX_ATTEMPTS = int(int64FromEnv("NOTIFICATION_MAX_ATTEMPTS", 8))
+
+// NOTIFICATION_RETRY_BASE is the wait after the first failed send, it doubles
+// with every further one up to NOTIFICATION_RETRY_MAX.
+var (
+	NOTIFICATION_RETRY_BASE = durationFromEnv("NOTIFICATION_RETRY_BASE", 30*time.Second)
+	NOTIFICATION_RETRY_MAX  = durationFromEnv("NOTIFICATION_RETRY_MAX", 6*time.Hour)
+)
+
+// NewNotification turns a message to one recipient into a queued outbox entry.
+func NewNotification(message utils.Message, recipientType string, now time.Time) models.Notification {
+	notification := models.Notification{
+		ID:            primitive.NewObjectID(),
+		RecipientType: recipientType,
+		To:            message.To[0],
+		Subject:       message.Subject,
+		Text:          message.Text,
+		HTML:          message.HTML,
+		Status:        models.NOTIFICATION_QUEUED,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	notification.NotificationId = notification.ID.Hex()
+
+	for _, attachment := range message.Attachments {
+		notification.Attachments = append(notification.Attachments, models.NotificationAttachment{
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			Data:        attachment.Data,
+		})
+	}
+	return notification
+}
+
+// BackfillNotificationParticipants stores the staff member and customer of
+// their interaction on emails queued before emails carried them. Those of a
+// deleted interaction get nil IDs, only callers who may read any interaction
+// see them.
+func BackfillNotificationParticipants(ctx context.Context, notifications database.NotificationStore, interactions database.InteractionStore) error {
+	query := database.ListQuery{
+		Filters: []database.Filter{
+			{Field: "interaction_id", Op: database.FILTER_EXISTS, Value: true},
+			{Field: "user_id", Op: database.FILTER_EXISTS, Value: false},
+		},
+		Limit: LIST_MAX_LIMIT,
+	}
+
+	for {
+		page, err := notifications.List(ctx, query)
+		if err != nil {
+			return err
+		}
+
+		for _, notification := range page.Items {
+			var interaction models.Interaction
+			if id, err := primitive.ObjectIDFromHex(notification.InteractionId); err == nil {
+				interaction, err = interactions.FindByID(ctx, id)
+				if err != nil && !errors.Is(err, database.ErrNotFound) {
+					return err
+				}
+			}
+
+			if err := notifications.SetParticipants(ctx, notification.InteractionId, interaction.UserID, interaction.CustomerID); err != nil {
+				return err
+			}
+		}
+
+		if page.Next == nil {
+			return nil
+		}
+		query.After = page.Next
+	}
+}
+
+// notificationMessage turns an outbox entry back into the message to send.
+func notificationMessage(notification models.Notification) utils.Message {
+	message := utils.Message{
+		ID:      notificationMessageID(notification),
+		To:      []string{notification.To},
+		Subject: notification.Subject,
+		Text:    notification.Text,
+		HTML:    notification.HTML,
+	}
+
+	for _, attachment := range notification.Attachments {
+		message.Attachments = append(message.Attachments, utils.MessageAttachment{
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			Data:        attachment.Data,
+		})
+	}
+	return message
+}
+
+// DeliverNotifications sends every queued email that is due. A failed send is
+// retried with a growing delay, one the mail server refused for good is
+// marked bounced. It only fails when the outbox itself cannot be reached.
+func DeliverNotifications(notifications database.NotificationStore, mailer utils.Mailer) JobHandler {
+	worker := workerName()
+
+	return func(ctx context.Context, job models.Job) error {
+		for ctx.Err() == nil {
+			now := time.Now()
+
+			notification, err := notifications.Claim(ctx, worker, now, now.Add(JOB_LEASE))
+			if errors.Is(err, database.ErrNotFound) {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			sendErr := mailer.Send(ctx, notificationMessage(notification))
+
+			// the job's own context may be gone, settling the email must still reach the store
+			storeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = settleNotification(storeCtx, notifications, notification, worker, sendErr)
+			cancel()
+
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				return err
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+func settleNotification(ctx context.Context, notifications database.NotificationStore, notification models.Notification, worker string, sendErr error) error {
+	switch {
+	case sendErr == nil:
+		return notifications.MarkSent(ctx, notification.ID, worker, time.Now())
+	case isPermanentMailError(sendErr):
+		log.Printf("email %s to %s bounced: %v", notification.NotificationId, notification.To, sendErr)
+		return notifications.Fail(ctx, notification.ID, worker, models.NOTIFICATION_BOUNCED, sendErr.Error())
+	case notification.Attempts >= NOTIFICATION_MAX_ATTEMPTS:
+		log.Printf("email %s to %s failed for good after %d attempts: %v", notification.NotificationId, notification.To, notification.Attempts, sendErr)
+		return notifications.Fail(ctx, notification.ID, worker, models.NOTIFICATION_FAILED, sendErr.Error())
+	default:
+		log.Printf("email %s to %s failed, retrying: %v", notification.NotificationId, notification.To, sendErr)
+		return notifications.Retry(ctx, notification.ID, worker, time.Now().Add(notificationBackoff(notification.Attempts)), sendErr.Error())
+	}
+}
+
+// isPermanentMailError reports whether the mail server answered with a 5xx
+// reply, which sending the same message again will not change.
+func isPermanentMailError(err error) bool {
+	var reply *textproto.Error
+	return errors.As(err, &reply) && reply.Code >= 500 && reply.Code < 600
+}
+
+// notificationBackoff doubles the wait after every failed attempt.
+func notificationBackoff(attempts int) time.Duration {
+	backoff := NOTIFICATION_RETRY_BASE
+	for i := 1; i < attempts && backoff < NOTIFICATION_RETRY_MAX; i++ {
+		backoff *= 2
+	}
+	return min(backoff, NOTIFICATION_RETRY_MAX)
+}
@@ -0,0 +1,229 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PermissionResources lists every resource and the actions that can be granted on it.
+var PermissionResources = map[string][]string{
+	"users":        {"read", "write", "delete"},
+	"customers":    {"read", "write", "delete"},
+	"accounts":     {"read", "write", "delete"},
+	"deals":        {"read", "write", "delete"},
+	"pipelines":    {"read", "write"},
+	"interactions": {"read", "write", "delete"},
+	"tickets":      {"read", "write", "delete", "assign"},
+	"queues":       {"read", "write"},
+	"sla":          {"read", "write"},
+	"roles":        {"read", "write", "assign"},
+	"templates":    {"read", "write"},
+}
+
+// USER_SIGNUP_ROLE is the role every staff user signs up with, other roles are
+// handed out through PUT /staff/users/:user_id/role.
+var USER_SIGNUP_ROLE = stringFromEnv("USER_SIGNUP_ROLE", models.ROLE_READONLY)
+
+// ADMIN_EMAIL and ADMIN_PASSWORD are the first admin, seeded on start when no
+// user has the email yet. Signing up never makes anyone an admin.
+var (
+	ADMIN_EMAIL    = stringFromEnv("ADMIN_EMAIL", "")
+	ADMIN_PASSWORD = stringFromEnv("ADMIN_PASSWORD", "")
+	ADMIN_NAME     = stringFromEnv("ADMIN_NAME", "Admin")
+)
+
+// DefaultRoles are seeded on start when missing, after that the roles collection
+// is the source of truth and admins can change them through the roles API.
+var DefaultRoles = map[string][]string{
+	models.ROLE_ADMIN: allPermissions(models.SCOPE_ANY),
+	models.ROLE_MANAGER: {
+		"users:read:any",
+		"customers:read:any", "customers:write:any",
+		"accounts:read:any", "accounts:write:any", "accounts:delete:any",
+		"deals:read:any", "deals:write:any", "deals:delete:any",
+		"pipelines:read:any", "pipelines:write:any",
+		"interactions:read:any", "interactions:write:any", "interactions:delete:any",
+		"tickets:read:any", "tickets:write:any", "tickets:delete:any", "tickets:assign:any",
+		"queues:read:any", "queues:write:any",
+		"sla:read:any", "sla:write:any",
+		"roles:read:any",
+		"templates:read:any",
+	},
+	models.ROLE_AGENT: {
+		"users:read:own", "users:write:own",
+		"customers:read:any",
+		"accounts:read:any", "accounts:write:own",
+		"deals:read:own", "deals:write:own", "deals:delete:own",
+		"pipelines:read:any",
+		"interactions:read:own", "interactions:write:own", "interactions:delete:own",
+		"tickets:read:any", "tickets:write:any", "tickets:assign:own",
+		"queues:read:any",
+		"sla:read:any",
+	},
+	models.ROLE_READONLY: {
+		"users:read:own",
+		"customers:read:any",
+		"accounts:read:any",
+		"deals:read:any",
+		"pipelines:read:any",
+		"interactions:read:any",
+		"tickets:read:any",
+		"queues:read:any",
+		"sla:read:any",
+	},
+	// USER predates the permission model and keeps the rights of an agent
+	models.ROLE_USER: {
+		"users:read:own", "users:write:own", "users:delete:own",
+		"customers:read:any",
+		"accounts:read:any", "accounts:write:own",
+		"deals:read:own", "deals:write:own", "deals:delete:own",
+		"pipelines:read:any",
+		"interactions:read:own", "interactions:write:own", "interactions:delete:own",
+		"tickets:read:any", "tickets:write:any", "tickets:assign:own",
+		"queues:read:any",
+		"sla:read:any",
+	},
+	// CUSTOMER is applied to every customer token
+	models.ROLE_CUSTOMER: {
+		"customers:read:own", "customers:write:own", "customers:delete:own",
+		"interactions:read:own",
+		"tickets:read:own", "tickets:write:own", "tickets:delete:own",
+	},
+}
+
+func allPermissions(scope string) []string {
+	permissions := []string{}
+	for resource, actions := range PermissionResources {
+		for _, action := range actions {
+			permissions = append(permissions, fmt.Sprintf("%s:%s:%s", resource, action, scope))
+		}
+	}
+	slices.Sort(permissions)
+	return permissions
+}
+
+// ValidatePermission checks a "resource:action:scope" string against PermissionResources.
+func ValidatePermission(permission string) error {
+	parts := strings.Split(permission, ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("permission %q must look like resource:action:scope", permission)
+	}
+
+	actions, ok := PermissionResources[parts[0]]
+	if !ok {
+		return fmt.Errorf("permission %q has an unknown resource", permission)
+	}
+
+	if !slices.Contains(actions, parts[1]) {
+		return fmt.Errorf("permission %q has an unknown action", permission)
+	}
+
+	if parts[2] != models.SCOPE_ANY && parts[2] != models.SCOPE_OWN {
+		return fmt.Errorf("permission %q must be scoped to any or own", permission)
+	}
+	return nil
+}
+
+// GrantedScope returns the widest scope the role grants for "resource:action",
+// or an empty string when the role does not grant it at all. A fully scoped
+// "resource:action:scope" permission must be granted exactly.
+func GrantedScope(role models.Role, permission string) string {
+	if parts := strings.Split(permission, ":"); len(parts) == 3 {
+		if slices.Contains(role.Permissions, permission) {
+			return parts[2]
+		}
+		return ""
+	}
+
+	if slices.Contains(role.Permissions, permission+":"+models.SCOPE_ANY) {
+		return models.SCOPE_ANY
+	}
+
+	if slices.Contains(role.Permissions, permission+":"+models.SCOPE_OWN) {
+		return models.SCOPE_OWN
+	}
+	return ""
+}
+
+// SeedAdmin creates the ADMIN_EMAIL user as an admin. A user who already has
+// the email is left alone, whoever signed up with it first is not promoted.
+func SeedAdmin(ctx context.Context, users database.UserStore) error {
+	if ADMIN_EMAIL == "" {
+		return nil
+	}
+
+	if ADMIN_PASSWORD == "" {
+		return errors.New("ADMIN_PASSWORD must be set with ADMIN_EMAIL")
+	}
+
+	found, err := users.FindByEmail(ctx, ADMIN_EMAIL)
+	if err == nil {
+		if stringValue(found.Role) != models.ROLE_ADMIN {
+			log.Printf("ADMIN_EMAIL %s belongs to a %s user, not seeding an admin", ADMIN_EMAIL, stringValue(found.Role))
+		}
+		return nil
+	}
+
+	if !errors.Is(err, database.ErrNotFound) {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(ADMIN_PASSWORD), 15)
+	if err != nil {
+		return err
+	}
+
+	email, name, password, role := ADMIN_EMAIL, ADMIN_NAME, string(hash), models.ROLE_ADMIN
+	user := models.User{
+		ID:        primitive.NewObjectID(),
+		Name:      &name,
+		Email:     &email,
+		Password:  &password,
+		Role:      &role,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	user.UserId = user.ID.Hex()
+
+	if err := users.Create(ctx, &user); err != nil && !errors.Is(err, database.ErrDuplicate) {
+		return fmt.Errorf("error seeding admin %s: %w", email, err)
+	}
+	return nil
+}
+
+func SeedRoles(ctx context.Context, roles database.RoleStore) error {
+	for name, permissions := range DefaultRoles {
+		_, err := roles.FindByName(ctx, name)
+		if err == nil {
+			continue
+		}
+
+		if !errors.Is(err, database.ErrNotFound) {
+			return err
+		}
+
+		roleName := name
+		role := models.Role{
+			ID:          primitive.NewObjectID(),
+			Name:        &roleName,
+			Permissions: permissions,
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		}
+
+		if err := roles.Create(ctx, &role); err != nil {
+			return fmt.Errorf("error seeding role %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package helpers
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/utils"
+)
+
+// INTERACTION_WINDOW_MAX bounds the ?from=&to= window repeating interactions are expanded in.
+var INTERACTION_WINDOW_MAX = durationFromEnv("INTERACTION_WINDOW_MAX", 366*24*time.Hour)
+
+var (
+	ErrTaskRecurrence     = errors.New("tasks cannot repeat")
+	ErrNotRecurring       = errors.New("interaction does not repeat")
+	ErrOccurrenceNotFound = errors.New("interaction has no such occurrence")
+)
+
+// PrepareRecurrence checks the rule of a repeating interaction and stores it in canonical form.
+func PrepareRecurrence(interaction *models.Interaction) error {
+	if interaction.RRule == "" {
+		return nil
+	}
+
+	if InteractionType(*interaction) == models.INTERACTION_TASK {
+		return ErrTaskRecurrence
+	}
+
+	if interaction.StartTime.IsZero() {
+		return errors.New("a repeating interaction needs a start_time")
+	}
+
+	rule, err := utils.ParseRRule(interaction.RRule)
+	if err != nil {
+		return err
+	}
+
+	interaction.RRule = rule.String()
+	return nil
+}
+
+func IsRecurring(interaction models.Interaction) bool {
+	return interaction.RRule != ""
+}
+
+// Occurrence is the copy of a repeating interaction that starts at start, it
+// keeps the series' ID and marks the occurrence with RecurrenceId.
+func Occurrence(interaction models.Interaction, start time.Time) models.Interaction {
+	occurrence := interaction
+	occurrence.StartTime = start
+	occurrence.EndTime = shiftedEnd(interaction, start)
+	occurrence.RecurrenceId = &start
+	occurrence.Revisions = nil
+	return occurrence
+}
+
+// seriesStart is the first start of a repeating interaction in the zone it was
+// scheduled in, so later occurrences keep its local time across DST changes.
+func seriesStart(interaction models.Interaction) time.Time {
+	return interaction.StartTime.In(InteractionLocation(interaction))
+}
+
+// shiftedEnd is the end time of interaction moved to start, keeping its length.
+func shiftedEnd(interaction models.Interaction, start time.Time) *time.Time {
+	if interaction.EndTime == nil {
+		return nil
+	}
+
+	end := start.Add(InteractionDuration(interaction))
+	return &end
+}
+
+// NextOccurrence is the first start of the interaction after t, zero when it
+// has none. For a one-off interaction that is its own time if still to come.
+func NextOccurrence(interaction models.Interaction, t time.Time) time.Time {
+	if !IsRecurring(interaction) {
+		if at := InteractionTime(interaction); at.After(t) {
+			return at
+		}
+		return time.Time{}
+	}
+
+	rule, err := utils.ParseRRule(interaction.RRule)
+	if err != nil {
+		return time.Time{}
+	}
+
+	next, _ := rule.Next(seriesStart(interaction), t, interaction.ExDates)
+	return next
+}
+
+// OccursAt reports whether the interaction, or one of its occurrences, starts at t.
+func OccursAt(interaction models.Interaction, t time.Time) bool {
+	if !IsRecurring(interaction) {
+		return InteractionTime(interaction).Equal(t)
+	}
+	return NextOccurrence(interaction, t.Add(-time.Nanosecond)).Equal(t)
+}
+
+// ExpandInteractions lists the interactions that happen in [from, to) by start
+// time, a repeating interaction shows up once for each of its occurrences.
+func ExpandInteractions(interactions []models.Interaction, from, to time.Time) []models.Interaction {
+	expanded := []models.Interaction{}
+
+	for _, interaction := range interactions {
+		if !IsRecurring(interaction) {
+			if at := InteractionTime(interaction); !at.IsZero() && !at.Before(from) && at.Before(to) {
+				expanded = append(expanded, interaction)
+			}
+			continue
+		}
+
+		rule, err := utils.ParseRRule(interaction.RRule)
+		if err != nil {
+			continue
+		}
+
+		for _, start := range rule.Occurrences(seriesStart(interaction), from, to, interaction.ExDates) {
+			expanded = append(expanded, Occurrence(interaction, start))
+		}
+	}
+
+	slices.SortStableFunc(expanded, func(a, b models.Interaction) int {
+		return InteractionTime(a).Compare(InteractionTime(b))
+	})
+	return expanded
+}
+
+// SplitSeries ends a repeating interaction just before the occurrence at start
+// and returns the rest of the series as a copy beginning at start, which still
+// needs its own ID. A COUNT is shared out between the two.
+func SplitSeries(interaction models.Interaction, start time.Time) (ended, rest models.Interaction, err error) {
+	rule, err := utils.ParseRRule(interaction.RRule)
+	if err != nil {
+		return ended, rest, err
+	}
+
+	endedRule, restRule := rule, rule
+
+	endedRule.Count = 0
+	endedRule.Until = start.Add(-time.Second)
+	if rule.Count > 0 {
+		restRule.Count = rule.Count - rule.CountBefore(seriesStart(interaction), start)
+	}
+
+	ended, rest = interaction, interaction
+	ended.RRule = endedRule.String()
+	ended.ExDates = nil
+	rest.RRule = restRule.String()
+	rest.ExDates = nil
+	rest.StartTime = start
+	rest.EndTime = shiftedEnd(interaction, start)
+
+	for _, exdate := range interaction.ExDates {
+		if exdate.Before(start) {
+			ended.ExDates = append(ended.ExDates, exdate)
+		} else {
+			rest.ExDates = append(rest.ExDates, exdate)
+		}
+	}
+	return ended, rest, nil
+}
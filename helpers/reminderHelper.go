@@ -0,0 +1,142 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const JOB_INTERACTION_REMINDER = "interaction.reminder"
+
+// INTERACTION_REMINDERS lists how long before an interaction its reminders go
+// out, e.g. "24h,15m", an empty list turns reminders off.
+var INTERACTION_REMINDERS = durationsFromEnv("INTERACTION_REMINDERS", []time.Duration{24 * time.Hour, 15 * time.Minute})
+
+func durationsFromEnv(key string, fallback []time.Duration) []time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	durations := []time.Duration{}
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(part)
+		if err != nil || d <= 0 {
+			log.Printf("ignoring invalid %s entry %q", key, part)
+			continue
+		}
+		durations = append(durations, d)
+	}
+	return durations
+}
+
+// ScheduleInteractionReminders replaces the pending reminders of an interaction
+// with one job per INTERACTION_REMINDERS offset, each for the next start that
+// is still far enough away, so a repeating interaction always has its next
+// occurrence covered.
+func ScheduleInteractionReminders(ctx context.Context, jobs database.JobStore, interaction models.Interaction, now time.Time) error {
+	if err := CancelInteractionReminders(ctx, jobs, interaction.InteractionId); err != nil {
+		return err
+	}
+
+	if InteractionType(interaction) == models.INTERACTION_FOLLOWUP {
+		return nil
+	}
+
+	if interaction.Task != nil && interaction.Task.Completed {
+		return nil
+	}
+
+	for _, before := range INTERACTION_REMINDERS {
+		at := NextOccurrence(interaction, now.Add(before))
+		if at.IsZero() {
+			continue
+		}
+
+		job := models.Job{
+			ID:   primitive.NewObjectID(),
+			Key:  fmt.Sprintf("%s:%s:%s", JOB_INTERACTION_REMINDER, interaction.InteractionId, before),
+			Kind: JOB_INTERACTION_REMINDER,
+			Ref:  interaction.InteractionId,
+			Payload: map[string]string{
+				"interaction_id": interaction.InteractionId,
+				"at":             at.UTC().Format(time.RFC3339Nano),
+				"before":         before.String(),
+			},
+			RunAt:     at.Add(-before),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+
+		if err := jobs.Schedule(ctx, &job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func CancelInteractionReminders(ctx context.Context, jobs database.JobStore, interactionId string) error {
+	return jobs.CancelByRef(ctx, JOB_INTERACTION_REMINDER, interactionId)
+}
+
+// SendInteractionReminder handles reminder jobs, a reminder whose interaction
+// is gone, done or moved to another time is dropped without sending. A
+// repeating interaction gets the reminders for its next occurrence queued.
+func SendInteractionReminder(interactions database.InteractionStore, users database.UserStore, customers database.CustomerStore, jobs database.JobStore, notifications database.NotificationStore, templates database.EmailTemplateStore) JobHandler {
+	return func(ctx context.Context, job models.Job) error {
+		id, err := primitive.ObjectIDFromHex(job.Payload["interaction_id"])
+		if err != nil {
+			return err
+		}
+
+		at, err := time.Parse(time.RFC3339Nano, job.Payload["at"])
+		if err != nil {
+			return err
+		}
+
+		interaction, err := interactions.FindByID(ctx, id)
+		if errors.Is(err, database.ErrNotFound) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if OccursAt(interaction, at) && (interaction.Task == nil || !interaction.Task.Completed) {
+			reminded := interaction
+			if IsRecurring(interaction) {
+				reminded = Occurrence(interaction, at)
+			}
+
+			// the reminder is queued before the next occurrence is
+			user, customer := InteractionContacts(ctx, users, customers, reminded)
+			outbox, err := InteractionNotifications(ctx, templates, reminded, utils.NOTICE_REMINDER, user, customer, time.Now())
+			if err != nil {
+				return err
+			}
+
+			if err := notifications.Enqueue(ctx, outbox); err != nil {
+				return err
+			}
+		}
+
+		if IsRecurring(interaction) {
+			return ScheduleInteractionReminders(ctx, jobs, interaction, time.Now())
+		}
+		return nil
+	}
+}
@@ -0,0 +1,158 @@
+package helpers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// JOB_POLL_INTERVAL is how often an idle scheduler looks for due jobs.
+var JOB_POLL_INTERVAL = durationFromEnv("JOB_POLL_INTERVAL", 10*time.Second)
+
+// JOB_LEASE is how long a claimed job belongs to one replica, a replica that
+// dies mid-job gives it up to the others once the lease runs out.
+var JOB_LEASE = durationFromEnv("JOB_LEASE", 2*time.Minute)
+
+// JOB_MAX_ATTEMPTS failed runs mark a one-off job as failed, recurring jobs always retry.
+var JOB_MAX_ATTEMPTS = int(int64FromEnv("JOB_MAX_ATTEMPTS", 5))
+
+// JobHandler does the work of one job kind, an error schedules a retry.
+type JobHandler func(ctx context.Context, job models.Job) error
+
+// Scheduler runs the jobs kept in the JobStore, any number of replicas can run
+// one against the same database and each due job is claimed by only one of them.
+type Scheduler struct {
+	jobs     database.JobStore
+	worker   string
+	handlers map[string]JobHandler
+}
+
+func NewScheduler(jobs database.JobStore) *Scheduler {
+	return &Scheduler{
+		jobs:     jobs,
+		worker:   workerName(),
+		handlers: map[string]JobHandler{},
+	}
+}
+
+// workerName tells the replicas holding leases apart.
+func workerName() string {
+	hostname, _ := os.Hostname()
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// Handle registers the handler for a job kind, call it before Run.
+func (s *Scheduler) Handle(kind string, handler JobHandler) {
+	s.handlers[kind] = handler
+}
+
+// Every registers handler and makes sure a recurring job of its kind exists.
+func (s *Scheduler) Every(ctx context.Context, kind string, interval time.Duration, handler JobHandler) error {
+	s.Handle(kind, handler)
+
+	now := time.Now()
+	job := models.Job{
+		ID:        primitive.NewObjectID(),
+		Key:       kind,
+		Kind:      kind,
+		RunAt:     now.Add(interval),
+		Interval:  interval,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	return s.jobs.EnsureRecurring(ctx, &job)
+}
+
+// Run claims and runs due jobs until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(JOB_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		// drain everything that is due before waiting for the next tick
+		for ctx.Err() == nil && s.runNext(ctx) {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNext runs one due job, it reports false when there was none.
+func (s *Scheduler) runNext(ctx context.Context) bool {
+	now := time.Now()
+
+	job, err := s.jobs.Claim(ctx, s.worker, now, now.Add(JOB_LEASE))
+	if errors.Is(err, database.ErrNotFound) {
+		return false
+	}
+
+	if err != nil {
+		log.Printf("error claiming job: %v", err)
+		return false
+	}
+
+	handler, ok := s.handlers[job.Kind]
+	if !ok {
+		err = fmt.Errorf("no handler for job kind %q", job.Kind)
+	} else {
+		// stop before the lease runs out so no other replica picks the job up while it still runs
+		runCtx, cancel := context.WithTimeout(ctx, JOB_LEASE)
+		err = handler(runCtx, job)
+		cancel()
+	}
+
+	// the job's own context may be gone, finishing it must still reach the store
+	storeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	switch {
+	case err == nil:
+		err = s.jobs.Complete(storeCtx, job, s.worker, time.Now())
+	case job.Interval <= 0 && job.Attempts >= JOB_MAX_ATTEMPTS:
+		log.Printf("job %s failed for good after %d attempts: %v", job.Key, job.Attempts, err)
+		err = s.jobs.Fail(storeCtx, job.ID, s.worker, err.Error())
+	default:
+		log.Printf("job %s failed, retrying: %v", job.Key, err)
+		err = s.jobs.Retry(storeCtx, job.ID, s.worker, time.Now().Add(jobBackoff(job)), err.Error())
+	}
+
+	if errors.Is(err, database.ErrNotFound) {
+		// rescheduled or cancelled while it ran, or the lease expired, the store already has the newer state
+		return true
+	}
+
+	if err != nil {
+		log.Printf("error finishing job %s: %v", job.Key, err)
+	}
+	return true
+}
+
+// jobBackoff waits longer after every failed attempt, a recurring job never
+// waits longer than its own interval.
+func jobBackoff(job models.Job) time.Duration {
+	backoff := time.Duration(job.Attempts*job.Attempts) * 30 * time.Second
+	if backoff > time.Hour {
+		backoff = time.Hour
+	}
+
+	if job.Interval > 0 && backoff > job.Interval {
+		backoff = job.Interval
+	}
+	return backoff
+}
@@ -0,0 +1,225 @@
+package helpers
+
+import (
+	"context"
+	"slices"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// the kinds of records search covers
+const (
+	SEARCH_CUSTOMERS    = "customers"
+	SEARCH_TICKETS      = "tickets"
+	SEARCH_INTERACTIONS = "interactions"
+)
+
+// SEARCH_DEFAULT_LIMIT is how many results of each kind a search returns
+// without ?limit=, SEARCH_MAX_LIMIT the most a caller may ask for and
+// SEARCH_MAX_LENGTH the longest search text in characters.
+const (
+	SEARCH_DEFAULT_LIMIT = 10
+	SEARCH_MAX_LIMIT     = 50
+	SEARCH_MAX_LENGTH    = 200
+)
+
+// SearchScopes holds the kinds of records a caller may search, with the
+// filters keeping them to the records they may see, none for every record.
+// The ticket filters also scope comments, so they may only use the fields
+// comments copy from their ticket, customer_id and assignee_id.
+type SearchScopes struct {
+	Kinds map[string][]database.Filter
+	// InternalNotes is whether internal ticket notes are searched
+	InternalNotes bool
+}
+
+// GetSearchScopes works out what the role lets the principal read, the same
+// way the read routes do: "any" is every record, "own" a customer's own
+// records or the interactions a staff member holds.
+func GetSearchScopes(role models.Role, principal Principal) SearchScopes {
+	scopes := SearchScopes{Kinds: map[string][]database.Filter{}, InternalNotes: principal.IsUser()}
+
+	id, err := primitive.ObjectIDFromHex(principal.Id)
+	if err != nil {
+		return scopes
+	}
+
+	switch scope := GrantedScope(role, "customers:read"); {
+	case scope == models.SCOPE_ANY:
+		scopes.Kinds[SEARCH_CUSTOMERS] = nil
+	case scope == models.SCOPE_OWN && principal.IsCustomer():
+		scopes.Kinds[SEARCH_CUSTOMERS] = []database.Filter{database.Eq("_id", id)}
+	}
+
+	switch scope := GrantedScope(role, "tickets:read"); {
+	case scope == models.SCOPE_ANY:
+		scopes.Kinds[SEARCH_TICKETS] = nil
+	case scope == models.SCOPE_OWN && principal.IsCustomer():
+		scopes.Kinds[SEARCH_TICKETS] = []database.Filter{database.Eq("customer_id", id)}
+	}
+
+	switch scope := GrantedScope(role, "interactions:read"); {
+	case scope == models.SCOPE_ANY:
+		scopes.Kinds[SEARCH_INTERACTIONS] = nil
+	case scope == models.SCOPE_OWN && principal.IsCustomer():
+		scopes.Kinds[SEARCH_INTERACTIONS] = []database.Filter{database.Eq("customer_id", id)}
+	case scope == models.SCOPE_OWN:
+		scopes.Kinds[SEARCH_INTERACTIONS] = []database.Filter{database.Eq("user_id", id)}
+	}
+	return scopes
+}
+
+type SearchResult[T any] struct {
+	Score  float64 `json:"score"`
+	Record T       `json:"record"`
+}
+
+// TicketSearchResult is a ticket whose description or comments matched,
+// Comments are the matching ones.
+type TicketSearchResult struct {
+	Score    float64                `json:"score"`
+	Record   models.Ticket          `json:"record"`
+	Comments []models.TicketComment `json:"comments,omitempty"`
+}
+
+// SearchResults groups the matches by kind, best first. A kind that was not
+// searched, because the caller may not see it or did not ask, is nil.
+type SearchResults struct {
+	Query        string                             `json:"query"`
+	Customers    []SearchResult[models.Customer]    `json:"customers"`
+	Tickets      []TicketSearchResult               `json:"tickets"`
+	Interactions []SearchResult[models.Interaction] `json:"interactions"`
+}
+
+// SearchRecords runs text against every kind in kinds the scopes allow.
+func SearchRecords(ctx context.Context, customers database.CustomerStore, tickets database.TicketStore, comments database.CommentStore, interactions database.InteractionStore, text string, kinds []string, limit int, scopes SearchScopes) (SearchResults, error) {
+	results := SearchResults{Query: text}
+
+	allowed := func(kind string) ([]database.Filter, bool) {
+		filters, ok := scopes.Kinds[kind]
+		return filters, ok && slices.Contains(kinds, kind)
+	}
+
+	if filters, ok := allowed(SEARCH_CUSTOMERS); ok {
+		hits, err := customers.Search(ctx, database.TextSearch{Text: text, Filters: filters, Limit: limit})
+		if err != nil {
+			return results, err
+		}
+
+		results.Customers = []SearchResult[models.Customer]{}
+		for _, hit := range hits {
+			hit.Item.Password = nil
+			results.Customers = append(results.Customers, SearchResult[models.Customer]{Score: hit.Score, Record: hit.Item})
+		}
+	}
+
+	if filters, ok := allowed(SEARCH_TICKETS); ok {
+		found, err := searchTickets(ctx, tickets, comments, text, limit, filters, scopes.InternalNotes)
+		if err != nil {
+			return results, err
+		}
+		results.Tickets = found
+	}
+
+	if filters, ok := allowed(SEARCH_INTERACTIONS); ok {
+		hits, err := interactions.Search(ctx, database.TextSearch{Text: text, Filters: filters, Limit: limit})
+		if err != nil {
+			return results, err
+		}
+
+		results.Interactions = []SearchResult[models.Interaction]{}
+		for _, hit := range hits {
+			results.Interactions = append(results.Interactions, SearchResult[models.Interaction]{Score: hit.Score, Record: hit.Item})
+		}
+	}
+	return results, nil
+}
+
+// searchTickets matches ticket descriptions and comments, a ticket found by
+// both scores the description's match plus its best comment's. A caller kept
+// to some tickets only has the comments on those searched.
+func searchTickets(ctx context.Context, tickets database.TicketStore, comments database.CommentStore, text string, limit int, filters []database.Filter, internalNotes bool) ([]TicketSearchResult, error) {
+	ticketHits, err := tickets.Search(ctx, database.TextSearch{Text: text, Filters: filters, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	commentFilters := []database.Filter{}
+	if !internalNotes {
+		commentFilters = append(commentFilters, database.Eq("internal", false))
+	}
+
+	// comments carry their ticket's customer_id and assignee_id, the ticket scope applies to them as is
+	commentFilters = append(commentFilters, filters...)
+
+	commentHits, err := comments.Search(ctx, database.TextSearch{Text: text, Filters: commentFilters, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []TicketSearchResult{}
+	index := map[primitive.ObjectID]int{}
+	for _, hit := range ticketHits {
+		index[hit.Item.ID] = len(results)
+		results = append(results, TicketSearchResult{Score: hit.Score, Record: hit.Item})
+	}
+
+	// tickets found only by a comment are read here, within the caller's scope
+	missing := bson.A{}
+	for _, hit := range commentHits {
+		if _, ok := index[hit.Item.TicketID]; !ok && !slices.Contains(missing, interface{}(hit.Item.TicketID)) {
+			missing = append(missing, hit.Item.TicketID)
+		}
+	}
+
+	if len(missing) > 0 {
+		query := database.ListQuery{
+			Filters: append(slices.Clone(filters), database.Filter{Field: "_id", Op: database.FILTER_IN, Value: missing}),
+			Limit:   len(missing),
+		}
+
+		page, err := tickets.List(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ticket := range page.Items {
+			index[ticket.ID] = len(results)
+			results = append(results, TicketSearchResult{Record: ticket})
+		}
+	}
+
+	best := map[primitive.ObjectID]float64{}
+	for _, hit := range commentHits {
+		i, ok := index[hit.Item.TicketID]
+		if !ok {
+			// a ticket the caller may not see
+			continue
+		}
+
+		results[i].Comments = append(results[i].Comments, hit.Item)
+		best[hit.Item.TicketID] = max(best[hit.Item.TicketID], hit.Score)
+	}
+
+	for i := range results {
+		results[i].Score += best[results[i].Record.ID]
+	}
+
+	slices.SortStableFunc(results, func(a, b TicketSearchResult) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
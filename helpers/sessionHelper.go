@@ -0,0 +1,140 @@
+package helpers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ACCESS_TOKEN_TTL = durationFromEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+var REFRESH_TOKEN_TTL = durationFromEnv("REFRESH_TOKEN_TTL", 7*24*time.Hour)
+
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid or has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used, session revoked")
+)
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+// NewRefreshToken returns an opaque refresh token and the hash that is stored in its place.
+func NewRefreshToken() (token, hash string, err error) {
+	return newOpaqueToken("refresh token")
+}
+
+func HashRefreshToken(token string) string {
+	return hashOpaqueToken(token)
+}
+
+// newOpaqueToken returns a random URL safe token and its sha256 hash, only the
+// hash is ever stored.
+func newOpaqueToken(purpose string) (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("error generating %s: %v", purpose, err)
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashOpaqueToken(token), nil
+}
+
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartSession opens a new session family for the principal and returns its first refresh token.
+func StartSession(ctx context.Context, sessions database.SessionStore, principalType, principalId string) (models.Session, string, error) {
+	refreshToken, hash, err := NewRefreshToken()
+	if err != nil {
+		return models.Session{}, "", err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ID:                     primitive.NewObjectID(),
+		PrincipalType:          principalType,
+		PrincipalId:            principalId,
+		RefreshTokenHash:       hash,
+		UsedRefreshTokenHashes: []string{},
+		ExpiresAt:              now.Add(REFRESH_TOKEN_TTL),
+		CreatedAt:              now,
+		UpdatedAt:              now,
+	}
+	session.SessionId = session.ID.Hex()
+
+	if err := sessions.Create(ctx, &session); err != nil {
+		return models.Session{}, "", err
+	}
+
+	return session, refreshToken, nil
+}
+
+// RotateSession exchanges a refresh token for a new one. Presenting a token that
+// was already rotated out revokes the session and every access token issued from it.
+func RotateSession(ctx context.Context, sessions database.SessionStore, revocations database.RevocationStore, principalType, refreshToken string) (models.Session, string, error) {
+	hash := HashRefreshToken(refreshToken)
+
+	session, err := sessions.FindByRefreshToken(ctx, hash)
+	if errors.Is(err, database.ErrNotFound) {
+		return session, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return session, "", err
+	}
+
+	if session.PrincipalType != principalType || session.RevokedAt != nil || session.ExpiresAt.Before(time.Now()) {
+		return session, "", ErrInvalidRefreshToken
+	}
+
+	if session.RefreshTokenHash != hash {
+		if err := RevokeSession(ctx, sessions, revocations, session.SessionId); err != nil {
+			return session, "", err
+		}
+		return session, "", ErrRefreshTokenReused
+	}
+
+	newToken, newHash, err := NewRefreshToken()
+	if err != nil {
+		return session, "", err
+	}
+
+	err = sessions.Rotate(ctx, session.SessionId, hash, newHash, time.Now().Add(REFRESH_TOKEN_TTL))
+	if errors.Is(err, database.ErrNotFound) {
+		// another request rotated this token first, treat it as a replay
+		if err := RevokeSession(ctx, sessions, revocations, session.SessionId); err != nil {
+			return session, "", err
+		}
+		return session, "", ErrRefreshTokenReused
+	}
+	if err != nil {
+		return session, "", err
+	}
+
+	return session, newToken, nil
+}
+
+// RevokeSession kills the refresh token family and, through the deny list, any
+// access token still carrying this session id.
+func RevokeSession(ctx context.Context, sessions database.SessionStore, revocations database.RevocationStore, sessionId string) error {
+	if err := sessions.Revoke(ctx, sessionId, time.Now()); err != nil && !errors.Is(err, database.ErrNotFound) {
+		return err
+	}
+
+	return revocations.Revoke(ctx, sessionId, time.Now().Add(ACCESS_TOKEN_TTL))
+}
@@ -0,0 +1,202 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+)
+
+var SLA_CHECK_INTERVAL = durationFromEnv("SLA_CHECK_INTERVAL", 5*time.Minute)
+
+var TicketPriorities = []string{models.PRIORITY_LOW, models.PRIORITY_NORMAL, models.PRIORITY_HIGH, models.PRIORITY_URGENT}
+
+// a calendar whose windows never match would loop forever, give up after ten years
+const maxCalendarDays = 3650
+
+func IsTicketPriority(priority string) bool {
+	return slices.Contains(TicketPriorities, priority)
+}
+
+// AddBusinessTime returns the moment d of working time after start, counting only
+// the calendar's business hours and skipping its holidays. A nil calendar counts
+// round the clock.
+func AddBusinessTime(calendar *models.BusinessCalendar, start time.Time, d time.Duration) time.Time {
+	if calendar == nil || len(calendar.Hours) == 0 {
+		return start.Add(d)
+	}
+
+	location, err := time.LoadLocation(calendar.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	hours := slices.Clone(calendar.Hours)
+	sort.Slice(hours, func(i, j int) bool { return hours[i].Start < hours[j].Start })
+
+	current := start.In(location)
+	remaining := d
+
+	for day := 0; day < maxCalendarDays; day++ {
+		date := time.Date(current.Year(), current.Month(), current.Day(), 0, 0, 0, 0, location).AddDate(0, 0, day)
+
+		if slices.Contains(calendar.Holidays, date.Format("2006-01-02")) {
+			continue
+		}
+
+		for _, window := range hours {
+			if window.Weekday != int(date.Weekday()) {
+				continue
+			}
+
+			opens, errStart := time.ParseInLocation("2006-01-02 15:04", date.Format("2006-01-02")+" "+window.Start, location)
+			closes, errEnd := time.ParseInLocation("2006-01-02 15:04", date.Format("2006-01-02")+" "+window.End, location)
+			if errStart != nil || errEnd != nil || !closes.After(current) {
+				continue
+			}
+
+			if opens.Before(current) {
+				opens = current
+			}
+
+			available := closes.Sub(opens)
+			if remaining <= available {
+				return opens.Add(remaining).In(start.Location())
+			}
+			remaining -= available
+		}
+	}
+	return start.Add(d)
+}
+
+// SelectSlaPolicy returns the policy for the priority, preferring one dedicated
+// to the customer's company over the generic one, or ErrNotFound.
+func SelectSlaPolicy(ctx context.Context, slas database.SlaStore, priority, company string) (models.SlaPolicy, error) {
+	policies, err := slas.ListPolicies(ctx)
+	if err != nil {
+		return models.SlaPolicy{}, err
+	}
+
+	var generic *models.SlaPolicy
+	for i, policy := range policies {
+		if policy.Priority == nil || *policy.Priority != priority {
+			continue
+		}
+
+		if policy.Company == nil || *policy.Company == "" {
+			if generic == nil {
+				generic = &policies[i]
+			}
+			continue
+		}
+
+		if company != "" && *policy.Company == company {
+			return policy, nil
+		}
+	}
+
+	if generic == nil {
+		return models.SlaPolicy{}, database.ErrNotFound
+	}
+	return *generic, nil
+}
+
+// ApplySlaPolicy sets the ticket's SLA policy and due dates, counted from when
+// the ticket was raised. Tickets no policy covers get no due dates.
+func ApplySlaPolicy(ctx context.Context, slas database.SlaStore, ticket *models.Ticket, company string) error {
+	ticket.SlaPolicy = ""
+	ticket.FirstResponseDue = nil
+	ticket.ResolutionDue = nil
+
+	policy, err := SelectSlaPolicy(ctx, slas, ticket.Priority, company)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var calendar *models.BusinessCalendar
+	if policy.Calendar != "" {
+		found, err := slas.FindCalendarByName(ctx, policy.Calendar)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			return err
+		}
+
+		if err == nil {
+			calendar = &found
+		}
+	}
+
+	firstResponseDue := AddBusinessTime(calendar, ticket.CreatedAt, time.Duration(policy.FirstResponseMinutes)*time.Minute)
+	resolutionDue := AddBusinessTime(calendar, ticket.CreatedAt, time.Duration(policy.ResolutionMinutes)*time.Minute)
+
+	ticket.SlaPolicy = *policy.Name
+	ticket.FirstResponseDue = &firstResponseDue
+	ticket.ResolutionDue = &resolutionDue
+	return nil
+}
+
+// RecordFirstResponse stamps the first staff response on the ticket and flags
+// the first response target when it came late.
+func RecordFirstResponse(ctx context.Context, tickets database.TicketStore, ticket models.Ticket, at time.Time) error {
+	if ticket.FirstRespondedAt != nil {
+		return nil
+	}
+
+	err := tickets.MarkFirstResponse(ctx, ticket.ID, at)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if ticket.FirstResponseDue != nil && at.After(*ticket.FirstResponseDue) {
+		return tickets.FlagSlaBreach(ctx, ticket.ID, models.SLA_FIRST_RESPONSE)
+	}
+	return nil
+}
+
+// FlagSlaBreaches marks every ticket that went past a due date without a first
+// response or a resolution, and returns how many breaches were flagged.
+func FlagSlaBreaches(ctx context.Context, tickets database.TicketStore) (int, error) {
+	now := time.Now()
+
+	overdue, err := tickets.ListSlaOverdue(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	flagged := 0
+	for _, ticket := range overdue {
+		breaches := []string{}
+
+		if ticket.FirstResponseDue != nil && ticket.FirstResponseDue.Before(now) && ticket.FirstRespondedAt == nil {
+			breaches = append(breaches, models.SLA_FIRST_RESPONSE)
+		}
+
+		if ticket.ResolutionDue != nil && ticket.ResolutionDue.Before(now) && ticket.Status != nil &&
+			(*ticket.Status == models.TICKET_OPEN || *ticket.Status == models.TICKETIN_PROGRESS) {
+			breaches = append(breaches, models.SLA_RESOLUTION)
+		}
+
+		for _, breach := range breaches {
+			if slices.Contains(ticket.SlaBreaches, breach) {
+				continue
+			}
+
+			if err := tickets.FlagSlaBreach(ctx, ticket.ID, breach); err != nil && !errors.Is(err, database.ErrNotFound) {
+				return flagged, err
+			}
+			flagged++
+		}
+	}
+	return flagged, nil
+}
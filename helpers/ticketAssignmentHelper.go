@@ -0,0 +1,184 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TICKET_DEFAULT_QUEUE receives tickets raised without a queue.
+var TICKET_DEFAULT_QUEUE = stringFromEnv("TICKET_DEFAULT_QUEUE", "support")
+
+var (
+	ErrNoAvailableAgent = errors.New("queue has no staff member able to take tickets")
+	ErrUnknownQueue     = errors.New("queue does not exist")
+	ErrQueueBusy        = errors.New("queue is busy assigning other tickets, try again")
+)
+
+func stringFromEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// CanWorkTickets reports whether the user's role allows them to be assigned tickets.
+func CanWorkTickets(ctx context.Context, roles database.RoleStore, user models.User) bool {
+	if user.Role == nil {
+		return false
+	}
+
+	role, err := roles.FindByName(ctx, *user.Role)
+	if err != nil {
+		return false
+	}
+	return GrantedScope(role, "tickets:assign") != ""
+}
+
+// queueCandidates returns the members of queue that can currently take tickets, in queue order.
+func queueCandidates(ctx context.Context, users database.UserStore, roles database.RoleStore, queue models.Queue) []models.User {
+	candidates := []models.User{}
+	for _, memberId := range queue.Members {
+		user, err := users.FindByID(ctx, memberId)
+		if err != nil || !CanWorkTickets(ctx, roles, user) {
+			continue
+		}
+		candidates = append(candidates, user)
+	}
+	return candidates
+}
+
+// PickAssignee chooses the member of the named queue who should receive the
+// next ticket, by round-robin or by fewest active tickets as the queue is configured.
+func PickAssignee(ctx context.Context, queues database.QueueStore, users database.UserStore, roles database.RoleStore, tickets database.TicketStore, queueName string) (primitive.ObjectID, error) {
+	// a concurrent assignment can move the round-robin cursor, retry from the fresh queue a few times
+	for attempt := 0; attempt < 3; attempt++ {
+		queue, err := queues.FindByName(ctx, queueName)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+
+		candidates := queueCandidates(ctx, users, roles, queue)
+		if len(candidates) == 0 {
+			return primitive.NilObjectID, ErrNoAvailableAgent
+		}
+
+		if queue.Strategy == models.QUEUE_LEAST_LOADED {
+			return leastLoaded(ctx, tickets, candidates)
+		}
+
+		next := candidates[0]
+		for i, candidate := range candidates {
+			if candidate.UserId == queue.LastAssignedId {
+				next = candidates[(i+1)%len(candidates)]
+				break
+			}
+		}
+
+		err = queues.AdvanceCursor(ctx, queueName, queue.LastAssignedId, next.UserId)
+		if errors.Is(err, database.ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		return next.ID, nil
+	}
+	return primitive.NilObjectID, ErrQueueBusy
+}
+
+func leastLoaded(ctx context.Context, tickets database.TicketStore, candidates []models.User) (primitive.ObjectID, error) {
+	best := primitive.NilObjectID
+	bestLoad := int64(-1)
+
+	for _, candidate := range candidates {
+		load, err := tickets.CountActiveByAssignee(ctx, candidate.ID)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+
+		if bestLoad < 0 || load < bestLoad {
+			best, bestLoad = candidate.ID, load
+		}
+	}
+	return best, nil
+}
+
+// OpenTicket readies a ticket the customer raises for saving: it starts open,
+// gets the SLA due dates of its priority and is handed to one of the staff of
+// its queue, or TICKET_DEFAULT_QUEUE. An unknown default queue leaves the
+// ticket unrouted for staff to claim, an unknown requested one is ErrUnknownQueue
+// and a queue too contended to pick an assignee is ErrQueueBusy.
+func OpenTicket(ctx context.Context, tickets database.TicketStore, queues database.QueueStore, users database.UserStore, roles database.RoleStore, slas database.SlaStore, ticket *models.Ticket, customer models.Customer) error {
+	// every ticket starts open, later moves go through the ticket workflow
+	status := models.TICKET_OPEN
+	ticket.Status = &status
+	ticket.ResolvedAt = nil
+	ticket.ClosedAt = nil
+
+	ticket.CustomerID = customer.ID
+	ticket.AccountID = customer.AccountID
+	ticket.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	ticket.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	ticket.ID = primitive.NewObjectID()
+	ticket.TicketId = ticket.ID.Hex()
+	ticket.StatusHistory = []models.TicketStatusChange{{
+		To:        status,
+		ActorType: models.PRINCIPAL_CUSTOMER,
+		ActorId:   customer.ID.Hex(),
+		ChangedAt: ticket.CreatedAt,
+	}}
+
+	ticket.AssigneeID = nil
+	ticket.AssignedAt = nil
+
+	if ticket.Priority == "" {
+		ticket.Priority = models.PRIORITY_NORMAL
+	}
+
+	company := ""
+	if customer.Company != nil {
+		company = *customer.Company
+	}
+
+	ticket.FirstRespondedAt = nil
+	ticket.SlaBreached = false
+	ticket.SlaBreaches = nil
+	if err := ApplySlaPolicy(ctx, slas, ticket, company); err != nil {
+		return fmt.Errorf("error applying SLA policy: %w", err)
+	}
+
+	queueName := ticket.Queue
+	if queueName == "" {
+		queueName = TICKET_DEFAULT_QUEUE
+	}
+
+	_, err := queues.FindByName(ctx, queueName)
+	switch {
+	case err == nil:
+		ticket.Queue = queueName
+		assigneeId, err := PickAssignee(ctx, queues, users, roles, tickets, queueName)
+		if err == nil {
+			ticket.AssigneeID = &assigneeId
+			ticket.AssignedAt = &ticket.CreatedAt
+		} else if errors.Is(err, ErrQueueBusy) {
+			return err
+		} else if !errors.Is(err, ErrNoAvailableAgent) {
+			log.Printf("error assigning ticket %s: %v", ticket.TicketId, err)
+		}
+	case errors.Is(err, database.ErrNotFound) && ticket.Queue == "":
+	case errors.Is(err, database.ErrNotFound):
+		return ErrUnknownQueue
+	default:
+		return fmt.Errorf("error fetching queue: %w", err)
+	}
+	return nil
+}
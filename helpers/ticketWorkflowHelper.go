@@ -0,0 +1,196 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TICKET_ACTOR_STAFF in a transition's roles admits every staff user whatever their role.
+const TICKET_ACTOR_STAFF = "STAFF"
+
+var TICKET_AUTO_CLOSE_AFTER = durationFromEnv("TICKET_AUTO_CLOSE_AFTER", 7*24*time.Hour)
+var TICKET_AUTO_CLOSE_INTERVAL = durationFromEnv("TICKET_AUTO_CLOSE_INTERVAL", time.Hour)
+var TICKET_REOPEN_WINDOW = durationFromEnv("TICKET_REOPEN_WINDOW", 14*24*time.Hour)
+
+var TicketStatuses = []string{models.TICKET_OPEN, models.TICKETIN_PROGRESS, models.TICKET_RESOLVED, models.TICKET_CLOSED}
+
+var (
+	ErrInvalidTicketStatus    = errors.New("status must be one of open, in_progress, resolved or closed")
+	ErrTicketTransitionDenied = errors.New("ticket status change is not allowed")
+	ErrTicketReopenExpired    = errors.New("ticket was resolved too long ago to be reopened")
+	ErrTicketStatusConflict   = errors.New("ticket status was changed by someone else, reload and retry")
+	ErrTicketStatusUnchanged  = errors.New("ticket already has this status")
+)
+
+// TicketTransition lets the listed roles move a ticket from one status to another.
+type TicketTransition struct {
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Roles []string `json:"roles"`
+}
+
+// TicketWorkflow is the default state machine, LoadTicketWorkflow replaces it
+// with the transitions from TICKET_WORKFLOW_FILE. Customers may confirm or
+// reopen a resolved ticket within TICKET_REOPEN_WINDOW, only managers reopen closed ones.
+var TicketWorkflow = []TicketTransition{
+	{From: models.TICKET_OPEN, To: models.TICKETIN_PROGRESS, Roles: []string{TICKET_ACTOR_STAFF}},
+	{From: models.TICKET_OPEN, To: models.TICKET_RESOLVED, Roles: []string{TICKET_ACTOR_STAFF}},
+	{From: models.TICKET_OPEN, To: models.TICKET_CLOSED, Roles: []string{models.ROLE_ADMIN, models.ROLE_MANAGER, models.ROLE_CUSTOMER}},
+	{From: models.TICKETIN_PROGRESS, To: models.TICKET_OPEN, Roles: []string{TICKET_ACTOR_STAFF}},
+	{From: models.TICKETIN_PROGRESS, To: models.TICKET_RESOLVED, Roles: []string{TICKET_ACTOR_STAFF}},
+	{From: models.TICKETIN_PROGRESS, To: models.TICKET_CLOSED, Roles: []string{models.ROLE_ADMIN, models.ROLE_MANAGER}},
+	{From: models.TICKET_RESOLVED, To: models.TICKET_OPEN, Roles: []string{TICKET_ACTOR_STAFF, models.ROLE_CUSTOMER}},
+	{From: models.TICKET_RESOLVED, To: models.TICKET_CLOSED, Roles: []string{TICKET_ACTOR_STAFF, models.ROLE_CUSTOMER}},
+	{From: models.TICKET_CLOSED, To: models.TICKET_OPEN, Roles: []string{models.ROLE_ADMIN, models.ROLE_MANAGER}},
+}
+
+// LoadTicketWorkflow replaces TicketWorkflow with the JSON array of transitions stored at path.
+func LoadTicketWorkflow(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading ticket workflow: %w", err)
+	}
+
+	var workflow []TicketTransition
+	if err := json.Unmarshal(raw, &workflow); err != nil {
+		return fmt.Errorf("error parsing ticket workflow: %w", err)
+	}
+
+	for _, transition := range workflow {
+		if !slices.Contains(TicketStatuses, transition.From) || !slices.Contains(TicketStatuses, transition.To) || len(transition.Roles) == 0 {
+			return fmt.Errorf("ticket workflow has an invalid transition %q -> %q", transition.From, transition.To)
+		}
+	}
+
+	TicketWorkflow = workflow
+	return nil
+}
+
+func IsTicketStatus(status string) bool {
+	return slices.Contains(TicketStatuses, status)
+}
+
+// CanTransitionTicket reports whether actor may move a ticket between the two statuses.
+func CanTransitionTicket(actor Principal, from, to string) bool {
+	if actor.Type == models.PRINCIPAL_SYSTEM {
+		return true
+	}
+
+	for _, transition := range TicketWorkflow {
+		if transition.From != from || transition.To != to {
+			continue
+		}
+
+		if slices.Contains(transition.Roles, actor.Role) || (actor.IsUser() && slices.Contains(transition.Roles, TICKET_ACTOR_STAFF)) {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionTicket moves ticket to status on behalf of actor, stamping
+// resolved_at/closed_at and recording the move in the ticket's status history.
+// fields are set by the same update, so they only land if the move does.
+func TransitionTicket(ctx context.Context, tickets database.TicketStore, ticket models.Ticket, to string, actor Principal, note string, fields bson.M) error {
+	if !IsTicketStatus(to) {
+		return ErrInvalidTicketStatus
+	}
+
+	from := ""
+	if ticket.Status != nil {
+		from = *ticket.Status
+	}
+
+	if from == to {
+		return ErrTicketStatusUnchanged
+	}
+
+	if !CanTransitionTicket(actor, from, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrTicketTransitionDenied, from, to)
+	}
+
+	now := time.Now()
+
+	if actor.IsCustomer() && from == models.TICKET_RESOLVED && to == models.TICKET_OPEN {
+		// a resolved ticket without resolved_at cannot show it is inside the window
+		if ticket.ResolvedAt == nil || now.Sub(*ticket.ResolvedAt) > TICKET_REOPEN_WINDOW {
+			return ErrTicketReopenExpired
+		}
+	}
+
+	// reopening a ticket clears both timestamps, resolving keeps closed_at unset
+	var resolvedAt, closedAt *time.Time
+	switch to {
+	case models.TICKET_RESOLVED:
+		resolvedAt = &now
+	case models.TICKET_CLOSED:
+		resolvedAt = ticket.ResolvedAt
+		closedAt = &now
+	}
+
+	change := models.TicketStatusChange{
+		From:      from,
+		To:        to,
+		ActorType: actor.Type,
+		ActorId:   actor.Id,
+		Note:      note,
+		ChangedAt: now,
+	}
+
+	err := tickets.Transition(ctx, ticket.ID, change, resolvedAt, closedAt, fields)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrTicketStatusConflict
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// a staff move counts as the first response, a late resolve breaches the resolution target
+	if actor.IsUser() {
+		if err := RecordFirstResponse(ctx, tickets, ticket, now); err != nil {
+			return err
+		}
+	}
+
+	if to == models.TICKET_RESOLVED && ticket.ResolutionDue != nil && now.After(*ticket.ResolutionDue) &&
+		!slices.Contains(ticket.SlaBreaches, models.SLA_RESOLUTION) {
+		return tickets.FlagSlaBreach(ctx, ticket.ID, models.SLA_RESOLUTION)
+	}
+	return nil
+}
+
+// AutoCloseResolvedTickets closes every ticket that stayed resolved for longer
+// than TICKET_AUTO_CLOSE_AFTER and returns how many were closed.
+func AutoCloseResolvedTickets(ctx context.Context, tickets database.TicketStore) (int, error) {
+	resolved, err := tickets.ListResolvedBefore(ctx, time.Now().Add(-TICKET_AUTO_CLOSE_AFTER))
+	if err != nil {
+		return 0, err
+	}
+
+	system := Principal{Type: models.PRINCIPAL_SYSTEM}
+	note := fmt.Sprintf("closed automatically after %s without a reopen", TICKET_AUTO_CLOSE_AFTER)
+
+	closed := 0
+	for _, ticket := range resolved {
+		err := TransitionTicket(ctx, tickets, ticket, models.TICKET_CLOSED, system, note, nil)
+		if errors.Is(err, ErrTicketStatusConflict) {
+			continue
+		}
+
+		if err != nil {
+			return closed, err
+		}
+		closed++
+	}
+	return closed, nil
+}
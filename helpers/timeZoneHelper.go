@@ -0,0 +1,194 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/utils"
+)
+
+// local times are written without an offset, the time zone says which one applies
+const localTimeLayout = "2006-01-02T15:04:05"
+
+var (
+	ErrAmbiguousLocalTime   = errors.New("happens twice when the clocks go back, send start_time with an offset instead")
+	ErrNonexistentLocalTime = errors.New("is skipped when the clocks go forward")
+)
+
+// LoadTimeZone loads an IANA time zone such as "Europe/Berlin", "" is UTC.
+func LoadTimeZone(name string) (*time.Location, error) {
+	// "Local" would be whatever zone the server runs in
+	if name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// PreparePreferences checks the time zone and locale a user or customer picked
+// and stores the locale in its supported form, nil leaves a preference unset.
+func PreparePreferences(timeZone, locale *string) error {
+	if timeZone != nil {
+		if _, err := LoadTimeZone(*timeZone); err != nil {
+			return err
+		}
+	}
+
+	if locale != nil {
+		resolved, ok := utils.ResolveLocale(*locale)
+		if !ok {
+			return fmt.Errorf("unsupported locale %q", *locale)
+		}
+		*locale = resolved
+	}
+	return nil
+}
+
+// preferredLocation is the zone a preference names, UTC when unset or unknown.
+func preferredLocation(timeZone *string) *time.Location {
+	if timeZone == nil {
+		return time.UTC
+	}
+
+	loc, err := LoadTimeZone(*timeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func UserLocation(user models.User) *time.Location {
+	return preferredLocation(user.TimeZone)
+}
+
+// InteractionLocation is the zone an interaction was scheduled in, its
+// occurrences keep their wall clock time there across DST changes.
+func InteractionLocation(interaction models.Interaction) *time.Location {
+	loc, err := LoadTimeZone(interaction.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// ParseLocalTime reads a wall clock time such as "2030-03-31T10:00" in loc and
+// rejects the ones a DST change makes ambiguous or skips.
+func ParseLocalTime(value string, loc *time.Location) (time.Time, error) {
+	layout := localTimeLayout
+	if strings.Count(value, ":") == 1 {
+		layout = strings.TrimSuffix(layout, ":05")
+	}
+
+	wall, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("local time %q must look like 2006-01-02T15:04", value)
+	}
+
+	// try the offsets in force half a day either side, a wall clock time that
+	// maps to both is ambiguous and one that maps to neither does not exist
+	matches := []time.Time{}
+	for _, around := range []time.Duration{-12 * time.Hour, 12 * time.Hour} {
+		_, offset := wall.Add(around).In(loc).Zone()
+		candidate := wall.Add(-time.Duration(offset) * time.Second).In(loc)
+
+		if sameWallClock(candidate, wall) && (len(matches) == 0 || !matches[0].Equal(candidate)) {
+			matches = append(matches, candidate)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return time.Time{}, fmt.Errorf("%s in %s %w", value, loc, ErrNonexistentLocalTime)
+	case 1:
+		return matches[0], nil
+	default:
+		return time.Time{}, fmt.Errorf("%s in %s %w", value, loc, ErrAmbiguousLocalTime)
+	}
+}
+
+func sameWallClock(t, wall time.Time) bool {
+	return t.Year() == wall.Year() && t.YearDay() == wall.YearDay() && t.Hour() == wall.Hour() && t.Minute() == wall.Minute() && t.Second() == wall.Second()
+}
+
+// ResolveInteractionTimes defaults the zone of a new interaction and turns its
+// start_local and end_local into start_time and end_time.
+func ResolveInteractionTimes(interaction *models.Interaction, defaultZone string) error {
+	if interaction.TimeZone == "" {
+		interaction.TimeZone = defaultZone
+	}
+
+	loc, err := LoadTimeZone(interaction.TimeZone)
+	if err != nil {
+		return err
+	}
+	interaction.TimeZone = loc.String()
+
+	if interaction.StartLocal != "" {
+		if !interaction.StartTime.IsZero() {
+			return errors.New("send either start_time or start_local")
+		}
+
+		if interaction.StartTime, err = ParseLocalTime(interaction.StartLocal, loc); err != nil {
+			return err
+		}
+	}
+
+	if interaction.EndLocal != "" {
+		if interaction.EndTime != nil {
+			return errors.New("send either end_time or end_local")
+		}
+
+		end, err := ParseLocalTime(interaction.EndLocal, loc)
+		if err != nil {
+			return err
+		}
+		interaction.EndTime = &end
+	}
+	return nil
+}
+
+// InteractionIn prepares an interaction for a reader in loc: its times are
+// given in loc, and start_local and end_local keep the wall clock time of the
+// zone it was scheduled in.
+func InteractionIn(interaction models.Interaction, loc *time.Location) models.Interaction {
+	own := InteractionLocation(interaction)
+
+	if !interaction.StartTime.IsZero() {
+		interaction.StartLocal = interaction.StartTime.In(own).Format(localTimeLayout)
+		interaction.StartTime = interaction.StartTime.In(loc)
+	}
+
+	if interaction.EndTime != nil {
+		interaction.EndLocal = interaction.EndTime.In(own).Format(localTimeLayout)
+		end := interaction.EndTime.In(loc)
+		interaction.EndTime = &end
+	}
+
+	if interaction.RecurrenceId != nil {
+		recurrenceId := interaction.RecurrenceId.In(loc)
+		interaction.RecurrenceId = &recurrenceId
+	}
+
+	if interaction.Task != nil && interaction.Task.DueAt != nil {
+		task := *interaction.Task
+		due := task.DueAt.In(loc)
+		task.DueAt = &due
+		interaction.Task = &task
+	}
+	return interaction
+}
+
+func InteractionsIn(interactions []models.Interaction, loc *time.Location) []models.Interaction {
+	localized := make([]models.Interaction, 0, len(interactions))
+	for _, interaction := range interactions {
+		localized = append(localized, InteractionIn(interaction, loc))
+	}
+	return localized
+}
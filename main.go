@@ -1,48 +1,246 @@
-package main
-
-import (
-	"fmt"
-	"net/http"
-	"os"
-
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
-
-	"github.com/roh4nyh/matrice_ai/routes"
-)
-
-func main() {
-	// err := godotenv.Load(".env")
-	// if err != nil {
-	// 	log.Printf("error loading .env file: %v", err)
-	// }
-
-	PORT := os.Getenv("PORT")
-	if PORT == "" {
-		PORT = "8080"
-	}
-
-	gin.SetMode(gin.ReleaseMode)
-
-	app := gin.New()
-	app.Use(gin.Logger())
-
-	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"*"}
-	config.AllowCredentials = true
-	config.AllowHeaders = []string{"Authorization", "Content-Type"}
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	app.Use(cors.New(config))
-
-	routes.AuthRoutes(app)
-
-	app.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"success": "server is up and running..."})
-	})
-
-	routes.UserRoutes(app)
-
-	routes.CustomerRoutes(app)
-
-	app.Run(fmt.Sprintf(":%s", PORT))
-}
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+	"github.com/roh4nyh/matrice_ai/routes"
+	"github.com/roh4nyh/matrice_ai/utils"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the API until it is interrupted or a listener fails. It returns
+// its errors rather than exiting, so the deferred cleanup always runs.
+func run() error {
+	// err := godotenv.Load(".env")
+	// if err != nil {
+	// 	log.Printf("error loading .env file: %v", err)
+	// }
+
+	PORT := os.Getenv("PORT")
+	if PORT == "" {
+		PORT = "8080"
+	}
+
+	// STORE_DRIVER=memory runs the whole API without a MongoDB instance
+	var stores *database.Stores
+	switch os.Getenv("STORE_DRIVER") {
+	case "memory":
+		stores = database.NewMemoryStores()
+	default:
+		dbConfig, err := database.LoadConfig()
+		if err != nil {
+			return err
+		}
+
+		client, err := database.Connect(dbConfig)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := client.Disconnect(ctx); err != nil {
+				log.Printf("error disconnecting from MongoDB: %v", err)
+			}
+		}()
+
+		db := client.Database(dbConfig.DatabaseName)
+
+		ctx, cancel := context.WithTimeout(context.Background(), dbConfig.ConnectTimeout)
+		err = database.CheckTransactions(ctx, client, dbConfig.AllowStandalone)
+		if err == nil {
+			err = database.EnsureIndexes(ctx, db)
+		}
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		stores = database.NewMongoStores(db)
+	}
+
+	blobs, err := database.NewBlobStore(database.LoadBlobConfig())
+	if err != nil {
+		return err
+	}
+	stores.Blobs = blobs
+
+	mailConfig, err := utils.LoadMailConfig()
+	if err != nil {
+		return err
+	}
+
+	mailer, err := utils.NewMailer(mailConfig)
+	if err != nil {
+		return err
+	}
+	defer mailer.Close()
+
+	if err := helpers.CheckAttachmentSigningKey(); err != nil {
+		return err
+	}
+
+	seedCtx, cancelSeed := context.WithTimeout(context.Background(), 10*time.Second)
+	err = helpers.SeedRoles(seedCtx, stores.Roles)
+	if err == nil {
+		err = helpers.SeedPipeline(seedCtx, stores.Pipelines)
+	}
+	if err == nil {
+		err = helpers.SeedAdmin(seedCtx, stores.Users)
+	}
+	if err == nil {
+		err = helpers.BackfillNotificationParticipants(seedCtx, stores.Notifications, stores.Interactions)
+	}
+	if err == nil {
+		err = helpers.BackfillCommentTickets(seedCtx, stores.Comments, stores.Tickets)
+	}
+	if err == nil {
+		err = helpers.BackfillAccountRecords(seedCtx, stores.Customers, stores.Tickets, stores.Interactions)
+	}
+	cancelSeed()
+	if err != nil {
+		return err
+	}
+
+	if path := os.Getenv("TICKET_WORKFLOW_FILE"); path != "" {
+		if err := helpers.LoadTicketWorkflow(path); err != nil {
+			return err
+		}
+	}
+
+	if err := helpers.LoadEmailTemplates(os.Getenv("EMAIL_TEMPLATE_DIR")); err != nil {
+		return err
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+
+	app := gin.New()
+	app.Use(gin.Logger())
+
+	config := cors.DefaultConfig()
+	config.AllowOrigins = []string{"*"}
+	config.AllowCredentials = true
+	config.AllowHeaders = []string{"Authorization", "Content-Type"}
+	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	app.Use(cors.New(config))
+
+	app.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"success": "server is up and running..."})
+	})
+
+	api := app.Group("/api/v1")
+
+	routes.StaffRoutes(api, stores)
+
+	routes.PortalRoutes(api, stores)
+
+	routes.SharedRoutes(api, stores)
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%s", PORT),
+		Handler: app,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// a listener that fails ends the run like an interrupt does
+	failed := make(chan error, 2)
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			failed <- fmt.Errorf("error starting server: %w", err)
+		}
+	}()
+
+	// jobs live in the database so reminders survive restarts and only one replica runs each
+	scheduler := helpers.NewScheduler(stores.Jobs)
+	scheduler.Handle(helpers.JOB_INTERACTION_REMINDER, helpers.SendInteractionReminder(stores.Interactions, stores.Users, stores.Customers, stores.Jobs, stores.Notifications, stores.EmailTemplates))
+
+	// close tickets left resolved past TICKET_AUTO_CLOSE_AFTER
+	err = scheduler.Every(ctx, "tickets.auto_close", helpers.TICKET_AUTO_CLOSE_INTERVAL, ticketSweep("auto closing resolved tickets", func(ctx context.Context) (int, error) {
+		return helpers.AutoCloseResolvedTickets(ctx, stores.Tickets)
+	}))
+	if err != nil {
+		return err
+	}
+
+	// flag tickets that went past their SLA due dates
+	err = scheduler.Every(ctx, "tickets.sla_check", helpers.SLA_CHECK_INTERVAL, ticketSweep("flagging SLA breaches", func(ctx context.Context) (int, error) {
+		return helpers.FlagSlaBreaches(ctx, stores.Tickets)
+	}))
+	if err != nil {
+		return err
+	}
+
+	// send the emails queued in the outbox, failed ones are retried later
+	err = scheduler.Every(ctx, helpers.JOB_NOTIFICATION_DELIVERY, helpers.NOTIFICATION_POLL_INTERVAL, helpers.DeliverNotifications(stores.Notifications, mailer))
+	if err != nil {
+		return err
+	}
+
+	go scheduler.Run(ctx)
+
+	// emails a local MTA hands over become tickets, like those posted to the webhook
+	if addr := os.Getenv("INBOUND_LMTP_ADDR"); addr != "" {
+		lmtp := &utils.LMTPServer{Addr: addr, MaxSize: helpers.INBOUND_EMAIL_MAX_SIZE, Handler: helpers.NewMailbox(stores).ReceiveLMTP}
+		go func() {
+			if err := lmtp.ListenAndServe(ctx); err != nil {
+				failed <- err
+			}
+		}()
+	}
+
+	// wait for an interrupt or a failed listener, then drain in-flight requests before the deferred cleanup runs
+	var runErr error
+	select {
+	case <-ctx.Done():
+	case runErr = <-failed:
+		stop()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("error shutting down server: %v", err)
+	}
+	return runErr
+}
+
+// ticketSweep turns a sweep over the tickets into a job, the sweep reports how
+// many records it changed so quiet runs stay out of the log.
+func ticketSweep(name string, sweep func(context.Context) (int, error)) helpers.JobHandler {
+	return func(ctx context.Context, job models.Job) error {
+		changed, err := sweep(ctx)
+		if err != nil {
+			return fmt.Errorf("error %s: %w", name, err)
+		}
+
+		if changed > 0 {
+			log.Printf("%s: %d tickets", name, changed)
+		}
+		return nil
+	}
+}
@@ -1,57 +1,156 @@
-package middleware
-
-import (
-	"net/http"
-
-	"github.com/gin-gonic/gin"
-	helper "github.com/roh4nyh/matrice_ai/helpers"
-)
-
-func AuthenticateUser() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		clientToken := c.Request.Header.Get("token")
-		if clientToken == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "No Authorization header found"})
-			c.Abort()
-			return
-		}
-
-		claims, err := helper.ValidateUserToken(clientToken)
-		if err != "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-			c.Abort()
-			return
-		}
-
-		c.Set("email", claims.Email)
-		c.Set("name", claims.Name)
-		c.Set("role", claims.Role)
-		c.Set("uid", claims.Uid)
-
-		c.Next()
-	}
-}
-
-func AuthenticateCustomer() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		clientToken := c.Request.Header.Get("token")
-		if clientToken == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "No Authorization header found"})
-			c.Abort()
-			return
-		}
-
-		claims, err := helper.ValidateCustomerToken(clientToken)
-		if err != "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-			c.Abort()
-			return
-		}
-
-		c.Set("cid", claims.Cid)
-		c.Set("email", claims.Email)
-		c.Set("name", claims.Name)
-
-		c.Next()
-	}
-}
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/matrice_ai/database"
+	helper "github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/models"
+)
+
+func AuthenticateUser(revocations database.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientToken := c.Request.Header.Get("token")
+		if clientToken == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "No Authorization header found"})
+			c.Abort()
+			return
+		}
+
+		claims, err := helper.ValidateUserToken(clientToken)
+		if err != "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			c.Abort()
+			return
+		}
+
+		if msg := checkRevoked(revocations, claims.Id, claims.Sid); msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			c.Abort()
+			return
+		}
+
+		setUserClaims(c, claims)
+
+		c.Next()
+	}
+}
+
+func AuthenticateCustomer(revocations database.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientToken := c.Request.Header.Get("token")
+		if clientToken == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "No Authorization header found"})
+			c.Abort()
+			return
+		}
+
+		claims, err := helper.ValidateCustomerToken(clientToken)
+		if err != "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			c.Abort()
+			return
+		}
+
+		if msg := checkRevoked(revocations, claims.Id, claims.Sid); msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			c.Abort()
+			return
+		}
+
+		setCustomerClaims(c, claims)
+
+		c.Next()
+	}
+}
+
+// AuthenticateAny accepts either a staff or a customer token, for routes shared
+// by both audiences; handlers tell them apart through helper.GetPrincipal.
+func AuthenticateAny(revocations database.RevocationStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientToken := c.Request.Header.Get("token")
+		if clientToken == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "No Authorization header found"})
+			c.Abort()
+			return
+		}
+
+		tokenId, sessionId := "", ""
+		if claims, err := helper.ValidateUserToken(clientToken); err == "" {
+			setUserClaims(c, claims)
+			tokenId, sessionId = claims.Id, claims.Sid
+		} else if claims, err := helper.ValidateCustomerToken(clientToken); err == "" {
+			setCustomerClaims(c, claims)
+			tokenId, sessionId = claims.Id, claims.Sid
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			c.Abort()
+			return
+		}
+
+		if msg := checkRevoked(revocations, tokenId, sessionId); msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func setUserClaims(c *gin.Context, claims *helper.SignedUserDetails) {
+	c.Set("email", claims.Email)
+	c.Set("name", claims.Name)
+	c.Set("role", claims.Role)
+	c.Set("uid", claims.Uid)
+	c.Set("sid", claims.Sid)
+	c.Set("jti", claims.Id)
+	c.Set("exp", claims.ExpiresAt)
+	c.Set("principal", helper.Principal{
+		Type:  models.PRINCIPAL_USER,
+		Id:    claims.Uid,
+		Email: claims.Email,
+		Name:  claims.Name,
+		Role:  claims.Role,
+	})
+}
+
+func setCustomerClaims(c *gin.Context, claims *helper.SignedCustomerDetails) {
+	c.Set("cid", claims.Cid)
+	c.Set("email", claims.Email)
+	c.Set("name", claims.Name)
+	c.Set("sid", claims.Sid)
+	c.Set("jti", claims.Id)
+	c.Set("exp", claims.ExpiresAt)
+	c.Set("principal", helper.Principal{
+		Type:  models.PRINCIPAL_CUSTOMER,
+		Id:    claims.Cid,
+		Email: claims.Email,
+		Name:  claims.Name,
+		Role:  models.ROLE_CUSTOMER,
+	})
+}
+
+// checkRevoked looks the token and its session up in the deny list, tokens
+// issued before sessions existed carry neither id and are rejected.
+func checkRevoked(revocations database.RevocationStore, tokenId, sessionId string) string {
+	if tokenId == "" || sessionId == "" {
+		return "the token is invalid"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	revoked, err := revocations.IsRevoked(ctx, tokenId, sessionId)
+	if err != nil {
+		return "unable to verify the token"
+	}
+
+	if revoked {
+		return "the token has been revoked"
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/matrice_ai/database"
+	helper "github.com/roh4nyh/matrice_ai/helpers"
+)
+
+// RequirePermission allows the request when the caller's role grants permission
+// ("resource:action") on any record or on their own, the granted scope is stored
+// under "scope" so handlers can restrict "own" callers to their records. Passing
+// "resource:action:any" admits only callers allowed to act on every record.
+func RequirePermission(roles database.RoleStore, permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roleName := helper.GetPrincipal(c).Role
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		role, err := roles.FindByName(ctx, roleName)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "UnAuthenticated to access this resource"})
+			c.Abort()
+			return
+		}
+
+		scope := helper.GrantedScope(role, permission)
+		if scope == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "UnAuthenticated to access this resource"})
+			c.Abort()
+			return
+		}
+
+		c.Set("scope", scope)
+
+		c.Next()
+	}
+}
@@ -1,79 +1,504 @@
-package models
-
-import (
-	"time"
-
-	"go.mongodb.org/mongo-driver/bson/primitive"
-)
-
-// type InteractionType string
-// type TicketStatus string
-
-const (
-	ROLE_ADMIN = "ADMIN"
-	ROLE_USER  = "USER"
-
-	// InteractionTask     InteractionType = "task"
-	// InteractionMeeting  InteractionType = "meeting"
-	// InteractionFollowup InteractionType = "followup"
-
-	TICKET_OPEN       = "open"
-	TICKETIN_PROGRESS = "in_progress"
-	TICKET_RESOLVED   = "resolved"
-	TICKET_CLOSED     = "closed"
-)
-
-// User model
-type User struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name     *string            `bson:"name" json:"name" validate:"required"`
-	Password *string            `bson:"password" json:"password" validate:"required,min=2,max=100"`
-	Email    *string            `bson:"email" json:"email" validate:"email,required"`
-	Role     *string            `bson:"role" json:"role" validate:"required,eq=ADMIN|eq=USER"`
-	// Company   *string            `bson:"company,omitempty" json:"company,omitempty"`
-	// PhoneNo   *string            `bson:"phone_no,omitempty" json:"phone_no,omitempty"`
-	Token     *string   `bson:"token,omitempty" json:"token,omitempty"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
-	UserId    string    `bson:"user_id" json:"user_id"`
-}
-
-// Customer model
-type Customer struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name       *string            `bson:"name" json:"name" validate:"required"`
-	Email      *string            `bson:"email" json:"email" validate:"email,required"`
-	Password   *string            `bson:"password" json:"password" validate:"required,min=2,max=100"`
-	Company    *string            `bson:"company,omitempty" json:"company,omitempty"`
-	Phone      *string            `bson:"phone,omitempty" json:"phone,omitempty"`
-	Token      *string            `bson:"token,omitempty" json:"token,omitempty"`
-	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
-	CustomerId string             `bson:"customer_id" json:"customer_id"`
-}
-
-// Interaction model
-type Interaction struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
-	CustomerID primitive.ObjectID `bson:"customer_id" json:"customer_id"`
-	// Type          *InteractionType   `bson:"type" json:"type" validate:"required,eq=task|eq=meeting|eq=followup"`
-	Title         *string   `bson:"title,omitempty" json:"title,omitempty"`
-	Description   *string   `bson:"description" json:"description"`
-	StartTime     time.Time `bson:"start_time,omitempty" json:"start_time,omitempty"`
-	CreatedAt     time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt     time.Time `bson:"updated_at" json:"updated_at"`
-	InteractionId string    `bson:"interaction_id" json:"interaction_id"`
-}
-
-// Ticket model
-type Ticket struct {
-	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	InteractionID primitive.ObjectID `bson:"interaction_id" json:"interaction_id"`
-	CustomerID    primitive.ObjectID `bson:"customer_id" json:"customer_id"`
-	Status        *string            `bson:"status" json:"status" validate:"required,eq=open|eq=in_progress|eq=resolved|eq=closed"`
-	Description   *string            `bson:"description" json:"description"`
-	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
-	TicketId      string             `bson:"ticket_id" json:"ticket_id"`
-}
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// type TicketStatus string
+
+const (
+	ROLE_ADMIN    = "ADMIN"
+	ROLE_MANAGER  = "MANAGER"
+	ROLE_AGENT    = "AGENT"
+	ROLE_READONLY = "READONLY"
+	ROLE_USER     = "USER"
+	ROLE_CUSTOMER = "CUSTOMER"
+
+	SCOPE_ANY = "any"
+	SCOPE_OWN = "own"
+
+	INTERACTION_TASK     = "task"
+	INTERACTION_MEETING  = "meeting"
+	INTERACTION_FOLLOWUP = "followup"
+	INTERACTION_CALL     = "call"
+
+	CALL_INBOUND  = "inbound"
+	CALL_OUTBOUND = "outbound"
+
+	TICKET_OPEN       = "open"
+	TICKETIN_PROGRESS = "in_progress"
+	TICKET_RESOLVED   = "resolved"
+	TICKET_CLOSED     = "closed"
+
+	TICKET_CHANNEL_PORTAL = "portal"
+	TICKET_CHANNEL_EMAIL  = "email"
+
+	PRIORITY_LOW    = "low"
+	PRIORITY_NORMAL = "normal"
+	PRIORITY_HIGH   = "high"
+	PRIORITY_URGENT = "urgent"
+
+	SLA_FIRST_RESPONSE = "first_response"
+	SLA_RESOLUTION     = "resolution"
+
+	ATTACHMENT_TICKET      = "ticket"
+	ATTACHMENT_INTERACTION = "interaction"
+
+	QUEUE_ROUND_ROBIN  = "round_robin"
+	QUEUE_LEAST_LOADED = "least_loaded"
+
+	JOB_PENDING = "pending"
+	JOB_FAILED  = "failed"
+
+	NOTIFICATION_QUEUED  = "queued"
+	NOTIFICATION_SENT    = "sent"
+	NOTIFICATION_FAILED  = "failed"
+	NOTIFICATION_BOUNCED = "bounced"
+	// NOTIFICATION_CANCELLED is a queued email whose interaction was deleted before it went out
+	NOTIFICATION_CANCELLED = "cancelled"
+
+	DEAL_OPEN = "open"
+	DEAL_WON  = "won"
+	DEAL_LOST = "lost"
+
+	PRINCIPAL_USER     = "user"
+	PRINCIPAL_CUSTOMER = "customer"
+	PRINCIPAL_SYSTEM   = "system"
+)
+
+// User model
+type User struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name     *string            `bson:"name" json:"name" validate:"required"`
+	Password *string            `bson:"password" json:"password" validate:"required,min=2,max=100"`
+	Email    *string            `bson:"email" json:"email" validate:"email,required"`
+	// Role is never taken from a signup, see helpers.USER_SIGNUP_ROLE
+	Role *string `bson:"role" json:"role"`
+	// TimeZone and Locale decide how times are written for the user, UTC and en-US when unset
+	TimeZone *string `bson:"time_zone,omitempty" json:"time_zone,omitempty" validate:"omitempty,timezone"`
+	Locale   *string `bson:"locale,omitempty" json:"locale,omitempty"`
+	// Company   *string            `bson:"company,omitempty" json:"company,omitempty"`
+	// PhoneNo   *string            `bson:"phone_no,omitempty" json:"phone_no,omitempty"`
+	Token        *string `bson:"-" json:"token,omitempty"`
+	RefreshToken *string `bson:"-" json:"refresh_token,omitempty"`
+	// CalendarTokenHash authorizes the user's calendar feed URL
+	CalendarTokenHash string    `bson:"calendar_token_hash,omitempty" json:"-"`
+	CreatedAt         time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time `bson:"updated_at" json:"updated_at"`
+	UserId            string    `bson:"user_id" json:"user_id"`
+}
+
+// Role model, Permissions are "resource:action:scope" strings such as "tickets:read:any"
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        *string            `bson:"name" json:"name" validate:"required,uppercase"`
+	Description *string            `bson:"description,omitempty" json:"description,omitempty"`
+	Permissions []string           `bson:"permissions" json:"permissions" validate:"required,dive,required"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// Customer model
+type Customer struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name     *string            `bson:"name" json:"name" validate:"required"`
+	Email    *string            `bson:"email" json:"email" validate:"email,required"`
+	Password *string            `bson:"password" json:"password" validate:"required,min=2,max=100"`
+	Company  *string            `bson:"company,omitempty" json:"company,omitempty"`
+	Phone    *string            `bson:"phone,omitempty" json:"phone,omitempty"`
+	TimeZone *string            `bson:"time_zone,omitempty" json:"time_zone,omitempty" validate:"omitempty,timezone"`
+	Locale   *string            `bson:"locale,omitempty" json:"locale,omitempty"`
+	// AccountID links the customer to the company account they are a contact of
+	AccountID *primitive.ObjectID `bson:"account_id,omitempty" json:"account_id,omitempty"`
+	// PendingAccountID is the account their email domain matched at signup,
+	// they join it once staff link them to it
+	PendingAccountID *primitive.ObjectID `bson:"pending_account_id,omitempty" json:"pending_account_id,omitempty"`
+	Token            *string             `bson:"-" json:"token,omitempty"`
+	RefreshToken     *string             `bson:"-" json:"refresh_token,omitempty"`
+	CreatedAt        time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time           `bson:"updated_at" json:"updated_at"`
+	CustomerId       string              `bson:"customer_id" json:"customer_id"`
+}
+
+// Account model, a company whose customers are linked to it as contacts.
+// Customers signing up with an email at Domain join it, OwnerID is the staff
+// member looking after it.
+type Account struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Name      *string             `bson:"name" json:"name" validate:"required"`
+	Domain    *string             `bson:"domain,omitempty" json:"domain,omitempty" validate:"omitempty,fqdn"`
+	Industry  *string             `bson:"industry,omitempty" json:"industry,omitempty"`
+	Size      string              `bson:"size,omitempty" json:"size,omitempty" validate:"omitempty,eq=1-10|eq=11-50|eq=51-200|eq=201-1000|eq=1000+"`
+	OwnerID   *primitive.ObjectID `bson:"owner_id,omitempty" json:"owner_id,omitempty"`
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time           `bson:"updated_at" json:"updated_at"`
+	AccountId string              `bson:"account_id" json:"account_id"`
+}
+
+// Pipeline model, the ordered stages deals move through. Probability is the
+// percent chance a deal in the stage is won, a stage with an Outcome closes
+// the deal as won or lost.
+type Pipeline struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        *string            `bson:"name" json:"name" validate:"required,lowercase"`
+	Description *string            `bson:"description,omitempty" json:"description,omitempty"`
+	Stages      []PipelineStage    `bson:"stages" json:"stages" validate:"required,min=1,dive"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+type PipelineStage struct {
+	Name        string `bson:"name" json:"name" validate:"required,lowercase"`
+	Probability int    `bson:"probability" json:"probability" validate:"min=0,max=100"`
+	Outcome     string `bson:"outcome,omitempty" json:"outcome,omitempty" validate:"omitempty,eq=won|eq=lost"`
+}
+
+// Deal model, a sale to a customer or their company account. Amount is in the
+// minor unit of Currency (cents for USD) and ExpectedCloseDate is a
+// "2006-01-02" date. Status follows the outcome of Stage, every stage move is
+// kept in StageHistory.
+type Deal struct {
+	ID                primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Title             *string             `bson:"title" json:"title" validate:"required"`
+	CustomerID        *primitive.ObjectID `bson:"customer_id,omitempty" json:"customer_id,omitempty"`
+	AccountID         *primitive.ObjectID `bson:"account_id,omitempty" json:"account_id,omitempty"`
+	OwnerID           *primitive.ObjectID `bson:"owner_id,omitempty" json:"owner_id,omitempty"`
+	Pipeline          string              `bson:"pipeline" json:"pipeline"`
+	Stage             string              `bson:"stage" json:"stage"`
+	Status            string              `bson:"status" json:"status"`
+	Amount            int64               `bson:"amount" json:"amount" validate:"gte=0"`
+	Currency          string              `bson:"currency" json:"currency" validate:"omitempty,iso4217"`
+	ExpectedCloseDate string              `bson:"expected_close_date,omitempty" json:"expected_close_date,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	ClosedAt          *time.Time          `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+	StageHistory      []DealStageChange   `bson:"stage_history" json:"stage_history,omitempty"`
+	CreatedAt         time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time           `bson:"updated_at" json:"updated_at"`
+	DealId            string              `bson:"deal_id" json:"deal_id"`
+}
+
+// DealStageChange is one entry of a deal's stage history, the deal went from
+// stage From of FromPipeline to stage To of Pipeline. From and FromPipeline
+// are empty for the entry written when the deal is created.
+type DealStageChange struct {
+	FromPipeline string    `bson:"from_pipeline,omitempty" json:"from_pipeline,omitempty"`
+	Pipeline     string    `bson:"pipeline" json:"pipeline"`
+	From         string    `bson:"from" json:"from"`
+	To           string    `bson:"to" json:"to"`
+	ActorId      string    `bson:"actor_id" json:"actor_id"`
+	Note         string    `bson:"note,omitempty" json:"note,omitempty"`
+	ChangedAt    time.Time `bson:"changed_at" json:"changed_at"`
+}
+
+// Interaction model, only the details block matching Type may be set.
+// Interactions stored before types existed have no Type and count as meetings.
+type Interaction struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	CustomerID primitive.ObjectID `bson:"customer_id" json:"customer_id"`
+	// AccountID copies the customer's, see Ticket
+	AccountID   *primitive.ObjectID `bson:"account_id,omitempty" json:"account_id,omitempty"`
+	Type        string              `bson:"type" json:"type" validate:"omitempty,eq=task|eq=meeting|eq=followup|eq=call"`
+	Title       *string             `bson:"title,omitempty" json:"title,omitempty"`
+	Description *string             `bson:"description" json:"description"`
+	StartTime   time.Time           `bson:"start_time,omitempty" json:"start_time,omitempty"`
+	EndTime     *time.Time          `bson:"end_time,omitempty" json:"end_time,omitempty"`
+	// TimeZone is the IANA zone the interaction was scheduled in, occurrences of a
+	// repeating interaction keep their local time there. StartLocal and EndLocal
+	// give start_time and end_time as local times in it.
+	TimeZone   string          `bson:"time_zone,omitempty" json:"time_zone,omitempty"`
+	StartLocal string          `bson:"-" json:"start_local,omitempty"`
+	EndLocal   string          `bson:"-" json:"end_local,omitempty"`
+	Meeting    *MeetingDetails `bson:"meeting,omitempty" json:"meeting,omitempty"`
+	Task       *TaskDetails    `bson:"task,omitempty" json:"task,omitempty"`
+	Call       *CallDetails    `bson:"call,omitempty" json:"call,omitempty"`
+	// DealID is the deal the interaction was part of
+	DealID *primitive.ObjectID `bson:"deal_id,omitempty" json:"deal_id,omitempty"`
+	// RRule repeats the interaction from StartTime, ExDates are its cancelled occurrences
+	RRule   string      `bson:"rrule,omitempty" json:"rrule,omitempty"`
+	ExDates []time.Time `bson:"exdates,omitempty" json:"exdates,omitempty"`
+	// SeriesId and RecurrenceId mark one occurrence of a repeating interaction,
+	// RecurrenceId is the start the occurrence had in the series
+	SeriesId      string                `bson:"series_id,omitempty" json:"series_id,omitempty"`
+	RecurrenceId  *time.Time            `bson:"recurrence_id,omitempty" json:"recurrence_id,omitempty"`
+	Revision      int                   `bson:"revision" json:"revision"`
+	Revisions     []InteractionRevision `bson:"revisions,omitempty" json:"revisions,omitempty"`
+	CreatedAt     time.Time             `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time             `bson:"updated_at" json:"updated_at"`
+	InteractionId string                `bson:"interaction_id" json:"interaction_id"`
+}
+
+// InteractionRevision keeps the values an interaction had before an edit,
+// Revision is the number the interaction carried until then.
+type InteractionRevision struct {
+	Revision    int         `bson:"revision" json:"revision"`
+	Title       *string     `bson:"title,omitempty" json:"title,omitempty"`
+	Description *string     `bson:"description" json:"description"`
+	StartTime   time.Time   `bson:"start_time,omitempty" json:"start_time,omitempty"`
+	EndTime     *time.Time  `bson:"end_time,omitempty" json:"end_time,omitempty"`
+	TimeZone    string      `bson:"time_zone,omitempty" json:"time_zone,omitempty"`
+	RRule       string      `bson:"rrule,omitempty" json:"rrule,omitempty"`
+	ExDates     []time.Time `bson:"exdates,omitempty" json:"exdates,omitempty"`
+	ChangedById string      `bson:"changed_by_id" json:"changed_by_id"`
+	ChangedAt   time.Time   `bson:"changed_at" json:"changed_at"`
+}
+
+type MeetingDetails struct {
+	Location        string   `bson:"location,omitempty" json:"location,omitempty"`
+	DurationMinutes int      `bson:"duration_minutes,omitempty" json:"duration_minutes,omitempty" validate:"gte=0"`
+	Attendees       []string `bson:"attendees,omitempty" json:"attendees,omitempty" validate:"dive,email"`
+}
+
+type TaskDetails struct {
+	DueAt       *time.Time `bson:"due_at,omitempty" json:"due_at,omitempty"`
+	Completed   bool       `bson:"completed" json:"completed"`
+	CompletedAt *time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+type CallDetails struct {
+	Direction       string `bson:"direction" json:"direction" validate:"required,eq=inbound|eq=outbound"`
+	Outcome         string `bson:"outcome,omitempty" json:"outcome,omitempty" validate:"omitempty,eq=connected|eq=voicemail|eq=no_answer|eq=busy"`
+	DurationMinutes int    `bson:"duration_minutes,omitempty" json:"duration_minutes,omitempty" validate:"gte=0"`
+}
+
+// Ticket model, Status only changes through the ticket workflow which records
+// every move in StatusHistory. Channel is how the customer raised it, a ticket
+// raised by email only has an InteractionID when the email answered one.
+type Ticket struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	InteractionID primitive.ObjectID `bson:"interaction_id,omitempty" json:"interaction_id"`
+	CustomerID    primitive.ObjectID `bson:"customer_id" json:"customer_id"`
+	// AccountID copies the customer's, so an account's tickets are found without listing its contacts
+	AccountID   *primitive.ObjectID `bson:"account_id,omitempty" json:"account_id,omitempty"`
+	Status      *string             `bson:"status" json:"status" validate:"omitempty,eq=open|eq=in_progress|eq=resolved|eq=closed"`
+	Description *string             `bson:"description" json:"description"`
+	Priority    string              `bson:"priority" json:"priority" validate:"omitempty,eq=low|eq=normal|eq=high|eq=urgent"`
+	Queue       string              `bson:"queue,omitempty" json:"queue,omitempty"`
+	Channel     string              `bson:"channel,omitempty" json:"channel,omitempty"`
+	AssigneeID  *primitive.ObjectID `bson:"assignee_id,omitempty" json:"assignee_id,omitempty"`
+	AssignedAt  *time.Time          `bson:"assigned_at,omitempty" json:"assigned_at,omitempty"`
+	ResolvedAt  *time.Time          `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+	ClosedAt    *time.Time          `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+	// SLA targets come from the matching SlaPolicy, SlaBreaches lists the missed ones
+	SlaPolicy        string               `bson:"sla_policy,omitempty" json:"sla_policy,omitempty"`
+	FirstResponseDue *time.Time           `bson:"first_response_due,omitempty" json:"first_response_due,omitempty"`
+	ResolutionDue    *time.Time           `bson:"resolution_due,omitempty" json:"resolution_due,omitempty"`
+	FirstRespondedAt *time.Time           `bson:"first_responded_at,omitempty" json:"first_responded_at,omitempty"`
+	SlaBreached      bool                 `bson:"sla_breached" json:"sla_breached"`
+	SlaBreaches      []string             `bson:"sla_breaches,omitempty" json:"sla_breaches,omitempty"`
+	StatusHistory    []TicketStatusChange `bson:"status_history" json:"status_history,omitempty"`
+	CreatedAt        time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time            `bson:"updated_at" json:"updated_at"`
+	TicketId         string               `bson:"ticket_id" json:"ticket_id"`
+}
+
+// TicketStatusChange is one entry of a ticket's status history, From is empty
+// for the entry written when the ticket is raised.
+type TicketStatusChange struct {
+	From      string    `bson:"from" json:"from"`
+	To        string    `bson:"to" json:"to"`
+	ActorType string    `bson:"actor_type" json:"actor_type"`
+	ActorId   string    `bson:"actor_id,omitempty" json:"actor_id,omitempty"`
+	Note      string    `bson:"note,omitempty" json:"note,omitempty"`
+	ChangedAt time.Time `bson:"changed_at" json:"changed_at"`
+}
+
+// TicketComment model, a reply on a ticket's conversation. ParentID threads a
+// reply under another comment, Internal notes are only visible to staff.
+type TicketComment struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	TicketID   primitive.ObjectID  `bson:"ticket_id" json:"ticket_id"`
+	ParentID   *primitive.ObjectID `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+	AuthorType string              `bson:"author_type" json:"author_type"`
+	AuthorId   string              `bson:"author_id" json:"author_id"`
+	AuthorName string              `bson:"author_name" json:"author_name"`
+	Body       *string             `bson:"body" json:"body" validate:"required,min=1,max=10000"`
+	Internal   bool                `bson:"internal" json:"internal"`
+	EditedAt   *time.Time          `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+	CreatedAt  time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time           `bson:"updated_at" json:"updated_at"`
+	CommentId  string              `bson:"comment_id" json:"comment_id"`
+	// CustomerID and AssigneeID copy the ticket's, so searches keep comments
+	// to the tickets a caller may see without reading the tickets first
+	CustomerID primitive.ObjectID  `bson:"customer_id" json:"-"`
+	AssigneeID *primitive.ObjectID `bson:"assignee_id,omitempty" json:"-"`
+}
+
+// Attachment model, the metadata of a file uploaded to a ticket or an
+// interaction, the bytes live in the blob store under StorageKey.
+type Attachment struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OwnerType      string             `bson:"owner_type" json:"owner_type"`
+	OwnerID        primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	FileName       string             `bson:"file_name" json:"file_name"`
+	ContentType    string             `bson:"content_type" json:"content_type"`
+	Size           int64              `bson:"size" json:"size"`
+	Checksum       string             `bson:"checksum" json:"checksum"`
+	StorageKey     string             `bson:"storage_key" json:"-"`
+	UploadedByType string             `bson:"uploaded_by_type" json:"uploaded_by_type"`
+	UploadedById   string             `bson:"uploaded_by_id" json:"uploaded_by_id"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	AttachmentId   string             `bson:"attachment_id" json:"attachment_id"`
+}
+
+// Queue model, tickets raised into a queue are handed to one of its Members
+// (staff user ids) using Strategy. LastAssignedId is the round-robin cursor.
+type Queue struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name           *string            `bson:"name" json:"name" validate:"required,lowercase"`
+	Description    *string            `bson:"description,omitempty" json:"description,omitempty"`
+	Strategy       string             `bson:"strategy" json:"strategy" validate:"omitempty,eq=round_robin|eq=least_loaded"`
+	Members        []string           `bson:"members" json:"members" validate:"dive,required"`
+	LastAssignedId string             `bson:"last_assigned_id,omitempty" json:"last_assigned_id,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// SlaPolicy model, the response and resolution targets for tickets of one
+// priority. A policy with Company only applies to that company's customers and
+// wins over the generic one, Calendar names the BusinessCalendar the targets
+// are counted in, empty means round the clock.
+type SlaPolicy struct {
+	ID                   primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name                 *string            `bson:"name" json:"name" validate:"required,lowercase"`
+	Priority             *string            `bson:"priority" json:"priority" validate:"required,eq=low|eq=normal|eq=high|eq=urgent"`
+	Company              *string            `bson:"company,omitempty" json:"company,omitempty"`
+	FirstResponseMinutes int                `bson:"first_response_minutes" json:"first_response_minutes" validate:"required,min=1"`
+	ResolutionMinutes    int                `bson:"resolution_minutes" json:"resolution_minutes" validate:"required,min=1"`
+	Calendar             string             `bson:"calendar,omitempty" json:"calendar,omitempty"`
+	CreatedAt            time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt            time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// BusinessCalendar model, the working hours SLA targets are counted in.
+// Holidays are "2006-01-02" dates in TimeZone.
+type BusinessCalendar struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name      *string            `bson:"name" json:"name" validate:"required,lowercase"`
+	TimeZone  string             `bson:"time_zone" json:"time_zone" validate:"omitempty,timezone"`
+	Hours     []BusinessHours    `bson:"hours" json:"hours" validate:"required,min=1,dive"`
+	Holidays  []string           `bson:"holidays" json:"holidays" validate:"dive,datetime=2006-01-02"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// BusinessHours is one working window, Weekday 0 is Sunday and Start/End are "15:04".
+type BusinessHours struct {
+	Weekday int    `bson:"weekday" json:"weekday" validate:"min=0,max=6"`
+	Start   string `bson:"start" json:"start" validate:"required,datetime=15:04"`
+	End     string `bson:"end" json:"end" validate:"required,datetime=15:04"`
+}
+
+// Session model, one per login. Every refresh rotates RefreshTokenHash and keeps
+// the previous hashes so a replayed refresh token revokes the whole session family.
+type Session struct {
+	ID                     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PrincipalType          string             `bson:"principal_type" json:"principal_type"`
+	PrincipalId            string             `bson:"principal_id" json:"principal_id"`
+	RefreshTokenHash       string             `bson:"refresh_token_hash" json:"-"`
+	UsedRefreshTokenHashes []string           `bson:"used_refresh_token_hashes" json:"-"`
+	ExpiresAt              time.Time          `bson:"expires_at" json:"expires_at"`
+	RevokedAt              *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	CreatedAt              time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt              time.Time          `bson:"updated_at" json:"updated_at"`
+	SessionId              string             `bson:"session_id" json:"session_id"`
+}
+
+// RevokedToken model, an access token id (jti) or a whole session id that must be
+// rejected until ExpiresAt, after which the token would be invalid anyway.
+type RevokedToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TokenId   string             `bson:"token_id" json:"token_id"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// InboundEmail model, what became of an email customers sent to support,
+// keyed by its Message-ID so a delivery the mail provider retries is filed
+// once. Action stays empty while the email is being filed, a claim that is
+// not completed by ClaimedUntil may be taken over.
+type InboundEmail struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Sender       string             `bson:"sender" json:"sender"`
+	MessageID    string             `bson:"message_id" json:"message_id"`
+	Action       string             `bson:"action,omitempty" json:"action,omitempty"`
+	Reason       string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	TicketId     string             `bson:"ticket_id,omitempty" json:"ticket_id,omitempty"`
+	CommentId    string             `bson:"comment_id,omitempty" json:"comment_id,omitempty"`
+	Attachments  []string           `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	Rejected     []string           `bson:"rejected,omitempty" json:"rejected,omitempty"`
+	ClaimedUntil time.Time          `bson:"claimed_until" json:"claimed_until"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// Job model, a unit of delayed work run by the scheduler. Key is unique so
+// scheduling the same work twice replaces it, Ref names the record the job is
+// about so its jobs can be cancelled together. A job with an Interval runs
+// again after every success, a one-off job is removed once it has run.
+type Job struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Key         string             `bson:"key" json:"key"`
+	Kind        string             `bson:"kind" json:"kind"`
+	Ref         string             `bson:"ref,omitempty" json:"ref,omitempty"`
+	Payload     map[string]string  `bson:"payload,omitempty" json:"payload,omitempty"`
+	RunAt       time.Time          `bson:"run_at" json:"run_at"`
+	Interval    time.Duration      `bson:"interval,omitempty" json:"interval,omitempty"`
+	Status      string             `bson:"status" json:"status"`
+	Attempts    int                `bson:"attempts" json:"attempts"`
+	LastError   string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	LockedBy    string             `bson:"locked_by,omitempty" json:"locked_by,omitempty"`
+	LockedUntil *time.Time         `bson:"locked_until,omitempty" json:"locked_until,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// Notification model, one email in the outbox. It is written together with the
+// change it reports and sent by the outbox worker: Status goes from queued to
+// sent, to bounced when the mail server refuses it for good, or to failed
+// once NOTIFICATION_MAX_ATTEMPTS tries went wrong.
+type Notification struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	InteractionId string             `bson:"interaction_id,omitempty" json:"interaction_id,omitempty"`
+	TicketId      string             `bson:"ticket_id,omitempty" json:"ticket_id,omitempty"`
+	// UserID and CustomerID are the staff member and customer the email is
+	// about, who may see it is decided by them
+	UserID         *primitive.ObjectID      `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	CustomerID     *primitive.ObjectID      `bson:"customer_id,omitempty" json:"customer_id,omitempty"`
+	Change         string                   `bson:"change" json:"change"`
+	RecipientType  string                   `bson:"recipient_type" json:"recipient_type"`
+	To             string                   `bson:"to" json:"to"`
+	Subject        string                   `bson:"subject" json:"subject"`
+	Text           string                   `bson:"text,omitempty" json:"-"`
+	HTML           string                   `bson:"html" json:"-"`
+	Attachments    []NotificationAttachment `bson:"attachments,omitempty" json:"-"`
+	Status         string                   `bson:"status" json:"status"`
+	Attempts       int                      `bson:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time                `bson:"next_attempt_at" json:"next_attempt_at"`
+	LastError      string                   `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	LockedBy       string                   `bson:"locked_by,omitempty" json:"-"`
+	LockedUntil    *time.Time               `bson:"locked_until,omitempty" json:"-"`
+	SentAt         *time.Time               `bson:"sent_at,omitempty" json:"sent_at,omitempty"`
+	CreatedAt      time.Time                `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time                `bson:"updated_at" json:"updated_at"`
+	NotificationId string                   `bson:"notification_id" json:"notification_id"`
+}
+
+type NotificationAttachment struct {
+	Filename    string `bson:"filename" json:"filename"`
+	ContentType string `bson:"content_type" json:"content_type"`
+	Data        []byte `bson:"data" json:"-"`
+}
+
+// EmailTemplate model, an admin's replacement for one of the email templates
+// shipped with the application or loaded from EMAIL_TEMPLATE_DIR. Locale is a
+// language ("de") or a language and region ("en-GB").
+type EmailTemplate struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Locale     string             `bson:"locale" json:"locale"`
+	Name       string             `bson:"name" json:"name"`
+	Source     string             `bson:"source" json:"source"`
+	UpdatedBy  string             `bson:"updated_by" json:"updated_by"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+	TemplateId string             `bson:"template_id" json:"template_id"`
+}
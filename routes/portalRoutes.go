@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/roh4nyh/matrice_ai/controllers"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/middleware"
+)
+
+// PortalRoutes mounts the customer portal API under /portal, every route past
+// authentication requires a customer token.
+func PortalRoutes(api *gin.RouterGroup, stores *database.Stores) {
+	portal := api.Group("/portal")
+
+	// customer authentication
+	portal.POST("/signup", controller.CustomerSignUp(stores.Customers, stores.Accounts))
+	portal.POST("/login", controller.CustomerLogIn(stores.Customers, stores.Sessions))
+	portal.POST("/refresh", controller.RefreshCustomerToken(stores.Customers, stores.Sessions, stores.Revocations))
+
+	incomingRoutes := portal.Group("", middleware.AuthenticateCustomer(stores.Revocations))
+
+	can := func(permission string) gin.HandlerFunc {
+		return middleware.RequirePermission(stores.Roles, permission)
+	}
+
+	incomingRoutes.POST("/logout", controller.CustomerLogOut(stores.Sessions, stores.Revocations))
+
+	// raise a ticket against one of the customer's interactions
+	incomingRoutes.POST("/interactions/:interaction_id/tickets", can("tickets:write"), controller.CreateTicket(stores.Tickets, stores.Interactions, stores.Customers, stores.Queues, stores.Users, stores.Roles, stores.Slas))
+}
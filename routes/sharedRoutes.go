@@ -0,0 +1,56 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/roh4nyh/matrice_ai/controllers"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/helpers"
+	"github.com/roh4nyh/matrice_ai/middleware"
+)
+
+// SharedRoutes mounts the routes both audiences use, they accept a staff or a
+// customer token and rely on permission scopes to keep customers to their own records.
+func SharedRoutes(api *gin.RouterGroup, stores *database.Stores) {
+	incomingRoutes := api.Group("", middleware.AuthenticateAny(stores.Revocations))
+
+	can := func(permission string) gin.HandlerFunc {
+		return middleware.RequirePermission(stores.Roles, permission)
+	}
+
+	// customer profile
+	incomingRoutes.GET("/customers/:customer_id", can("customers:read"), controller.GetCustomer(stores.Customers))
+	incomingRoutes.PUT("/customers/:customer_id", can("customers:write"), controller.UpdateCustomer(stores.Customers))
+	incomingRoutes.DELETE("/customers/:customer_id", can("customers:delete"), controller.DeleteCustomer(stores.Customers))
+
+	// tickets
+	// get all tickets raised by a customer
+	incomingRoutes.GET("/customers/:customer_id/tickets", can("tickets:read"), controller.GetTicketsByCustomerID(stores.Tickets))
+
+	incomingRoutes.GET("/tickets/:ticket_id", can("tickets:read"), controller.GetTicket(stores.Tickets))
+	incomingRoutes.GET("/tickets/:ticket_id/history", can("tickets:read"), controller.GetTicketHistory(stores.Tickets))
+	incomingRoutes.PUT("/tickets/:ticket_id", can("tickets:write"), controller.UpdateTicket(stores.Tickets, stores.Customers, stores.Slas))
+	// ticket conversation, customers never see internal notes
+	incomingRoutes.GET("/tickets/:ticket_id/comments", can("tickets:read"), controller.GetTicketComments(stores.Tickets, stores.Comments))
+	incomingRoutes.POST("/tickets/:ticket_id/comments", can("tickets:write"), controller.CreateTicketComment(stores.Tickets, stores.Comments, stores.Customers, stores.EmailTemplates, stores.Notifications, stores.Transactions))
+	incomingRoutes.PUT("/tickets/:ticket_id/comments/:comment_id", can("tickets:write"), controller.UpdateTicketComment(stores.Tickets, stores.Comments))
+
+	// ticket attachments
+	incomingRoutes.GET("/tickets/:ticket_id/attachments", can("tickets:read"), controller.GetTicketAttachments(stores.Tickets, stores.Attachments))
+	incomingRoutes.POST("/tickets/:ticket_id/attachments", can("tickets:write"), controller.UploadTicketAttachment(stores.Tickets, stores.Attachments, stores.Blobs))
+	incomingRoutes.GET("/tickets/:ticket_id/attachments/:attachment_id/url", can("tickets:read"), controller.GetTicketAttachmentURL(stores.Tickets, stores.Attachments))
+
+	incomingRoutes.DELETE("/tickets/:ticket_id", can("tickets:delete"), controller.DeleteTicket(stores.Tickets, stores.Comments, stores.Attachments, stores.Blobs))
+
+	// interaction attachments, customers see the ones on their own interactions
+	incomingRoutes.GET("/interactions/:interaction_id/attachments", can("interactions:read"), controller.GetInteractionAttachments(stores.Interactions, stores.Attachments))
+	incomingRoutes.GET("/interactions/:interaction_id/attachments/:attachment_id/url", can("interactions:read"), controller.GetInteractionAttachmentURL(stores.Interactions, stores.Attachments))
+
+	// search checks the read permission of each kind of record itself
+	incomingRoutes.GET("/search", controller.Search(stores.Roles, stores.Users, stores.Customers, stores.Tickets, stores.Comments, stores.Interactions))
+
+	// signed download links carry their own authorization
+	api.GET("/attachments/:attachment_id/download", controller.DownloadAttachment(stores.Attachments, stores.Blobs))
+
+	// the mail provider authenticates with INBOUND_EMAIL_SECRET
+	api.POST("/inbound/email", controller.ReceiveInboundEmail(helpers.NewMailbox(stores)))
+}
@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/roh4nyh/matrice_ai/controllers"
+	"github.com/roh4nyh/matrice_ai/database"
+	"github.com/roh4nyh/matrice_ai/middleware"
+)
+
+// StaffRoutes mounts the API used by staff users under /staff, every route past
+// authentication requires a user token.
+func StaffRoutes(api *gin.RouterGroup, stores *database.Stores) {
+	staff := api.Group("/staff")
+
+	// user authentication
+	staff.POST("/signup", controller.UserSignUp(stores.Users, stores.Roles))
+	staff.POST("/login", controller.UserLogIn(stores.Users, stores.Sessions))
+	staff.POST("/refresh", controller.RefreshUserToken(stores.Users, stores.Sessions, stores.Revocations))
+
+	incomingRoutes := staff.Group("", middleware.AuthenticateUser(stores.Revocations))
+
+	can := func(permission string) gin.HandlerFunc {
+		return middleware.RequirePermission(stores.Roles, permission)
+	}
+
+	incomingRoutes.POST("/logout", controller.UserLogOut(stores.Sessions, stores.Revocations))
+
+	// calendar subscription, the feed itself is fetched with the token in its URL
+	incomingRoutes.POST("/calendar/token", can("interactions:read"), controller.CreateCalendarToken(stores.Users))
+	api.GET("/calendar/:token", controller.GetCalendarFeed(stores.Users, stores.Roles, stores.Interactions))
+
+	// user crud operations
+	incomingRoutes.GET("/users", can("users:read:any"), controller.GetUsers(stores.Users))
+	incomingRoutes.GET("/users/:user_id", can("users:read"), controller.GetUser(stores.Users))
+	incomingRoutes.PUT("/users/:user_id", can("users:write"), controller.UpdateUser(stores.Users))
+	incomingRoutes.DELETE("/users/:user_id", can("users:delete"), controller.DeleteUser(stores.Users))
+
+	// when a user, and optionally a customer, is busy or free, to propose meeting slots
+	incomingRoutes.GET("/users/:user_id/freebusy", can("interactions:read"), controller.GetUserFreeBusy(stores.Users, stores.Customers, stores.Interactions))
+
+	// roles administration
+	incomingRoutes.GET("/roles", can("roles:read:any"), controller.GetRoles(stores.Roles))
+	incomingRoutes.POST("/roles", can("roles:write:any"), controller.CreateRole(stores.Roles))
+	incomingRoutes.PUT("/roles/:role_name", can("roles:write:any"), controller.UpdateRole(stores.Roles))
+	incomingRoutes.PUT("/users/:user_id/role", can("roles:assign:any"), controller.AssignUserRole(stores.Users, stores.Roles))
+
+	// customers
+	incomingRoutes.GET("/customers", can("customers:read:any"), controller.GetCustomers(stores.Customers))
+	incomingRoutes.PUT("/customers/:customer_id/account", can("customers:write:any"), controller.LinkCustomerAccount(stores.Customers, stores.Accounts, stores.Tickets, stores.Interactions, stores.Deals, stores.Transactions))
+
+	// company accounts and the customers that are their contacts
+	incomingRoutes.GET("/accounts", can("accounts:read:any"), controller.GetAccounts(stores.Accounts))
+	incomingRoutes.POST("/accounts", can("accounts:write"), controller.CreateAccount(stores.Accounts, stores.Users))
+	incomingRoutes.GET("/accounts/:account_id", can("accounts:read"), controller.GetAccount(stores.Accounts, stores.Users, stores.Roles, stores.Customers, stores.Tickets, stores.Interactions))
+	incomingRoutes.PUT("/accounts/:account_id", can("accounts:write"), controller.UpdateAccount(stores.Accounts, stores.Users))
+	incomingRoutes.DELETE("/accounts/:account_id", can("accounts:delete"), controller.DeleteAccount(stores.Accounts, stores.Customers, stores.Tickets, stores.Interactions, stores.Deals, stores.Transactions))
+
+	// sales pipelines and the deals moving through them
+	incomingRoutes.GET("/pipelines", can("pipelines:read"), controller.GetPipelines(stores.Pipelines))
+	incomingRoutes.POST("/pipelines", can("pipelines:write:any"), controller.CreatePipeline(stores.Pipelines))
+	incomingRoutes.PUT("/pipelines/:pipeline_name", can("pipelines:write:any"), controller.UpdatePipeline(stores.Pipelines, stores.Deals))
+	incomingRoutes.GET("/deals", can("deals:read:any"), controller.GetDeals(stores.Deals))
+	incomingRoutes.GET("/deals/mine", can("deals:read"), controller.GetMyDeals(stores.Deals))
+	incomingRoutes.GET("/deals/forecast", can("deals:read"), controller.GetDealForecast(stores.Deals, stores.Pipelines))
+	incomingRoutes.POST("/deals", can("deals:write"), controller.CreateDeal(stores.Deals, stores.Pipelines, stores.Users, stores.Customers, stores.Accounts))
+	incomingRoutes.GET("/deals/:deal_id", can("deals:read"), controller.GetDeal(stores.Deals))
+	incomingRoutes.PUT("/deals/:deal_id", can("deals:write"), controller.UpdateDeal(stores.Deals, stores.Pipelines, stores.Users))
+	incomingRoutes.DELETE("/deals/:deal_id", can("deals:delete"), controller.DeleteDeal(stores.Deals, stores.Interactions, stores.Transactions))
+
+	// interactions
+	// get all interactions, ?type=task|meeting|followup|call filters them
+	incomingRoutes.GET("/interactions", can("interactions:read:any"), controller.GetAllInteractions(stores.Interactions, stores.Users))
+
+	// get all interactions of the current user
+	incomingRoutes.GET("/interactions/mine", can("interactions:read"), controller.GetInteractionsByUserID(stores.Interactions, stores.Users))
+
+	// create interaction with a customer
+	incomingRoutes.POST("/customers/:customer_id/interactions", can("interactions:write"), controller.CreateInteractionAndSendEmail(stores.Interactions, stores.Users, stores.Customers, stores.Deals, stores.Roles, stores.Jobs, stores.Notifications, stores.ScheduleLocks, stores.Transactions, stores.EmailTemplates))
+
+	// edit or reschedule an interaction, keeps the earlier versions
+	incomingRoutes.PUT("/interactions/:interaction_id", can("interactions:write"), controller.UpdateInteraction(stores.Interactions, stores.Tickets, stores.Users, stores.Customers, stores.Attachments, stores.Blobs, stores.Jobs, stores.Notifications, stores.ScheduleLocks, stores.Transactions, stores.EmailTemplates))
+	incomingRoutes.GET("/interactions/:interaction_id/history", can("interactions:read"), controller.GetInteractionHistory(stores.Interactions, stores.Users))
+
+	// make an interaction part of a deal, or of none
+	incomingRoutes.PUT("/interactions/:interaction_id/deal", can("interactions:write"), controller.LinkInteractionDeal(stores.Interactions, stores.Customers, stores.Deals, stores.Roles))
+
+	// mark a task interaction as done
+	incomingRoutes.POST("/interactions/:interaction_id/complete", can("interactions:write"), controller.CompleteInteractionTask(stores.Interactions, stores.Jobs))
+
+	// delete interaction by meet id
+	incomingRoutes.DELETE("/interactions/:interaction_id", can("interactions:delete"), controller.DeleteInteraction(stores.Interactions, stores.Users, stores.Customers, stores.Attachments, stores.Blobs, stores.Jobs, stores.Notifications, stores.Transactions, stores.EmailTemplates))
+
+	// emails sent about an interaction and whether they were delivered, ?interaction_id=
+	incomingRoutes.GET("/notifications", can("interactions:read"), controller.GetNotifications(stores.Notifications))
+
+	// attach a file (meeting deck, notes) to an interaction
+	incomingRoutes.POST("/interactions/:interaction_id/attachments", can("interactions:write"), controller.UploadInteractionAttachment(stores.Interactions, stores.Attachments, stores.Blobs))
+
+	// tickets
+	// get all tickets, ?sla=breached for the overdue ones
+	incomingRoutes.GET("/tickets", can("tickets:read:any"), controller.GetAllTickets(stores.Tickets))
+
+	// tickets assigned to the current user
+	incomingRoutes.GET("/tickets/mine", can("tickets:read"), controller.GetMyTickets(stores.Tickets))
+	incomingRoutes.POST("/tickets/:ticket_id/claim", can("tickets:assign"), controller.ClaimTicket(stores.Tickets, stores.Comments, stores.Transactions))
+	incomingRoutes.PUT("/tickets/:ticket_id/assignee", can("tickets:assign"), controller.AssignTicket(stores.Tickets, stores.Comments, stores.Users, stores.Roles, stores.Queues, stores.Transactions))
+
+	// SLA policies and the business hours they are counted in
+	incomingRoutes.GET("/sla/policies", can("sla:read"), controller.GetSlaPolicies(stores.Slas))
+	incomingRoutes.POST("/sla/policies", can("sla:write:any"), controller.CreateSlaPolicy(stores.Slas))
+	incomingRoutes.PUT("/sla/policies/:policy_name", can("sla:write:any"), controller.UpdateSlaPolicy(stores.Slas))
+	incomingRoutes.GET("/sla/calendars", can("sla:read"), controller.GetBusinessCalendars(stores.Slas))
+	incomingRoutes.POST("/sla/calendars", can("sla:write:any"), controller.CreateBusinessCalendar(stores.Slas))
+	incomingRoutes.PUT("/sla/calendars/:calendar_name", can("sla:write:any"), controller.UpdateBusinessCalendar(stores.Slas))
+
+	// email templates, an edited template replaces the default for its locale
+	incomingRoutes.GET("/email-templates", can("templates:read"), controller.GetEmailTemplates(stores.EmailTemplates))
+	incomingRoutes.GET("/email-templates/:locale/:name", can("templates:read"), controller.GetEmailTemplate(stores.EmailTemplates))
+	incomingRoutes.PUT("/email-templates/:locale/:name", can("templates:write:any"), controller.PutEmailTemplate(stores.EmailTemplates))
+	incomingRoutes.DELETE("/email-templates/:locale/:name", can("templates:write:any"), controller.DeleteEmailTemplate(stores.EmailTemplates))
+
+	// ticket queues
+	incomingRoutes.GET("/queues", can("queues:read"), controller.GetQueues(stores.Queues))
+	incomingRoutes.POST("/queues", can("queues:write:any"), controller.CreateQueue(stores.Queues, stores.Users))
+	incomingRoutes.PUT("/queues/:queue_name", can("queues:write:any"), controller.UpdateQueue(stores.Queues, stores.Users))
+}
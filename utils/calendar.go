@@ -0,0 +1,240 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// iCalendar METHOD values, REQUEST and CANCEL go out with emails, PUBLISH is the feed
+const (
+	ICS_REQUEST = "REQUEST"
+	ICS_CANCEL  = "CANCEL"
+	ICS_PUBLISH = "PUBLISH"
+)
+
+const icsTimeFormat = "20060102T150405Z"
+
+// local times go with a TZID naming their zone
+const icsLocalTimeFormat = "20060102T150405"
+
+// a repeating event gets the rules of its zone for this long, after which
+// calendars carry on with the last ones given
+const icsZoneHorizon = 10 * 365 * 24 * time.Hour
+
+// CalendarEvent is one RFC 5545 VEVENT, UID stays the same for the life of the
+// event and Sequence goes up whenever it changes. A repeating event carries its
+// RRule and ExDates, a single occurrence of one shares its UID and sets RecurrenceID.
+// Times are written in TimeZone when set, so occurrences keep their local time
+// across DST changes, and in UTC otherwise.
+type CalendarEvent struct {
+	UID          string
+	Sequence     int
+	Summary      string
+	Description  string
+	Location     string
+	Start        time.Time
+	End          time.Time
+	Organizer    string
+	Attendees    []string
+	Cancelled    bool
+	RRule        string
+	ExDates      []time.Time
+	RecurrenceID time.Time
+	TimeZone     *time.Location
+}
+
+// WriteCalendar renders events as a VCALENDAR document with CRLF line endings.
+func WriteCalendar(method string, events []CalendarEvent, now time.Time) []byte {
+	var ics strings.Builder
+
+	line := func(name, value string) {
+		ics.WriteString(foldICSLine(name + ":" + value))
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//matrice_ai//CRM//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", method)
+
+	for _, zone := range calendarZones(events) {
+		writeTimeZone(line, zone, events)
+	}
+
+	for _, event := range events {
+		timeLine := func(name string, t time.Time) {
+			if zone := eventZone(event); zone != nil {
+				line(name+";TZID="+zone.String(), t.In(zone).Format(icsLocalTimeFormat))
+				return
+			}
+			line(name, t.UTC().Format(icsTimeFormat))
+		}
+
+		line("BEGIN", "VEVENT")
+		line("UID", escapeICSText(event.UID))
+		line("SEQUENCE", fmt.Sprint(event.Sequence))
+		line("DTSTAMP", now.UTC().Format(icsTimeFormat))
+		timeLine("DTSTART", event.Start)
+		timeLine("DTEND", event.End)
+
+		if !event.RecurrenceID.IsZero() {
+			timeLine("RECURRENCE-ID", event.RecurrenceID)
+		}
+
+		if event.RRule != "" {
+			line("RRULE", event.RRule)
+		}
+
+		for _, exdate := range event.ExDates {
+			timeLine("EXDATE", exdate)
+		}
+
+		line("SUMMARY", escapeICSText(event.Summary))
+
+		if event.Description != "" {
+			line("DESCRIPTION", escapeICSText(event.Description))
+		}
+
+		if event.Location != "" {
+			line("LOCATION", escapeICSText(event.Location))
+		}
+
+		if event.Organizer != "" {
+			line("ORGANIZER", "mailto:"+event.Organizer)
+		}
+
+		for _, attendee := range event.Attendees {
+			ics.WriteString(foldICSLine("ATTENDEE;ROLE=REQ-PARTICIPANT;RSVP=TRUE:mailto:" + attendee))
+		}
+
+		if event.Cancelled {
+			line("STATUS", "CANCELLED")
+		} else {
+			line("STATUS", "CONFIRMED")
+		}
+		line("END", "VEVENT")
+	}
+
+	line("END", "VCALENDAR")
+	return []byte(ics.String())
+}
+
+// eventZone is the zone an event's times are written in, nil for UTC.
+func eventZone(event CalendarEvent) *time.Location {
+	if event.TimeZone == nil || event.TimeZone == time.UTC || event.TimeZone.String() == "UTC" {
+		return nil
+	}
+	return event.TimeZone
+}
+
+// calendarZones lists the zones the events use, each once.
+func calendarZones(events []CalendarEvent) []*time.Location {
+	zones := []*time.Location{}
+	seen := map[string]bool{}
+
+	for _, event := range events {
+		if zone := eventZone(event); zone != nil && !seen[zone.String()] {
+			seen[zone.String()] = true
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// writeTimeZone writes the VTIMEZONE the events in zone refer to, with the
+// offset in force at their earliest start and every change until they are over.
+func writeTimeZone(line func(name, value string), zone *time.Location, events []CalendarEvent) {
+	var earliest, horizon time.Time
+	for _, event := range events {
+		if eventZone(event) == nil || eventZone(event).String() != zone.String() {
+			continue
+		}
+
+		last := event.End
+		if event.RRule != "" {
+			last = last.Add(icsZoneHorizon)
+		}
+
+		if earliest.IsZero() || event.Start.Before(earliest) {
+			earliest = event.Start
+		}
+		if last.After(horizon) {
+			horizon = last
+		}
+	}
+
+	observance := func(at time.Time, since string) {
+		name, offset := at.In(zone).Zone()
+		_, before := at.Add(-time.Second).In(zone).Zone()
+		if since == "" {
+			since = at.In(time.FixedZone("", before)).Format(icsLocalTimeFormat)
+		}
+
+		kind := "STANDARD"
+		if at.In(zone).IsDST() {
+			kind = "DAYLIGHT"
+		}
+
+		line("BEGIN", kind)
+		line("DTSTART", since)
+		line("TZOFFSETFROM", icsOffset(before))
+		line("TZOFFSETTO", icsOffset(offset))
+		line("TZNAME", escapeICSText(name))
+		line("END", kind)
+	}
+
+	line("BEGIN", "VTIMEZONE")
+	line("TZID", zone.String())
+
+	// the offset in force at the earliest start, from when it began
+	start, end := earliest.In(zone).ZoneBounds()
+	if start.IsZero() {
+		observance(earliest, "19700101T000000")
+	} else {
+		observance(start, "")
+	}
+
+	for !end.IsZero() && end.Before(horizon) {
+		observance(end, "")
+		_, end = end.In(zone).ZoneBounds()
+	}
+	line("END", "VTIMEZONE")
+}
+
+// icsOffset writes a UTC offset in seconds as +HHMM.
+func icsOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+}
+
+// escapeICSText escapes a TEXT value, RFC 5545 section 3.3.11.
+func escapeICSText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// foldICSLine ends a content line with CRLF, splitting it so no line is longer
+// than 75 octets without cutting a UTF-8 sequence in half.
+func foldICSLine(s string) string {
+	var folded strings.Builder
+
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+
+		folded.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		// continuation lines lose one octet to the leading space
+		limit = 74
+	}
+
+	folded.WriteString(s + "\r\n")
+	return folded.String()
+}
@@ -1,141 +1,88 @@
-package utils
-
-import (
-	"crypto/tls"
-	"fmt"
-	"net/smtp"
-	"os"
-
-	"github.com/roh4nyh/matrice_ai/models"
-)
-
-func SendInteractionNotificationWithEmail(interaction models.Interaction, emailTo, meetingStartTime string) error {
-	host := os.Getenv("SMTP_HOST")
-	port := os.Getenv("SMTP_PORT")
-	from := os.Getenv("SMTP_MAIL")
-	password := os.Getenv("SMTP_PASSWORD")
-
-	//	example@example.com		EXAMPLE_PASSWORD	smtp.example.com
-	auth := smtp.PlainAuth(
-		"",
-		from,
-		password,
-		host,
-	)
-
-	subject := fmt.Sprintf("Meeting Notification: %s", *interaction.Title)
-
-	body := fmt.Sprintf(`
-<!DOCTYPE html>
-<html>
-<head>
-    <meta charset="UTF-8">
-    <style>
-        body {
-            font-family: Arial, sans-serif;
-            line-height: 1.6;
-        }
-        .container {
-            padding: 20px;
-            border: 1px solid #ddd;
-            border-radius: 8px;
-            background-color: #f9f9f9;
-        }
-        .header {
-            font-size: 18px;
-            font-weight: bold;
-            color: #333;
-            margin-bottom: 10px;
-        }
-        .content {
-            font-size: 16px;
-            color: #555;
-        }
-        .footer {
-            font-size: 14px;
-            color: #888;
-            margin-top: 20px;
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <div class="header">Meeting Notification</div>
-        <div class="content">
-            <p>Dear User,</p>
-            <p>You have a scheduled meeting with the following details:</p>
-            <p><strong>Interaction ID:</strong> %s</p>
-            <p><strong>Title:</strong> %s</p>
-            <p><strong>Description:</strong> %s</p>
-            <p><strong>Start Time:</strong> %s</p>
-            <p>Please ensure you are prepared for the meeting.</p>
-        </div>
-        <div class="footer">
-            <p>Thank you,</p>
-            <p>Support Team</p>
-        </div>
-    </div>
-</body>
-</html>
-`, interaction.CustomerID.Hex(), *interaction.Title, *interaction.Description, meetingStartTime)
-
-	// subject := "Ticket Created: " + *interaction.Title
-	// body := fmt.Sprintf("Dear User,\n\nYour Interaction with ID %s has been created.\n\nDetails:\nDescription: %s\n\nThank you,\nSupport Team", interaction.CustomerID, *interaction.Title, *interaction.Description)
-
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         host,
-	}
-
-	client, err := smtp.Dial(host + ":" + port)
-	if err != nil {
-		fmt.Println("error while connecting to smtp...")
-		return err
-	}
-
-	client.StartTLS(tlsConfig)
-
-	if err := client.Auth(auth); err != nil {
-		fmt.Println("error while authenticating to SMTP...")
-		return err
-	}
-
-	if err := client.Mail(from); err != nil {
-		fmt.Println("error while initiating mail transaction...")
-		return err
-	}
-
-	if err := client.Rcpt(emailTo); err != nil {
-		fmt.Println("error while issuing RCPT(CMD) to SMTP...")
-		return err
-	}
-
-	w, err := client.Data()
-	if err != nil {
-		fmt.Println("error while issuing DATA(CMD) to SMTP...")
-		return err
-	}
-
-	_, err = w.Write([]byte(
-		fmt.Sprintf("MIME-Version: %v\r\n", "1.0") +
-			fmt.Sprintf("Content-type: %v\r\n", "text/html; charset=UTF-8") +
-			fmt.Sprintf("From: %v\r\n", from) +
-			fmt.Sprintf("To: %v\r\n", emailTo) +
-			fmt.Sprintf("Subject: %v\r\n", subject) +
-			fmt.Sprintf("%v\r\n", body),
-	))
-
-	if err != nil {
-		fmt.Println("error while writing data to the header/body")
-		return err
-	}
-
-	err = w.Close()
-	if err != nil {
-		fmt.Println("error closing writer")
-		return err
-	}
-
-	client.Quit()
-	return nil
-}
+package utils
+
+import (
+	"fmt"
+)
+
+// what happened to the interaction an email is about
+const (
+	NOTICE_CREATED     = "created"
+	NOTICE_UPDATED     = "updated"
+	NOTICE_RESCHEDULED = "rescheduled"
+	NOTICE_CANCELLED   = "cancelled"
+	NOTICE_REMINDER    = "reminder"
+)
+
+// InteractionEmail is what the interaction email templates are rendered with,
+// Type is one of the models.INTERACTION_ values and Time is already written
+// out in the recipient's time zone and locale.
+type InteractionEmail struct {
+	Recipient   string
+	Type        string
+	Change      string
+	Title       string
+	Description string
+	Location    string
+	Time        string
+}
+
+// InteractionMessage renders the email about an interaction from the templates
+// of email.Change (one of the NOTICE_ values) in locale. A non-empty invite is
+// attached as a text/calendar part.
+func InteractionMessage(templates EmailTemplates, locale string, email InteractionEmail, emailTo string, invite []byte) (Message, error) {
+	rendered, err := templates.Render(locale, InteractionTemplateName(email.Change), email)
+	if err != nil {
+		return Message{}, err
+	}
+
+	message := Message{
+		To:      []string{emailTo},
+		Subject: rendered.Subject,
+		Text:    rendered.Text,
+		HTML:    rendered.HTML,
+	}
+
+	if len(invite) > 0 {
+		method := ICS_REQUEST
+		if email.Change == NOTICE_CANCELLED {
+			method = ICS_CANCEL
+		}
+
+		message.Attachments = append(message.Attachments, MessageAttachment{
+			Filename:    "invite.ics",
+			ContentType: fmt.Sprintf("text/calendar; charset=UTF-8; method=%s", method),
+			Data:        invite,
+		})
+	}
+	return message, nil
+}
+
+// TicketEmail is what the ticket reply template is rendered with, Title is the
+// start of the ticket and Description the reply. Location and Time stay empty,
+// they are there for the layout every email shares.
+type TicketEmail struct {
+	Recipient   string
+	Author      string
+	TicketId    string
+	Title       string
+	Description string
+	Location    string
+	Time        string
+}
+
+// TicketReplyMessage renders the email telling a customer staff answered their
+// ticket. The subject ends in the "[#<ticket id>]" token whatever the template
+// says, so an answer to the email finds its way back to the ticket.
+func TicketReplyMessage(templates EmailTemplates, locale string, email TicketEmail, emailTo string) (Message, error) {
+	rendered, err := templates.Render(locale, TICKET_REPLY_TEMPLATE, email)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      []string{emailTo},
+		Subject: fmt.Sprintf("%s [#%s]", rendered.Subject, email.TicketId),
+		Text:    rendered.Text,
+		HTML:    rendered.HTML,
+	}, nil
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates/email
+var builtinEmailTemplates embed.FS
+
+// DEFAULT_TEMPLATE_LOCALE is used for any template missing in the recipient's language.
+const DEFAULT_TEMPLATE_LOCALE = "en"
+
+// An email is put together from three templates of the recipient's locale:
+// "layout" defines "subject", "text" and "html", "common" the words every
+// email shares, and the template of the notification kind (interaction_created,
+// ticket_reply and so on) what is particular to it.
+const (
+	EMAIL_TEMPLATE_LAYOUT = "layout"
+	EMAIL_TEMPLATE_COMMON = "common"
+)
+
+// EmailTemplateNames lists every template that can be replaced.
+var EmailTemplateNames = []string{
+	EMAIL_TEMPLATE_LAYOUT,
+	EMAIL_TEMPLATE_COMMON,
+	InteractionTemplateName(NOTICE_CREATED),
+	InteractionTemplateName(NOTICE_UPDATED),
+	InteractionTemplateName(NOTICE_RESCHEDULED),
+	InteractionTemplateName(NOTICE_CANCELLED),
+	InteractionTemplateName(NOTICE_REMINDER),
+	TICKET_REPLY_TEMPLATE,
+}
+
+// TICKET_REPLY_TEMPLATE is the template of the email about a staff reply on a ticket.
+const TICKET_REPLY_TEMPLATE = "ticket_reply"
+
+// InteractionTemplateName names the template of an email about change (one of the NOTICE_ values).
+func InteractionTemplateName(change string) string {
+	return "interaction_" + change
+}
+
+var templateLocalePattern = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
+
+// IsTemplateLocale accepts a language ("de") or a language and region ("de-AT").
+func IsTemplateLocale(locale string) bool {
+	return templateLocalePattern.MatchString(locale)
+}
+
+// EmailTemplates holds template sources by locale and name.
+type EmailTemplates map[string]map[string]string
+
+// BuiltinEmailTemplates returns the templates shipped with the application.
+func BuiltinEmailTemplates() (EmailTemplates, error) {
+	dir, err := fs.Sub(builtinEmailTemplates, "templates/email")
+	if err != nil {
+		return nil, err
+	}
+	return LoadEmailTemplates(dir)
+}
+
+// LoadEmailTemplates reads <locale>/<name>.tmpl files, other files are ignored.
+func LoadEmailTemplates(dir fs.FS) (EmailTemplates, error) {
+	files, err := fs.Glob(dir, "*/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	templates := EmailTemplates{}
+	for _, file := range files {
+		locale, name := path.Dir(file), strings.TrimSuffix(path.Base(file), ".tmpl")
+		if !IsTemplateLocale(locale) || !slices.Contains(EmailTemplateNames, name) {
+			continue
+		}
+
+		source, err := fs.ReadFile(dir, file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading email template %s: %w", file, err)
+		}
+		templates.Set(locale, name, string(source))
+	}
+	return templates, nil
+}
+
+func (t EmailTemplates) Set(locale, name, source string) {
+	if t[locale] == nil {
+		t[locale] = map[string]string{}
+	}
+	t[locale][name] = source
+}
+
+// Merge returns a copy of t with the templates of other in place of its own.
+func (t EmailTemplates) Merge(other EmailTemplates) EmailTemplates {
+	merged := EmailTemplates{}
+	for _, templates := range []EmailTemplates{t, other} {
+		for locale, names := range templates {
+			for name, source := range names {
+				merged.Set(locale, name, source)
+			}
+		}
+	}
+	return merged
+}
+
+// Locales lists the locales that have templates of their own, sorted.
+func (t EmailTemplates) Locales() []string {
+	locales := []string{}
+	for locale := range t {
+		locales = append(locales, locale)
+	}
+	slices.Sort(locales)
+	return locales
+}
+
+// Lookup finds the template for locale, falling back to its language and then
+// DEFAULT_TEMPLATE_LOCALE. It reports the locale the template was found in.
+func (t EmailTemplates) Lookup(locale, name string) (source, foundIn string, ok bool) {
+	for _, candidate := range templateLocales(locale) {
+		if source, ok := t[candidate][name]; ok {
+			return source, candidate, true
+		}
+	}
+	return "", "", false
+}
+
+func templateLocales(locale string) []string {
+	locales := []string{locale}
+	if language, _, ok := strings.Cut(locale, "-"); ok {
+		locales = append(locales, language)
+	}
+	return append(locales, DEFAULT_TEMPLATE_LOCALE)
+}
+
+// RenderedEmail is the subject and both bodies of an email.
+type RenderedEmail struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Render puts the email called name together for locale. The subject and the
+// text body come from text/template, the HTML body from html/template, which
+// escapes everything data brings in.
+func (t EmailTemplates) Render(locale, name string, data any) (RenderedEmail, error) {
+	var email RenderedEmail
+
+	text := texttemplate.New(name).Option("missingkey=error")
+	html := htmltemplate.New(name).Option("missingkey=error")
+
+	for _, part := range []string{EMAIL_TEMPLATE_LAYOUT, EMAIL_TEMPLATE_COMMON, name} {
+		source, foundIn, ok := t.Lookup(locale, part)
+		if !ok {
+			return email, fmt.Errorf("no email template %s for %s", part, locale)
+		}
+
+		if _, err := text.Parse(source); err != nil {
+			return email, fmt.Errorf("error parsing email template %s/%s: %w", foundIn, part, err)
+		}
+
+		if _, err := html.Parse(source); err != nil {
+			return email, fmt.Errorf("error parsing email template %s/%s: %w", foundIn, part, err)
+		}
+	}
+
+	var subject, body, htmlBody bytes.Buffer
+
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return email, fmt.Errorf("error rendering subject of %s: %w", name, err)
+	}
+
+	if err := text.ExecuteTemplate(&body, "text", data); err != nil {
+		return email, fmt.Errorf("error rendering text of %s: %w", name, err)
+	}
+
+	if err := html.ExecuteTemplate(&htmlBody, "html", data); err != nil {
+		return email, fmt.Errorf("error rendering HTML of %s: %w", name, err)
+	}
+
+	// a subject is one header line whatever the template or the data put in it
+	email.Subject = strings.Join(strings.Fields(subject.String()), " ")
+	email.Text = strings.TrimSpace(body.String()) + "\n"
+	email.HTML = htmlBody.String()
+
+	if email.Subject == "" {
+		return email, errors.New("email template rendered an empty subject")
+	}
+	return email, nil
+}
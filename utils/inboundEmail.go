@@ -0,0 +1,263 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// ReceivedEmail is what ParseEmail reads out of an incoming message.
+type ReceivedEmail struct {
+	MessageID string
+	// From is the sender's address without the display name
+	From     string
+	FromName string
+	Subject  string
+	// Text is the plain text body, or the HTML body stripped of its markup
+	// when the message has no plain text
+	Text string
+	// References lists the Message-IDs of In-Reply-To and References without
+	// the angle brackets, the message replied to first
+	References []string
+	// AutoReply is set for out of office notices, bounces and mailing list
+	// traffic, which must never be answered or filed
+	AutoReply bool
+	// CalendarReply is set when the message answers a meeting invite, e.g. the
+	// "Accepted:" email a calendar sends when the invite is accepted
+	CalendarReply bool
+	Attachments   []MessageAttachment
+}
+
+// EMAIL_MAX_PARTS caps the MIME parts read from one message, nested ones included.
+const EMAIL_MAX_PARTS = 100
+
+var ErrTooManyParts = fmt.Errorf("email has more than %d MIME parts", EMAIL_MAX_PARTS)
+
+var messageIDPattern = regexp.MustCompile(`<([^<>\s]+)>`)
+
+// ParseEmail reads a raw RFC 5322 message. Quoted-printable and base64 parts
+// are decoded and UTF-8 and Latin-1 text is returned as UTF-8, other charsets
+// are passed through as they are.
+func ParseEmail(raw io.Reader) (ReceivedEmail, error) {
+	var email ReceivedEmail
+
+	message, err := mail.ReadMessage(raw)
+	if err != nil {
+		return email, fmt.Errorf("error reading email: %w", err)
+	}
+
+	from, err := message.Header.AddressList("From")
+	if err != nil || len(from) == 0 {
+		return email, errors.New("email has no valid From address")
+	}
+	email.From = from[0].Address
+	email.FromName = from[0].Name
+
+	decoder := new(mime.WordDecoder)
+	email.Subject = message.Header.Get("Subject")
+	if subject, err := decoder.DecodeHeader(email.Subject); err == nil {
+		email.Subject = subject
+	}
+	email.Subject = strings.Join(strings.Fields(email.Subject), " ")
+
+	if ids := messageIDPattern.FindStringSubmatch(message.Header.Get("Message-ID")); ids != nil {
+		email.MessageID = ids[1]
+	}
+
+	// In-Reply-To names the message answered, References the thread oldest first
+	for _, match := range messageIDPattern.FindAllStringSubmatch(message.Header.Get("In-Reply-To"), -1) {
+		email.References = append(email.References, match[1])
+	}
+	references := messageIDPattern.FindAllStringSubmatch(message.Header.Get("References"), -1)
+	for i := len(references) - 1; i >= 0; i-- {
+		email.References = append(email.References, references[i][1])
+	}
+
+	email.AutoReply = isAutoReply(message.Header)
+
+	reader := emailReader{email: &email}
+	if err := reader.read(textproto.MIMEHeader(message.Header), message.Body); err != nil {
+		return email, err
+	}
+
+	email.Text = reader.text
+	if email.Text == "" && reader.html != "" {
+		email.Text = htmlText(reader.html)
+	}
+	email.Text = strings.TrimSpace(strings.ReplaceAll(email.Text, "\r\n", "\n"))
+	return email, nil
+}
+
+// isAutoReply follows RFC 3834 and the headers older responders still send.
+func isAutoReply(header mail.Header) bool {
+	if value := strings.ToLower(header.Get("Auto-Submitted")); value != "" && value != "no" {
+		return true
+	}
+
+	switch strings.ToLower(header.Get("Precedence")) {
+	case "bulk", "junk", "list", "auto_reply":
+		return true
+	}
+
+	if header.Get("X-Autoreply") != "" || header.Get("X-Autorespond") != "" || header.Get("List-Id") != "" {
+		return true
+	}
+
+	// bounces are sent with an empty return path
+	return strings.TrimSpace(header.Get("Return-Path")) == "<>"
+}
+
+// emailReader walks the MIME tree, keeping the first text and HTML body it
+// meets and every part that is a file.
+type emailReader struct {
+	email *ReceivedEmail
+	text  string
+	html  string
+	parts int
+}
+
+func (r *emailReader) read(header textproto.MIMEHeader, body io.Reader) error {
+	r.parts++
+	if r.parts > EMAIL_MAX_PARTS {
+		return ErrTooManyParts
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading email part: %w", err)
+			}
+
+			if err := r.read(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(transferDecoder(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("error decoding email part: %w", err)
+	}
+
+	if mediaType == "text/calendar" && (strings.EqualFold(params["method"], "REPLY") || bytes.Contains(data, []byte("METHOD:REPLY"))) {
+		r.email.CalendarReply = true
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	fileName := dispositionParams["filename"]
+	if fileName == "" {
+		fileName = params["name"]
+	}
+
+	switch {
+	case disposition != "attachment" && fileName == "" && mediaType == "text/plain" && r.text == "":
+		r.text = charsetText(params["charset"], data)
+	case disposition != "attachment" && fileName == "" && mediaType == "text/html" && r.html == "":
+		r.html = charsetText(params["charset"], data)
+	case disposition == "attachment" || fileName != "" || !strings.HasPrefix(mediaType, "text/"):
+		if decoded, err := new(mime.WordDecoder).DecodeHeader(fileName); err == nil {
+			fileName = decoded
+		}
+		if fileName == "" {
+			fileName = "attachment"
+			if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 {
+				fileName += extensions[0]
+			}
+		}
+
+		r.email.Attachments = append(r.email.Attachments, MessageAttachment{
+			Filename:    fileName,
+			ContentType: mediaType,
+			Data:        data,
+		})
+	}
+	return nil
+}
+
+func transferDecoder(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// charsetText returns data as UTF-8 where it can, Latin-1 is widened byte by byte.
+func charsetText(charset string, data []byte) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	default:
+		return strings.ToValidUTF8(string(data), "\uFFFD")
+	}
+}
+
+var (
+	htmlDropPattern  = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)>`)
+	htmlBreakPattern = regexp.MustCompile(`(?i)<(br|/p|/div|/li|/tr|/h[1-6])\b[^>]*>`)
+	htmlTagPattern   = regexp.MustCompile(`<[^>]*>`)
+	blankLines       = regexp.MustCompile(`\n[ \t]*\n(\s*\n)+`)
+)
+
+// htmlText reduces an HTML body to the text a reader would see, good enough
+// for a ticket, not a renderer.
+func htmlText(body string) string {
+	body = htmlDropPattern.ReplaceAllString(body, "")
+	body = htmlBreakPattern.ReplaceAllString(body, "\n")
+	body = htmlTagPattern.ReplaceAllString(body, "")
+	body = html.UnescapeString(body)
+	return blankLines.ReplaceAllString(body, "\n\n")
+}
+
+// ReplyText drops the quoted message mail clients put below a reply: the
+// trailing lines starting with ">" and the "... wrote:" line above them.
+func ReplyText(text string) string {
+	lines := strings.Split(text, "\n")
+
+	end, quoted := len(lines), false
+	for end > 0 && (strings.HasPrefix(lines[end-1], ">") || strings.TrimSpace(lines[end-1]) == "") {
+		quoted = quoted || strings.HasPrefix(lines[end-1], ">")
+		end--
+	}
+
+	if !quoted {
+		return text
+	}
+
+	if end > 0 && strings.HasSuffix(strings.TrimSpace(lines[end-1]), ":") {
+		end--
+	}
+
+	reply := strings.TrimSpace(strings.Join(lines[:end], "\n"))
+	if reply == "" {
+		return text
+	}
+	return reply
+}
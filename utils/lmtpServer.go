@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// how long a client may stay silent before the connection is dropped
+const lmtpIdleTimeout = 5 * time.Minute
+
+// LMTPHandler takes one message, an error that is a *textproto.Error is sent
+// back with its code, any other one as a temporary failure the MTA retries.
+type LMTPHandler func(ctx context.Context, raw io.Reader) error
+
+// LMTPServer takes the mail a local MTA delivers over LMTP (RFC 2033), e.g.
+// Postfix with "lmtp:inet:127.0.0.1:2424" as the transport of the support address.
+// It trusts whoever connects, listen on loopback or a private network only.
+type LMTPServer struct {
+	Addr    string
+	MaxSize int64
+	Handler LMTPHandler
+}
+
+// ListenAndServe accepts connections until ctx is done.
+func (s *LMTPServer) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("error listening for LMTP: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("error accepting LMTP connection: %w", err)
+		}
+
+		go s.serve(ctx, conn)
+	}
+}
+
+func (s *LMTPServer) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	hostname, _ := os.Hostname()
+
+	reply := func(code int, message string) {
+		text.PrintfLine("%d %s", code, message)
+	}
+
+	greeted, sender := false, false
+	var recipients []string
+
+	reply(220, hostname+" LMTP ready")
+	for {
+		conn.SetReadDeadline(time.Now().Add(lmtpIdleTimeout))
+
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "LHLO":
+			greeted, sender, recipients = true, false, nil
+			text.PrintfLine("250-%s", hostname)
+			text.PrintfLine("250-PIPELINING")
+			text.PrintfLine("250-ENHANCEDSTATUSCODES")
+			text.PrintfLine("250-8BITMIME")
+			text.PrintfLine("250 SIZE %d", s.MaxSize)
+		case "HELO", "EHLO":
+			reply(500, "5.5.1 this is an LMTP server, use LHLO")
+		case "MAIL":
+			if !greeted {
+				reply(503, "5.5.1 send LHLO first")
+				continue
+			}
+			if !strings.HasPrefix(strings.ToUpper(arg), "FROM:") {
+				reply(501, "5.5.4 syntax: MAIL FROM:<address>")
+				continue
+			}
+			sender, recipients = true, nil
+			reply(250, "2.1.0 OK")
+		case "RCPT":
+			if !sender {
+				reply(503, "5.5.1 send MAIL first")
+				continue
+			}
+			if !strings.HasPrefix(strings.ToUpper(arg), "TO:") {
+				reply(501, "5.5.4 syntax: RCPT TO:<address>")
+				continue
+			}
+			recipients = append(recipients, strings.TrimSpace(arg[3:]))
+			reply(250, "2.1.5 OK")
+		case "DATA":
+			if len(recipients) == 0 {
+				reply(503, "5.5.1 send RCPT first")
+				continue
+			}
+			reply(354, "end data with <CR><LF>.<CR><LF>")
+
+			code, message := s.deliver(ctx, text)
+			if code == 0 {
+				return
+			}
+
+			// LMTP answers once for every recipient, the message is filed once for all of them
+			for range recipients {
+				reply(code, message)
+			}
+			sender, recipients = false, nil
+		case "RSET":
+			sender, recipients = false, nil
+			reply(250, "2.0.0 OK")
+		case "NOOP":
+			reply(250, "2.0.0 OK")
+		case "QUIT":
+			reply(221, "2.0.0 bye")
+			return
+		default:
+			reply(502, "5.5.2 command not recognized")
+		}
+	}
+}
+
+// deliver reads the message and hands it to Handler, it returns the reply or
+// a zero code when the connection broke.
+func (s *LMTPServer) deliver(ctx context.Context, text *textproto.Conn) (int, string) {
+	dot := text.DotReader()
+
+	data, err := io.ReadAll(io.LimitReader(dot, s.MaxSize+1))
+	if err != nil {
+		return 0, ""
+	}
+
+	// the rest of an oversized message still has to be read up to the dot
+	if _, err := io.Copy(io.Discard, dot); err != nil {
+		return 0, ""
+	}
+
+	if int64(len(data)) > s.MaxSize {
+		return 552, "5.3.4 message too big"
+	}
+
+	err = s.Handler(ctx, bytes.NewReader(data))
+
+	var replyErr *textproto.Error
+	switch {
+	case err == nil:
+		return 250, "2.0.0 OK"
+	case errors.As(err, &replyErr):
+		return replyErr.Code, replyErr.Msg
+	default:
+		log.Printf("error handling LMTP message: %v", err)
+		return 451, "4.3.0 message could not be filed, try again later"
+	}
+}
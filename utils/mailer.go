@@ -0,0 +1,349 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Mailer sends emails, NewMailer picks the implementation.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+	// Close drops any connection the mailer keeps open.
+	Close() error
+}
+
+// Message is one email, an empty From is filled in with the mailer's sender.
+// With Text set it goes out as multipart/alternative, so mail clients that do
+// not show HTML show the plain text instead. ID is the part of the Message-ID
+// before the @, so replies can be traced back to the message, a random one is
+// made up when it is empty.
+type Message struct {
+	ID          string
+	From        string
+	To          []string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []MessageAttachment
+}
+
+// MessageAttachment is a file sent along with a message, base64 encoded.
+type MessageAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Bytes renders the message as it goes over the wire, just its body or, with
+// attachments, a multipart/mixed message carrying the body and the files.
+func (m Message) Bytes(now time.Time) []byte {
+	var message bytes.Buffer
+
+	header := func(name, value string) {
+		message.WriteString(fmt.Sprintf("%s: %s\r\n", name, value))
+	}
+
+	header("MIME-Version", "1.0")
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", messageID(m.ID, m.From))
+	header("From", m.From)
+	header("To", strings.Join(m.To, ", "))
+	header("Subject", mime.QEncoding.Encode("UTF-8", m.Subject))
+
+	bodyHeader, body := m.body()
+
+	if len(m.Attachments) == 0 {
+		for _, name := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+			if value := bodyHeader.Get(name); value != "" {
+				header(name, value)
+			}
+		}
+		message.WriteString("\r\n")
+		message.Write(body)
+		return message.Bytes()
+	}
+
+	parts := multipart.NewWriter(&message)
+	header("Content-Type", "multipart/mixed; boundary="+parts.Boundary())
+	message.WriteString("\r\n")
+
+	part, _ := parts.CreatePart(bodyHeader)
+	part.Write(body)
+
+	for _, attachment := range m.Attachments {
+		part, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", attachment.Filename)},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+
+		// base64 lines must not be longer than 76 characters
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+
+	parts.Close()
+	return message.Bytes()
+}
+
+// body is the HTML part or, with Text set, a multipart/alternative of the
+// text and the HTML, the one mail clients prefer last.
+func (m Message) body() (textproto.MIMEHeader, []byte) {
+	if m.Text == "" {
+		return textPart("text/html; charset=UTF-8", m.HTML)
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	for _, content := range []struct{ contentType, text string }{
+		{"text/plain; charset=UTF-8", m.Text},
+		{"text/html; charset=UTF-8", m.HTML},
+	} {
+		header, data := textPart(content.contentType, content.text)
+		part, _ := parts.CreatePart(header)
+		part.Write(data)
+	}
+
+	parts.Close()
+	return textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + parts.Boundary()}}, body.Bytes()
+}
+
+// textPart encodes text as quoted-printable, which keeps any character safe
+// on servers that only take 7-bit mail.
+func textPart(contentType, text string) (textproto.MIMEHeader, []byte) {
+	var encoded bytes.Buffer
+	w := quotedprintable.NewWriter(&encoded)
+	w.Write([]byte(text))
+	w.Close()
+
+	header := textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	}
+	return header, encoded.Bytes()
+}
+
+// messageID makes the Message-ID in the domain of the sender, id must be
+// unique and is random when empty.
+func messageID(id, from string) string {
+	domain := "localhost"
+	if _, after, ok := strings.Cut(from, "@"); ok {
+		domain = strings.TrimSuffix(after, ">")
+	}
+
+	if id == "" {
+		random := make([]byte, 16)
+		rand.Read(random)
+		id = hex.EncodeToString(random)
+	}
+	return fmt.Sprintf("<%s@%s>", id, domain)
+}
+
+// MailConfig selects the mailer, MAIL_DRIVER is "smtp" (the default when
+// SMTP_HOST is set), "file" (the default otherwise) or "capture".
+type MailConfig struct {
+	Driver string
+	From   string
+	// SMTP server, SMTP_TLS is "starttls" (required), "implicit", "opportunistic"
+	// or "none" and defaults to implicit on port 465 and starttls elsewhere
+	Host        string
+	Port        string
+	Username    string
+	Password    string
+	TLS         string
+	IdleTimeout time.Duration
+	PoolSize    int
+	// file drop
+	Dir string
+}
+
+// SMTP_TLS values
+const (
+	SMTP_TLS_STARTTLS      = "starttls"
+	SMTP_TLS_IMPLICIT      = "implicit"
+	SMTP_TLS_OPPORTUNISTIC = "opportunistic"
+	SMTP_TLS_NONE          = "none"
+)
+
+func LoadMailConfig() (MailConfig, error) {
+	config := MailConfig{
+		Driver:      os.Getenv("MAIL_DRIVER"),
+		From:        os.Getenv("SMTP_MAIL"),
+		Host:        os.Getenv("SMTP_HOST"),
+		Port:        os.Getenv("SMTP_PORT"),
+		Username:    os.Getenv("SMTP_USERNAME"),
+		Password:    os.Getenv("SMTP_PASSWORD"),
+		TLS:         os.Getenv("SMTP_TLS"),
+		IdleTimeout: 30 * time.Second,
+		PoolSize:    4,
+		Dir:         os.Getenv("MAIL_DIR"),
+	}
+
+	// without a mail server configured messages are dropped in MAIL_DIR
+	if config.Driver == "" {
+		config.Driver = "smtp"
+		if config.Host == "" {
+			config.Driver = "file"
+		}
+	}
+
+	// SMTP_MAIL has always been both the sender and the login
+	if config.Username == "" {
+		config.Username = config.From
+	}
+
+	if config.From == "" {
+		config.From = "crm@localhost"
+	}
+
+	if config.Port == "" {
+		config.Port = "587"
+	}
+
+	if config.TLS == "" {
+		config.TLS = SMTP_TLS_STARTTLS
+		if config.Port == "465" {
+			config.TLS = SMTP_TLS_IMPLICIT
+		}
+	}
+
+	if config.Dir == "" {
+		config.Dir = "maildir"
+	}
+
+	if value := os.Getenv("SMTP_IDLE_TIMEOUT"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid SMTP_IDLE_TIMEOUT %q: %w", value, err)
+		}
+		config.IdleTimeout = d
+	}
+
+	if value := os.Getenv("SMTP_POOL_SIZE"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return config, fmt.Errorf("invalid SMTP_POOL_SIZE %q, it must be a positive number", value)
+		}
+		config.PoolSize = n
+	}
+	return config, nil
+}
+
+func NewMailer(config MailConfig) (Mailer, error) {
+	switch config.Driver {
+	case "smtp":
+		if config.Host == "" {
+			return nil, fmt.Errorf("SMTP_HOST must be set for the smtp mail driver")
+		}
+
+		if !slices.Contains([]string{SMTP_TLS_STARTTLS, SMTP_TLS_IMPLICIT, SMTP_TLS_OPPORTUNISTIC, SMTP_TLS_NONE}, config.TLS) {
+			return nil, fmt.Errorf("unknown SMTP_TLS %q", config.TLS)
+		}
+		return newSMTPMailer(config), nil
+	case "file":
+		return NewFileMailer(config.Dir, config.From)
+	case "capture":
+		return NewCaptureMailer(config.From), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", config.Driver)
+	}
+}
+
+// FileMailer drops every message into a maildir, for local development
+// without a mail server. Any mail client that reads maildirs can open it.
+type FileMailer struct {
+	dir   string
+	from  string
+	count atomic.Int64
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o750); err != nil {
+			return nil, fmt.Errorf("error creating mail directory: %w", err)
+		}
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+// Send writes the message to tmp and moves it to new, so readers never see half a message.
+func (m *FileMailer) Send(ctx context.Context, message Message) error {
+	if message.From == "" {
+		message.From = m.from
+	}
+
+	now := time.Now()
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), m.count.Add(1), strings.ReplaceAll(hostname, "/", "_"))
+
+	tmp := filepath.Join(m.dir, "tmp", name)
+	if err := os.WriteFile(tmp, message.Bytes(now), 0o640); err != nil {
+		return fmt.Errorf("error writing message: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(m.dir, "new", name))
+}
+
+func (m *FileMailer) Close() error {
+	return nil
+}
+
+// CaptureMailer keeps sent messages in memory so tests can check what went out.
+type CaptureMailer struct {
+	from     string
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewCaptureMailer(from string) *CaptureMailer {
+	return &CaptureMailer{from: from}
+}
+
+func (m *CaptureMailer) Send(ctx context.Context, message Message) error {
+	if message.From == "" {
+		message.From = m.from
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (m *CaptureMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.messages)
+}
+
+// Reset forgets the messages sent so far.
+func (m *CaptureMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
+
+func (m *CaptureMailer) Close() error {
+	return nil
+}
@@ -0,0 +1,341 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RRULE FREQ values
+const (
+	RRULE_DAILY   = "DAILY"
+	RRULE_WEEKLY  = "WEEKLY"
+	RRULE_MONTHLY = "MONTHLY"
+	RRULE_YEARLY  = "YEARLY"
+)
+
+// a rule that never matches would otherwise be walked forever
+const maxRRulePeriods = 50000
+
+var icsWeekdays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// RRule is the subset of an RFC 5545 recurrence rule we support: FREQ,
+// INTERVAL, BYDAY, COUNT and UNTIL. A zero Count or Until means no limit.
+type RRule struct {
+	Freq     string
+	Interval int
+	ByDay    []RRuleDay
+	Count    int
+	Until    time.Time
+}
+
+// RRuleDay is one BYDAY entry, a non-zero Ordinal picks the nth weekday of the
+// month, counted from the end when negative, and only goes with FREQ=MONTHLY.
+type RRuleDay struct {
+	Ordinal int
+	Weekday time.Weekday
+}
+
+// ParseRRule reads a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=10",
+// with or without the "RRULE:" prefix.
+func ParseRRule(s string) (RRule, error) {
+	rule := RRule{Interval: 1}
+	seen := map[string]bool{}
+
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimSpace(s), "RRULE:"), ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(name)
+		if !ok || value == "" {
+			return RRule{}, fmt.Errorf("invalid RRULE part %q", part)
+		}
+
+		if seen[name] {
+			return RRule{}, fmt.Errorf("RRULE has %s more than once", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(value)
+			if !slices.Contains([]string{RRULE_DAILY, RRULE_WEEKLY, RRULE_MONTHLY, RRULE_YEARLY}, rule.Freq) {
+				return RRule{}, fmt.Errorf("unsupported RRULE FREQ %q", value)
+			}
+		case "INTERVAL":
+			rule.Interval, err = positiveInt(name, value)
+		case "COUNT":
+			rule.Count, err = positiveInt(name, value)
+		case "UNTIL":
+			rule.Until, err = parseUntil(value)
+		case "BYDAY":
+			rule.ByDay, err = parseByDay(value)
+		default:
+			return RRule{}, fmt.Errorf("unsupported RRULE part %s", name)
+		}
+
+		if err != nil {
+			return RRule{}, err
+		}
+	}
+
+	if rule.Freq == "" {
+		return RRule{}, errors.New("RRULE needs a FREQ")
+	}
+
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return RRule{}, errors.New("RRULE cannot have both COUNT and UNTIL")
+	}
+
+	for _, day := range rule.ByDay {
+		if rule.Freq == RRULE_YEARLY {
+			return RRule{}, errors.New("BYDAY is not supported with FREQ=YEARLY")
+		}
+
+		if day.Ordinal != 0 && rule.Freq != RRULE_MONTHLY {
+			return RRule{}, errors.New("numbered BYDAY entries need FREQ=MONTHLY")
+		}
+	}
+	return rule, nil
+}
+
+func positiveInt(name, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("RRULE %s must be a positive number", name)
+	}
+	return n, nil
+}
+
+// parseUntil takes a UTC date-time or a date, which includes the whole day.
+func parseUntil(value string) (time.Time, error) {
+	if until, err := time.Parse(icsTimeFormat, value); err == nil {
+		return until, nil
+	}
+
+	if day, err := time.Parse("20060102", value); err == nil {
+		return day.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("RRULE UNTIL %q must look like 20060102T150405Z", value)
+}
+
+func parseByDay(value string) ([]RRuleDay, error) {
+	days := []RRuleDay{}
+
+	for _, entry := range strings.Split(strings.ToUpper(value), ",") {
+		if len(entry) < 2 {
+			return nil, fmt.Errorf("invalid RRULE BYDAY entry %q", entry)
+		}
+
+		weekday := slices.Index(icsWeekdays, entry[len(entry)-2:])
+		if weekday < 0 {
+			return nil, fmt.Errorf("invalid RRULE BYDAY entry %q", entry)
+		}
+
+		day := RRuleDay{Weekday: time.Weekday(weekday)}
+		if ordinal := entry[:len(entry)-2]; ordinal != "" {
+			n, err := strconv.Atoi(ordinal)
+			if err != nil || n == 0 || n < -5 || n > 5 {
+				return nil, fmt.Errorf("invalid RRULE BYDAY entry %q", entry)
+			}
+			day.Ordinal = n
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// String writes the rule back in RFC 5545 form, leaving out defaults.
+func (r RRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+
+	if len(r.ByDay) > 0 {
+		days := []string{}
+		for _, day := range r.ByDay {
+			entry := icsWeekdays[day.Weekday]
+			if day.Ordinal != 0 {
+				entry = fmt.Sprint(day.Ordinal) + entry
+			}
+			days = append(days, entry)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(icsTimeFormat))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Occurrences lists the starts in [from, to) of the series that begins at
+// dtstart, skipping excluded ones. Excluded starts still count towards COUNT.
+func (r RRule) Occurrences(dtstart, from, to time.Time, excluded []time.Time) []time.Time {
+	starts := []time.Time{}
+
+	r.each(dtstart, func(start time.Time) bool {
+		if !start.Before(to) {
+			return false
+		}
+
+		if !start.Before(from) && !isExcluded(start, excluded) {
+			starts = append(starts, start)
+		}
+		return true
+	})
+	return starts
+}
+
+// Next returns the first start of the series after t that is not excluded, and
+// false when the series ends before that.
+func (r RRule) Next(dtstart, t time.Time, excluded []time.Time) (time.Time, bool) {
+	var next time.Time
+
+	r.each(dtstart, func(start time.Time) bool {
+		if start.After(t) && !isExcluded(start, excluded) {
+			next = start
+			return false
+		}
+		return true
+	})
+	return next, !next.IsZero()
+}
+
+// CountBefore is the number of occurrences, excluded or not, that start before t.
+func (r RRule) CountBefore(dtstart, t time.Time) int {
+	count := 0
+
+	r.each(dtstart, func(start time.Time) bool {
+		if !start.Before(t) {
+			return false
+		}
+		count++
+		return true
+	})
+	return count
+}
+
+func isExcluded(start time.Time, excluded []time.Time) bool {
+	return slices.ContainsFunc(excluded, start.Equal)
+}
+
+// each calls yield with every start of the series in order until yield returns
+// false or the series ends. dtstart is always the first occurrence and sets the
+// time of day, in its own location, of all the others.
+func (r RRule) each(dtstart time.Time, yield func(time.Time) bool) {
+	interval := max(r.Interval, 1)
+	emitted := 0
+
+	emit := func(start time.Time) bool {
+		if !r.Until.IsZero() && start.After(r.Until) {
+			return false
+		}
+
+		if r.Count > 0 && emitted >= r.Count {
+			return false
+		}
+
+		emitted++
+		return yield(start)
+	}
+
+	if !emit(dtstart) {
+		return
+	}
+
+	year, month, day := dtstart.Date()
+	hour, minute, second := dtstart.Clock()
+	loc := dtstart.Location()
+
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, minute, second, dtstart.Nanosecond(), loc)
+	}
+
+	for period := 0; period < maxRRulePeriods; period++ {
+		var candidates []time.Time
+
+		switch r.Freq {
+		case RRULE_DAILY:
+			candidate := at(year, month, day+period*interval)
+			if len(r.ByDay) == 0 || slices.ContainsFunc(r.ByDay, func(d RRuleDay) bool { return d.Weekday == candidate.Weekday() }) {
+				candidates = append(candidates, candidate)
+			}
+		case RRULE_WEEKLY:
+			// weeks start on Monday, the RFC 5545 default WKST
+			monday := day - (int(dtstart.Weekday())+6)%7 + period*interval*7
+
+			weekdays := []time.Weekday{dtstart.Weekday()}
+			if len(r.ByDay) > 0 {
+				weekdays = weekdays[:0]
+				for _, d := range r.ByDay {
+					weekdays = append(weekdays, d.Weekday)
+				}
+			}
+
+			for _, weekday := range weekdays {
+				candidates = append(candidates, at(year, month, monday+(int(weekday)+6)%7))
+			}
+		case RRULE_MONTHLY:
+			first := time.Date(year, month+time.Month(period*interval), 1, 0, 0, 0, 0, loc)
+			candidates = monthlyCandidates(r.ByDay, first.Year(), first.Month(), day, at)
+		case RRULE_YEARLY:
+			// a February 29 series only happens in leap years
+			if candidate := at(year+period*interval, month, day); candidate.Day() == day {
+				candidates = append(candidates, candidate)
+			}
+		}
+
+		slices.SortFunc(candidates, func(a, b time.Time) int { return a.Compare(b) })
+		candidates = slices.CompactFunc(candidates, func(a, b time.Time) bool { return a.Equal(b) })
+
+		for _, candidate := range candidates {
+			if candidate.After(dtstart) && !emit(candidate) {
+				return
+			}
+		}
+	}
+}
+
+// monthlyCandidates lists the days of one month a MONTHLY rule picks, months
+// too short for the start's day of the month are skipped.
+func monthlyCandidates(byDay []RRuleDay, year int, month time.Month, day int, at func(int, time.Month, int) time.Time) []time.Time {
+	candidates := []time.Time{}
+	daysInMonth := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+
+	if len(byDay) == 0 {
+		if day <= daysInMonth {
+			candidates = append(candidates, at(year, month, day))
+		}
+		return candidates
+	}
+
+	firstWeekday := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).Weekday()
+	for _, d := range byDay {
+		// every day of the month that falls on the weekday
+		matches := []int{}
+		for dom := 1 + (int(d.Weekday)-int(firstWeekday)+7)%7; dom <= daysInMonth; dom += 7 {
+			matches = append(matches, dom)
+		}
+
+		switch {
+		case d.Ordinal == 0:
+			for _, dom := range matches {
+				candidates = append(candidates, at(year, month, dom))
+			}
+		case d.Ordinal > 0 && d.Ordinal <= len(matches):
+			candidates = append(candidates, at(year, month, matches[d.Ordinal-1]))
+		case d.Ordinal < 0 && -d.Ordinal <= len(matches):
+			candidates = append(candidates, at(year, month, matches[len(matches)+d.Ordinal]))
+		}
+	}
+	return candidates
+}
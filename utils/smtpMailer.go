@@ -0,0 +1,240 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// how long one message may take when the context has no deadline
+const smtpSendTimeout = 30 * time.Second
+
+// smtpMailer sends each message on a connection of its own, taken from up to
+// PoolSize kept open between messages and closed after IdleTimeout without
+// any. Certificates are always verified.
+type smtpMailer struct {
+	config MailConfig
+
+	mu     sync.Mutex
+	idle   []*smtpConnection
+	closed bool
+}
+
+// smtpConnection is one logged in connection, idle closes it while pooled.
+type smtpConnection struct {
+	conn   net.Conn
+	client *smtp.Client
+	idle   *time.Timer
+}
+
+func newSMTPMailer(config MailConfig) *smtpMailer {
+	return &smtpMailer{config: config}
+}
+
+func (m *smtpMailer) Send(ctx context.Context, message Message) error {
+	if message.From == "" {
+		message.From = m.config.From
+	}
+
+	if len(message.To) == 0 {
+		return errors.New("message has no recipients")
+	}
+
+	// a kept connection may have been dropped by the server, then try once more on a new one
+	c := m.take()
+	reused := c != nil
+
+	var err error
+	if !reused {
+		c, err = m.connect(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.send(ctx, message)
+	if err != nil && reused {
+		c.close()
+		c, err = m.connect(ctx)
+		if err != nil {
+			return err
+		}
+		err = c.send(ctx, message)
+	}
+
+	if err != nil {
+		c.close()
+		return err
+	}
+
+	m.put(c)
+	return nil
+}
+
+// take removes an idle connection from the pool, nil when there is none.
+func (m *smtpMailer) take() *smtpConnection {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	last := len(m.idle) - 1
+	if last < 0 {
+		return nil
+	}
+
+	c := m.idle[last]
+	m.idle = m.idle[:last]
+	c.idle.Stop()
+	return c
+}
+
+// put keeps c for the next message, or closes it when the pool is full.
+func (m *smtpMailer) put(c *smtpConnection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed || m.config.IdleTimeout <= 0 || len(m.idle) >= m.config.PoolSize {
+		go c.close()
+		return
+	}
+
+	// the timer only closes c while it is still waiting in the pool
+	c.idle = time.AfterFunc(m.config.IdleTimeout, func() {
+		m.mu.Lock()
+		i := slices.Index(m.idle, c)
+		if i >= 0 {
+			m.idle = slices.Delete(m.idle, i, i+1)
+		}
+		m.mu.Unlock()
+
+		if i >= 0 {
+			c.close()
+		}
+	})
+	m.idle = append(m.idle, c)
+}
+
+func (c *smtpConnection) send(ctx context.Context, message Message) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpSendTimeout)
+	}
+
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	// a pooled connection may still hold the envelope of a failed message
+	if err := c.client.Reset(); err != nil {
+		return fmt.Errorf("error resetting SMTP connection: %w", err)
+	}
+
+	if err := c.client.Mail(message.From); err != nil {
+		return fmt.Errorf("error starting SMTP mail transaction: %w", err)
+	}
+
+	for _, to := range message.To {
+		if err := c.client.Rcpt(to); err != nil {
+			return fmt.Errorf("error adding SMTP recipient %s: %w", to, err)
+		}
+	}
+
+	w, err := c.client.Data()
+	if err != nil {
+		return fmt.Errorf("error starting SMTP message data: %w", err)
+	}
+
+	if _, err := w.Write(message.Bytes(time.Now())); err != nil {
+		return fmt.Errorf("error writing SMTP message: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error finishing SMTP message: %w", err)
+	}
+	return nil
+}
+
+// connect dials the server, sets up TLS as SMTP_TLS asks and logs in.
+func (m *smtpMailer) connect(ctx context.Context) (*smtpConnection, error) {
+	addr := net.JoinHostPort(m.config.Host, m.config.Port)
+	tlsConfig := &tls.Config{ServerName: m.config.Host, MinVersion: tls.VersionTLS12}
+
+	dialer := &net.Dialer{Timeout: smtpSendTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to SMTP server: %w", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(smtpSendTimeout))
+	}
+
+	if m.config.TLS == SMTP_TLS_IMPLICIT {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error starting TLS with SMTP server: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error greeting SMTP server: %w", err)
+	}
+
+	if m.config.TLS == SMTP_TLS_STARTTLS || m.config.TLS == SMTP_TLS_OPPORTUNISTIC {
+		offered, _ := client.Extension("STARTTLS")
+		if !offered && m.config.TLS == SMTP_TLS_STARTTLS {
+			client.Close()
+			return nil, errors.New("SMTP server does not offer STARTTLS, set SMTP_TLS to allow that")
+		}
+
+		if offered {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return nil, fmt.Errorf("error starting TLS with SMTP server: %w", err)
+			}
+		}
+	}
+
+	// net/smtp only sends PLAIN credentials over TLS or to localhost
+	if ok, _ := client.Extension("AUTH"); ok && m.config.Username != "" && m.config.Password != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("error authenticating to SMTP server: %w", err)
+		}
+	}
+
+	return &smtpConnection{conn: conn, client: client}, nil
+}
+
+// close says goodbye to the server.
+func (c *smtpConnection) close() {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := c.client.Quit(); err != nil {
+		c.client.Close()
+	}
+}
+
+func (m *smtpMailer) Close() error {
+	m.mu.Lock()
+	idle := m.idle
+	m.idle = nil
+	m.closed = true
+	m.mu.Unlock()
+
+	for _, c := range idle {
+		c.idle.Stop()
+		c.close()
+	}
+	return nil
+}
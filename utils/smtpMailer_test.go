@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer answers just enough SMTP for smtpMailer, each message's
+// final reply waits until inFlight messages are being sent at once.
+type fakeSMTPServer struct {
+	listener    net.Listener
+	connections atomic.Int32
+	inFlight    int
+
+	mu      sync.Mutex
+	sending int
+	wake    chan struct{}
+}
+
+func newFakeSMTPServer(t *testing.T, inFlight int) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeSMTPServer{listener: listener, inFlight: inFlight, wake: make(chan struct{})}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.connections.Add(1)
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		switch command := strings.ToUpper(strings.Fields(line)[0]); command {
+		case "DATA":
+			reply("354 go ahead")
+			for line != ".\r\n" {
+				if line, err = r.ReadString('\n'); err != nil {
+					return
+				}
+			}
+			s.waitForOthers()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 localhost")
+		}
+	}
+}
+
+// waitForOthers holds a message until inFlight of them have arrived, or a while.
+func (s *fakeSMTPServer) waitForOthers() {
+	s.mu.Lock()
+	s.sending++
+	if s.sending == s.inFlight {
+		close(s.wake)
+	}
+	wake := s.wake
+	s.mu.Unlock()
+
+	select {
+	case <-wake:
+	case <-time.After(2 * time.Second):
+	}
+}
+
+func TestSMTPMailerPool(t *testing.T) {
+	server := newFakeSMTPServer(t, 3)
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+
+	mailer := newSMTPMailer(MailConfig{From: "crm@example.com", Host: host, Port: port, TLS: SMTP_TLS_NONE, IdleTimeout: time.Minute, PoolSize: 2})
+	defer mailer.Close()
+
+	send := func() error {
+		return mailer.Send(context.Background(), Message{To: []string{"alice@example.com"}, Subject: "hello", HTML: "<p>hello</p>"})
+	}
+
+	// three messages at once go out side by side on three connections
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := send(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("sending took %s, the messages went out one by one", elapsed)
+	}
+	if n := server.connections.Load(); n != 3 {
+		t.Fatalf("got %d connections, want 3", n)
+	}
+
+	// the pool keeps two of them for the next messages
+	for range 2 {
+		if err := send(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := server.connections.Load(); n != 3 {
+		t.Fatalf("got %d connections after reusing the pool, want 3", n)
+	}
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DEFAULT_LOCALE formats times for people who have not picked a locale.
+const DEFAULT_LOCALE = "en-US"
+
+// timeLocale spells out a date the way one locale writes it, pattern takes the
+// weekday, day, month, year, clock and zone in that order.
+type timeLocale struct {
+	pattern  string
+	clock    string
+	weekdays [7]string
+	months   [12]string
+}
+
+var englishWeekdays = [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+var englishMonths = [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+
+var timeLocales = map[string]timeLocale{
+	"en-US": {
+		pattern:  "%[1]s, %[3]s %[2]d, %[4]d at %[5]s %[6]s",
+		clock:    "3:04 PM",
+		weekdays: englishWeekdays,
+		months:   englishMonths,
+	},
+	"en-GB": {
+		pattern:  "%[1]s %[2]d %[3]s %[4]d at %[5]s %[6]s",
+		clock:    "15:04",
+		weekdays: englishWeekdays,
+		months:   englishMonths,
+	},
+	"de-DE": {
+		pattern:  "%[1]s, %[2]d. %[3]s %[4]d um %[5]s %[6]s",
+		clock:    "15:04",
+		weekdays: [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		months:   [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+	},
+	"fr-FR": {
+		pattern:  "%[1]s %[2]d %[3]s %[4]d à %[5]s %[6]s",
+		clock:    "15:04",
+		weekdays: [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		months:   [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+	},
+	"es-ES": {
+		pattern:  "%[1]s, %[2]d de %[3]s de %[4]d, %[5]s %[6]s",
+		clock:    "15:04",
+		weekdays: [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		months:   [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+	},
+}
+
+// ResolveLocale matches a BCP 47 tag such as "de-AT" or "en_gb" to a supported
+// locale, trying the exact tag first and then its language.
+func ResolveLocale(tag string) (string, bool) {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+
+	for locale := range timeLocales {
+		if strings.EqualFold(locale, tag) {
+			return locale, true
+		}
+	}
+
+	language, _, _ := strings.Cut(tag, "-")
+	if language == "" {
+		return "", false
+	}
+
+	// the first locale listed for a language is its default
+	for _, locale := range []string{"en-US", "en-GB", "de-DE", "fr-FR", "es-ES"} {
+		if strings.EqualFold(locale[:2], language) {
+			return locale, true
+		}
+	}
+	return "", false
+}
+
+// FormatTime writes t as a reader in loc using locale expects it, unknown
+// locales fall back to DEFAULT_LOCALE and a nil loc to UTC.
+func FormatTime(t time.Time, loc *time.Location, locale string) string {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	format, ok := timeLocales[locale]
+	if !ok {
+		format = timeLocales[DEFAULT_LOCALE]
+	}
+
+	t = t.In(loc)
+	zone := t.Format("MST")
+	if loc != time.UTC && zone != loc.String() {
+		zone = fmt.Sprintf("%s (%s)", zone, loc)
+	}
+
+	return fmt.Sprintf(format.pattern, format.weekdays[t.Weekday()], t.Day(), format.months[t.Month()-1], t.Year(), t.Format(format.clock), zone)
+}